@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// TextToSpeech speaks customer prompts in accessibility mode.
+type TextToSpeech interface {
+	Speak(text, locale string) error
+}
+
+// LogTextToSpeech prints what would be spoken to W, stdout when nil.
+type LogTextToSpeech struct {
+	W io.Writer
+}
+
+func (t LogTextToSpeech) Speak(text, locale string) error {
+	fmt.Fprintf(orStdout(t.W), "(speech %s) %s\n", locale, text)
+	return nil
+}
+
+// SetAccessibility turns accessibility mode on or off for the next rider:
+// timeouts are lengthened by AccessibleTimeoutFactor, prompts are spoken
+// through TTS and tickets are sold one at a time. It can only be chosen
+// from the ready state, and ends when the machine returns to it.
+func (m *TicketMachine) SetAccessibility(on bool) error {
+	if err := m.logAction(JournalEntry{Action: "accessibility", On: on}); err != nil {
+		return err
+	}
+	if err := m.inService(); err != nil {
+		return err
+	}
+	switch m.State.(type) {
+	case *IdleState, *CashBoxFullState:
+	default:
+		return newError(CodeBusy, "transaction in progress")
+	}
+	m.Accessible = on
+	if on {
+		m.show("Accessibility mode on. Tickets are sold one at a time.")
+	}
+	return nil
+}
+
+// timeout is the inactivity timeout for the current rider.
+func (m *TicketMachine) timeout() time.Duration {
+	if m.Accessible && m.AccessibleTimeoutFactor > 1 {
+		return m.Timeout * time.Duration(m.AccessibleTimeoutFactor)
+	}
+	return m.Timeout
+}
+
+// checkAccessibleQty keeps to one ticket per sale in accessibility mode.
+func (m *TicketMachine) checkAccessibleQty(qty int) error {
+	if m.Accessible && qty != 1 {
+		return newError(CodeNotOffered, "one ticket at a time in accessibility mode")
+	}
+	return nil
+}
+
+// speakingDisplay shows prompts on the display and speaks them as well.
+type speakingDisplay struct {
+	Display
+	m *TicketMachine
+}
+
+func (d speakingDisplay) speak(text string) {
+	if err := d.m.TTS.Speak(text, d.m.AudioLocale); err != nil {
+		d.m.warn("text to speech: %v", err)
+	}
+}
+
+func (d speakingDisplay) ShowPrice(s Selection) {
+	d.Display.ShowPrice(s)
+	text := s.TicketType
+	if s.Price != "" {
+		text += ", " + s.Price
+	}
+	if s.Prompt != "" {
+		text += ". " + s.Prompt
+	}
+	d.speak(text)
+}
+
+func (d speakingDisplay) ShowBalance(b Balance) {
+	d.Display.ShowBalance(b)
+	d.speak(fmt.Sprintf("Inserted %s. Paid %s of %s.", b.Inserted.In(b.Currency), b.Total.In(b.Currency), b.Price.In(b.Currency)))
+}
+
+func (d speakingDisplay) ShowError(err error) {
+	d.Display.ShowError(err)
+	d.speak(err.Error())
+}
+
+func (d speakingDisplay) ShowIdle(welcome string) {
+	d.Display.ShowIdle(welcome)
+	if welcome != "" {
+		d.speak(welcome)
+	}
+}
+
+func (d speakingDisplay) ShowMessage(text string) {
+	d.Display.ShowMessage(text)
+	d.speak(text)
+}
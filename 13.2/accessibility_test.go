@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// spoken records what text to speech was asked to say.
+type spoken []string
+
+func (s *spoken) Speak(text, locale string) error {
+	*s = append(*s, locale+": "+text)
+	return nil
+}
+
+func TestAccessibilityMode(t *testing.T) {
+	m, _ := newTestMachine(t)
+	tts := &spoken{}
+	m.TTS, m.AudioLocale = tts, "en"
+	must(t, m.SetAccessibility(true))
+	if err := m.SelectTicket("metro", 2); CodeOf(err) != CodeNotOffered {
+		t.Fatalf("two tickets in accessibility mode = %v, want %s", err, CodeNotOffered)
+	}
+	must(t, m.SelectTicket("metro", 1))
+	if err := m.SetAccessibility(false); CodeOf(err) != CodeBusy {
+		t.Fatalf("SetAccessibility mid-sale = %v, want %s", err, CodeBusy)
+	}
+	must(t, m.InsertMoney(KZT(200)))
+	var said bool
+	for _, s := range *tts {
+		if strings.HasPrefix(s, "en: Inserted ") {
+			said = true
+		}
+	}
+	if !said {
+		t.Fatalf("balance not spoken; said %q", *tts)
+	}
+	must(t, m.InsertMoney(KZT(100)))
+	_, err := m.DispenseTicket()
+	must(t, err)
+	must(t, m.StartOver())
+
+	if m.Accessible {
+		t.Fatal("accessibility mode outlived the rider")
+	}
+	n := len(*tts)
+	must(t, m.SelectTicket("metro", 2))
+	if len(*tts) != n {
+		t.Fatalf("spoke %q outside accessibility mode", (*tts)[n:])
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Authenticator checks operator credentials for admin actions.
+type Authenticator interface {
+	Authenticate(operatorID, pin string) error
+}
+
+// PINAuthenticator is an Authenticator backed by a table of operator PINs.
+type PINAuthenticator map[string]string
+
+func (a PINAuthenticator) Authenticate(operatorID, pin string) error {
+	if expected, ok := a[operatorID]; !ok || expected != pin {
+		return newError(CodeInvalidCredentials, "invalid operator credentials")
+	}
+	return nil
+}
+
+// AuditEntry is one record in the machine's audit trail.
+type AuditEntry struct {
+	Time       time.Time
+	MachineID  string
+	StationID  string
+	OperatorID string
+	// TransactionID is set for entries made during a sale.
+	TransactionID string
+	Action        string
+	Detail        string
+	// Seq numbers the entries of the machine; Hash chains the entry to
+	// the one before, whose hash is PrevHash. See VerifyAuditLog.
+	Seq      int
+	PrevHash string
+	Hash     string
+}
+
+func (m *TicketMachine) audit(operatorID, action, detail string) {
+	e := AuditEntry{
+		Time:          m.Clock.Now(),
+		MachineID:     m.MachineID,
+		StationID:     m.Location.StationID,
+		OperatorID:    operatorID,
+		TransactionID: m.saleID(),
+		Action:        action,
+		Detail:        detail,
+	}
+	seq, head := m.auditHead()
+	e.Seq, e.PrevHash = seq+1, head
+	e.Hash = e.chainHash()
+	m.AuditLog = append(m.AuditLog, e)
+	m.auditSeq, m.auditHash = e.Seq, e.Hash
+	m.log(slog.LevelInfo, "audit", slog.String("operator_id", operatorID), slog.String("action", action),
+		slog.String("detail", detail))
+	m.checkpointIfDue(e)
+}
+
+// CashCollection records one emptying of the cash box.
+type CashCollection struct {
+	Time       time.Time
+	OperatorID string
+	Amount     Money
+	Contents   []TallyLine
+	Foreign    []ForeignCash
+}
+
+// CollectCash empties the cash box on behalf of an authenticated operator
+// and records the collection in the audit trail.
+func (m *TicketMachine) CollectCash(operatorID, pin string) (CashCollection, error) {
+	if err := m.Auth.Authenticate(operatorID, pin); err != nil {
+		m.audit(operatorID, "collect_cash_denied", err.Error())
+		return CashCollection{}, err
+	}
+	if err := m.authorize(operatorID, PermCollectCash, "collect_cash"); err != nil {
+		return CashCollection{}, err
+	}
+	return m.collectCash(operatorID), nil
+}
+
+func (m *TicketMachine) collectCash(operatorID string) CashCollection {
+	c := CashCollection{
+		Time:       m.Clock.Now(),
+		OperatorID: operatorID,
+		Amount:     m.CashBox.Total(),
+		Contents:   tallyLines(m.CashBox.Contents),
+		Foreign:    m.CashBox.Foreign,
+	}
+	m.CashBox.Contents = map[Money]int{}
+	m.CashBox.Foreign = nil
+	m.Collections = append(m.Collections, c)
+	m.audit(operatorID, "collect_cash", fmt.Sprintf("collected %s", c.Amount.In(m.Currency)))
+	if _, full := m.State.(*CashBoxFullState); full {
+		m.SetState(&IdleState{})
+	}
+	fmt.Fprintf(m.out(), "Cash collected by %s: %s\n", operatorID, c.Amount.In(m.Currency))
+	return c
+}
+
+// ReprintTicket re-emits the tickets of the most recent sale from the
+// transaction journal, for when the printer jammed. Nothing is charged and
+// stock is not touched.
+func (m *TicketMachine) ReprintTicket(operatorID, pin string) ([]Ticket, error) {
+	if err := m.Auth.Authenticate(operatorID, pin); err != nil {
+		m.audit(operatorID, "reprint_denied", err.Error())
+		return nil, err
+	}
+	if err := m.authorize(operatorID, PermReprint, "reprint"); err != nil {
+		return nil, err
+	}
+	for i := len(m.Transactions) - 1; i >= 0; i-- {
+		rec := m.Transactions[i]
+		if len(rec.Tickets) == 0 {
+			continue
+		}
+		m.audit(operatorID, "reprint", fmt.Sprintf("reprinted %d tickets of %s", len(rec.Tickets), rec.ID))
+		for _, t := range rec.Tickets {
+			fmt.Fprintf(m.out(), "Reprinted ticket %s (%s)\n", t.ID, t.Type)
+		}
+		return rec.Tickets, nil
+	}
+	return nil, newError(CodeNoTicketToReprint, "no ticket to reprint")
+}
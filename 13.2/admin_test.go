@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+func TestCollectCash(t *testing.T) {
+	tests := []struct {
+		name     string
+		operator string
+		pin      string
+		code     ErrorCode
+		action   string
+	}{
+		{"supervisor", "admin", "0000", "", "collect_cash"},
+		{"cash collector", "collector", "2222", "", "collect_cash"},
+		{"clerk lacks permission", "clerk", "1111", CodePermissionDenied, "collect_cash_denied"},
+		{"wrong pin", "admin", "9999", CodeInvalidCredentials, "collect_cash_denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.CashBox = NewCashBox(2)
+			sellMetro(t, m)
+			must(t, m.StartOver())
+			if _, full := m.State.(*CashBoxFullState); !full {
+				t.Fatalf("state = %s, want CashBoxFull", m.State.Name())
+			}
+			c, err := m.CollectCash(tt.operator, tt.pin)
+			if got := m.AuditLog[len(m.AuditLog)-1].Action; got != tt.action {
+				t.Fatalf("last audit action = %q, want %q", got, tt.action)
+			}
+			if tt.code != "" {
+				if CodeOf(err) != tt.code {
+					t.Fatalf("err = %v, want %s", err, tt.code)
+				}
+				if m.CashBox.Total() != KZT(300) || len(m.Collections) != 0 {
+					t.Fatalf("refused collection emptied the box: %s, %d collections", m.CashBox.Total(), len(m.Collections))
+				}
+				return
+			}
+			must(t, err)
+			if c.Amount != KZT(300) || c.OperatorID != tt.operator || len(c.Contents) != 2 {
+				t.Fatalf("collection = %+v", c)
+			}
+			if m.CashBox.Total() != 0 || m.CashBox.Count() != 0 || len(m.Collections) != 1 {
+				t.Fatalf("cash box = %s after collection, %d collections", m.CashBox.Total(), len(m.Collections))
+			}
+			if _, idle := m.State.(*IdleState); !idle {
+				t.Fatalf("state = %s, want Idle", m.State.Name())
+			}
+		})
+	}
+}
+
+func TestReprintTicket(t *testing.T) {
+	tests := []struct {
+		name     string
+		operator string
+		pin      string
+		sales    int
+		code     ErrorCode
+	}{
+		{"clerk", "clerk", "1111", 2, ""},
+		{"nothing sold yet", "clerk", "1111", 0, CodeNoTicketToReprint},
+		{"collector lacks permission", "collector", "2222", 1, CodePermissionDenied},
+		{"wrong pin", "clerk", "0000", 1, CodeInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			for i := 0; i < tt.sales; i++ {
+				sellMetro(t, m)
+				must(t, m.StartOver())
+			}
+			stock, sold := m.Catalog.Stock("metro"), len(m.Transactions)
+			tickets, err := m.ReprintTicket(tt.operator, tt.pin)
+			if CodeOf(err) != tt.code {
+				t.Fatalf("ReprintTicket = %v, want %q", err, tt.code)
+			}
+			if m.Catalog.Stock("metro") != stock || len(m.Transactions) != sold {
+				t.Fatalf("reprint changed stock to %d or recorded a sale", m.Catalog.Stock("metro"))
+			}
+			if tt.code != "" {
+				return
+			}
+			last := m.Transactions[len(m.Transactions)-1]
+			if len(tickets) != 1 || tickets[0].ID != last.Tickets[0].ID {
+				t.Fatalf("reprinted %+v, want the tickets of %s", tickets, last.ID)
+			}
+			if got := m.AuditLog[len(m.AuditLog)-1].Action; got != "reprint" {
+				t.Fatalf("last audit action = %q", got)
+			}
+		})
+	}
+}
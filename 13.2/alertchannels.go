@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertRoute sends alerts of at least MinSeverity, and of Kinds when set,
+// to a Notifier.
+type AlertRoute struct {
+	MinSeverity Severity
+	Kinds       []AlertKind
+	Notifier    Notifier
+}
+
+func (r AlertRoute) matches(a Alert) bool {
+	if a.Severity < r.MinSeverity {
+		return false
+	}
+	if len(r.Kinds) == 0 {
+		return true
+	}
+	for _, k := range r.Kinds {
+		if k == a.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+// AlertRouter delivers every alert to each route it matches, e.g. all of
+// them to a chat channel and the critical ones to the on-call phone too.
+type AlertRouter []AlertRoute
+
+func (r AlertRouter) Notify(a Alert) error {
+	var errs []error
+	for _, route := range r {
+		if route.matches(a) {
+			if err := route.Notifier.Notify(a); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AlertQueue delivers alerts to a Notifier in the background, so a slow
+// chat or mail server never holds up a sale. Notify fails at once when
+// the queue is full; a failed delivery is logged.
+type AlertQueue struct {
+	next   Notifier
+	queue  chan Alert
+	done   chan struct{}
+	closed sync.Once
+}
+
+// NewAlertQueue starts delivering to next with room for size waiting
+// alerts, 64 when size is 0; Close stops it.
+func NewAlertQueue(next Notifier, size int) *AlertQueue {
+	if size <= 0 {
+		size = 64
+	}
+	q := &AlertQueue{next: next, queue: make(chan Alert, size), done: make(chan struct{})}
+	go q.run()
+	return q
+}
+
+func (q *AlertQueue) Notify(a Alert) error {
+	select {
+	case q.queue <- a:
+		return nil
+	default:
+		return fmt.Errorf("alert queue full, %s dropped", a.Kind)
+	}
+}
+
+// Close stops taking alerts and waits for the queued ones.
+func (q *AlertQueue) Close() {
+	q.closed.Do(func() { close(q.queue) })
+	<-q.done
+}
+
+func (q *AlertQueue) run() {
+	defer close(q.done)
+	for a := range q.queue {
+		if err := q.next.Notify(a); err != nil {
+			slog.Default().Warn("alert not delivered", slog.String("machine_id", a.MachineID),
+				slog.String("kind", string(a.Kind)), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// ThrottledNotifier keeps ops from being flooded. An alert of the same
+// kind, ticket type and detail as one let through less than Window ago
+// is held back and counted in the next one that passes, so a fault of
+// another component always gets through. At most MaxPerWindow
+// alerts pass per Window, critical ones aside; 0 means no limit.
+type ThrottledNotifier struct {
+	Next Notifier
+	// Clock is the system clock when nil.
+	Clock        Clock
+	Window       time.Duration
+	MaxPerWindow int
+
+	mu     sync.Mutex
+	last   map[string]time.Time
+	held   map[string]int
+	passed []time.Time
+}
+
+func (t *ThrottledNotifier) Notify(a Alert) error {
+	if !t.pass(&a) {
+		return nil
+	}
+	return t.Next.Notify(a)
+}
+
+// pass decides whether a goes out now and, when it does, adds the count
+// of alerts like it that were held back.
+func (t *ThrottledNotifier) pass(a *Alert) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last == nil {
+		t.last, t.held = map[string]time.Time{}, map[string]int{}
+	}
+	var now time.Time
+	if t.Clock != nil {
+		now = t.Clock.Now()
+	} else {
+		now = time.Now()
+	}
+	key := string(a.Kind) + "/" + a.TicketType + "/" + a.Detail
+	if last, ok := t.last[key]; ok && now.Sub(last) < t.Window {
+		t.held[key]++
+		return false
+	}
+	recent := t.passed[:0]
+	for _, p := range t.passed {
+		if now.Sub(p) < t.Window {
+			recent = append(recent, p)
+		}
+	}
+	t.passed = recent
+	if t.MaxPerWindow > 0 && len(t.passed) >= t.MaxPerWindow && a.Severity < SeverityCritical {
+		t.held[key]++
+		return false
+	}
+	t.passed = append(t.passed, now)
+	t.last[key] = now
+	a.Suppressed = t.held[key]
+	delete(t.held, key)
+	return true
+}
+
+// alertTimeout bounds a delivery to a chat, mail or SMS server.
+const alertTimeout = 10 * time.Second
+
+var alertClient = &http.Client{Timeout: alertTimeout}
+
+// SlackNotifier posts alerts to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+	// Client is a client with a 10s timeout when nil.
+	Client *http.Client
+}
+
+func (n SlackNotifier) Notify(a Alert) error {
+	body, _ := json.Marshal(map[string]string{"text": fmt.Sprintf("[%s] %s", a.Severity, a)})
+	client := n.Client
+	if client == nil {
+		client = alertClient
+	}
+	resp, err := client.Post(n.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack: %s", resp.Status)
+	}
+	return nil
+}
+
+// EmailNotifier mails alerts through the SMTP server at Addr, over TLS
+// when the server offers STARTTLS.
+type EmailNotifier struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+	To   []string
+	// Timeout bounds the whole exchange with the server, 10s when 0.
+	Timeout time.Duration
+}
+
+func (n EmailNotifier) Notify(a Alert) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\n", n.From, strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [%s] %s %s\r\n\r\n", a.Severity, a.MachineID, a.Kind)
+	fmt.Fprintf(&msg, "%s\r\nTime: %s\r\n", a, a.Time.Format(time.RFC3339))
+	if a.TransactionID != "" {
+		fmt.Fprintf(&msg, "Transaction: %s\r\n", a.TransactionID)
+	}
+	return n.send([]byte(msg.String()))
+}
+
+// send is smtp.SendMail with a deadline.
+func (n EmailNotifier) send(msg []byte) error {
+	timeout := n.Timeout
+	if timeout <= 0 {
+		timeout = alertTimeout
+	}
+	conn, err := net.DialTimeout("tcp", n.Addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	host, _, _ := net.SplitHostPort(n.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.Auth != nil {
+		if err := c.Auth(n.Auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	for _, to := range n.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// SMSSender sends a text message; an adapter over the operator's SMS
+// gateway plugs in here and should time out on its own.
+type SMSSender interface {
+	SendSMS(to, text string) error
+}
+
+// SMSNotifier texts alerts to the phone numbers in To.
+type SMSNotifier struct {
+	Sender SMSSender
+	To     []string
+}
+
+func (n SMSNotifier) Notify(a Alert) error {
+	var errs []error
+	for _, to := range n.To {
+		if err := n.Sender.SendSMS(to, fmt.Sprintf("[%s] %s", a.Severity, a)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
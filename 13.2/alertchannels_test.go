@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingNotifier struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (n *recordingNotifier) Notify(a Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.alerts = append(n.alerts, a)
+	return nil
+}
+
+func TestThrottledNotifierKeysOnDetail(t *testing.T) {
+	clock := &FakeClock{T: time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)}
+	next := &recordingNotifier{}
+	n := &ThrottledNotifier{Next: next, Clock: clock, Window: 10 * time.Minute}
+	fault := func(detail string) Alert {
+		return Alert{Kind: AlertHardwareFault, Severity: SeverityCritical, Detail: detail}
+	}
+	must(t, n.Notify(fault("printer: paper jam")))
+	must(t, n.Notify(fault("printer: paper jam")))
+	must(t, n.Notify(fault("coin_acceptor: jam")))
+	clock.Advance(11 * time.Minute)
+	must(t, n.Notify(fault("printer: paper jam")))
+	var got []string
+	for _, a := range next.alerts {
+		got = append(got, a.Detail)
+	}
+	want := "printer: paper jam,coin_acceptor: jam,printer: paper jam"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("passed %q, want %q", got, want)
+	}
+	if s := next.alerts[2].Suppressed; s != 1 {
+		t.Errorf("suppressed %d, want 1", s)
+	}
+}
+
+type blockingNotifier struct {
+	started chan struct{}
+	release chan struct{}
+	recordingNotifier
+}
+
+func (n *blockingNotifier) Notify(a Alert) error {
+	n.started <- struct{}{}
+	<-n.release
+	return n.recordingNotifier.Notify(a)
+}
+
+func TestAlertQueueDoesNotBlockTheSale(t *testing.T) {
+	slow := &blockingNotifier{started: make(chan struct{}, 2), release: make(chan struct{})}
+	q := NewAlertQueue(slow, 1)
+	m, _ := newTestMachine(t)
+	m.Notifier = q
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.notify(Alert{Kind: AlertSoldOut, TicketType: "metro"})
+		<-slow.started
+		m.notify(Alert{Kind: AlertSoldOut, TicketType: "bus"})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notify waited for a slow notifier")
+	}
+	// One alert is being delivered and one waits; the queue is full.
+	if err := q.Notify(Alert{Kind: AlertLowStock}); err == nil {
+		t.Error("full queue took an alert")
+	}
+	close(slow.release)
+	q.Close()
+	q.Close()
+	if n := len(slow.alerts); n != 2 {
+		t.Errorf("delivered %d alerts, want 2", n)
+	}
+}
+
+func TestEmailNotifierTimesOut(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	must(t, err)
+	defer l.Close()
+	go func() {
+		// Accept and never greet, like a hung server.
+		c, err := l.Accept()
+		if err == nil {
+			defer c.Close()
+			time.Sleep(2 * time.Second)
+		}
+	}()
+	n := EmailNotifier{Addr: l.Addr().String(), From: "tm@example.com", To: []string{"ops@example.com"}, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	err = n.Notify(Alert{Kind: AlertCoinJam})
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("err %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %s", d)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// AlertKind classifies operational alerts.
+type AlertKind string
+
+const (
+	AlertLowStock AlertKind = "low_stock"
+	AlertSoldOut  AlertKind = "sold_out"
+	AlertPaperLow AlertKind = "paper_low"
+	AlertPaperOut AlertKind = "paper_out"
+	AlertSecurity AlertKind = "security"
+	// AlertChangeFault reports a change dispenser failure; AlertAttendant
+	// asks staff to pay out change owed by hand.
+	AlertChangeFault AlertKind = "change_fault"
+	AlertAttendant   AlertKind = "attendant"
+	AlertCoinJam     AlertKind = "coin_jam"
+	// AlertBackupFailed reports a scheduled backup that could not be made.
+	AlertBackupFailed AlertKind = "backup_failed"
+	// AlertLowChange reports the change stock running below the exact
+	// change threshold.
+	AlertLowChange AlertKind = "low_change"
+	// AlertHardwareFault reports a device failure that took the machine
+	// out of service.
+	AlertHardwareFault AlertKind = "hardware_fault"
+	// AlertPaymentFailures reports PaymentFailureAlertAt card payments in
+	// a row that the gateway could not process.
+	AlertPaymentFailures AlertKind = "payment_failures"
+)
+
+// Severity ranks alerts for routing; notify fills it in by kind when it
+// is not set.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota + 1
+	SeverityWarning
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+var alertSeverities = map[AlertKind]Severity{
+	AlertLowStock:        SeverityInfo,
+	AlertPaperLow:        SeverityInfo,
+	AlertSoldOut:         SeverityWarning,
+	AlertLowChange:       SeverityWarning,
+	AlertBackupFailed:    SeverityWarning,
+	AlertAttendant:       SeverityWarning,
+	AlertPaymentFailures: SeverityWarning,
+	AlertPaperOut:        SeverityCritical,
+	AlertChangeFault:     SeverityCritical,
+	AlertCoinJam:         SeverityCritical,
+	AlertSecurity:        SeverityCritical,
+	AlertHardwareFault:   SeverityCritical,
+}
+
+// Alert is a message for the operations team.
+type Alert struct {
+	Time       time.Time
+	MachineID  string
+	StationID  string
+	Kind       AlertKind
+	Severity   Severity
+	TicketType string
+	Stock      int
+	// Detail describes alerts that are not about stock.
+	Detail string
+	// TransactionID is the sale the alert was raised during, if any.
+	TransactionID string
+	// Suppressed counts the alerts like this one a ThrottledNotifier held
+	// back since the last one it let through.
+	Suppressed int
+}
+
+func (a Alert) String() string {
+	id := a.MachineID
+	if a.StationID != "" {
+		id += "@" + a.StationID
+	}
+	var s string
+	if a.Detail != "" {
+		s = fmt.Sprintf("%s %s: %s", id, a.Kind, a.Detail)
+	} else {
+		s = fmt.Sprintf("%s %s: %s stock %d", id, a.Kind, a.TicketType, a.Stock)
+	}
+	if a.Suppressed > 0 {
+		s += fmt.Sprintf(" (%d more suppressed)", a.Suppressed)
+	}
+	return s
+}
+
+// Notifier delivers alerts, e.g. to a pager or a chat channel.
+type Notifier interface {
+	Notify(a Alert) error
+}
+
+// LogNotifier prints alerts to W, stdout when nil.
+type LogNotifier struct {
+	W io.Writer
+}
+
+func (n LogNotifier) Notify(a Alert) error {
+	fmt.Fprintln(orStdout(n.W), "ALERT:", a)
+	return nil
+}
+
+// takeStock removes sold tickets from stock and alerts when the product
+// drops to its low-stock threshold or sells out.
+func (m *TicketMachine) takeStock(ticketType string, n int) {
+	before := m.Catalog.Stock(ticketType)
+	m.Catalog.take(ticketType, n)
+	m.persist(ticketType)
+	m.recordMovement(ticketType, -n, "customer", MoveSale, m.TransactionID)
+	after := m.Catalog.Stock(ticketType)
+	p, _ := m.Catalog.Product(ticketType)
+	switch {
+	case after <= 0 && before > 0:
+		m.notify(Alert{Kind: AlertSoldOut, TicketType: ticketType, Stock: after})
+		m.emit(ProductSoldOut{TransactionID: m.TransactionID, TicketType: ticketType})
+	case after <= p.LowStockAt && before > p.LowStockAt:
+		m.notify(Alert{Kind: AlertLowStock, TicketType: ticketType, Stock: after})
+	}
+}
+
+func (m *TicketMachine) notify(a Alert) {
+	if m.Notifier == nil {
+		return
+	}
+	a.Time, a.MachineID, a.StationID = m.Clock.Now(), m.MachineID, m.Location.StationID
+	a.TransactionID = m.saleID()
+	if a.Severity == 0 {
+		a.Severity = SeverityWarning
+		if s, ok := alertSeverities[a.Kind]; ok {
+			a.Severity = s
+		}
+	}
+	m.log(slog.LevelInfo, "alert", slog.String("kind", string(a.Kind)), slog.String("severity", a.Severity.String()),
+		slog.String("detail", a.String()))
+	if err := m.Notifier.Notify(a); err != nil {
+		m.warn("alert not delivered: %v", err)
+	}
+}
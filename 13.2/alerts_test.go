@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestStockAlerts(t *testing.T) {
+	tests := []struct {
+		name     string
+		sell     int
+		kind     AlertKind
+		severity Severity
+		stock    int
+	}{
+		{"above the threshold", 7, "", 0, 0},
+		{"reaches the threshold", 8, AlertLowStock, SeverityInfo, 2},
+		{"already low", 9, AlertLowStock, SeverityInfo, 2},
+		{"sold out", 10, AlertSoldOut, SeverityWarning, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			n := &recordingNotifier{}
+			m.Notifier = n
+			for i := 0; i < tt.sell; i++ {
+				sellMetro(t, m)
+				must(t, m.StartOver())
+			}
+			var last Alert
+			for _, a := range n.alerts {
+				if a.TicketType == "metro" {
+					last = a
+				}
+			}
+			if last.Kind != tt.kind || last.Severity != tt.severity || last.Stock != tt.stock {
+				t.Fatalf("last metro alert = %+v, want %s at stock %d", last, tt.kind, tt.stock)
+			}
+			if tt.kind != "" && (last.MachineID != m.MachineID || last.TransactionID == "") {
+				t.Fatalf("alert not stamped: %+v", last)
+			}
+		})
+	}
+}
+
+func TestLowStockAlertOnce(t *testing.T) {
+	m, _ := newTestMachine(t)
+	n := &recordingNotifier{}
+	m.Notifier = n
+	for i := 0; i < 9; i++ {
+		sellMetro(t, m)
+		must(t, m.StartOver())
+	}
+	low := 0
+	for _, a := range n.alerts {
+		if a.Kind == AlertLowStock {
+			low++
+		}
+	}
+	if low != 1 {
+		t.Fatalf("%d low-stock alerts, want 1", low)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// APIHandler is the local API of the machine for the host and field
+// technicians: /healthz and /readyz, /metrics, and the dashboard at /.
+// Close stops the health monitor and the dashboard behind it.
+type APIHandler struct {
+	http.Handler
+	health    *HealthMonitor
+	dashboard *Dashboard
+}
+
+// API starts the monitors the local API serves from.
+func (m *TicketMachine) API() *APIHandler {
+	a := &APIHandler{health: m.HealthMonitor(), dashboard: m.Dashboard(0)}
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", a.health.HealthHandler())
+	mux.Handle("/readyz", a.health.ReadinessHandler())
+	mux.Handle("/metrics", m.MetricsHandler())
+	mux.Handle("/", a.dashboard)
+	a.Handler = mux
+	return a
+}
+
+// Close detaches the monitors from the machine.
+func (a *APIHandler) Close() {
+	a.health.Close()
+	a.dashboard.Close()
+}
+
+// ServeAPI serves the local API on port of the loopback interface until
+// the returned func is called; port 0 serves nothing.
+func (m *TicketMachine) ServeAPI(port int) (stop func(), err error) {
+	if port == 0 {
+		return func() {}, nil
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	if err != nil {
+		return nil, newErrorf(CodeInvalidConfig, "local API: %w", err)
+	}
+	a := m.API()
+	srv := &http.Server{Handler: a, ReadHeaderTimeout: 5 * time.Second}
+	go srv.Serve(ln)
+	return func() {
+		srv.Close()
+		a.Close()
+	}, nil
+}
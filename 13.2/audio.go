@@ -0,0 +1,100 @@
+package main
+
+// AudioEvent is a moment of the customer flow that can be voiced.
+type AudioEvent string
+
+const (
+	AudioCoinAccepted    AudioEvent = "coin_accepted"
+	AudioPaymentComplete AudioEvent = "payment_complete"
+	AudioTicketReady     AudioEvent = "ticket_ready"
+	AudioTakeChange      AudioEvent = "take_change"
+	AudioCanceled        AudioEvent = "canceled"
+	AudioError           AudioEvent = "error"
+)
+
+// AudioPrompt is what is played for an event: a sound, a spoken text, or
+// both.
+type AudioPrompt struct {
+	Event  AudioEvent
+	Locale string
+	Sound  string
+	Speech string
+}
+
+// AudioSink plays prompts on the machine's speaker.
+type AudioSink interface {
+	Play(p AudioPrompt) error
+}
+
+// MockAudioSink records the prompts played.
+type MockAudioSink struct {
+	Played []AudioPrompt
+}
+
+func (s *MockAudioSink) Play(p AudioPrompt) error {
+	s.Played = append(s.Played, p)
+	return nil
+}
+
+// DefaultAudioPrompts are the prompts per locale and event. An event
+// missing from a locale falls back to English; one missing from English
+// stays silent.
+func DefaultAudioPrompts() map[string]map[AudioEvent]AudioPrompt {
+	return map[string]map[AudioEvent]AudioPrompt{
+		"en": {
+			AudioCoinAccepted:    {Sound: "chime"},
+			AudioPaymentComplete: {Sound: "confirm", Speech: "Payment complete."},
+			AudioTicketReady:     {Sound: "ticket", Speech: "Please take your ticket."},
+			AudioTakeChange:      {Sound: "coins", Speech: "Please take your ticket and change."},
+			AudioCanceled:        {Speech: "Transaction canceled."},
+			AudioError:           {Sound: "error", Speech: "Sorry, something went wrong."},
+		},
+		"ru": {
+			AudioPaymentComplete: {Sound: "confirm", Speech: "Оплата принята."},
+			AudioTicketReady:     {Sound: "ticket", Speech: "Возьмите билет."},
+			AudioTakeChange:      {Sound: "coins", Speech: "Возьмите билет и сдачу."},
+			AudioCanceled:        {Speech: "Операция отменена."},
+			AudioError:           {Sound: "error", Speech: "Произошла ошибка."},
+		},
+		"kk": {
+			AudioPaymentComplete: {Sound: "confirm", Speech: "Төлем қабылданды."},
+			AudioTicketReady:     {Sound: "ticket", Speech: "Билетті алыңыз."},
+			AudioTakeChange:      {Sound: "coins", Speech: "Билет пен қайтарымды алыңыз."},
+			AudioCanceled:        {Speech: "Операция тоқтатылды."},
+			AudioError:           {Sound: "error", Speech: "Қате орын алды."},
+		},
+	}
+}
+
+// cue plays the prompt for ev in the audio locale.
+func (m *TicketMachine) cue(ev AudioEvent) {
+	if m.Audio == nil {
+		return
+	}
+	p, ok := m.AudioPrompts[m.AudioLocale][ev]
+	if !ok {
+		if p, ok = m.AudioPrompts["en"][ev]; !ok {
+			return
+		}
+	}
+	p.Event, p.Locale = ev, m.AudioLocale
+	if err := m.Audio.Play(p); err != nil {
+		m.warn("audio: %v", err)
+	}
+}
+
+// cueState plays the prompt for entering s, if it has one.
+func (m *TicketMachine) cueState(s State) {
+	switch s.(type) {
+	case *MoneyReceivedState:
+		m.cue(AudioPaymentComplete)
+	case *TicketDispensedState:
+		m.cue(AudioTicketReady)
+	case *ChangeDispensedState:
+		m.cue(AudioTakeChange)
+	case *TransactionCanceledState:
+		m.cue(AudioCanceled)
+	case *CardDeclinedState, *PrintErrorState, *CoinJamState:
+		m.cue(AudioError)
+	}
+}
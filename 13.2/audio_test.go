@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestAudioCues(t *testing.T) {
+	tests := []struct {
+		locale string
+		cancel bool
+		want   []AudioPrompt
+	}{
+		{"en", false, []AudioPrompt{
+			{Event: AudioCoinAccepted, Sound: "chime"},
+			{Event: AudioPaymentComplete, Sound: "confirm", Speech: "Payment complete."},
+			{Event: AudioCoinAccepted, Sound: "chime"},
+			{Event: AudioTicketReady, Sound: "ticket", Speech: "Please take your ticket."},
+		}},
+		{"ru", true, []AudioPrompt{
+			// Russian has no coin sound and falls back to English.
+			{Event: AudioCoinAccepted, Sound: "chime"},
+			{Event: AudioCanceled, Speech: "Операция отменена."},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			sink := &MockAudioSink{}
+			m.Audio, m.AudioLocale = sink, tt.locale
+			if tt.cancel {
+				must(t, m.SelectTicket("metro", 1))
+				must(t, m.InsertMoney(KZT(200)))
+				must(t, m.Cancel())
+			} else {
+				sellMetro(t, m)
+			}
+			if len(sink.Played) != len(tt.want) {
+				t.Fatalf("played %+v", sink.Played)
+			}
+			for i, w := range tt.want {
+				w.Locale = tt.locale
+				if sink.Played[i] != w {
+					t.Errorf("prompt %d = %+v, want %+v", i, sink.Played[i], w)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// AuditCheckpoint is a signed statement of the head of the audit chain:
+// entries up to Seq, ending in Hash, were in the log at Time.
+type AuditCheckpoint struct {
+	Time      time.Time
+	MachineID string
+	Seq       int
+	Hash      string
+	KeyID     string
+	Signature []byte
+}
+
+// chainHash is the SHA-256 of the entry fields and the hash of the entry
+// before it, so that editing, removing or reordering an entry breaks the
+// chain from there on.
+func (e AuditEntry) chainHash() string {
+	h := sha256.New()
+	for _, f := range []string{
+		strconv.Itoa(e.Seq), e.PrevHash, e.Time.UTC().Format(time.RFC3339Nano), e.MachineID, e.StationID,
+		e.OperatorID, e.TransactionID, e.Action, e.Detail,
+	} {
+		h.Write([]byte(f))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c AuditCheckpoint) message() []byte {
+	return []byte("audit-checkpoint\x00" + c.MachineID + "\x00" + strconv.Itoa(c.Seq) + "\x00" + c.Hash +
+		"\x00" + c.Time.UTC().Format(time.RFC3339Nano))
+}
+
+// auditHead is the seq and hash of the last audit entry. The machine
+// keeps them apart from AuditLog, so the chain carries on when retention
+// prunes the log; a log set from outside that is further ahead wins.
+func (m *TicketMachine) auditHead() (seq int, hash string) {
+	if n := len(m.AuditLog); n > 0 && m.AuditLog[n-1].Seq > m.auditSeq {
+		last := m.AuditLog[n-1]
+		m.auditSeq, m.auditHash = last.Seq, last.Hash
+	}
+	return m.auditSeq, m.auditHash
+}
+
+// checkpointIfDue signs the head of the chain when the entry completes
+// another AuditCheckpointEvery entries.
+func (m *TicketMachine) checkpointIfDue(e AuditEntry) {
+	if m.AuditSigner == nil || m.AuditCheckpointEvery <= 0 || e.Seq%m.AuditCheckpointEvery != 0 {
+		return
+	}
+	if _, err := m.AuditCheckpoint(); err != nil {
+		m.warn("audit checkpoint: %v", err)
+	}
+}
+
+// AuditCheckpoint signs the head of the audit chain now, e.g. before a
+// cash collection is handed over.
+func (m *TicketMachine) AuditCheckpoint() (AuditCheckpoint, error) {
+	if m.AuditSigner == nil {
+		return AuditCheckpoint{}, newError(CodeInvalidConfig, "no audit signer")
+	}
+	seq, head := m.auditHead()
+	if seq == 0 {
+		return AuditCheckpoint{}, newError(CodeInvalidState, "audit log is empty")
+	}
+	c := AuditCheckpoint{Time: m.Clock.Now(), MachineID: m.MachineID, Seq: seq, Hash: head, KeyID: m.AuditSigner.KeyID()}
+	sig, err := m.AuditSigner.Sign(c.message())
+	if err != nil {
+		return AuditCheckpoint{}, err
+	}
+	c.Signature = sig
+	m.AuditCheckpoints = append(m.AuditCheckpoints, c)
+	m.log(slog.LevelInfo, "audit checkpoint", slog.Int("seq", c.Seq), slog.String("hash", c.Hash))
+	return c, nil
+}
+
+// VerifyAuditLog checks that the entries chain up, and that every
+// checkpoint still covered by them is signed by a key of v and matches
+// the entry it names. The first entry anchors the chain, so a log pruned
+// by retention still verifies; a checkpoint past the last entry, or any
+// checkpoint with no entries at all, means the tail of the log was cut.
+// With a nil v the signatures are not checked.
+func VerifyAuditLog(entries []AuditEntry, checkpoints []AuditCheckpoint, v *TicketVerifier) error {
+	bySeq := map[int]AuditEntry{}
+	for i, e := range entries {
+		if i > 0 {
+			prev := entries[i-1]
+			if e.Seq != prev.Seq+1 {
+				return newErrorf(CodeAuditTampered, "audit entry %d follows %d", e.Seq, prev.Seq)
+			}
+			if e.PrevHash != prev.Hash {
+				return newErrorf(CodeAuditTampered, "audit entry %d does not chain to %d", e.Seq, prev.Seq)
+			}
+		}
+		if e.Hash != e.chainHash() {
+			return newErrorf(CodeAuditTampered, "audit entry %d was altered", e.Seq)
+		}
+		bySeq[e.Seq] = e
+	}
+	for _, c := range checkpoints {
+		if v != nil {
+			if err := v.VerifySignature(c.KeyID, c.message(), c.Signature); err != nil {
+				return newErrorf(CodeAuditTampered, "audit checkpoint %d: %v", c.Seq, err)
+			}
+		}
+		if len(entries) > 0 && c.Seq < entries[0].Seq {
+			continue
+		}
+		e, ok := bySeq[c.Seq]
+		if !ok {
+			return newErrorf(CodeAuditTampered, "audit log ends before checkpoint %d", c.Seq)
+		}
+		if e.Hash != c.Hash {
+			return newErrorf(CodeAuditTampered, "audit entry %d does not match its checkpoint", c.Seq)
+		}
+	}
+	return nil
+}
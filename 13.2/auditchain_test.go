@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// auditedMachine is a machine with a few audit entries, checkpointed
+// every second one.
+func auditedMachine(t *testing.T) (*TicketMachine, *FakeClock, *TicketVerifier) {
+	t.Helper()
+	m, clock := newTestMachine(t)
+	key := &HMACSigner{ID: "audit-1", Key: []byte("audit-secret")}
+	m.AuditSigner, m.AuditCheckpointEvery = key, 2
+	v := NewTicketVerifier(clock)
+	v.AddHMACKey(key.ID, key.Key)
+	for i := 0; i < 4; i++ {
+		m.audit("admin", "test", "entry")
+		clock.Advance(time.Hour)
+	}
+	return m, clock, v
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(entries []AuditEntry, checkpoints []AuditCheckpoint) ([]AuditEntry, []AuditCheckpoint)
+		ok     bool
+	}{
+		{"intact", func(e []AuditEntry, c []AuditCheckpoint) ([]AuditEntry, []AuditCheckpoint) { return e, c }, true},
+		{"pruned head", func(e []AuditEntry, c []AuditCheckpoint) ([]AuditEntry, []AuditCheckpoint) { return e[2:], c }, true},
+		{"edited", func(e []AuditEntry, c []AuditCheckpoint) ([]AuditEntry, []AuditCheckpoint) {
+			e[1].Detail = "nothing to see"
+			return e, c
+		}, false},
+		{"edited and rehashed", func(e []AuditEntry, c []AuditCheckpoint) ([]AuditEntry, []AuditCheckpoint) {
+			e[1].Detail = "nothing to see"
+			e[1].Hash = e[1].chainHash()
+			return e, c
+		}, false},
+		{"tail cut", func(e []AuditEntry, c []AuditCheckpoint) ([]AuditEntry, []AuditCheckpoint) { return e[:3], c }, false},
+		{"all deleted", func(e []AuditEntry, c []AuditCheckpoint) ([]AuditEntry, []AuditCheckpoint) { return nil, c }, false},
+		{"entry removed", func(e []AuditEntry, c []AuditCheckpoint) ([]AuditEntry, []AuditCheckpoint) {
+			return append(e[:1:1], e[2:]...), c
+		}, false},
+		{"bad signature", func(e []AuditEntry, c []AuditCheckpoint) ([]AuditEntry, []AuditCheckpoint) {
+			c[0].Signature = []byte("forged")
+			return e, c
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _, v := auditedMachine(t)
+			entries := append([]AuditEntry(nil), m.AuditLog...)
+			checkpoints := append([]AuditCheckpoint(nil), m.AuditCheckpoints...)
+			entries, checkpoints = tt.tamper(entries, checkpoints)
+			err := VerifyAuditLog(entries, checkpoints, v)
+			if tt.ok && err != nil {
+				t.Errorf("verify: %v", err)
+			}
+			if !tt.ok && CodeOf(err) != CodeAuditTampered {
+				t.Errorf("verify: %v, want %s", err, CodeAuditTampered)
+			}
+		})
+	}
+}
+
+func TestAuditChainSurvivesPrune(t *testing.T) {
+	m, clock, v := auditedMachine(t)
+	m.Retention.Keep = time.Hour
+	clock.Advance(48 * time.Hour)
+	if _, err := m.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if first := m.AuditLog[0]; first.Seq != 5 || first.PrevHash == "" {
+		t.Errorf("first entry after prune: seq %d, prev %q", first.Seq, first.PrevHash)
+	}
+	must(t, VerifyAuditLog(m.AuditLog, m.AuditCheckpoints, v))
+	m.AuditLog = nil
+	m.audit("admin", "test", "after a wipe")
+	if seq := m.AuditLog[0].Seq; seq != 6 {
+		t.Errorf("seq %d after the log was emptied, want 6", seq)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StockStatus is how available a product is.
+type StockStatus string
+
+const (
+	InStock  StockStatus = "in_stock"
+	LowStock StockStatus = "low_stock"
+	SoldOut  StockStatus = "sold_out"
+)
+
+// ProductAvailability is a product on sale with its stock status.
+type ProductAvailability struct {
+	Product
+	Status StockStatus
+}
+
+func (m *TicketMachine) stockStatus(p Product) StockStatus {
+	switch {
+	case p.Stock <= 0:
+		return SoldOut
+	case p.Stock <= p.LowStockAt:
+		return LowStock
+	}
+	return InStock
+}
+
+// ListAvailableProducts lists the active products, sold out ones
+// included, with their stock status.
+func (m *TicketMachine) ListAvailableProducts() []ProductAvailability {
+	var list []ProductAvailability
+	for _, p := range m.Catalog.List() {
+		if p.Active {
+			list = append(list, ProductAvailability{Product: p, Status: m.stockStatus(p)})
+		}
+	}
+	return list
+}
+
+// UnavailableReason says why a product cannot be sold.
+type UnavailableReason string
+
+const (
+	ReasonSoldOut  UnavailableReason = "sold out"
+	ReasonInactive UnavailableReason = "not on sale"
+	ReasonUnknown  UnavailableReason = "unknown product"
+)
+
+// UnavailableError is returned when a ticket type cannot be sold, with
+// the in-stock alternatives configured for it.
+type UnavailableError struct {
+	TicketType   string
+	Reason       UnavailableReason
+	Alternatives []string
+}
+
+func (e *UnavailableError) ErrorCode() ErrorCode { return CodeTicketUnavailable }
+
+func (e *UnavailableError) Error() string {
+	msg := fmt.Sprintf("ticket unavailable: %s %s", e.TicketType, e.Reason)
+	if len(e.Alternatives) > 0 {
+		msg += "; try " + strings.Join(e.Alternatives, ", ")
+	}
+	return msg
+}
+
+// unavailable explains why ticketType cannot be sold.
+func (m *TicketMachine) unavailable(ticketType string) error {
+	p, ok := m.Catalog.Product(ticketType)
+	e := &UnavailableError{TicketType: ticketType, Reason: ReasonSoldOut}
+	switch {
+	case !ok:
+		e.Reason = ReasonUnknown
+	case !p.Active:
+		e.Reason = ReasonInactive
+	}
+	for _, alt := range p.Alternatives {
+		if m.HasTicket(alt) {
+			e.Alternatives = append(e.Alternatives, alt)
+		}
+	}
+	return e
+}
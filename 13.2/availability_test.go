@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSoldOutProduct(t *testing.T) {
+	tests := []struct {
+		name         string
+		ticket       string
+		soldOut      []string
+		reason       UnavailableReason
+		alternatives []string
+	}{
+		{"suggests the carnet", "metro", []string{"metro"}, ReasonSoldOut, []string{"metro_carnet"}},
+		{"alternative sold out too", "metro", []string{"metro", "metro_carnet"}, ReasonSoldOut, nil},
+		{"unknown product", "monorail", nil, ReasonUnknown, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			for _, p := range tt.soldOut {
+				must(t, m.Catalog.Adjust(p, -m.Catalog.Stock(p)))
+			}
+			err := m.SelectTicket(tt.ticket, 1)
+			var u *UnavailableError
+			if !errors.As(err, &u) || CodeOf(err) != CodeTicketUnavailable {
+				t.Fatalf("SelectTicket = %v, want UnavailableError", err)
+			}
+			if u.Reason != tt.reason || !reflect.DeepEqual(u.Alternatives, tt.alternatives) {
+				t.Fatalf("reason %q, alternatives %v", u.Reason, u.Alternatives)
+			}
+		})
+	}
+}
+
+func TestListAvailableProducts(t *testing.T) {
+	m, _ := newTestMachine(t)
+	must(t, m.Catalog.Adjust("metro", -m.Catalog.Stock("metro")))
+	must(t, m.Catalog.Adjust("bus", 2-m.Catalog.Stock("bus")))
+	must(t, m.Catalog.Deactivate("tram"))
+	status := map[string]StockStatus{}
+	for _, p := range m.ListAvailableProducts() {
+		status[p.Type] = p.Status
+	}
+	if _, listed := status["tram"]; listed {
+		t.Fatal("inactive product listed")
+	}
+	want := map[string]StockStatus{"metro": SoldOut, "bus": LowStock, "train": InStock}
+	for p, s := range want {
+		if status[p] != s {
+			t.Errorf("%s status %q, want %q", p, status[p], s)
+		}
+	}
+}
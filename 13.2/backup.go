@@ -0,0 +1,315 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupDestination stores backups by name: a local directory, or an
+// uploader to the fleet's storage.
+type BackupDestination interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	List() ([]string, error)
+	Delete(name string) error
+}
+
+// DirDestination keeps backups as files in Dir, written atomically.
+type DirDestination struct {
+	Dir string
+}
+
+func (d DirDestination) Put(name string, data []byte) error {
+	if err := os.MkdirAll(d.Dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(d.Dir, ".backup-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(d.Dir, name))
+}
+
+func (d DirDestination) Get(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(d.Dir, name))
+}
+
+func (d DirDestination) List() ([]string, error) {
+	entries, err := os.ReadDir(d.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (d DirDestination) Delete(name string) error {
+	return os.Remove(filepath.Join(d.Dir, name))
+}
+
+// MemoryDestination keeps backups in memory, for tests and demos.
+type MemoryDestination struct {
+	Backups map[string][]byte
+}
+
+func (d *MemoryDestination) Put(name string, data []byte) error {
+	if d.Backups == nil {
+		d.Backups = map[string][]byte{}
+	}
+	d.Backups[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (d *MemoryDestination) Get(name string) ([]byte, error) {
+	data, ok := d.Backups[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return data, nil
+}
+
+func (d *MemoryDestination) List() ([]string, error) {
+	var names []string
+	for n := range d.Backups {
+		names = append(names, n)
+	}
+	return names, nil
+}
+
+func (d *MemoryDestination) Delete(name string) error {
+	delete(d.Backups, name)
+	return nil
+}
+
+// BackupScheduler backs up the machine every Interval: a gzipped tar of
+// the machine snapshot, Files, e.g. the journal, and Databases, with a
+// SHA256SUMS entry for each member. A sidecar NAME.sha256 holds the
+// checksum of the whole archive. Only the newest Keep backups are kept.
+// Last is the time of the last backup that succeeded, so a failed one is
+// tried again on the next Tick.
+type BackupScheduler struct {
+	Dest     BackupDestination
+	Interval time.Duration
+	Keep     int
+	Files    []string
+	// Databases are SQLite databases, copied live with VACUUM INTO so the
+	// copy is consistent even in WAL mode, under their name in the
+	// archive. Their files must not be in Files.
+	Databases map[string]*sql.DB
+	Last      time.Time
+}
+
+type backupMember struct {
+	name string
+	data []byte
+}
+
+// backupIfDue runs a backup when the interval has passed; the host loop
+// drives it through Tick.
+func (m *TicketMachine) backupIfDue() {
+	b := m.Backups
+	if b == nil || b.Interval <= 0 || m.Clock.Now().Sub(b.Last) < b.Interval {
+		return
+	}
+	if _, err := m.Backup(); err != nil {
+		m.audit("", "backup_failed", err.Error())
+		m.notify(Alert{Kind: AlertBackupFailed, Detail: err.Error()})
+	}
+}
+
+// Backup writes a backup now and rotates old ones; it returns the name of
+// the backup.
+func (m *TicketMachine) Backup() (string, error) {
+	b := m.Backups
+	if b == nil || b.Dest == nil {
+		return "", newError(CodeInvalidConfig, "no backup destination")
+	}
+	now := m.Clock.Now()
+	snap, err := m.MarshalJSON()
+	if err != nil {
+		return "", newErrorf(CodeStorage, "backup: %w", err)
+	}
+	members := []backupMember{{"snapshot.json", snap}}
+	for _, path := range b.Files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", newErrorf(CodeStorage, "backup: %w", err)
+		}
+		members = append(members, backupMember{filepath.Base(path), data})
+	}
+	var dbs []string
+	for name := range b.Databases {
+		dbs = append(dbs, name)
+	}
+	sort.Strings(dbs)
+	for _, name := range dbs {
+		data, err := vacuumCopy(b.Databases[name])
+		if err != nil {
+			return "", newErrorf(CodeStorage, "backup %s: %w", name, err)
+		}
+		members = append(members, backupMember{name, data})
+	}
+	var sums bytes.Buffer
+	for _, mb := range members {
+		sum := sha256.Sum256(mb.data)
+		fmt.Fprintf(&sums, "%s  %s\n", hex.EncodeToString(sum[:]), mb.name)
+	}
+	var archive bytes.Buffer
+	zw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(zw)
+	for _, mb := range append(members, backupMember{"SHA256SUMS", sums.Bytes()}) {
+		hdr := &tar.Header{Name: mb.name, Mode: 0o600, Size: int64(len(mb.data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", err
+		}
+		if _, err := tw.Write(mb.data); err != nil {
+			return "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	name := m.MachineID + "-" + now.UTC().Format("20060102T150405Z") + ".tar.gz"
+	sum := sha256.Sum256(archive.Bytes())
+	if err := b.Dest.Put(name, archive.Bytes()); err != nil {
+		return "", newErrorf(CodeStorage, "backup %s: %w", name, err)
+	}
+	if err := b.Dest.Put(name+".sha256", []byte(hex.EncodeToString(sum[:])+"\n")); err != nil {
+		return "", newErrorf(CodeStorage, "backup %s: %w", name, err)
+	}
+	b.Last = now
+	m.audit("", "backup", name)
+	if err := m.rotateBackups(); err != nil {
+		m.warn("backup rotation: %v", err)
+	}
+	return name, nil
+}
+
+// vacuumCopy is a consistent copy of a live database, written by VACUUM
+// INTO to a file of its own, which must not exist yet.
+func vacuumCopy(db *sql.DB) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "copy.db")
+	if _, err := db.Exec(`VACUUM INTO ?`, path); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// rotateBackups deletes all but the newest Keep backups of the machine.
+func (m *TicketMachine) rotateBackups() error {
+	b := m.Backups
+	if b.Keep <= 0 {
+		return nil
+	}
+	names, err := b.Dest.List()
+	if err != nil {
+		return err
+	}
+	var ours []string
+	for _, n := range names {
+		if strings.HasPrefix(n, m.MachineID+"-") && strings.HasSuffix(n, ".tar.gz") {
+			ours = append(ours, n)
+		}
+	}
+	sort.Strings(ours)
+	for len(ours) > b.Keep {
+		for _, n := range []string{ours[0], ours[0] + ".sha256"} {
+			if err := b.Dest.Delete(n); err != nil {
+				return err
+			}
+		}
+		ours = ours[1:]
+	}
+	return nil
+}
+
+// VerifyBackup checks a backup against its sidecar checksum and the
+// checksum of every member.
+func VerifyBackup(dest BackupDestination, name string) error {
+	data, err := dest.Get(name)
+	if err != nil {
+		return newErrorf(CodeStorage, "backup %s: %w", name, err)
+	}
+	want, err := dest.Get(name + ".sha256")
+	if err != nil {
+		return newErrorf(CodeStorage, "backup %s: no checksum: %w", name, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != strings.TrimSpace(string(want)) {
+		return newErrorf(CodeStorage, "backup %s: checksum mismatch", name)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return newErrorf(CodeStorage, "backup %s: %w", name, err)
+	}
+	tr := tar.NewReader(zr)
+	got := map[string]string{}
+	var sums string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return newErrorf(CodeStorage, "backup %s: %w", name, err)
+		}
+		raw, err := io.ReadAll(tr)
+		if err != nil {
+			return newErrorf(CodeStorage, "backup %s: %w", name, err)
+		}
+		if hdr.Name == "SHA256SUMS" {
+			sums = string(raw)
+			continue
+		}
+		s := sha256.Sum256(raw)
+		got[hdr.Name] = hex.EncodeToString(s[:])
+	}
+	for _, line := range strings.Split(strings.TrimSpace(sums), "\n") {
+		sum, member, ok := strings.Cut(line, "  ")
+		if !ok || got[member] != sum {
+			return newErrorf(CodeStorage, "backup %s: member %s corrupt", name, member)
+		}
+		delete(got, member)
+	}
+	if len(got) > 0 {
+		return newErrorf(CodeStorage, "backup %s: members without checksum", name)
+	}
+	return nil
+}
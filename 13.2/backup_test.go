@@ -0,0 +1,78 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backupMembers reads the members of a backup archive.
+func backupMembers(t *testing.T, archive []byte) map[string]string {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(archive))
+	must(t, err)
+	tr := tar.NewReader(zr)
+	members := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members
+		}
+		must(t, err)
+		raw, err := io.ReadAll(tr)
+		must(t, err)
+		members[hdr.Name] = string(raw)
+	}
+}
+
+func TestBackupCopiesDatabases(t *testing.T) {
+	db, _ := newFakeSQL(t)
+	m, _ := newTestMachine(t)
+	must(t, m.UseStore(&SQLStore{DB: db}))
+	sellMetro(t, m)
+	dest := &MemoryDestination{}
+	m.Backups = &BackupScheduler{Dest: dest, Databases: map[string]*sql.DB{"ledger.db": db}}
+	name, err := m.Backup()
+	must(t, err)
+	must(t, VerifyBackup(dest, name))
+	ledger := backupMembers(t, dest.Backups[name])["ledger.db"]
+	if !strings.Contains(ledger, "transactions 1\n") {
+		t.Errorf("ledger.db member:\n%s", ledger)
+	}
+}
+
+// flakyDestination refuses backups while down.
+type flakyDestination struct {
+	MemoryDestination
+	down bool
+}
+
+func (d *flakyDestination) Put(name string, data []byte) error {
+	if d.down {
+		return errors.New("upload failed")
+	}
+	return d.MemoryDestination.Put(name, data)
+}
+
+func TestBackupRetriedAfterFailure(t *testing.T) {
+	m, clock := newTestMachine(t)
+	dest := &flakyDestination{down: true}
+	last := clock.Now().Add(-2 * time.Hour)
+	m.Backups = &BackupScheduler{Dest: dest, Interval: time.Hour, Last: last}
+	m.backupIfDue()
+	if !m.Backups.Last.Equal(last) {
+		t.Fatalf("Last moved to %s by a failed backup", m.Backups.Last)
+	}
+	dest.down = false
+	clock.Advance(time.Minute)
+	m.backupIfDue()
+	if !m.Backups.Last.Equal(clock.Now()) || len(dest.Backups) == 0 {
+		t.Errorf("backup not retried: Last %s, %d files", m.Backups.Last, len(dest.Backups))
+	}
+}
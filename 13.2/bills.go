@@ -0,0 +1,149 @@
+package main
+
+// BillEscrowed is reported by a bill validator when it holds a note in
+// escrow, waiting for the machine to stack or return it.
+type BillEscrowed struct {
+	Amount Money
+}
+
+// BillValidator is the banknote validator driver. A note reported on
+// Events stays in escrow until Stack moves it into the cash box or Return
+// hands it back; no further note is taken in meanwhile.
+type BillValidator interface {
+	Enable() error
+	Disable() error
+	Events() <-chan BillEscrowed
+	Stack() error
+	Return() error
+}
+
+// SimulatedBillValidator stands in for validator hardware with a single
+// note escrow.
+type SimulatedBillValidator struct {
+	Enabled  bool
+	Escrow   Money
+	Stacked  []Money
+	Returned []Money
+	events   chan BillEscrowed
+}
+
+func NewSimulatedBillValidator() *SimulatedBillValidator {
+	return &SimulatedBillValidator{events: make(chan BillEscrowed, 1)}
+}
+
+func (v *SimulatedBillValidator) Enable() error  { v.Enabled = true; return nil }
+func (v *SimulatedBillValidator) Disable() error { v.Enabled = false; return nil }
+
+func (v *SimulatedBillValidator) Events() <-chan BillEscrowed { return v.events }
+
+func (v *SimulatedBillValidator) SelfTest() error { return nil }
+
+// Insert simulates a note fed into the validator. It is refused while the
+// validator is disabled or its escrow is occupied.
+func (v *SimulatedBillValidator) Insert(note Money) bool {
+	if !v.Enabled || v.Escrow != 0 {
+		return false
+	}
+	v.Escrow = note
+	v.events <- BillEscrowed{Amount: note}
+	return true
+}
+
+func (v *SimulatedBillValidator) Stack() error {
+	if v.Escrow == 0 {
+		return newError(CodeHardware, "no note in escrow")
+	}
+	v.Stacked = append(v.Stacked, v.Escrow)
+	v.Escrow = 0
+	return nil
+}
+
+func (v *SimulatedBillValidator) Return() error {
+	if v.Escrow == 0 {
+		return newError(CodeHardware, "no note in escrow")
+	}
+	v.Returned = append(v.Returned, v.Escrow)
+	v.Escrow = 0
+	return nil
+}
+
+// syncBillValidator enables the bill validator only while cash is
+// accepted.
+func (m *TicketMachine) syncBillValidator() {
+	if m.Bills == nil {
+		return
+	}
+	var err error
+	if m.takesCash() {
+		err = m.Bills.Enable()
+	} else {
+		err = m.Bills.Disable()
+	}
+	if err != nil {
+		m.publish(HardwareEvent{Kind: EventFault, Device: "bill_validator", Err: err})
+	}
+}
+
+// PollBills publishes the notes waiting in escrow.
+func (m *TicketMachine) PollBills() {
+	if m.Bills == nil {
+		return
+	}
+	for {
+		select {
+		case ev := <-m.Bills.Events():
+			m.publish(HardwareEvent{Kind: EventBillEscrowed, Device: "bill_validator", Amount: ev.Amount})
+		default:
+			return
+		}
+	}
+}
+
+// billEscrowed credits a note in escrow. A note that completes the
+// payment stays in escrow until the sale is committed, so it can be handed
+// back untouched on cancel; other notes are stacked at once to make room
+// for the next one. Refused notes are returned.
+func (m *TicketMachine) billEscrowed(ev HardwareEvent) {
+	if err := m.InsertMoney(ev.Amount); err != nil {
+		m.show("Note returned: %s (%v)", ev.Amount.In(m.Currency), err)
+		m.billCommand(m.Bills.Return)
+		return
+	}
+	if _, paid := m.State.(*MoneyReceivedState); paid {
+		m.escrow = ev.Amount
+	} else {
+		m.billCommand(m.Bills.Stack)
+	}
+}
+
+// stackEscrow moves the note held in escrow into the cash box once the
+// sale is committed.
+func (m *TicketMachine) stackEscrow() {
+	if m.escrow == 0 {
+		return
+	}
+	m.escrow = 0
+	m.billCommand(m.Bills.Stack)
+}
+
+// returnEscrow hands back the note held in escrow and returns its amount.
+func (m *TicketMachine) returnEscrow() Money {
+	note := m.escrow
+	if note == 0 {
+		return 0
+	}
+	m.escrow = 0
+	if !m.billCommand(m.Bills.Return) {
+		return 0
+	}
+	m.show("Returned from escrow: %s", note.In(m.Currency))
+	return note
+}
+
+func (m *TicketMachine) billCommand(cmd func() error) bool {
+	if err := cmd(); err != nil {
+		m.warn("bill validator: %v", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestBillEscrow(t *testing.T) {
+	for _, cancel := range []bool{false, true} {
+		name := "sale stacks the note"
+		if cancel {
+			name = "cancel returns the note"
+		}
+		t.Run(name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			bills := NewSimulatedBillValidator()
+			m.Bills = bills
+			must(t, m.SelectTicket("metro", 1))
+			bills.Insert(KZT(3))
+			m.PollBills()
+			bills.Insert(KZT(200))
+			m.PollBills()
+			if len(bills.Returned) != 1 || len(bills.Stacked) != 1 || m.InsertedMoney != KZT(200) {
+				t.Fatalf("returned %v, stacked %v, inserted %s", bills.Returned, bills.Stacked, m.InsertedMoney)
+			}
+			bills.Insert(KZT(100))
+			m.PollBills()
+			if bills.Escrow != KZT(100) || len(bills.Stacked) != 1 {
+				t.Fatalf("escrow %s, stacked %v: paying note not held", bills.Escrow, bills.Stacked)
+			}
+			if bills.Insert(KZT(100)) {
+				t.Fatal("note taken in with the escrow occupied")
+			}
+			if cancel {
+				must(t, m.Cancel())
+				if len(bills.Returned) != 2 || bills.Returned[1] != KZT(100) {
+					t.Fatalf("returned %v, want the escrowed note back", bills.Returned)
+				}
+				return
+			}
+			_, err := m.DispenseTicket()
+			must(t, err)
+			if len(bills.Stacked) != 2 || bills.Escrow != 0 || bills.Enabled {
+				t.Fatalf("stacked %v, escrow %s, enabled %v after the sale", bills.Stacked, bills.Escrow, bills.Enabled)
+			}
+		})
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// JourneySpec is a journey variant of a product in a Config.
+type JourneySpec struct {
+	Type                  JourneyType `json:"type" yaml:"type"`
+	Price                 Money       `json:"price" yaml:"price"`
+	ReturnWithinMinutes   int         `json:"return_within_minutes,omitempty" yaml:"return_within_minutes,omitempty"`
+	TransferWindowMinutes int         `json:"transfer_window_minutes,omitempty" yaml:"transfer_window_minutes,omitempty"`
+}
+
+// ProductSpec is a product in a Config. Amounts are in minor units of the
+// machine currency. A single ticket has a validity; a pass has pass_days
+// or pass_months instead. A seated product has coaches of
+// seats_per_coach seats.
+type ProductSpec struct {
+	Type            string        `json:"type" yaml:"type"`
+	Name            string        `json:"name" yaml:"name"`
+	Description     string        `json:"description,omitempty" yaml:"description,omitempty"`
+	Price           Money         `json:"price" yaml:"price"`
+	VATRate         int           `json:"vat_bp" yaml:"vat_bp"`
+	Stock           int           `json:"stock" yaml:"stock"`
+	LowStockAt      int           `json:"low_stock_at,omitempty" yaml:"low_stock_at,omitempty"`
+	ValidityMinutes int           `json:"validity_minutes,omitempty" yaml:"validity_minutes,omitempty"`
+	PassDays        int           `json:"pass_days,omitempty" yaml:"pass_days,omitempty"`
+	PassMonths      int           `json:"pass_months,omitempty" yaml:"pass_months,omitempty"`
+	Alternatives    []string      `json:"alternatives,omitempty" yaml:"alternatives,omitempty"`
+	Journeys        []JourneySpec `json:"journeys,omitempty" yaml:"journeys,omitempty"`
+	Seated          bool          `json:"seated,omitempty" yaml:"seated,omitempty"`
+	Coaches         int           `json:"coaches,omitempty" yaml:"coaches,omitempty"`
+	SeatsPerCoach   int           `json:"seats_per_coach,omitempty" yaml:"seats_per_coach,omitempty"`
+	BundleOf        string        `json:"bundle_of,omitempty" yaml:"bundle_of,omitempty"`
+	BundleRides     int           `json:"bundle_rides,omitempty" yaml:"bundle_rides,omitempty"`
+	BundlePersons   int           `json:"bundle_persons,omitempty" yaml:"bundle_persons,omitempty"`
+}
+
+// LocationSpec is where the machine of a Config stands.
+type LocationSpec struct {
+	StationID string `json:"station_id" yaml:"station_id"`
+	Station   string `json:"station" yaml:"station"`
+	Zone      string `json:"zone,omitempty" yaml:"zone,omitempty"`
+}
+
+// PriceRuleSpec is a price schedule rule in a Config. Days are "mon" to
+// "sun", every day when empty; from and to are "HH:MM".
+type PriceRuleSpec struct {
+	TicketType string   `json:"ticket_type" yaml:"ticket_type"`
+	Days       []string `json:"days,omitempty" yaml:"days,omitempty"`
+	From       string   `json:"from" yaml:"from"`
+	To         string   `json:"to" yaml:"to"`
+	Price      Money    `json:"price" yaml:"price"`
+}
+
+// OperatorSpec is an operator in a Config, with their PIN and roles.
+type OperatorSpec struct {
+	ID    string `json:"id" yaml:"id"`
+	PIN   string `json:"pin" yaml:"pin"`
+	Roles []Role `json:"roles" yaml:"roles"`
+}
+
+// Config is what a machine is built from: its location, products and
+// price schedule, the cash it takes and holds, top-up amounts, operators,
+// the ticket signing key, timeouts and locale.
+type Config struct {
+	MachineID            string          `json:"machine_id" yaml:"machine_id"`
+	Locale               string          `json:"locale" yaml:"locale"`
+	Location             LocationSpec    `json:"location" yaml:"location"`
+	Products             []ProductSpec   `json:"products" yaml:"products"`
+	Schedule             []PriceRuleSpec `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	Denominations        []Money         `json:"denominations" yaml:"denominations"`
+	Hopper               map[Money]int   `json:"hopper" yaml:"hopper"`
+	CashBoxCapacity      int             `json:"cash_box_capacity" yaml:"cash_box_capacity"`
+	ExactChangeThreshold Money           `json:"exact_change_threshold" yaml:"exact_change_threshold"`
+	TopUpAmounts         []Money         `json:"top_up_amounts,omitempty" yaml:"top_up_amounts,omitempty"`
+	TimeoutSeconds       int             `json:"timeout_seconds" yaml:"timeout_seconds"`
+	RefundWindowMinutes  int             `json:"refund_window_minutes" yaml:"refund_window_minutes"`
+	Operators            []OperatorSpec  `json:"operators" yaml:"operators"`
+	// TicketKeyID and TicketKey sign ticket QR codes; gates verify them
+	// with the same key, so it is at least 16 bytes and kept secret.
+	TicketKeyID string `json:"ticket_key_id" yaml:"ticket_key_id"`
+	TicketKey   string `json:"ticket_key" yaml:"ticket_key"`
+	// APIPort is the port the host serves the local API on; zero disables
+	// it.
+	APIPort int `json:"api_port,omitempty" yaml:"api_port,omitempty"`
+}
+
+// DefaultConfig is the configuration of NewTicketMachine, for demos and
+// tests: its operator PINs are well known and its ticket key is made up
+// on each call, so its tickets only verify within the run.
+func DefaultConfig() Config {
+	cfg := Config{
+		MachineID: "TM-0001",
+		Locale:    "en",
+		Location:  LocationSpec{StationID: "ALM", Station: "Almaly", Zone: "A"},
+		Products: []ProductSpec{
+			{Type: "metro", Name: "Metro", Description: "Single metro ride", Price: KZT(300), Stock: 10, ValidityMinutes: 90, Alternatives: []string{"metro_carnet"}},
+			{Type: "bus", Name: "Bus", Description: "Single bus ride", Price: KZT(250), Stock: 15, ValidityMinutes: 90, Alternatives: []string{"tram"}},
+			{Type: "train", Name: "Train", Description: "Single train journey", Price: KZT(1000), Stock: 5, ValidityMinutes: 24 * 60,
+				Seated: true, Coaches: 4, SeatsPerCoach: 40},
+			{Type: "day_pass", Name: "Day pass", Description: "Unlimited rides for a day", Price: KZT(1000), Stock: 10, PassDays: 1},
+			{Type: "week_pass", Name: "Week pass", Description: "Unlimited rides for a week", Price: KZT(4000), Stock: 10, PassDays: 7},
+			{Type: "month_pass", Name: "Month pass", Description: "Unlimited rides for a month", Price: KZT(12000), Stock: 10, PassMonths: 1},
+			{Type: "tram", Name: "Tram", Description: "Tram ride, also as return or transfer", Price: KZT(200), Stock: 20, ValidityMinutes: 90,
+				Journeys: []JourneySpec{
+					{Type: JourneyReturn, Price: KZT(380), ReturnWithinMinutes: 24 * 60},
+					{Type: JourneyTransfer, Price: KZT(300), TransferWindowMinutes: 60},
+				}},
+			{Type: "metro_carnet", Name: "Metro 10 rides", Description: "Ten metro ride credits, valid 30 days", Price: KZT(2500), Stock: 10, ValidityMinutes: 30 * 24 * 60,
+				BundleOf: "metro", BundleRides: 10},
+			{Type: "family_day_pass", Name: "Family day pass", Description: "Unlimited rides for a day for up to 4 riders", Price: KZT(2500), Stock: 5, PassDays: 1,
+				BundlePersons: 4},
+		},
+		Denominations: []Money{
+			KZT(1), KZT(2), KZT(5), KZT(10), KZT(20), KZT(50), KZT(100), KZT(200),
+			KZT(500), KZT(1000), KZT(2000), KZT(5000), KZT(10000), KZT(20000),
+		},
+		Hopper: map[Money]int{
+			KZT(10): 50, KZT(20): 50, KZT(50): 40, KZT(100): 40, KZT(200): 30,
+			KZT(500): 20, KZT(1000): 10,
+		},
+		Schedule: []PriceRuleSpec{
+			{TicketType: "metro", Days: []string{"mon", "tue", "wed", "thu", "fri"}, From: "07:00", To: "10:00", Price: KZT(300)},
+			{TicketType: "metro", Days: []string{"mon", "tue", "wed", "thu", "fri"}, From: "17:00", To: "20:00", Price: KZT(300)},
+		},
+		CashBoxCapacity:      500,
+		ExactChangeThreshold: KZT(1000),
+		TopUpAmounts:         []Money{KZT(500), KZT(1000), KZT(2000), KZT(5000)},
+		TimeoutSeconds:       60,
+		RefundWindowMinutes:  30,
+		Operators: []OperatorSpec{
+			{ID: "admin", PIN: "0000", Roles: []Role{RoleSupervisor}},
+			{ID: "clerk", PIN: "1111", Roles: []Role{RoleRefillClerk}},
+			{ID: "collector", PIN: "2222", Roles: []Role{RoleCashCollector}},
+		},
+		TicketKeyID: "default",
+		TicketKey:   newTicketKey(),
+	}
+	for i := range cfg.Products {
+		cfg.Products[i].VATRate = 1200
+		cfg.Products[i].LowStockAt = 2
+	}
+	return cfg
+}
+
+// ConfigDecoders decode a configuration document by format. JSON and
+// YAML are built in; an integrator may replace the YAML decoder with a
+// full one, e.g. yaml.Unmarshal of gopkg.in/yaml.v3, whose tags Config
+// carries.
+var ConfigDecoders = map[string]func(data []byte, v interface{}) error{
+	"json": decodeStrictJSON,
+	"yaml": decodeYAML,
+}
+
+func decodeStrictJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// ReadConfig decodes and validates a configuration document in format,
+// "json", "yaml" or a format registered in ConfigDecoders.
+func ReadConfig(r io.Reader, format string) (Config, error) {
+	decode, ok := ConfigDecoders[format]
+	if !ok {
+		return Config{}, newErrorf(CodeInvalidConfig, "unsupported config format %q", format)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := decode(raw, &cfg); err != nil {
+		return Config{}, newErrorf(CodeInvalidConfig, "config: %w", err)
+	}
+	return cfg, cfg.Validate()
+}
+
+// LoadConfigFile reads a configuration file, taking the format from its
+// extension: .json, .yaml or .yml.
+func LoadConfigFile(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+	format := strings.TrimPrefix(filepath.Ext(path), ".")
+	if format == "yml" {
+		format = "yaml"
+	}
+	cfg, err := ReadConfig(f, format)
+	if err != nil {
+		return Config{}, newErrorf(CodeOf(err), "%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration and reports every problem found.
+func (c Config) Validate() error {
+	var problems []string
+	bad := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if c.MachineID == "" {
+		bad("machine_id is required")
+	}
+	if _, ok := DefaultAudioPrompts()[c.Locale]; !ok {
+		bad("locale %q is not supported", c.Locale)
+	}
+	if len(c.Products) == 0 {
+		bad("at least one product is required")
+	}
+	types := map[string]bool{}
+	for _, p := range c.Products {
+		types[p.Type] = true
+	}
+	seen := map[string]bool{}
+	for i, p := range c.Products {
+		at := fmt.Sprintf("products[%d]", i)
+		if p.Type != "" {
+			at += " (" + p.Type + ")"
+		}
+		switch {
+		case p.Type == "":
+			bad("%s: type is required", at)
+		case seen[p.Type]:
+			bad("%s: duplicate product", at)
+		}
+		seen[p.Type] = true
+		problems = append(problems, p.problems(at, types)...)
+	}
+	if len(c.Denominations) == 0 {
+		bad("at least one denomination is required")
+	}
+	denoms := map[Money]bool{}
+	for _, d := range c.Denominations {
+		if d <= 0 {
+			bad("denomination %d must be positive", d)
+		}
+		if denoms[d] {
+			bad("duplicate denomination %d", d)
+		}
+		denoms[d] = true
+	}
+	for d, n := range c.Hopper {
+		if !denoms[d] {
+			bad("hopper: %d is not a denomination", d)
+		}
+		if n < 0 {
+			bad("hopper: count of %d must not be negative", d)
+		}
+	}
+	if c.CashBoxCapacity <= 0 {
+		bad("cash_box_capacity must be positive")
+	}
+	if c.ExactChangeThreshold < 0 {
+		bad("exact_change_threshold must not be negative")
+	}
+	if c.TimeoutSeconds <= 0 {
+		bad("timeout_seconds must be positive")
+	}
+	if c.RefundWindowMinutes < 0 {
+		bad("refund_window_minutes must not be negative")
+	}
+	for i, r := range c.Schedule {
+		at := fmt.Sprintf("schedule[%d]", i)
+		if !types[r.TicketType] {
+			bad("%s: unknown ticket_type %q", at, r.TicketType)
+		}
+		for _, d := range r.Days {
+			if _, ok := weekdayNames[d]; !ok {
+				bad("%s: unknown day %q", at, d)
+			}
+		}
+		if _, err := parseTimeOfDay(r.From); err != nil {
+			bad("%s: from: %v", at, err)
+		}
+		if _, err := parseTimeOfDay(r.To); err != nil {
+			bad("%s: to: %v", at, err)
+		}
+		if r.Price <= 0 {
+			bad("%s: price must be positive", at)
+		}
+	}
+	for _, a := range c.TopUpAmounts {
+		if a <= 0 {
+			bad("top-up amount %d must be positive", a)
+		}
+	}
+	ids := map[string]bool{}
+	roles := DefaultRoles()
+	for i, o := range c.Operators {
+		at := fmt.Sprintf("operators[%d]", i)
+		switch {
+		case o.ID == "":
+			bad("%s: id is required", at)
+		case ids[o.ID]:
+			bad("%s: duplicate operator %q", at, o.ID)
+		}
+		ids[o.ID] = true
+		if o.PIN == "" {
+			bad("%s: pin is required", at)
+		}
+		for _, r := range o.Roles {
+			if _, ok := roles[r]; !ok {
+				bad("%s: unknown role %q", at, r)
+			}
+		}
+	}
+	if c.TicketKeyID == "" {
+		bad("ticket_key_id is required")
+	}
+	if len(c.TicketKey) < 16 {
+		bad("ticket_key must be at least 16 bytes")
+	}
+	if c.APIPort < 0 || c.APIPort > 65535 {
+		bad("api_port %d is out of range", c.APIPort)
+	}
+	if len(problems) > 0 {
+		return newError(CodeInvalidConfig, "invalid config: "+strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// problems checks a product; types are the products it may refer to.
+func (p ProductSpec) problems(at string, types map[string]bool) []string {
+	var problems []string
+	bad := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if p.Name == "" {
+		bad("%s: name is required", at)
+	}
+	if p.Price <= 0 {
+		bad("%s: price must be positive", at)
+	}
+	if p.VATRate < 0 || p.VATRate > 10000 {
+		bad("%s: vat_bp must be between 0 and 10000", at)
+	}
+	if p.Stock < 0 || p.LowStockAt < 0 {
+		bad("%s: stock must not be negative", at)
+	}
+	pass := p.PassDays > 0 || p.PassMonths > 0
+	if p.PassDays < 0 || p.PassMonths < 0 || p.ValidityMinutes < 0 {
+		bad("%s: validity must not be negative", at)
+	}
+	if pass == (p.ValidityMinutes > 0) {
+		bad("%s: set either validity_minutes or pass_days/pass_months", at)
+	}
+	for _, a := range p.Alternatives {
+		if !types[a] {
+			bad("%s: unknown alternative %q", at, a)
+		}
+	}
+	if p.BundleOf != "" && !types[p.BundleOf] {
+		bad("%s: unknown bundle_of %q", at, p.BundleOf)
+	}
+	if (p.BundleOf != "") != (p.BundleRides > 0) {
+		bad("%s: bundle_of and bundle_rides go together", at)
+	}
+	if p.Seated && (p.Coaches <= 0 || p.SeatsPerCoach <= 0) {
+		bad("%s: a seated product needs coaches and seats_per_coach", at)
+	}
+	for _, j := range p.Journeys {
+		if j.Price <= 0 {
+			bad("%s: journey %s: price must be positive", at, j.Type)
+		}
+	}
+	return problems
+}
+
+// catalog builds the product catalog of the configuration.
+func (c Config) catalog() *TicketCatalog {
+	cat := NewTicketCatalog()
+	for _, s := range c.Products {
+		cat.RegisterProduct(s.product())
+	}
+	return cat
+}
+
+// schedule builds the price schedule of the configuration, nil when it
+// has no rules.
+func (c Config) schedule() *PriceSchedule {
+	if len(c.Schedule) == 0 {
+		return nil
+	}
+	s := &PriceSchedule{}
+	for _, r := range c.Schedule {
+		rule := PriceRule{TicketType: r.TicketType, Price: r.Price}
+		for _, d := range r.Days {
+			rule.Days = append(rule.Days, weekdayNames[d])
+		}
+		rule.From, _ = parseTimeOfDay(r.From)
+		rule.To, _ = parseTimeOfDay(r.To)
+		s.Rules = append(s.Rules, rule)
+	}
+	return s
+}
+
+// seats lays out the coaches of the seated products, nil when there are
+// none.
+func (c Config) seats() *MockSeatInventory {
+	var inv *MockSeatInventory
+	for _, p := range c.Products {
+		if !p.Seated {
+			continue
+		}
+		layout := NewMockSeatInventory(p.Type, p.Coaches, p.SeatsPerCoach)
+		if inv == nil {
+			inv = layout
+		} else {
+			inv.Seats[p.Type] = layout.Seats[p.Type]
+		}
+	}
+	return inv
+}
+
+// operators returns the PINs and roles of the configured operators.
+func (c Config) operators() (PINAuthenticator, map[string][]Role) {
+	pins, roles := PINAuthenticator{}, map[string][]Role{}
+	for _, o := range c.Operators {
+		pins[o.ID] = o.PIN
+		roles[o.ID] = append([]Role(nil), o.Roles...)
+	}
+	return pins, roles
+}
+
+func newTicketKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (s ProductSpec) product() Product {
+	p := Product{
+		Type:         s.Type,
+		Name:         s.Name,
+		Description:  s.Description,
+		Price:        s.Price,
+		VATRate:      s.VATRate,
+		Stock:        s.Stock,
+		LowStockAt:   s.LowStockAt,
+		Alternatives: s.Alternatives,
+		Validity:     time.Duration(s.ValidityMinutes) * time.Minute,
+		Seated:       s.Seated,
+	}
+	if s.PassDays > 0 || s.PassMonths > 0 {
+		p.Pass = &PassPeriod{Days: s.PassDays, Months: s.PassMonths}
+	}
+	for _, j := range s.Journeys {
+		p.Journeys = append(p.Journeys, JourneyOption{
+			Type:           j.Type,
+			Price:          j.Price,
+			ReturnWithin:   time.Duration(j.ReturnWithinMinutes) * time.Minute,
+			TransferWindow: time.Duration(j.TransferWindowMinutes) * time.Minute,
+		})
+	}
+	if s.BundleOf != "" || s.BundlePersons > 0 {
+		p.Bundle = &Bundle{Of: s.BundleOf, Rides: s.BundleRides, Persons: s.BundlePersons}
+	}
+	return p
+}
+
+// NewTicketMachineFrom builds a machine from a validated configuration.
+func NewTicketMachineFrom(cfg Config, opts ...Option) (*TicketMachine, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return newTicketMachine(cfg, opts...), nil
+}
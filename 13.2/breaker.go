@@ -0,0 +1,132 @@
+package main
+
+import "time"
+
+// ErrCircuitOpen is returned by ResilientGateway while the breaker is open.
+var ErrCircuitOpen = newError(CodePaymentUnavailable, "card payments temporarily unavailable")
+
+// RetryPolicy retries a failed call with exponential backoff.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay << attempt
+	if p.MaxDelay > 0 && (d > p.MaxDelay || d <= 0) {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// CircuitBreaker opens after Threshold consecutive failures and lets a
+// single trial call through once Cooldown has passed.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	Clock     Clock
+
+	failures int
+	openedAt time.Time
+	open     bool
+}
+
+// Allow reports whether a call may be made now.
+func (b *CircuitBreaker) Allow() bool {
+	if !b.open {
+		return true
+	}
+	return b.Clock.Now().Sub(b.openedAt) >= b.Cooldown
+}
+
+// Open reports whether the breaker is refusing calls.
+func (b *CircuitBreaker) Open() bool { return !b.Allow() }
+
+func (b *CircuitBreaker) record(err error) {
+	if err == nil {
+		b.failures = 0
+		b.open = false
+		return
+	}
+	b.failures++
+	if b.failures >= b.Threshold {
+		b.open = true
+		b.openedAt = b.Clock.Now()
+	}
+}
+
+// ResilientGateway wraps a PaymentGateway with retries and a circuit
+// breaker. Declines are answers, not failures, and are never retried.
+// Retries reuse the idempotency key, so they are safe to repeat.
+type ResilientGateway struct {
+	Gateway PaymentGateway
+	Retry   RetryPolicy
+	Breaker *CircuitBreaker
+	Sleep   func(time.Duration)
+}
+
+// NewResilientGateway wraps g with three attempts starting at 200ms and a
+// breaker that opens for a minute after five failed calls.
+func NewResilientGateway(g PaymentGateway, clock Clock) *ResilientGateway {
+	return &ResilientGateway{
+		Gateway: g,
+		Retry:   RetryPolicy{MaxAttempts: 3, BaseDelay: 200 * time.Millisecond, MaxDelay: 2 * time.Second},
+		Breaker: &CircuitBreaker{Threshold: 5, Cooldown: time.Minute, Clock: clock},
+		Sleep:   time.Sleep,
+	}
+}
+
+// Available reports whether card payments can currently be attempted.
+func (g *ResilientGateway) Available() bool { return g.Breaker.Allow() }
+
+func (g *ResilientGateway) call(fn func() error) error {
+	if !g.Breaker.Allow() {
+		return ErrCircuitOpen
+	}
+	var err error
+	for attempt := 0; attempt < g.Retry.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			g.Sleep(g.Retry.delay(attempt - 1))
+		}
+		if err = fn(); err == nil {
+			break
+		}
+	}
+	g.Breaker.record(err)
+	return err
+}
+
+func (g *ResilientGateway) Authorize(key string, amount Money, card CardDetails) (Authorization, error) {
+	var auth Authorization
+	err := g.call(func() error {
+		var err error
+		auth, err = g.Gateway.Authorize(key, amount, card)
+		return err
+	})
+	return auth, err
+}
+
+func (g *ResilientGateway) Capture(key string, auth Authorization) error {
+	return g.call(func() error { return g.Gateway.Capture(key, auth) })
+}
+
+func (g *ResilientGateway) Void(key string, auth Authorization) error {
+	return g.call(func() error { return g.Gateway.Void(key, auth) })
+}
+
+func (g *ResilientGateway) Refund(key string, auth Authorization, amount Money) error {
+	return g.call(func() error { return g.Gateway.Refund(key, auth, amount) })
+}
+
+// CardPaymentsAvailable reports whether the machine can take cards right
+// now. Cash is still accepted while it returns false.
+func (m *TicketMachine) CardPaymentsAvailable() bool {
+	if m.Gateway == nil {
+		return false
+	}
+	if g, ok := m.Gateway.(interface{ Available() bool }); ok {
+		return g.Available()
+	}
+	return true
+}
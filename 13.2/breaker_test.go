@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyGateway fails the first fails authorizations, then approves.
+type flakyGateway struct {
+	MockGateway
+	fails, calls int
+}
+
+func (g *flakyGateway) Authorize(key string, amount Money, card CardDetails) (Authorization, error) {
+	g.calls++
+	if g.calls <= g.fails {
+		return Authorization{}, errors.New("gateway unreachable")
+	}
+	return g.MockGateway.Authorize(key, amount, card)
+}
+
+func TestResilientGatewayRetries(t *testing.T) {
+	tests := []struct {
+		name   string
+		fails  int
+		token  string
+		ok     bool
+		calls  int
+		sleeps []time.Duration
+	}{
+		{"first try", 0, "tok_visa", true, 1, nil},
+		{"recovers on retry", 2, "tok_visa", true, 3, []time.Duration{200 * time.Millisecond, 400 * time.Millisecond}},
+		{"gives up after three attempts", 5, "tok_visa", false, 3, []time.Duration{200 * time.Millisecond, 400 * time.Millisecond}},
+		{"decline is not retried", 0, "tok_declined", true, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &flakyGateway{fails: tt.fails}
+			inner.Decline = map[string]string{"tok_declined": "insufficient funds"}
+			g := NewResilientGateway(inner, &FakeClock{T: time.Now()})
+			var sleeps []time.Duration
+			g.Sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+			auth, err := g.Authorize("tx/auth/1", KZT(300), CardDetails{Token: tt.token})
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v", err)
+			}
+			if tt.token == "tok_declined" && auth.Approved {
+				t.Fatal("declined card approved")
+			}
+			if inner.calls != tt.calls || len(sleeps) != len(tt.sleeps) {
+				t.Fatalf("calls %d, sleeps %v; want %d, %v", inner.calls, sleeps, tt.calls, tt.sleeps)
+			}
+			for i := range sleeps {
+				if sleeps[i] != tt.sleeps[i] {
+					t.Errorf("sleep %d = %s, want %s", i, sleeps[i], tt.sleeps[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := &FakeClock{T: time.Now()}
+	inner := &flakyGateway{fails: 1 << 30}
+	g := NewResilientGateway(inner, clock)
+	g.Sleep = func(time.Duration) {}
+	for i := 0; i < g.Breaker.Threshold; i++ {
+		if _, err := g.Authorize("k", KZT(300), CardDetails{}); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d: err = %v, want a gateway failure", i, err)
+		}
+	}
+	calls := inner.calls
+	if _, err := g.Authorize("k", KZT(300), CardDetails{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want the open circuit", err)
+	}
+	if inner.calls != calls || g.Available() {
+		t.Fatalf("open breaker let a call through (%d calls) or reports available", inner.calls-calls)
+	}
+
+	clock.Advance(g.Breaker.Cooldown)
+	inner.fails = 0
+	if !g.Available() {
+		t.Fatal("breaker still open after the cooldown")
+	}
+	if _, err := g.Authorize("k", KZT(300), CardDetails{}); err != nil {
+		t.Fatalf("trial call: %v", err)
+	}
+	if g.Breaker.Open() {
+		t.Fatal("breaker open after a successful trial")
+	}
+}
+
+func TestOpenBreakerFallsBackToCash(t *testing.T) {
+	m, clock := newTestMachine(t)
+	g := NewResilientGateway(&MockGateway{Fail: errors.New("gateway unreachable")}, clock)
+	g.Sleep = func(time.Duration) {}
+	g.Breaker.Threshold = 1
+	m.Gateway = g
+	must(t, m.SelectTicket("metro", 1))
+	if err := m.PayByCard(CardDetails{Token: "tok_visa"}); CodeOf(err) != CodeCardDeclined {
+		t.Fatalf("first card = %v, want a failed authorization", err)
+	}
+	must(t, m.Cancel())
+	must(t, m.StartOver())
+	must(t, m.SelectTicket("metro", 1))
+	if err := m.PayByCard(CardDetails{Token: "tok_visa"}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want the open circuit", err)
+	}
+	if m.CardPaymentsAvailable() || m.GetCurrentState() != (&WaitingForMoneyState{}).Name() {
+		t.Fatalf("cards available %v, state %s", m.CardPaymentsAvailable(), m.GetCurrentState())
+	}
+	must(t, m.InsertMoney(KZT(200)))
+	must(t, m.InsertMoney(KZT(100)))
+	_, err := m.DispenseTicket()
+	must(t, err)
+}
@@ -0,0 +1,53 @@
+package main
+
+// Bundle makes a product a group of rides or riders sold together. A ride
+// bundle (carnet) dispenses Rides ride credits for product Of; a group
+// ticket is a single ticket for Persons riders. Bundles have their own
+// price and stock; selling one does not take stock of Of.
+type Bundle struct {
+	Of      string
+	Rides   int
+	Persons int
+}
+
+// bundleOf returns the bundle of ticketType, if it is one.
+func (m *TicketMachine) bundleOf(ticketType string) *Bundle {
+	p, _ := m.Catalog.Product(ticketType)
+	return p.Bundle
+}
+
+// issueLine issues the tickets of a cart line: one per unit, or for a
+// ride bundle one ride credit per ride, sharing the unit price.
+func (m *TicketMachine) issueLine(l CartLine) []Ticket {
+	b := m.bundleOf(l.TicketType)
+	var tickets []Ticket
+	for i := 0; i < l.Qty; i++ {
+		if b == nil || b.Rides < 1 {
+			t := m.newTicket(l, i)
+			if b != nil {
+				t.Persons = b.Persons
+			}
+			tickets = append(tickets, m.printable(t))
+			continue
+		}
+		share, rest := l.Price()/Money(b.Rides), l.Price()%Money(b.Rides)
+		for r := 0; r < b.Rides; r++ {
+			t := m.newTicket(l, i)
+			t.Type, t.Kind, t.Bundle = b.Of, m.Kind(b.Of), l.TicketType
+			t.PricePaid = share
+			if r == 0 {
+				t.PricePaid += rest
+			}
+			tickets = append(tickets, m.printable(t))
+		}
+	}
+	return tickets
+}
+
+// printable attaches the QR code to a finished ticket.
+func (m *TicketMachine) printable(t Ticket) Ticket {
+	if err := m.attachQR(&t); err != nil {
+		m.warn("ticket QR code unavailable: %v", err)
+	}
+	return t
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestRideBundle(t *testing.T) {
+	tests := []struct {
+		name  string
+		price Money
+		first Money
+	}{
+		{"even split", KZT(2500), KZT(250)},
+		{"remainder on the first ride", KZT(2500) + 7, KZT(250) + 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			must(t, m.Catalog.SetPrice("metro_carnet", tt.price))
+			metro, carnets := m.Catalog.Stock("metro"), m.Catalog.Stock("metro_carnet")
+			must(t, m.SelectTicket("metro_carnet", 1))
+			must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}))
+			d, err := m.DispenseTicket()
+			must(t, err)
+			if len(d.Tickets) != 10 {
+				t.Fatalf("got %d ride credits, want 10", len(d.Tickets))
+			}
+			var total Money
+			for i, tk := range d.Tickets {
+				if tk.Type != "metro" || tk.Bundle != "metro_carnet" || tk.Kind != KindSingle {
+					t.Fatalf("credit %d = %+v", i, tk)
+				}
+				total += tk.PricePaid
+			}
+			if total != tt.price || d.Tickets[0].PricePaid != tt.first || d.Tickets[1].PricePaid != KZT(250) {
+				t.Fatalf("credits total %s, first %s", total, d.Tickets[0].PricePaid)
+			}
+			if m.Catalog.Stock("metro") != metro || m.Catalog.Stock("metro_carnet") != carnets-1 {
+				t.Fatalf("stock metro %d, carnets %d", m.Catalog.Stock("metro"), m.Catalog.Stock("metro_carnet"))
+			}
+		})
+	}
+}
+
+func TestGroupTicket(t *testing.T) {
+	m, _ := newTestMachine(t)
+	must(t, m.SelectTicket("family_day_pass", 1))
+	must(t, m.InsertMoney(KZT(2000)))
+	must(t, m.InsertMoney(KZT(500)))
+	d, err := m.DispenseTicket()
+	must(t, err)
+	if len(d.Tickets) != 1 || d.Tickets[0].Persons != 4 || d.Tickets[0].Kind != KindPass {
+		t.Fatalf("tickets = %+v", d.Tickets)
+	}
+	c, err := DecodeTicketQR(d.Tickets[0].QRPayload)
+	must(t, err)
+	if c.Persons != 4 {
+		t.Fatalf("claims persons = %d", c.Persons)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import "sync"
+
+// EventKind is the kind of a hardware event.
+type EventKind string
+
+const (
+	EventCoinInserted  EventKind = "coin_inserted"
+	EventBillEscrowed  EventKind = "bill_escrowed"
+	EventCardPresented EventKind = "card_presented"
+	EventPaperLow      EventKind = "paper_low"
+	EventPaperOut      EventKind = "paper_out"
+	EventJam           EventKind = "jam"
+	EventFault         EventKind = "fault"
+	EventSecurity      EventKind = "security"
+)
+
+// HardwareEvent is something a device reports. Only the fields of its
+// kind are set.
+type HardwareEvent struct {
+	Kind   EventKind
+	Device string
+	Amount Money
+	Card   CardDetails
+	Sensor SensorKind
+	// Level is what is left, e.g. of the paper roll.
+	Level  int
+	Err    error
+	Detail string
+}
+
+// EventHandler handles a hardware event.
+type EventHandler func(HardwareEvent)
+
+// EventBus carries hardware events from device drivers to their
+// subscribers. Events are handled one at a time in publication order:
+// an event published by a handler, or from another goroutine while one is
+// being handled, is queued behind it. Handlers run on the publishing
+// goroutine unless RunOn moves them, as TicketMachine.Run does.
+type EventBus struct {
+	mu       sync.Mutex
+	handlers map[EventKind][]EventHandler
+	queue    []HardwareEvent
+	busy     bool
+	run      func(func())
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{handlers: map[EventKind][]EventHandler{}}
+}
+
+// Subscribe adds a handler for kind.
+func (b *EventBus) Subscribe(kind EventKind, h EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[kind] = append(b.handlers[kind], h)
+}
+
+// RunOn makes the bus hand the handling of every event to run, which
+// runs it on the goroutine that owns the subscribers; nil handles events
+// on the publishing goroutine again.
+func (b *EventBus) RunOn(run func(func())) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.run = run
+}
+
+// Publish hands ev to the subscribers of its kind.
+func (b *EventBus) Publish(ev HardwareEvent) {
+	b.mu.Lock()
+	if run := b.run; run != nil {
+		handlers := b.handlers[ev.Kind]
+		b.mu.Unlock()
+		run(func() {
+			for _, h := range handlers {
+				h(ev)
+			}
+		})
+		return
+	}
+	b.queue = append(b.queue, ev)
+	if b.busy {
+		b.mu.Unlock()
+		return
+	}
+	b.busy = true
+	for len(b.queue) > 0 {
+		ev := b.queue[0]
+		b.queue = b.queue[1:]
+		handlers := b.handlers[ev.Kind]
+		b.mu.Unlock()
+		for _, h := range handlers {
+			h(ev)
+		}
+		b.mu.Lock()
+	}
+	b.busy = false
+	b.mu.Unlock()
+}
+
+func (m *TicketMachine) bus() *EventBus {
+	if m.Bus == nil {
+		m.Bus = NewEventBus()
+		m.subscribeHardware()
+	}
+	return m.Bus
+}
+
+// publish sends a hardware event to the machine's bus.
+func (m *TicketMachine) publish(ev HardwareEvent) {
+	m.bus().Publish(ev)
+}
+
+// subscribeHardware connects the state machine to the hardware events.
+func (m *TicketMachine) subscribeHardware() {
+	b := m.Bus
+	b.Subscribe(EventCoinInserted, m.coinInserted)
+	b.Subscribe(EventBillEscrowed, m.billEscrowed)
+	b.Subscribe(EventCardPresented, func(ev HardwareEvent) {
+		if err := m.PayByCard(ev.Card); err != nil {
+			m.display().ShowError(err)
+		}
+	})
+	b.Subscribe(EventPaperLow, func(ev HardwareEvent) {
+		m.notify(Alert{Kind: AlertPaperLow, TicketType: "paper", Stock: ev.Level})
+	})
+	b.Subscribe(EventPaperOut, m.paperOut)
+	fault := func(ev HardwareEvent) {
+		if ev.Device == "coin_acceptor" {
+			m.coinJam(ev.Err)
+			return
+		}
+		if m.inService() == nil {
+			m.HardwareFault(ev.Device, ev.Err)
+		}
+	}
+	b.Subscribe(EventJam, fault)
+	b.Subscribe(EventFault, fault)
+	b.Subscribe(EventSecurity, func(ev HardwareEvent) {
+		m.SecurityEvent(SecurityEvent{Sensor: ev.Sensor, Detail: ev.Detail})
+	})
+}
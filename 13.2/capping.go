@@ -0,0 +1,122 @@
+package main
+
+import "time"
+
+// RiderIdentifier tells who is buying, so their spend can be capped.
+type RiderIdentifier interface {
+	IdentifyRider() (string, error)
+}
+
+// TransitCardRiders identifies riders by the transit card on the reader.
+type TransitCardRiders struct {
+	Cards TransitCardReader
+}
+
+func (r TransitCardRiders) IdentifyRider() (string, error) {
+	card, err := r.Cards.ReadTransitCard()
+	return card.ID, err
+}
+
+// FareCapper works out how much of a purchase is waived because the rider
+// has reached a spending cap, and keeps the spend it is based on.
+type FareCapper interface {
+	Discount(riderID string, amount Money, at time.Time) Money
+	Record(riderID string, amount Money, at time.Time)
+}
+
+type capSpend struct {
+	At     time.Time
+	Amount Money
+}
+
+// FareCaps caps a rider's spend per calendar day and per week, starting
+// Monday. A zero cap is not applied.
+type FareCaps struct {
+	Daily  Money
+	Weekly Money
+
+	spend map[string][]capSpend
+}
+
+func (c *FareCaps) spentSince(riderID string, since time.Time) Money {
+	var total Money
+	for _, s := range c.spend[riderID] {
+		if !s.At.Before(since) {
+			total += s.Amount
+		}
+	}
+	return total
+}
+
+func (c *FareCaps) Discount(riderID string, amount Money, at time.Time) Money {
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+	week := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	payable := amount
+	if c.Daily > 0 {
+		payable = minMoney(payable, c.Daily-c.spentSince(riderID, day))
+	}
+	if c.Weekly > 0 {
+		payable = minMoney(payable, c.Weekly-c.spentSince(riderID, week))
+	}
+	if payable < 0 {
+		payable = 0
+	}
+	return amount - payable
+}
+
+func (c *FareCaps) Record(riderID string, amount Money, at time.Time) {
+	if c.spend == nil {
+		c.spend = map[string][]capSpend{}
+	}
+	c.spend[riderID] = append(c.spend[riderID], capSpend{At: at, Amount: amount})
+}
+
+func minMoney(a, b Money) Money {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// LinkRider identifies the rider, e.g. by their transit card, so that fare
+// capping applies to the selected tickets. It is allowed until the first
+// payment is made.
+func (m *TicketMachine) LinkRider() error {
+	if err := m.logAction(JournalEntry{Action: "link_rider"}); err != nil {
+		return err
+	}
+	switch m.State.(type) {
+	case *WaitingForMoneyState, *CartState:
+	default:
+		return newError(CodeInvalidState, "rider cannot be linked now")
+	}
+	if m.PaidTotal() > 0 {
+		return newError(CodeAlreadyPaid, "payment already started")
+	}
+	if m.Riders == nil || m.Capping == nil {
+		return newError(CodePaymentUnavailable, "fare capping not available")
+	}
+	var id string
+	err := m.deviceCall("riders.identify", &id, func() (err error) {
+		id, err = m.Riders.IdentifyRider()
+		return err
+	})
+	if err != nil {
+		return newErrorf(CodeRiderUnidentified, "cannot identify rider: %w", err)
+	}
+	m.RiderID = id
+	m.repriceCart()
+	if m.CapDiscount > 0 {
+		m.show("Fare cap reached: -%s (%s)", m.CapDiscount.In(m.Currency), m.formatPrice(m.CurrentPrice))
+	} else {
+		m.show("Rider linked: %v", id)
+	}
+	return nil
+}
+
+// recordCapSpend counts the sale towards the rider's caps.
+func (m *TicketMachine) recordCapSpend() {
+	if m.RiderID != "" && m.Capping != nil {
+		m.Capping.Record(m.RiderID, m.CurrentPrice, m.Clock.Now())
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFareCapsDiscount(t *testing.T) {
+	monday := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		spent []capSpend
+		at    time.Time
+		want  Money
+	}{
+		{"under the caps", nil, monday, 0},
+		{"reaches the daily cap", []capSpend{{monday, KZT(600)}}, monday, KZT(200)},
+		{"daily cap already reached", []capSpend{{monday, KZT(700)}}, monday, KZT(300)},
+		{"new day", []capSpend{{monday, KZT(700)}}, monday.AddDate(0, 0, 1), 0},
+		{"weekly cap", []capSpend{{monday, KZT(700)}, {monday.AddDate(0, 0, 1), KZT(700)}, {monday.AddDate(0, 0, 2), KZT(500)}}, monday.AddDate(0, 0, 3), KZT(200)},
+		{"new week", []capSpend{{monday, KZT(700)}, {monday.AddDate(0, 0, 1), KZT(700)}, {monday.AddDate(0, 0, 2), KZT(600)}}, monday.AddDate(0, 0, 7), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &FareCaps{Daily: KZT(700), Weekly: KZT(2000)}
+			for _, s := range tt.spent {
+				c.Record("ONAY-1", s.Amount, s.At)
+			}
+			if got := c.Discount("ONAY-1", KZT(300), tt.at); got != tt.want {
+				t.Fatalf("Discount = %s, want %s", got, tt.want)
+			}
+			if got := c.Discount("ONAY-2", KZT(300), tt.at); got != 0 {
+				t.Fatalf("other rider discounted %s", got)
+			}
+		})
+	}
+}
+
+func TestLinkRiderCapsFare(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.Riders = TransitCardRiders{Cards: &MockTransitCards{Presented: "ONAY-1"}}
+	m.Capping = &FareCaps{Daily: KZT(700)}
+	want := []Money{KZT(300), KZT(300), KZT(100), 0}
+	for i, price := range want {
+		must(t, m.SelectTicket("metro", 1))
+		must(t, m.LinkRider())
+		if m.CurrentPrice != price {
+			t.Fatalf("ride %d costs %s, want %s", i+1, m.CurrentPrice, price)
+		}
+		for m.InsertedMoney < price {
+			must(t, m.InsertMoney(KZT(100)))
+		}
+		_, err := m.DispenseTicket()
+		must(t, err)
+		must(t, m.StartOver())
+	}
+}
+
+func TestLinkRiderErrors(t *testing.T) {
+	m, _ := newTestMachine(t)
+	if err := m.LinkRider(); CodeOf(err) != CodeInvalidState {
+		t.Fatalf("LinkRider when idle = %v", err)
+	}
+	must(t, m.SelectTicket("metro", 1))
+	if err := m.LinkRider(); CodeOf(err) != CodePaymentUnavailable {
+		t.Fatalf("LinkRider without capping = %v", err)
+	}
+	m.Riders = TransitCardRiders{Cards: &MockTransitCards{}}
+	m.Capping = &FareCaps{Daily: KZT(700)}
+	if err := m.LinkRider(); CodeOf(err) != CodeRiderUnidentified {
+		t.Fatalf("LinkRider with no card = %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+// EMVData is the chip data a chip or contactless read yields for online
+// authorization.
+type EMVData struct {
+	// AID is the application identifier; Label its display name, e.g.
+	// "VISA CREDIT".
+	AID   string `json:"aid"`
+	Label string `json:"label"`
+	// Cryptogram is the ARQC the issuer checks; CVM the cardholder
+	// verification performed, e.g. "no_cvm" or "pin".
+	Cryptogram string `json:"cryptogram"`
+	CVM        string `json:"cvm"`
+}
+
+// CardPresented is reported by a card reader for each card inserted,
+// swiped or tapped.
+type CardPresented struct {
+	Card CardDetails
+}
+
+// CardReader is the payment card reader driver, with chip, magstripe and
+// contactless interfaces. The machine enables it while a card payment is
+// possible and reads presented cards from Events.
+type CardReader interface {
+	Enable() error
+	Disable() error
+	Events() <-chan CardPresented
+}
+
+// SimulatedCardReader stands in for card reader hardware: Insert, Swipe
+// and Tap present a card, and are refused while the reader is disabled.
+type SimulatedCardReader struct {
+	Enabled bool
+	events  chan CardPresented
+}
+
+func NewSimulatedCardReader() *SimulatedCardReader {
+	return &SimulatedCardReader{events: make(chan CardPresented, 1)}
+}
+
+func (r *SimulatedCardReader) Enable() error  { r.Enabled = true; return nil }
+func (r *SimulatedCardReader) Disable() error { r.Enabled = false; return nil }
+
+func (r *SimulatedCardReader) Events() <-chan CardPresented { return r.events }
+
+// Insert simulates a chip card inserted into the reader.
+func (r *SimulatedCardReader) Insert(card CardDetails, emv EMVData) bool {
+	card.EntryMode = EntryChip
+	card.EMV = &emv
+	return r.present(card)
+}
+
+// Swipe simulates a magstripe swipe; track is the reader's encrypted
+// track 2 data.
+func (r *SimulatedCardReader) Swipe(card CardDetails, track string) bool {
+	card.EntryMode = EntryMagstripe
+	card.Track = track
+	return r.present(card)
+}
+
+// Tap simulates a contactless card or phone wallet held to the reader.
+func (r *SimulatedCardReader) Tap(card CardDetails, emv EMVData) bool {
+	card.EntryMode = EntryContactless
+	card.EMV = &emv
+	return r.present(card)
+}
+
+func (r *SimulatedCardReader) present(card CardDetails) bool {
+	if !r.Enabled || len(r.events) == cap(r.events) {
+		return false
+	}
+	r.events <- CardPresented{Card: card}
+	return true
+}
+
+func (r *SimulatedCardReader) SelfTest() error { return nil }
+
+// takesCard reports whether the current state accepts a card payment.
+func (m *TicketMachine) takesCard() bool {
+	switch m.State.(type) {
+	case *WaitingForMoneyState, *CardDeclinedState:
+		return m.CardPaymentsAvailable()
+	}
+	return false
+}
+
+// syncCardReader enables the card reader only while a card payment is
+// possible.
+func (m *TicketMachine) syncCardReader() {
+	if m.CardReader == nil {
+		return
+	}
+	var err error
+	if m.takesCard() {
+		err = m.CardReader.Enable()
+	} else {
+		err = m.CardReader.Disable()
+	}
+	if err != nil {
+		m.warn("card reader: %v", err)
+	}
+}
+
+// PollCardReader publishes the cards presented since the last call.
+func (m *TicketMachine) PollCardReader() {
+	if m.CardReader == nil {
+		return
+	}
+	for {
+		select {
+		case ev := <-m.CardReader.Events():
+			m.publish(HardwareEvent{Kind: EventCardPresented, Device: "card_reader", Card: ev.Card})
+		default:
+			return
+		}
+	}
+}
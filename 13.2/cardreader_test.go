@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+// cardsSeen records the cards the machine sends for authorization.
+type cardsSeen struct {
+	*MockGateway
+	cards []CardDetails
+}
+
+func (g *cardsSeen) Authorize(key string, amount Money, card CardDetails) (Authorization, error) {
+	g.cards = append(g.cards, card)
+	return g.MockGateway.Authorize(key, amount, card)
+}
+
+func TestCardReader(t *testing.T) {
+	card := CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}
+	emv := EMVData{AID: "A0000000031010", Label: "VISA CREDIT", Cryptogram: "ARQC", CVM: "no_cvm"}
+	tests := []struct {
+		name    string
+		present func(*SimulatedCardReader) bool
+		mode    string
+	}{
+		{"chip", func(r *SimulatedCardReader) bool { return r.Insert(card, emv) }, EntryChip},
+		{"magstripe", func(r *SimulatedCardReader) bool { return r.Swipe(card, "enc-track2") }, EntryMagstripe},
+		{"contactless", func(r *SimulatedCardReader) bool { return r.Tap(card, emv) }, EntryContactless},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			g := &cardsSeen{MockGateway: &MockGateway{}}
+			m.Gateway = g
+			reader := NewSimulatedCardReader()
+			m.CardReader = reader
+			if tt.present(reader) {
+				t.Fatal("card taken while idle")
+			}
+			must(t, m.SelectTicket("metro", 1))
+			if !tt.present(reader) {
+				t.Fatal("card refused while waiting for payment")
+			}
+			m.PollCardReader()
+			if m.CardAuth == nil || len(g.cards) != 1 || g.cards[0].EntryMode != tt.mode {
+				t.Fatalf("authorized %+v with cards %+v", m.CardAuth, g.cards)
+			}
+			if reader.Enabled {
+				t.Fatal("reader enabled once paid")
+			}
+			_, err := m.DispenseTicket()
+			must(t, err)
+			if len(g.Captured) != 1 {
+				t.Fatalf("captured %+v", g.Captured)
+			}
+		})
+	}
+}
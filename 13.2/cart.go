@@ -0,0 +1,172 @@
+package main
+
+// CartLine is a quantity of one ticket type in the current transaction.
+type CartLine struct {
+	TicketType string `json:"ticket_type"`
+	Qty        int    `json:"qty"`
+	UnitPrice  Money  `json:"unit_price"`
+	// Discount is taken off each unit for the transaction's fare category.
+	Discount Money `json:"discount,omitempty"`
+	// FromZone and ToZone are set for zoned products.
+	FromZone string `json:"from_zone,omitempty"`
+	ToZone   string `json:"to_zone,omitempty"`
+	// Journey is the journey type chosen for products that offer one.
+	Journey JourneyType `json:"journey,omitempty"`
+	// Seats are the seats reserved for the line's tickets, in order.
+	Seats []Seat `json:"seats,omitempty"`
+}
+
+// Price is what one ticket of the line costs after discount.
+func (l CartLine) Price() Money { return l.UnitPrice - l.Discount }
+
+// Total is the price of the whole line.
+func (l CartLine) Total() Money { return l.Price() * Money(l.Qty) }
+
+// addLine adds qty tickets of ticketType to the cart, checking stock
+// against what the cart already holds.
+func (m *TicketMachine) addLine(ticketType string, qty int) error {
+	if qty < 1 {
+		return newError(CodeInvalidInput, "quantity must be at least 1")
+	}
+	if !m.HasTicket(ticketType) {
+		return m.unavailable(ticketType)
+	}
+	if len(m.Cart) > 0 && (m.isZoned(ticketType) || m.isZoned(m.Cart[0].TicketType)) {
+		return newError(CodeCart, "zone tickets must be bought on their own")
+	}
+	held := 0
+	for _, l := range m.Cart {
+		if l.TicketType == ticketType {
+			held = l.Qty
+		}
+	}
+	if left := m.Catalog.Stock(ticketType) - held; left < qty {
+		return newErrorf(CodeInsufficientStock, "only %d %s tickets left", left, ticketType)
+	}
+	merged := false
+	for i := range m.Cart {
+		if m.Cart[i].TicketType == ticketType {
+			m.Cart[i].Qty += qty
+			merged = true
+		}
+	}
+	if !merged {
+		m.Cart = append(m.Cart, CartLine{TicketType: ticketType, Qty: qty, UnitPrice: m.GetTicketPrice(ticketType)})
+	}
+	m.CurrentTicket = ticketType
+	m.repriceCart()
+	return nil
+}
+
+// repriceCart recomputes the transaction price and tax from the cart.
+func (m *TicketMachine) repriceCart() {
+	m.CurrentPrice = 0
+	m.CurrentTax = TaxBreakdown{}
+	for i := range m.Cart {
+		l := &m.Cart[i]
+		l.Discount = m.discountFor(m.FareCategory, l.TicketType, l.UnitPrice)
+		m.CurrentPrice += l.Total()
+		m.CurrentTax = m.CurrentTax.Add(Breakdown(l.Total(), m.VATRate(l.TicketType)))
+	}
+	m.PromoDiscount = 0
+	if m.Promo != nil {
+		m.PromoDiscount = m.Promo.Discount(m.Cart, m.CurrentPrice)
+		m.CurrentTax = m.CurrentTax.Scale(m.CurrentPrice-m.PromoDiscount, m.CurrentPrice)
+		m.CurrentPrice -= m.PromoDiscount
+	}
+	m.CapDiscount = 0
+	if m.RiderID != "" && m.Capping != nil {
+		m.CapDiscount = m.Capping.Discount(m.RiderID, m.CurrentPrice, m.Clock.Now())
+		m.CurrentTax = m.CurrentTax.Scale(m.CurrentPrice-m.CapDiscount, m.CurrentPrice)
+		m.CurrentPrice -= m.CapDiscount
+	}
+}
+
+// AddToCart adds tickets to the cart, starting a new cart from the ready
+// state.
+func (m *TicketMachine) AddToCart(ticketType string, qty int) error {
+	if err := m.logAction(JournalEntry{Action: "cart_add", TicketType: ticketType, Qty: qty}); err != nil {
+		return err
+	}
+	if err := m.inService(); err != nil {
+		return err
+	}
+	if m.Accessible {
+		return newError(CodeNotOffered, "cart not available in accessibility mode")
+	}
+	switch m.State.(type) {
+	case *IdleState, *CashBoxFullState:
+		if err := m.checkPaper(); err != nil {
+			return err
+		}
+		m.startSale()
+		if err := m.addLine(ticketType, qty); err != nil {
+			return err
+		}
+		m.SetState(&CartState{})
+	case *CartState:
+		if err := m.addLine(ticketType, qty); err != nil {
+			return err
+		}
+	default:
+		return newError(CodeInvalidState, "cannot add to cart now")
+	}
+	m.show("Added %d x %s. Cart total: %s", qty, ticketType, m.CurrentPrice.In(m.Currency))
+	return nil
+}
+
+// RemoveFromCart drops a ticket type from the cart.
+func (m *TicketMachine) RemoveFromCart(ticketType string) error {
+	if err := m.logAction(JournalEntry{Action: "cart_remove", TicketType: ticketType}); err != nil {
+		return err
+	}
+	if _, ok := m.State.(*CartState); !ok {
+		return newError(CodeInvalidState, "no cart open")
+	}
+	for i, l := range m.Cart {
+		if l.TicketType == ticketType {
+			m.Cart = append(m.Cart[:i], m.Cart[i+1:]...)
+			m.repriceCart()
+			return nil
+		}
+	}
+	return newError(CodeCart, "ticket type not in cart")
+}
+
+// Checkout closes the cart and waits for payment of its total.
+func (m *TicketMachine) Checkout() error {
+	if err := m.logAction(JournalEntry{Action: "checkout"}); err != nil {
+		return err
+	}
+	if _, ok := m.State.(*CartState); !ok {
+		return newError(CodeInvalidState, "no cart open")
+	}
+	if len(m.Cart) == 0 {
+		return newError(CodeCart, "cart is empty")
+	}
+	m.SetState(&WaitingForMoneyState{})
+	m.show("Cart total: %s", m.formatPrice(m.CurrentPrice))
+	return nil
+}
+
+// CartState collects ticket lines before payment.
+type CartState struct{}
+
+func (s *CartState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeStepRequired, "cart open, add to cart or check out")
+}
+func (s *CartState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeStepRequired, "please check out first")
+}
+func (s *CartState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeStepRequired, "please check out first")
+}
+func (s *CartState) Cancel(m *TicketMachine) error {
+	m.Cart = nil
+	m.SetState(&TransactionCanceledState{})
+	return nil
+}
+func (s *CartState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeStepRequired, "please check out first")
+}
+func (s *CartState) Name() string { return "Cart" }
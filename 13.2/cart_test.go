@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestCartCheckout(t *testing.T) {
+	m, _ := newTestMachine(t)
+	must(t, m.AddToCart("metro", 2))
+	must(t, m.AddToCart("bus", 1))
+	must(t, m.AddToCart("metro", 1))
+	if len(m.Cart) != 2 || m.Cart[0].Qty != 3 || m.CurrentPrice != KZT(1150) {
+		t.Fatalf("cart %+v, price %s", m.Cart, m.CurrentPrice)
+	}
+	if err := m.InsertMoney(KZT(100)); CodeOf(err) != CodeStepRequired {
+		t.Fatalf("InsertMoney with the cart open = %v", err)
+	}
+	must(t, m.AddToCart("tram", 1))
+	must(t, m.RemoveFromCart("bus"))
+	if m.CurrentPrice != KZT(1100) {
+		t.Fatalf("price after removal = %s", m.CurrentPrice)
+	}
+	must(t, m.Checkout())
+	must(t, m.InsertMoney(KZT(1000)))
+	must(t, m.InsertMoney(KZT(100)))
+	d, err := m.DispenseTicket()
+	must(t, err)
+	types := map[string]int{}
+	for _, tk := range d.Tickets {
+		types[tk.Type]++
+	}
+	if len(d.Tickets) != 4 || types["metro"] != 3 || types["tram"] != 1 {
+		t.Fatalf("tickets by type = %v", types)
+	}
+}
+
+func TestCartErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, m *TicketMachine) error
+		code ErrorCode
+	}{
+		{"empty checkout", func(t *testing.T, m *TicketMachine) error {
+			must(t, m.AddToCart("metro", 1))
+			must(t, m.RemoveFromCart("metro"))
+			return m.Checkout()
+		}, CodeCart},
+		{"remove what is not in the cart", func(t *testing.T, m *TicketMachine) error {
+			must(t, m.AddToCart("metro", 1))
+			return m.RemoveFromCart("bus")
+		}, CodeCart},
+		{"stock counts the whole cart", func(t *testing.T, m *TicketMachine) error {
+			must(t, m.AddToCart("metro", 6))
+			return m.AddToCart("metro", 5)
+		}, CodeInsufficientStock},
+		{"no checkout without a cart", func(t *testing.T, m *TicketMachine) error {
+			return m.Checkout()
+		}, CodeInvalidState},
+		{"select while the cart is open", func(t *testing.T, m *TicketMachine) error {
+			must(t, m.AddToCart("metro", 1))
+			return m.SelectTicket("bus", 1)
+		}, CodeStepRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			if err := tt.run(t, m); CodeOf(err) != tt.code {
+				t.Fatalf("err = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// UnsupportedDenominationError is returned by InsertMoney for an amount that
+// is not a single accepted coin or banknote.
+type UnsupportedDenominationError struct {
+	Amount Money
+}
+
+func (e *UnsupportedDenominationError) ErrorCode() ErrorCode { return CodeUnsupportedDenomination }
+
+func (e *UnsupportedDenominationError) Error() string {
+	return fmt.Sprintf("denomination %s not accepted", e.Amount)
+}
+
+// AcceptsDenomination reports whether amount is an accepted coin or note.
+func (m *TicketMachine) AcceptsDenomination(amount Money) bool {
+	for _, d := range m.Denominations {
+		if d == amount {
+			return true
+		}
+	}
+	return false
+}
+
+// returnCash hands back the cash inserted in the current transaction: a
+// note in escrow from the bill validator, the rest through the change
+// dispenser. Foreign cash is handed back as inserted. Coins the dispenser fails to return go to the cash box and are
+// owed on a voucher.
+func (m *TicketMachine) returnCash() {
+	note := m.returnEscrow()
+	if note > 0 {
+		m.SessionTally[note]--
+	}
+	rest := m.InsertedMoney - note
+	for _, f := range m.SessionForeign {
+		m.show("Returned: %s", f.Amount.In(f.Currency))
+		rest -= f.Credited
+	}
+	if rest > 0 {
+		m.show("Returned: %s", rest.In(m.Currency))
+		c := Change{Amount: rest, Coins: tallyLines(m.SessionTally)}
+		kept := map[Money]int{}
+		m.dispenseChange(&c, kept)
+		m.deposit(kept)
+	}
+	m.InsertedMoney = 0
+	m.Overpayment = 0
+	m.SessionTally = map[Money]int{}
+	m.SessionForeign = nil
+}
+
+// TallyLine is the number of coins or notes of one denomination.
+type TallyLine struct {
+	Denomination Money
+	Count        int
+}
+
+// Tally returns the coins and notes inserted in the current session, from
+// the largest denomination down.
+func (m *TicketMachine) Tally() []TallyLine {
+	return tallyLines(m.SessionTally)
+}
+
+func tallyLines(t map[Money]int) []TallyLine {
+	lines := make([]TallyLine, 0, len(t))
+	for d, n := range t {
+		if n > 0 {
+			lines = append(lines, TallyLine{Denomination: d, Count: n})
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].Denomination > lines[j].Denomination })
+	return lines
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInsertMoneyDenominations(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount Money
+		ok     bool
+	}{
+		{"coin", KZT(100), true},
+		{"note", KZT(1000), true},
+		{"smallest coin", KZT(1), true},
+		{"not a denomination", 12345, false},
+		{"fraction of a tenge", 50, false},
+		{"zero", 0, false},
+		{"negative", -KZT(100), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			must(t, m.SelectTicket("train", 1))
+			must(t, m.SkipSeat())
+			err := m.InsertMoney(tt.amount)
+			var want Money
+			if tt.ok {
+				must(t, err)
+				want = tt.amount
+			} else if CodeOf(err) != CodeUnsupportedDenomination {
+				t.Fatalf("InsertMoney(%s) = %v, want %s", tt.amount, err, CodeUnsupportedDenomination)
+			}
+			if m.InsertedMoney != want {
+				t.Errorf("inserted %s, want %s", m.InsertedMoney, want)
+			}
+		})
+	}
+}
+
+func TestTallyReturnedOnCancel(t *testing.T) {
+	m, _ := newTestMachine(t)
+	must(t, m.SelectTicket("train", 1))
+	must(t, m.SkipSeat())
+	for _, a := range []Money{KZT(200), KZT(100), KZT(200)} {
+		must(t, m.InsertMoney(a))
+	}
+	want := []TallyLine{{KZT(200), 2}, {KZT(100), 1}}
+	if got := m.Tally(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("tally %v, want %v", got, want)
+	}
+	d := &MockChangeDispenser{}
+	m.ChangeDispenser = d
+	must(t, m.Cancel())
+	if !reflect.DeepEqual(d.Dispensed, want) {
+		t.Errorf("returned %v, want %v", d.Dispensed, want)
+	}
+	if len(m.Tally()) != 0 || m.CashBox.Count() != 0 {
+		t.Errorf("tally %v and %d in the cash box after cancel", m.Tally(), m.CashBox.Count())
+	}
+}
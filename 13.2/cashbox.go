@@ -0,0 +1,99 @@
+package main
+
+// CashBox is the vault holding the cash taken for sold tickets. Capacity is
+// the number of coins and notes it physically fits.
+type CashBox struct {
+	Contents map[Money]int
+	// Foreign holds the coins and notes taken in other currencies.
+	Foreign  []ForeignCash
+	Capacity int
+	// WarnAt is the fill level at which near-full warnings start.
+	WarnAt int
+}
+
+// NewCashBox returns an empty cash box that warns at 90% of capacity.
+func NewCashBox(capacity int) *CashBox {
+	return &CashBox{
+		Contents: map[Money]int{},
+		Capacity: capacity,
+		WarnAt:   capacity * 9 / 10,
+	}
+}
+
+// Count is the number of coins and notes in the box.
+func (b *CashBox) Count() int {
+	n := 0
+	for _, c := range b.Contents {
+		n += c
+	}
+	return n + len(b.Foreign)
+}
+
+// Total is the value of the box contents, foreign cash at the value it was
+// credited as.
+func (b *CashBox) Total() Money {
+	var total Money
+	for d, n := range b.Contents {
+		total += d * Money(n)
+	}
+	for _, f := range b.Foreign {
+		total += f.Credited
+	}
+	return total
+}
+
+func (b *CashBox) IsFull() bool   { return b.Count() >= b.Capacity }
+func (b *CashBox) NearFull() bool { return b.Count() >= b.WarnAt }
+
+// deposit moves the session tally into the cash box.
+func (m *TicketMachine) deposit(tally map[Money]int) {
+	for d, n := range tally {
+		m.CashBox.Contents[d] += n
+	}
+	switch {
+	case m.CashBox.IsFull():
+		m.warn("cash box full (%d/%d)", m.CashBox.Count(), m.CashBox.Capacity)
+	case m.CashBox.NearFull():
+		m.warn("cash box nearly full (%d/%d)", m.CashBox.Count(), m.CashBox.Capacity)
+	}
+}
+
+// depositForeign moves foreign cash taken in the session into the cash box.
+func (m *TicketMachine) depositForeign(cash []ForeignCash) {
+	m.CashBox.Foreign = append(m.CashBox.Foreign, cash...)
+}
+
+// canAcceptCash reports whether one more coin or note fits in the cash box
+// together with what is already held for the current session.
+func (m *TicketMachine) canAcceptCash() bool {
+	pending := len(m.SessionForeign)
+	for _, n := range m.SessionTally {
+		pending += n
+	}
+	return m.CashBox.Count()+pending < m.CashBox.Capacity
+}
+
+// CashBoxFullState replaces IdleState while the vault is full: tickets can
+// still be selected, but only non-cash payment is possible.
+type CashBoxFullState struct{}
+
+func (s *CashBoxFullState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	if err := (&IdleState{}).SelectTicket(m, ticketType, qty); err != nil {
+		return err
+	}
+	m.show("Cash box full. Card payment only.")
+	return nil
+}
+func (s *CashBoxFullState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeCashBoxFull, "cash box full, cash not accepted")
+}
+func (s *CashBoxFullState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeNoTicketSelected, "please select a ticket first")
+}
+func (s *CashBoxFullState) Cancel(m *TicketMachine) error {
+	return newError(CodeNoTransaction, "no active transaction")
+}
+func (s *CashBoxFullState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeNotPaid, "no paid ticket")
+}
+func (s *CashBoxFullState) Name() string { return "CashBoxFull" }
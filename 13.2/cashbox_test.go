@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestCashBoxCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		inserted []Money
+		code     ErrorCode
+		state    string
+	}{
+		{"room left", 10, []Money{KZT(200), KZT(100)}, "", (&IdleState{}).Name()},
+		{"filled by the sale", 2, []Money{KZT(200), KZT(100)}, "", (&CashBoxFullState{}).Name()},
+		{"no room for the last coin", 1, []Money{KZT(200), KZT(100)}, CodeCashBoxFull, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.CashBox = NewCashBox(tt.capacity)
+			must(t, m.SelectTicket("metro", 1))
+			var err error
+			for _, a := range tt.inserted {
+				if err = m.InsertMoney(a); err != nil {
+					break
+				}
+			}
+			if CodeOf(err) != tt.code {
+				t.Fatalf("InsertMoney = %v, want %q", err, tt.code)
+			}
+			if tt.code != "" {
+				return
+			}
+			_, err = m.DispenseTicket()
+			must(t, err)
+			must(t, m.StartOver())
+			if m.GetCurrentState() != tt.state {
+				t.Errorf("state %s, want %s", m.GetCurrentState(), tt.state)
+			}
+			if m.CashBox.Count() != len(tt.inserted) || m.CashBox.Total() != KZT(300) {
+				t.Errorf("cash box holds %d worth %s", m.CashBox.Count(), m.CashBox.Total())
+			}
+		})
+	}
+}
+
+func TestCashBoxFullTakesCardsOnly(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.CashBox = NewCashBox(1)
+	m.CashBox.Contents[KZT(100)] = 1
+	m.SetState(m.readyState())
+	if !m.CashBox.IsFull() || !m.CashBox.NearFull() {
+		t.Fatal("a box at capacity is not full")
+	}
+	must(t, m.SelectTicket("metro", 1))
+	if err := m.InsertMoney(KZT(100)); CodeOf(err) != CodeCashBoxFull {
+		t.Fatalf("InsertMoney = %v, want %s", err, CodeCashBoxFull)
+	}
+	must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}))
+	_, err := m.DispenseTicket()
+	must(t, err)
+}
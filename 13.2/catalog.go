@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Product is a ticket product the machine can sell.
+type Product struct {
+	Type        string
+	Name        string
+	Description string
+	Price       Money
+	// VATRate is in basis points.
+	VATRate int
+	Stock   int
+	// LowStockAt is the stock level at which a low-stock alert is sent.
+	LowStockAt int
+	// Alternatives are suggested when the product is unavailable.
+	Alternatives []string
+	// Validity is how long a single ticket stays valid; Pass, when set,
+	// makes the product a pass valid for a calendar period instead.
+	Validity time.Duration
+	Pass     *PassPeriod
+	// Journeys are the round-trip and transfer variants on offer.
+	Journeys []JourneyOption
+	// Seated products offer seat reservation.
+	Seated bool
+	Bundle *Bundle
+	Active bool
+}
+
+// TicketCatalog is the registry of products, their metadata and stock. It
+// can be changed at runtime.
+type TicketCatalog struct {
+	products map[string]*Product
+}
+
+func NewTicketCatalog() *TicketCatalog {
+	return &TicketCatalog{products: map[string]*Product{}}
+}
+
+// RegisterProduct adds or replaces a product. Registered products are
+// active.
+func (c *TicketCatalog) RegisterProduct(p Product) error {
+	if p.Type == "" {
+		return newError(CodeInvalidInput, "product type required")
+	}
+	if p.Price < 0 || p.Stock < 0 {
+		return newError(CodeInvalidInput, "price and stock must not be negative")
+	}
+	p.Active = true
+	c.products[p.Type] = &p
+	return nil
+}
+
+// Deactivate withdraws a product from sale, keeping its record.
+func (c *TicketCatalog) Deactivate(ticketType string) error {
+	p, ok := c.products[ticketType]
+	if !ok {
+		return newErrorf(CodeUnknownProduct, "unknown product %s", ticketType)
+	}
+	p.Active = false
+	return nil
+}
+
+// Restock adds n tickets to a product's stock.
+func (c *TicketCatalog) Restock(ticketType string, n int) error {
+	p, ok := c.products[ticketType]
+	if !ok {
+		return newErrorf(CodeUnknownProduct, "unknown product %s", ticketType)
+	}
+	if n <= 0 {
+		return newError(CodeInvalidInput, "restock count must be positive")
+	}
+	p.Stock += n
+	return nil
+}
+
+// Adjust changes a product's stock by delta, never below zero.
+func (c *TicketCatalog) Adjust(ticketType string, delta int) error {
+	p, ok := c.products[ticketType]
+	if !ok {
+		return newErrorf(CodeUnknownProduct, "unknown product %s", ticketType)
+	}
+	if p.Stock+delta < 0 {
+		return newErrorf(CodeInsufficientStock, "only %d %s tickets in stock", p.Stock, ticketType)
+	}
+	p.Stock += delta
+	return nil
+}
+
+// SetPrice changes the price of a product.
+func (c *TicketCatalog) SetPrice(ticketType string, price Money) error {
+	p, ok := c.products[ticketType]
+	if !ok {
+		return newErrorf(CodeUnknownProduct, "unknown product %s", ticketType)
+	}
+	if price < 0 {
+		return newError(CodeInvalidInput, "price must not be negative")
+	}
+	p.Price = price
+	return nil
+}
+
+func (c *TicketCatalog) clone() *TicketCatalog {
+	n := NewTicketCatalog()
+	for t, p := range c.products {
+		cp := *p
+		n.products[t] = &cp
+	}
+	return n
+}
+
+// List returns every product, active or not, sorted by type.
+func (c *TicketCatalog) List() []Product {
+	list := make([]Product, 0, len(c.products))
+	for _, p := range c.products {
+		list = append(list, *p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Type < list[j].Type })
+	return list
+}
+
+// Product looks up a product by type.
+func (c *TicketCatalog) Product(ticketType string) (Product, bool) {
+	p, ok := c.products[ticketType]
+	if !ok {
+		return Product{}, false
+	}
+	return *p, true
+}
+
+// Stock is the number of tickets left for an active product.
+func (c *TicketCatalog) Stock(ticketType string) int {
+	if p, ok := c.products[ticketType]; ok && p.Active {
+		return p.Stock
+	}
+	return 0
+}
+
+func (c *TicketCatalog) take(ticketType string, n int) {
+	if p, ok := c.products[ticketType]; ok {
+		p.Stock -= n
+	}
+}
+
+// GetPrice is the flat catalog price of an active product.
+func (c *TicketCatalog) GetPrice(ticketType string, ctx PriceContext) (Money, bool) {
+	p, ok := c.products[ticketType]
+	if !ok || !p.Active {
+		return 0, false
+	}
+	if ctx.Journey != "" && ctx.Journey != JourneySingle {
+		o, ok := p.Journey(ctx.Journey)
+		return o.Price, ok
+	}
+	return p.Price, true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegisterProduct(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Product
+		code ErrorCode
+	}{
+		{"new product", Product{Type: "cable_car", Price: KZT(500), Stock: 3}, ""},
+		{"no type", Product{Price: KZT(500)}, CodeInvalidInput},
+		{"negative price", Product{Type: "cable_car", Price: -1}, CodeInvalidInput},
+		{"negative stock", Product{Type: "cable_car", Stock: -1}, CodeInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			if err := m.Catalog.RegisterProduct(tt.p); CodeOf(err) != tt.code {
+				t.Fatalf("RegisterProduct = %v, want %q", err, tt.code)
+			}
+			if tt.code != "" {
+				return
+			}
+			must(t, m.SelectTicket("cable_car", 1))
+			if m.CurrentPrice != KZT(500) {
+				t.Fatalf("price = %s", m.CurrentPrice)
+			}
+		})
+	}
+}
+
+func TestCatalogChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *TicketCatalog) error
+		code   ErrorCode
+		price  Money
+		stock  int
+	}{
+		{"restock", func(c *TicketCatalog) error { return c.Restock("metro", 5) }, "", KZT(300), 15},
+		{"restock nothing", func(c *TicketCatalog) error { return c.Restock("metro", 0) }, CodeInvalidInput, KZT(300), 10},
+		{"adjust down", func(c *TicketCatalog) error { return c.Adjust("metro", -4) }, "", KZT(300), 6},
+		{"adjust below zero", func(c *TicketCatalog) error { return c.Adjust("metro", -11) }, CodeInsufficientStock, KZT(300), 10},
+		{"new price", func(c *TicketCatalog) error { return c.SetPrice("metro", KZT(350)) }, "", KZT(350), 10},
+		{"negative price", func(c *TicketCatalog) error { return c.SetPrice("metro", -1) }, CodeInvalidInput, KZT(300), 10},
+		{"unknown product", func(c *TicketCatalog) error { return c.Restock("monorail", 1) }, CodeUnknownProduct, KZT(300), 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			if err := tt.change(m.Catalog); CodeOf(err) != tt.code {
+				t.Fatalf("err = %v, want %q", err, tt.code)
+			}
+			if p := m.GetTicketPrice("metro"); p != tt.price {
+				t.Fatalf("price = %s, want %s", p, tt.price)
+			}
+			if s := m.Catalog.Stock("metro"); s != tt.stock {
+				t.Fatalf("stock = %d, want %d", s, tt.stock)
+			}
+		})
+	}
+}
+
+func TestDeactivateProduct(t *testing.T) {
+	m, _ := newTestMachine(t)
+	must(t, m.Catalog.Deactivate("metro"))
+	var unavailable *UnavailableError
+	if err := m.SelectTicket("metro", 1); !errors.As(err, &unavailable) || unavailable.Reason != ReasonInactive {
+		t.Fatalf("SelectTicket = %v, want it inactive", err)
+	}
+	list, listed := m.Catalog.List(), false
+	for i, p := range list {
+		if i > 0 && list[i-1].Type >= p.Type {
+			t.Fatalf("List not sorted at %s", p.Type)
+		}
+		listed = listed || p.Type == "metro"
+	}
+	if !listed {
+		t.Fatal("inactive product dropped from List")
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "time"
+
+// Clock tells the machine the current time. Tests and simulators inject a
+// fake one.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// FakeClock is a Clock that only moves when told to.
+type FakeClock struct {
+	T time.Time
+}
+
+func (c *FakeClock) Now() time.Time { return c.T }
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) { c.T = c.T.Add(d) }
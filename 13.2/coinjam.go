@@ -0,0 +1,107 @@
+package main
+
+import "fmt"
+
+// ReasonCoinJam is the reason code of a machine stopped by a jammed coin
+// acceptor.
+const ReasonCoinJam ReasonCode = "coin_jam"
+
+// coinJam suspends the machine when the coin acceptor fails. The
+// transaction in progress ends: a note in escrow is handed back, a card
+// authorization voided, a QR payment refunded, and the cash the rider
+// inserted, since jammed coins cannot be returned, is paid out of the
+// hopper, or owed on a voucher when the hopper cannot make it.
+func (m *TicketMachine) coinJam(err error) {
+	if m.inService() != nil {
+		return
+	}
+	detail := "coin_acceptor"
+	if err != nil {
+		detail += ": " + err.Error()
+	}
+	m.audit("", "coin_jam", detail)
+	m.notify(Alert{Kind: AlertCoinJam, Detail: detail})
+	jam := &CoinJamState{Detail: detail}
+	_, paid := m.State.(*MoneyReceivedState)
+	if s, ok := m.State.(*PrintErrorState); ok {
+		if err := m.refundUnprinted(s); err != nil {
+			m.warn("refund failed: %v", err)
+		}
+	} else if paid || m.awaitingCustomer() {
+		jam.TransactionID = m.TransactionID
+		if m.CardAuth != nil {
+			if err := m.deviceCall("payment.void", nil, func() error {
+				return m.Gateway.Void(m.gatewayKey("void"), *m.CardAuth)
+			}); err != nil {
+				m.warn("card void failed: %v", err)
+			} else {
+				m.CardAuth = nil
+			}
+		}
+		if err := m.refundQR(); err != nil {
+			m.warn("%v", err)
+		}
+		if note := m.returnEscrow(); note > 0 {
+			m.SessionTally[note]--
+			m.InsertedMoney -= note
+		}
+		m.deposit(m.SessionTally)
+		m.depositForeign(m.SessionForeign)
+		if m.InsertedMoney > 0 {
+			m.show("Coin acceptor jammed. Your money will be refunded.")
+			if plan, ok := m.PlanChange(m.InsertedMoney); ok {
+				jam.Refund = m.payOut(m.InsertedMoney, plan).Voucher
+			} else {
+				jam.Refund = m.issueVoucher(m.InsertedMoney)
+			}
+		}
+		m.InsertedMoney = 0
+		m.Overpayment = 0
+	}
+	m.startSale()
+	m.SetState(jam)
+	fmt.Fprintln(m.out(), "Out of service:", detail)
+}
+
+// ClearJam returns the machine to service once an operator has cleared
+// the coin acceptor.
+func (m *TicketMachine) ClearJam(operatorID, pin string) error {
+	if err := m.Auth.Authenticate(operatorID, pin); err != nil {
+		m.audit(operatorID, "clear_jam_denied", err.Error())
+		return err
+	}
+	if err := m.authorize(operatorID, PermService, "clear_jam"); err != nil {
+		return err
+	}
+	if _, ok := m.State.(*CoinJamState); !ok {
+		return newError(CodeInvalidState, "no coin jam")
+	}
+	m.audit(operatorID, "clear_jam", "")
+	m.SetState(m.readyState())
+	fmt.Fprintln(m.out(), "Coin jam cleared.")
+	return nil
+}
+
+// CoinJamState refuses customers until ClearJam. Refund is what the
+// suspended transaction owes the rider.
+type CoinJamState struct {
+	Detail        string
+	TransactionID string
+	Refund        *ChangeVoucher
+}
+
+func (s *CoinJamState) err() error {
+	return &OutOfServiceError{Reason: ReasonCoinJam, Detail: "coin acceptor jammed"}
+}
+func (s *CoinJamState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return s.err()
+}
+func (s *CoinJamState) InsertMoney(m *TicketMachine, amount Money) error { return s.err() }
+func (s *CoinJamState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return s.err()
+}
+func (s *CoinJamState) Cancel(m *TicketMachine) error { return s.err() }
+func (s *CoinJamState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, s.err()
+}
+func (s *CoinJamState) Name() string { return "CoinJam" }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCoinJamReturnsPayment(t *testing.T) {
+	t.Run("card", func(t *testing.T) {
+		m, _ := newTestMachine(t)
+		g := &MockGateway{}
+		m.Gateway = g
+		must(t, m.SelectTicket("metro", 1))
+		must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}))
+		m.coinJam(errors.New("coin stuck"))
+		if len(g.Voided) != 1 || len(g.Refunded) != 0 || m.CardAuth != nil {
+			t.Errorf("voided %+v, refunded %+v, want the authorization voided", g.Voided, g.Refunded)
+		}
+	})
+	t.Run("qr", func(t *testing.T) {
+		m, _ := newTestMachine(t)
+		qr := &MockQRProvider{}
+		m.QRProvider = qr
+		must(t, m.SelectTicket("metro", 1))
+		p, err := m.PayByQR()
+		must(t, err)
+		qr.MarkPaid(p.ID)
+		must(t, m.PollQRPayment())
+		m.coinJam(errors.New("coin stuck"))
+		if qr.Refunded[p.ID] != p.Amount || m.QRPaid != nil {
+			t.Errorf("QR refunded %s, want %s", qr.Refunded[p.ID], p.Amount)
+		}
+		if s, ok := m.State.(*CoinJamState); !ok || s.Refund != nil {
+			t.Errorf("state %s after the jam", m.State.Name())
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+// CoinInserted is reported by a coin acceptor for each coin it takes in.
+// Err is set instead when the acceptor jams.
+type CoinInserted struct {
+	Amount Money
+	Err    error
+}
+
+// CoinAcceptor is the coin acceptor driver. The machine enables it while
+// it takes cash and reads inserted coins from Events.
+type CoinAcceptor interface {
+	Enable() error
+	Disable() error
+	Events() <-chan CoinInserted
+}
+
+// SimulatedCoinAcceptor stands in for coin hardware: Insert drops a coin
+// in, and is refused while the acceptor is disabled.
+type SimulatedCoinAcceptor struct {
+	Enabled bool
+	events  chan CoinInserted
+}
+
+func NewSimulatedCoinAcceptor() *SimulatedCoinAcceptor {
+	return &SimulatedCoinAcceptor{events: make(chan CoinInserted, 32)}
+}
+
+func (a *SimulatedCoinAcceptor) Enable() error  { a.Enabled = true; return nil }
+func (a *SimulatedCoinAcceptor) Disable() error { a.Enabled = false; return nil }
+
+func (a *SimulatedCoinAcceptor) Events() <-chan CoinInserted { return a.events }
+
+func (a *SimulatedCoinAcceptor) SelfTest() error { return nil }
+
+// Jam simulates the acceptor jamming.
+func (a *SimulatedCoinAcceptor) Jam(err error) {
+	a.events <- CoinInserted{Err: err}
+}
+
+// Insert simulates a coin dropped into the slot.
+func (a *SimulatedCoinAcceptor) Insert(amount Money) bool {
+	if !a.Enabled {
+		return false
+	}
+	a.events <- CoinInserted{Amount: amount}
+	return true
+}
+
+// takesCash reports whether the current state accepts inserted money.
+func (m *TicketMachine) takesCash() bool {
+	switch m.State.(type) {
+	case *WaitingForMoneyState, *MoneyReceivedState, *CardDeclinedState:
+		return m.canAcceptCash()
+	}
+	return false
+}
+
+// syncCoinAcceptor enables the coin acceptor only while cash is accepted.
+func (m *TicketMachine) syncCoinAcceptor() {
+	if m.Coins == nil {
+		return
+	}
+	var err error
+	if m.takesCash() {
+		err = m.Coins.Enable()
+	} else {
+		err = m.Coins.Disable()
+	}
+	if err != nil {
+		m.publish(HardwareEvent{Kind: EventFault, Device: "coin_acceptor", Err: err})
+	}
+}
+
+// PollCoins publishes the coins the acceptor has taken in since the last
+// call; the host loop calls it alongside Tick.
+func (m *TicketMachine) PollCoins() {
+	if m.Coins == nil {
+		return
+	}
+	for {
+		select {
+		case ev := <-m.Coins.Events():
+			if ev.Err != nil {
+				m.publish(HardwareEvent{Kind: EventJam, Device: "coin_acceptor", Err: ev.Err})
+				continue
+			}
+			m.publish(HardwareEvent{Kind: EventCoinInserted, Device: "coin_acceptor", Amount: ev.Amount})
+		default:
+			return
+		}
+	}
+}
+
+// coinInserted credits an inserted coin. Refused coins are returned.
+func (m *TicketMachine) coinInserted(ev HardwareEvent) {
+	if err := m.InsertMoney(ev.Amount); err != nil {
+		m.show("Coin returned: %s (%v)", ev.Amount.In(m.Currency), err)
+	}
+}
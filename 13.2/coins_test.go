@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestCoinAcceptor(t *testing.T) {
+	m, _ := newTestMachine(t)
+	coins := NewSimulatedCoinAcceptor()
+	m.Coins = coins
+	if coins.Insert(KZT(100)) {
+		t.Fatal("coin accepted while idle")
+	}
+	must(t, m.SelectTicket("metro", 1))
+	if !coins.Enabled {
+		t.Fatal("acceptor disabled while waiting for money")
+	}
+	coins.Insert(KZT(200))
+	coins.Insert(KZT(3))
+	m.PollCoins()
+	if m.InsertedMoney != KZT(200) {
+		t.Fatalf("inserted %s, want %s", m.InsertedMoney, KZT(200))
+	}
+	coins.Insert(KZT(100))
+	m.PollCoins()
+	if _, ok := m.State.(*MoneyReceivedState); !ok {
+		t.Fatalf("state %s once paid", m.State.Name())
+	}
+	_, err := m.DispenseTicket()
+	must(t, err)
+	if coins.Enabled {
+		t.Fatal("acceptor enabled after the sale")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ProductConfig is a product as described in a configuration document.
+// Fields left out keep their current value; a new product needs a name
+// and price.
+type ProductConfig struct {
+	Type            string   `json:"type"`
+	Name            string   `json:"name,omitempty"`
+	Description     string   `json:"description,omitempty"`
+	Price           *Money   `json:"price,omitempty"`
+	VATRate         *int     `json:"vat_bp,omitempty"`
+	ValidityMinutes int      `json:"validity_minutes,omitempty"`
+	LowStockAt      *int     `json:"low_stock_at,omitempty"`
+	Alternatives    []string `json:"alternatives,omitempty"`
+	Active          *bool    `json:"active,omitempty"`
+}
+
+// MachineConfig is a configuration document pushed to the fleet. Version
+// must increase with every document.
+type MachineConfig struct {
+	Version              int               `json:"version"`
+	Products             []ProductConfig   `json:"products,omitempty"`
+	ExactChangeThreshold *Money            `json:"exact_change_threshold,omitempty"`
+	TimeoutSeconds       int               `json:"timeout_seconds,omitempty"`
+	RetentionDays        int               `json:"retention_days,omitempty"`
+	Messages             map[string]string `json:"messages,omitempty"`
+}
+
+// SignedConfig is the envelope a configuration document travels in; the
+// signature is over the raw Config bytes.
+type SignedConfig struct {
+	KeyID     string `json:"kid"`
+	Config    []byte `json:"config"`
+	Signature []byte `json:"sig"`
+}
+
+// SignConfig builds a signed configuration envelope.
+func SignConfig(cfg MachineConfig, signer TicketSigner) ([]byte, error) {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.Sign(raw)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(SignedConfig{KeyID: signer.KeyID(), Config: raw, Signature: sig})
+}
+
+// ApplyConfig verifies a signed configuration document and applies it as a
+// whole: it is validated against a copy of the current configuration and
+// only swapped in when every part is valid, so a rejected document
+// changes nothing. It is only applied between transactions.
+func (m *TicketMachine) ApplyConfig(envelope []byte) error {
+	switch m.State.(type) {
+	case *IdleState, *CashBoxFullState, *OutOfServiceState, *MaintenanceState:
+	default:
+		return newError(CodeBusy, "transaction in progress, retry later")
+	}
+	cfg, err := m.verifyConfig(envelope)
+	if err == nil {
+		err = m.applyConfig(cfg)
+	}
+	if err != nil {
+		m.audit("", "config_rejected", err.Error())
+		return err
+	}
+	m.audit("", "config_applied", fmt.Sprintf("version %d", cfg.Version))
+	m.store("config", func(s Store) error { return s.SaveConfig(cfg) })
+	fmt.Fprintf(m.out(), "Configuration version %d applied\n", cfg.Version)
+	return nil
+}
+
+func (m *TicketMachine) applyConfig(cfg MachineConfig) error {
+	catalog := m.Catalog.clone()
+	for _, pc := range cfg.Products {
+		if err := applyProductConfig(catalog, pc); err != nil {
+			return newErrorf(CodeInvalidConfig, "config version %d: %w", cfg.Version, err)
+		}
+	}
+	if cfg.ExactChangeThreshold != nil && *cfg.ExactChangeThreshold < 0 {
+		return newErrorf(CodeInvalidConfig, "config version %d: negative exact change threshold", cfg.Version)
+	}
+	if cfg.TimeoutSeconds < 0 {
+		return newErrorf(CodeInvalidConfig, "config version %d: negative timeout", cfg.Version)
+	}
+	if cfg.RetentionDays < 0 {
+		return newErrorf(CodeInvalidConfig, "config version %d: negative retention", cfg.Version)
+	}
+	messages := map[string]string{}
+	for k, v := range m.Messages {
+		messages[k] = v
+	}
+	for k, v := range cfg.Messages {
+		messages[k] = v
+	}
+
+	m.Catalog = catalog
+	for _, pc := range cfg.Products {
+		m.persist(pc.Type)
+	}
+	m.Messages = messages
+	if cfg.ExactChangeThreshold != nil {
+		m.ExactChangeThreshold = *cfg.ExactChangeThreshold
+	}
+	if cfg.TimeoutSeconds > 0 {
+		m.Timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	if cfg.RetentionDays > 0 {
+		m.Retention.Keep = time.Duration(cfg.RetentionDays) * 24 * time.Hour
+	}
+	m.ConfigVersion = cfg.Version
+	return nil
+}
+
+func (m *TicketMachine) verifyConfig(envelope []byte) (MachineConfig, error) {
+	var env SignedConfig
+	if err := json.Unmarshal(envelope, &env); err != nil {
+		return MachineConfig{}, newError(CodeInvalidConfig, "malformed config envelope")
+	}
+	if m.ConfigVerifier == nil {
+		return MachineConfig{}, newError(CodeInvalidConfig, "remote configuration not enabled")
+	}
+	if err := m.ConfigVerifier.VerifySignature(env.KeyID, env.Config, env.Signature); err != nil {
+		return MachineConfig{}, newErrorf(CodeInvalidConfig, "config signature: %w", err)
+	}
+	var cfg MachineConfig
+	if err := json.Unmarshal(env.Config, &cfg); err != nil {
+		return MachineConfig{}, newErrorf(CodeInvalidConfig, "malformed config: %w", err)
+	}
+	if cfg.Version <= m.ConfigVersion {
+		return MachineConfig{}, newErrorf(CodeInvalidConfig, "config version %d is not newer than %d", cfg.Version, m.ConfigVersion)
+	}
+	return cfg, nil
+}
+
+func applyProductConfig(c *TicketCatalog, pc ProductConfig) error {
+	p, exists := c.Product(pc.Type)
+	if !exists {
+		if pc.Name == "" || pc.Price == nil {
+			return newErrorf(CodeInvalidConfig, "new product %q needs a name and price", pc.Type)
+		}
+		p = Product{Type: pc.Type, Validity: 90 * time.Minute}
+	}
+	if pc.Name != "" {
+		p.Name = pc.Name
+	}
+	if pc.Description != "" {
+		p.Description = pc.Description
+	}
+	if pc.Price != nil {
+		p.Price = *pc.Price
+	}
+	if pc.VATRate != nil {
+		if *pc.VATRate < 0 || *pc.VATRate > 10000 {
+			return newErrorf(CodeInvalidConfig, "product %s: invalid VAT rate", pc.Type)
+		}
+		p.VATRate = *pc.VATRate
+	}
+	if pc.ValidityMinutes > 0 {
+		p.Validity = time.Duration(pc.ValidityMinutes) * time.Minute
+	}
+	if pc.LowStockAt != nil {
+		if *pc.LowStockAt < 0 {
+			return newErrorf(CodeInvalidConfig, "product %s: negative low-stock threshold", pc.Type)
+		}
+		p.LowStockAt = *pc.LowStockAt
+	}
+	if pc.Alternatives != nil {
+		p.Alternatives = pc.Alternatives
+	}
+	if err := c.RegisterProduct(p); err != nil {
+		return newErrorf(CodeInvalidConfig, "product %s: %w", pc.Type, err)
+	}
+	if (pc.Active != nil && !*pc.Active) || (pc.Active == nil && exists && !p.Active) {
+		c.Deactivate(pc.Type)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyConfig(t *testing.T) {
+	fleet := &HMACSigner{ID: "fleet-1", Key: []byte("fleet-secret")}
+	price, badVAT, threshold := KZT(270), 20000, KZT(500)
+	tests := []struct {
+		name   string
+		cfg    MachineConfig
+		signer TicketSigner
+		busy   bool
+		code   ErrorCode
+	}{
+		{"applied", MachineConfig{Version: 1, Products: []ProductConfig{{Type: "bus", Price: &price}},
+			ExactChangeThreshold: &threshold, TimeoutSeconds: 45, Messages: map[string]string{"welcome": "Hi"}}, fleet, false, ""},
+		{"untrusted key", MachineConfig{Version: 1, Products: []ProductConfig{{Type: "bus", Price: &price}}},
+			&HMACSigner{ID: "fleet-1", Key: []byte("guess")}, false, CodeInvalidConfig},
+		{"old version", MachineConfig{Version: 0, Products: []ProductConfig{{Type: "bus", Price: &price}}}, fleet, false, CodeInvalidConfig},
+		{"one bad product rejects all", MachineConfig{Version: 1, Products: []ProductConfig{
+			{Type: "bus", Price: &price}, {Type: "metro", VATRate: &badVAT}}}, fleet, false, CodeInvalidConfig},
+		{"new product without a price", MachineConfig{Version: 1, Products: []ProductConfig{{Type: "ferry", Name: "Ferry"}}}, fleet, false, CodeInvalidConfig},
+		{"sale in progress", MachineConfig{Version: 1, Products: []ProductConfig{{Type: "bus", Price: &price}}}, fleet, true, CodeBusy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.ConfigVerifier = NewTicketVerifier(m.Clock)
+			m.ConfigVerifier.AddHMACKey(fleet.ID, fleet.Key)
+			if tt.busy {
+				must(t, m.SelectTicket("metro", 1))
+			}
+			doc, err := SignConfig(tt.cfg, tt.signer)
+			must(t, err)
+			err = m.ApplyConfig(doc)
+			if CodeOf(err) != tt.code {
+				t.Fatalf("ApplyConfig = %v, want %q", err, tt.code)
+			}
+			if tt.code != "" {
+				if m.GetTicketPrice("bus") != KZT(250) || m.ConfigVersion != 0 {
+					t.Fatalf("rejected config applied: bus %s, version %d", m.GetTicketPrice("bus"), m.ConfigVersion)
+				}
+				return
+			}
+			if m.GetTicketPrice("bus") != price || m.ConfigVersion != 1 || m.ExactChangeThreshold != threshold ||
+				m.Timeout != 45*time.Second || m.Messages["welcome"] != "Hi" {
+				t.Fatalf("bus %s, version %d, threshold %s, timeout %s, messages %v",
+					m.GetTicketPrice("bus"), m.ConfigVersion, m.ExactChangeThreshold, m.Timeout, m.Messages)
+			}
+			if err := m.ApplyConfig(doc); CodeOf(err) != CodeInvalidConfig {
+				t.Fatalf("replayed config = %v", err)
+			}
+		})
+	}
+}
+
+func TestApplyConfigNotEnabled(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.ConfigVerifier = nil
+	doc, err := SignConfig(MachineConfig{Version: 1}, &HMACSigner{ID: "k", Key: []byte("k")})
+	must(t, err)
+	if err := m.ApplyConfig(doc); CodeOf(err) != CodeInvalidConfig {
+		t.Fatalf("ApplyConfig = %v", err)
+	}
+	if err := m.ApplyConfig([]byte("not json")); CodeOf(err) != CodeInvalidConfig {
+		t.Fatalf("malformed envelope = %v", err)
+	}
+}
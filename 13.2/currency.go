@@ -0,0 +1,165 @@
+package main
+
+import "strings"
+
+// Currency is an ISO 4217 currency code.
+type Currency string
+
+const (
+	CurrencyKZT Currency = "KZT"
+	CurrencyUSD Currency = "USD"
+	CurrencyRUB Currency = "RUB"
+)
+
+// In formats the amount with the given currency code, e.g. "5.00 USD".
+func (m Money) In(c Currency) string {
+	return m.String() + " " + string(c)
+}
+
+// RateProvider converts amounts between currencies. Implementations may use
+// a fixed table or fetch live rates.
+type RateProvider interface {
+	Convert(amount Money, from, to Currency) (Money, error)
+}
+
+// FixedRates is a RateProvider backed by a static table: Rates[c] is the
+// value of one major unit of c expressed in Base.
+type FixedRates struct {
+	Base  Currency
+	Rates map[Currency]Money
+}
+
+func (r *FixedRates) Convert(amount Money, from, to Currency) (Money, error) {
+	if from == to {
+		return amount, nil
+	}
+	base, err := r.toBase(amount, from)
+	if err != nil {
+		return 0, err
+	}
+	if to == r.Base {
+		return base, nil
+	}
+	rate, ok := r.Rates[to]
+	if !ok || rate <= 0 {
+		return 0, newErrorf(CodeCurrency, "no exchange rate for %s", to)
+	}
+	return base * MinorUnits / rate, nil
+}
+
+func (r *FixedRates) toBase(amount Money, from Currency) (Money, error) {
+	if from == r.Base {
+		return amount, nil
+	}
+	rate, ok := r.Rates[from]
+	if !ok || rate <= 0 {
+		return 0, newErrorf(CodeCurrency, "no exchange rate for %s", from)
+	}
+	return amount * rate / MinorUnits, nil
+}
+
+// DefaultRates is the fixed KZT table used by NewTicketMachine.
+func DefaultRates() *FixedRates {
+	return &FixedRates{
+		Base: CurrencyKZT,
+		Rates: map[Currency]Money{
+			CurrencyUSD: KZT(520),
+			CurrencyRUB: 580, // 5.80 KZT
+		},
+	}
+}
+
+// AcceptsCurrency reports whether cash in c can be inserted.
+func (m *TicketMachine) AcceptsCurrency(c Currency) bool {
+	for _, a := range m.AcceptedCurrencies {
+		if a == c {
+			return true
+		}
+	}
+	return false
+}
+
+// PriceIn returns the price of a ticket converted to c.
+func (m *TicketMachine) PriceIn(ticketType string, c Currency) (Money, error) {
+	return m.Rates.Convert(m.GetTicketPrice(ticketType), m.Currency, c)
+}
+
+// formatPrice renders an amount in the machine currency followed by its
+// equivalent in every display currency, e.g. "300.00 KZT / 0.57 USD".
+func (m *TicketMachine) formatPrice(amount Money) string {
+	parts := []string{amount.In(m.Currency)}
+	for _, c := range m.DisplayCurrencies {
+		if c == m.Currency {
+			continue
+		}
+		if v, err := m.Rates.Convert(amount, m.Currency, c); err == nil {
+			parts = append(parts, v.In(c))
+		}
+	}
+	return strings.Join(parts, " / ")
+}
+
+// ForeignCash is a coin or note in a currency other than the machine's,
+// with the amount it was credited as when inserted.
+type ForeignCash struct {
+	Amount   Money    `json:"amount"`
+	Currency Currency `json:"currency"`
+	Credited Money    `json:"credited"`
+}
+
+// DefaultForeignDenominations are the notes NewTicketMachine accepts in
+// foreign currencies.
+func DefaultForeignDenominations() map[Currency][]Money {
+	return map[Currency][]Money{
+		CurrencyUSD: {KZT(1), KZT(5), KZT(10), KZT(20), KZT(50), KZT(100)},
+		CurrencyRUB: {KZT(50), KZT(100), KZT(200), KZT(500), KZT(1000)},
+	}
+}
+
+// AcceptsDenominationIn reports whether amount is an accepted coin or note
+// in c.
+func (m *TicketMachine) AcceptsDenominationIn(amount Money, c Currency) bool {
+	if c == m.Currency {
+		return m.AcceptsDenomination(amount)
+	}
+	for _, d := range m.ForeignDenominations[c] {
+		if d == amount {
+			return true
+		}
+	}
+	return false
+}
+
+// InsertMoneyIn inserts cash denominated in c. It goes through the same
+// checks as InsertMoney; the amount is converted to the machine currency
+// before it reaches the current state, and the coin or note itself is kept
+// for the cash box or handed back on cancel.
+func (m *TicketMachine) InsertMoneyIn(amount Money, c Currency) (err error) {
+	if !m.AcceptsCurrency(c) {
+		return newErrorf(CodeUnsupportedCurrency, "currency %s not accepted", c)
+	}
+	if c == m.Currency {
+		return m.InsertMoney(amount)
+	}
+	defer m.endAction(m.startAction("insert_in"), &err)
+	if err := m.logAction(JournalEntry{Action: "insert_in", Amount: amount, Currency: c}); err != nil {
+		return err
+	}
+	return m.insertCash(amount, c)
+}
+
+// convertCash converts an inserted amount in c to the machine currency.
+func (m *TicketMachine) convertCash(amount Money, c Currency) (Money, error) {
+	if c == m.Currency {
+		return amount, nil
+	}
+	var converted Money
+	err := m.deviceCall("rates.convert", &converted, func() (err error) {
+		converted, err = m.Rates.Convert(amount, c, m.Currency)
+		return err
+	})
+	if err != nil {
+		return 0, newError(CodeCurrency, "cannot convert inserted money")
+	}
+	return converted, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestInsertMoneyIn(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   Money
+		capacity int
+		sell     bool
+		code     ErrorCode
+		inBox    int
+	}{
+		{"sale keeps the note", KZT(1), 100, true, "", 1},
+		{"cancel hands it back", KZT(1), 100, false, "", 0},
+		{"unsupported denomination", KZT(3), 100, false, CodeUnsupportedDenomination, 0},
+		{"cash box full", KZT(1), 0, false, CodeCashBoxFull, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.AcceptedCurrencies = append(m.AcceptedCurrencies, CurrencyUSD)
+			m.CashBox.Capacity = tt.capacity
+			var inserted []MoneyInserted
+			m.Subscribe(func(e MachineEvent) {
+				if ev, ok := e.(MoneyInserted); ok {
+					inserted = append(inserted, ev)
+				}
+			})
+			must(t, m.SelectTicket("metro", 1))
+			err := m.InsertMoneyIn(tt.amount, CurrencyUSD)
+			if tt.code != "" {
+				if CodeOf(err) != tt.code {
+					t.Fatalf("err = %v, want %s", err, tt.code)
+				}
+				if m.InsertedMoney != 0 || len(m.SessionForeign) != 0 || len(inserted) != 0 {
+					t.Fatalf("refused note credited: inserted %s, session %v", m.InsertedMoney, m.SessionForeign)
+				}
+				return
+			}
+			must(t, err)
+			if m.InsertedMoney != KZT(520) || m.CashStats.Accepted != 1 {
+				t.Fatalf("inserted %s, accepted %d", m.InsertedMoney, m.CashStats.Accepted)
+			}
+			if len(inserted) != 1 || inserted[0].Foreign == nil || inserted[0].Foreign.Currency != CurrencyUSD {
+				t.Fatalf("events = %+v", inserted)
+			}
+			if tt.sell {
+				_, err = m.DispenseTicket()
+			} else {
+				err = m.Cancel()
+			}
+			must(t, err)
+			if len(m.CashBox.Foreign) != tt.inBox || len(m.SessionForeign) != 0 {
+				t.Fatalf("cash box foreign = %v, session %v", m.CashBox.Foreign, m.SessionForeign)
+			}
+			if tt.inBox > 0 && m.CashBox.Total() != KZT(520) {
+				t.Fatalf("cash box total = %s", m.CashBox.Total())
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DashboardView is what the dashboard shows of a machine; money is in
+// minor units.
+type DashboardView struct {
+	MachineID   string             `json:"machine_id"`
+	StationID   string             `json:"station_id,omitempty"`
+	State       string             `json:"state"`
+	Updated     time.Time          `json:"updated"`
+	Currency    Currency           `json:"currency"`
+	Transaction *DashboardSale     `json:"transaction,omitempty"`
+	Inventory   []DashboardProduct `json:"inventory"`
+	Cash        DashboardCash      `json:"cash"`
+	PaperLeft   int                `json:"paper_left"`
+	Events      []DashboardEvent   `json:"events"`
+}
+
+// DashboardSale is the transaction in flight.
+type DashboardSale struct {
+	ID          string `json:"id"`
+	TicketType  string `json:"ticket_type,omitempty"`
+	Price       Money  `json:"price"`
+	Inserted    Money  `json:"inserted"`
+	Outstanding Money  `json:"outstanding"`
+}
+
+// DashboardProduct is the stock of one product.
+type DashboardProduct struct {
+	Type       string `json:"type"`
+	Name       string `json:"name"`
+	Stock      int    `json:"stock"`
+	LowStockAt int    `json:"low_stock_at"`
+	Active     bool   `json:"active"`
+}
+
+// DashboardCash is the cash box fill and the change stock.
+type DashboardCash struct {
+	BoxTotal    Money       `json:"box_total"`
+	BoxCount    int         `json:"box_count"`
+	BoxCapacity int         `json:"box_capacity"`
+	Hopper      []TallyLine `json:"hopper"`
+	HopperTotal Money       `json:"hopper_total"`
+}
+
+// DashboardEvent is a machine event in the dashboard history.
+type DashboardEvent struct {
+	Time time.Time       `json:"time"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Dashboard is an HTTP dashboard of one machine for field technicians:
+// the page at /, the view as JSON at /state, and a Server-Sent Events
+// stream of views at /events that the page follows. The view is taken on
+// every machine event and on Tick, from the machine's own goroutine, so
+// requests never touch the machine while it runs. Requests sign in with
+// HTTP basic auth as an operator, ID and PIN, who needs the diagnostics
+// permission; without an Authenticator every request is refused.
+type Dashboard struct {
+	auth    Authenticator
+	authz   Authorizer
+	mu      sync.Mutex
+	keep    int
+	view    DashboardView
+	events  []DashboardEvent
+	streams map[chan []byte]bool
+	stop    func()
+}
+
+// Dashboard starts a dashboard for the machine that keeps the last
+// events; Close stops it.
+func (m *TicketMachine) Dashboard(events int) *Dashboard {
+	if events <= 0 {
+		events = 20
+	}
+	d := &Dashboard{auth: m.Auth, authz: m.Authz, keep: events, streams: map[chan []byte]bool{}}
+	unsubscribe := m.Subscribe(func(e MachineEvent) {
+		data, _ := json.Marshal(e)
+		d.record(DashboardEvent{Time: m.Clock.Now(), Type: eventType(e), Data: data})
+		d.refresh(m)
+	})
+	m.dashboard = d
+	d.stop = func() {
+		unsubscribe()
+		if m.dashboard == d {
+			m.dashboard = nil
+		}
+	}
+	d.refresh(m)
+	return d
+}
+
+// Close detaches the dashboard from the machine and ends its streams.
+func (d *Dashboard) Close() {
+	d.stop()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for s := range d.streams {
+		close(s)
+		delete(d.streams, s)
+	}
+}
+
+func (d *Dashboard) record(e DashboardEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.events = append(d.events, e)
+	if len(d.events) > d.keep {
+		d.events = append([]DashboardEvent(nil), d.events[len(d.events)-d.keep:]...)
+	}
+}
+
+// refresh takes a new view of the machine and sends it to the streams;
+// a stream that is behind misses the view and gets the next one.
+func (d *Dashboard) refresh(m *TicketMachine) {
+	if d == nil {
+		return
+	}
+	v := DashboardView{
+		MachineID: m.MachineID,
+		StationID: m.Location.StationID,
+		State:     m.State.Name(),
+		Updated:   m.Clock.Now(),
+		Currency:  m.Currency,
+		Inventory: []DashboardProduct{},
+		Cash: DashboardCash{
+			Hopper:      m.HopperLevels(),
+			HopperTotal: m.HopperTotal(),
+		},
+	}
+	if inSale(m.State) {
+		v.Transaction = &DashboardSale{
+			ID:          m.TransactionID,
+			TicketType:  m.CurrentTicket,
+			Price:       m.CurrentPrice,
+			Inserted:    m.PaidTotal(),
+			Outstanding: m.Outstanding(),
+		}
+	}
+	for _, p := range m.Catalog.List() {
+		v.Inventory = append(v.Inventory, DashboardProduct{Type: p.Type, Name: p.Name, Stock: p.Stock, LowStockAt: p.LowStockAt, Active: p.Active})
+	}
+	if b := m.CashBox; b != nil {
+		v.Cash.BoxTotal, v.Cash.BoxCount, v.Cash.BoxCapacity = b.Total(), b.Count(), b.Capacity
+	}
+	if m.Paper != nil {
+		v.PaperLeft = m.Paper.Remaining
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v.Events = append([]DashboardEvent{}, d.events...)
+	d.view = v
+	raw, _ := json.Marshal(v)
+	for s := range d.streams {
+		select {
+		case s <- raw:
+		default:
+		}
+	}
+}
+
+// View returns the last view taken.
+func (d *Dashboard) View() DashboardView {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.view
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !d.signedIn(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="ticket machine", charset="UTF-8"`)
+		http.Error(w, "operator sign-in required", http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/", "":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, dashboardPage)
+	case "/state":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d.View())
+	case "/events":
+		d.stream(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (d *Dashboard) signedIn(r *http.Request) bool {
+	id, pin, ok := r.BasicAuth()
+	if !ok || d.auth == nil || d.auth.Authenticate(id, pin) != nil {
+		return false
+	}
+	return d.authz == nil || d.authz.Authorize(id, PermDiagnostics) == nil
+}
+
+func (d *Dashboard) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	s := make(chan []byte, 4)
+	d.mu.Lock()
+	d.streams[s] = true
+	first, _ := json.Marshal(d.view)
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.streams[s] {
+			delete(d.streams, s)
+		}
+	}()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "data: %s\n\n", first)
+	flusher.Flush()
+	for {
+		select {
+		case raw, ok := <-s:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", raw)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+const dashboardPage = `<!doctype html>
+<html><head><meta charset="utf-8"><title>Ticket machine</title>
+<style>
+body{font-family:sans-serif;margin:1em}table{border-collapse:collapse}
+td,th{border:1px solid #ccc;padding:2px 8px;text-align:left}.low{color:#b00}
+</style></head>
+<body>
+<h1 id="title">Ticket machine</h1>
+<p>State: <b id="state"></b> <span id="sale"></span></p>
+<h2>Inventory</h2><table id="inventory"></table>
+<h2>Cash</h2><p id="cash"></p><table id="hopper"></table>
+<h2>Paper</h2><p id="paper"></p>
+<h2>Recent events</h2><table id="events"></table>
+<script>
+const money = v => (v / 100).toFixed(2);
+// A cell is a value or {text, cls}; it is set as text, never as HTML.
+const rows = (id, head, list) => {
+  const table = document.getElementById(id);
+  table.replaceChildren();
+  const add = (tag, cells) => {
+    const tr = table.insertRow();
+    for (const c of cells) {
+      const cell = document.createElement(tag);
+      if (c !== null && typeof c === "object") {
+        cell.textContent = c.text;
+        cell.className = c.cls;
+      } else {
+        cell.textContent = c;
+      }
+      tr.appendChild(cell);
+    }
+  };
+  add("th", head);
+  list.forEach(r => add("td", r));
+};
+function show(v) {
+  document.getElementById("title").textContent = v.machine_id + (v.station_id ? " @ " + v.station_id : "");
+  document.getElementById("state").textContent = v.state;
+  const t = v.transaction;
+  document.getElementById("sale").textContent = t ?
+    t.id + " " + (t.ticket_type || "") + " price " + money(t.price) + ", inserted " + money(t.inserted) + ", due " + money(t.outstanding) : "";
+  rows("inventory", ["Type", "Name", "Stock", "On sale"], v.inventory.map(p =>
+    [p.type, p.name, (p.stock <= p.low_stock_at ? {text: p.stock, cls: "low"} : p.stock), p.active ? "yes" : "no"]));
+  const c = v.cash;
+  document.getElementById("cash").textContent = "Cash box " + money(c.box_total) + " " + v.currency + ", " +
+    c.box_count + "/" + c.box_capacity + " notes and coins; change stock " + money(c.hopper_total);
+  rows("hopper", ["Denomination", "Count"], (c.hopper || []).map(l => [money(l.Denomination), l.Count]));
+  document.getElementById("paper").textContent = v.paper_left + " tickets left";
+  rows("events", ["Time", "Event", "Data"], v.events.slice().reverse().map(e =>
+    [new Date(e.time).toLocaleTimeString(), e.type, JSON.stringify(e.data)]));
+}
+new EventSource("events").onmessage = e => show(JSON.parse(e.data));
+</script>
+</body></html>
+`
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboardRequiresSignIn(t *testing.T) {
+	m, _ := newTestMachine(t)
+	d := m.Dashboard(0)
+	defer d.Close()
+	tests := []struct {
+		name      string
+		id, pin   string
+		basicAuth bool
+		want      int
+	}{
+		{name: "no credentials", want: http.StatusUnauthorized},
+		{name: "wrong pin", id: "admin", pin: "9999", basicAuth: true, want: http.StatusUnauthorized},
+		{name: "no diagnostics permission", id: "clerk", pin: "1111", basicAuth: true, want: http.StatusUnauthorized},
+		{name: "supervisor", id: "admin", pin: "0000", basicAuth: true, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/state", nil)
+			if tt.basicAuth {
+				req.SetBasicAuth(tt.id, tt.pin)
+			}
+			w := httptest.NewRecorder()
+			d.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate challenge")
+			}
+		})
+	}
+}
+
+func TestDashboardWithoutAuthenticator(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.Auth = nil
+	d := m.Dashboard(0)
+	defer d.Close()
+	req := httptest.NewRequest("GET", "/state", nil)
+	req.SetBasicAuth("admin", "0000")
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestDashboardPageSetsText(t *testing.T) {
+	if strings.Contains(dashboardPage, "innerHTML") {
+		t.Error("dashboard page writes HTML from machine data")
+	}
+}
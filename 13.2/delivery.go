@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// DeliveryChannel is how e-tickets reach the rider.
+type DeliveryChannel string
+
+const (
+	ChannelEmail DeliveryChannel = "email"
+	ChannelSMS   DeliveryChannel = "sms"
+	ChannelPush  DeliveryChannel = "push"
+)
+
+// DeliveryStatus tracks an e-ticket delivery.
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// TicketDeliverer sends tickets to an address on one channel: an email
+// address, a phone number or a push token.
+type TicketDeliverer interface {
+	Deliver(address string, tickets []Ticket) error
+}
+
+// MockDeliverer records what it sends and fails while Fail is set.
+type MockDeliverer struct {
+	mu   sync.Mutex
+	Fail error
+	Sent map[string][]Ticket
+}
+
+func (d *MockDeliverer) Deliver(address string, tickets []Ticket) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.Fail != nil {
+		return d.Fail
+	}
+	if d.Sent == nil {
+		d.Sent = map[string][]Ticket{}
+	}
+	d.Sent[address] = append(d.Sent[address], tickets...)
+	return nil
+}
+
+// DeliveryRequest is the rider's choice of digital delivery.
+type DeliveryRequest struct {
+	Channel DeliveryChannel
+	Address string
+}
+
+// Delivery is the state of an asynchronous e-ticket delivery. It is
+// updated in the background, so read it through its methods.
+type Delivery struct {
+	DeliveryRequest
+
+	mu       sync.Mutex
+	status   DeliveryStatus
+	attempts int
+	err      error
+}
+
+// Status returns the delivery status, the attempts made and the last error.
+func (d *Delivery) Status() (DeliveryStatus, int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status, d.attempts, d.err
+}
+
+func (d *Delivery) update(s DeliveryStatus, attempts int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status, d.attempts, d.err = s, attempts, err
+}
+
+// ChooseDelivery asks for the tickets of the current sale to be sent
+// digitally as well. It can be chosen until the tickets are dispensed, or
+// after a printer failure in place of the unprinted tickets.
+func (m *TicketMachine) ChooseDelivery(channel DeliveryChannel, address string) error {
+	if err := m.logAction(JournalEntry{Action: "delivery", Channel: channel, Address: address}); err != nil {
+		return err
+	}
+	switch m.State.(type) {
+	case *WaitingForMoneyState, *MoneyReceivedState, *PrintErrorState:
+	default:
+		return newError(CodeInvalidState, "delivery cannot be chosen now")
+	}
+	if m.TopUp != nil {
+		return newError(CodeInvalidState, "top-ups issue no tickets")
+	}
+	if _, ok := m.Deliverers[channel]; !ok {
+		return newErrorf(CodeDeliveryUnavailable, "%s delivery not available", channel)
+	}
+	switch {
+	case channel == ChannelEmail && !strings.Contains(address, "@"),
+		channel == ChannelSMS && !strings.HasPrefix(address, "+"),
+		address == "":
+		return newErrorf(CodeInvalidInput, "invalid %s address", channel)
+	}
+	m.Delivery = &DeliveryRequest{Channel: channel, Address: address}
+	m.show("Tickets will be sent by %s to %s", channel, address)
+	return nil
+}
+
+// deliver starts the requested delivery of a sale's tickets in the
+// background, retrying per DeliveryRetry.
+func (m *TicketMachine) deliver(rec *TransactionRecord) {
+	if m.Delivery == nil {
+		return
+	}
+	d := &Delivery{DeliveryRequest: *m.Delivery, status: DeliveryPending}
+	m.Delivery = nil
+	rec.Delivery = d
+	deliverer, policy, sleep, tickets := m.Deliverers[d.Channel], m.DeliveryRetry, m.Sleep, rec.Tickets
+	m.deliveries.Add(1)
+	go func() {
+		defer m.deliveries.Done()
+		var err error
+		for attempt := 0; attempt < policy.MaxAttempts; attempt++ {
+			if attempt > 0 {
+				sleep(policy.delay(attempt - 1))
+			}
+			if err = deliverer.Deliver(d.Address, tickets); err == nil {
+				d.update(DeliveryDelivered, attempt+1, nil)
+				return
+			}
+			d.update(DeliveryPending, attempt+1, err)
+		}
+		_, attempts, _ := d.Status()
+		d.update(DeliveryFailed, attempts, err)
+	}()
+}
+
+// WaitForDeliveries blocks until background deliveries have finished.
+func (m *TicketMachine) WaitForDeliveries() {
+	m.deliveries.Wait()
+}
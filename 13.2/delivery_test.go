@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChooseDelivery(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel DeliveryChannel
+		address string
+		code    ErrorCode
+	}{
+		{"email", ChannelEmail, "rider@example.com", ""},
+		{"sms", ChannelSMS, "+77010000000", ""},
+		{"bad email", ChannelEmail, "rider", CodeInvalidInput},
+		{"bad phone", ChannelSMS, "87010000000", CodeInvalidInput},
+		{"channel not fitted", ChannelPush, "token", CodeDeliveryUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.Deliverers = map[DeliveryChannel]TicketDeliverer{ChannelEmail: &MockDeliverer{}, ChannelSMS: &MockDeliverer{}}
+			must(t, m.SelectTicket("metro", 1))
+			if err := m.ChooseDelivery(tt.channel, tt.address); CodeOf(err) != tt.code {
+				t.Fatalf("ChooseDelivery = %v, want %q", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestDeliverTickets(t *testing.T) {
+	tests := []struct {
+		name     string
+		fails    bool
+		status   DeliveryStatus
+		attempts int
+	}{
+		{"delivered", false, DeliveryDelivered, 1},
+		{"gives up after the retries", true, DeliveryFailed, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			d := &MockDeliverer{}
+			if tt.fails {
+				d.Fail = errors.New("mailbox full")
+			}
+			m.Deliverers = map[DeliveryChannel]TicketDeliverer{ChannelEmail: d}
+			m.Sleep = func(time.Duration) {}
+			must(t, m.SelectTicket("metro", 1))
+			must(t, m.ChooseDelivery(ChannelEmail, "rider@example.com"))
+			must(t, m.InsertMoney(KZT(200)))
+			must(t, m.InsertMoney(KZT(100)))
+			dispensed, err := m.DispenseTicket()
+			must(t, err)
+			m.WaitForDeliveries()
+			rec := m.Transactions[len(m.Transactions)-1]
+			status, attempts, _ := rec.Delivery.Status()
+			if status != tt.status || attempts != tt.attempts {
+				t.Fatalf("status %s after %d attempts, want %s after %d", status, attempts, tt.status, tt.attempts)
+			}
+			if !tt.fails && d.Sent["rider@example.com"][0].ID != dispensed.Tickets[0].ID {
+				t.Fatalf("sent %+v", d.Sent)
+			}
+		})
+	}
+}
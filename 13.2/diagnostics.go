@@ -0,0 +1,131 @@
+package main
+
+import "fmt"
+
+// SelfTester is implemented by devices that can check themselves. Devices
+// without it are reported as not tested, and a critical one as failed.
+type SelfTester interface {
+	SelfTest() error
+}
+
+// ComponentResult is the diagnostic outcome of one device.
+type ComponentResult struct {
+	Component string
+	Critical  bool
+	Tested    bool
+	Err       error
+}
+
+// Passed reports whether the component passed or, not being critical,
+// could not be tested.
+func (r ComponentResult) Passed() bool { return r.Err == nil }
+
+func (r ComponentResult) String() string {
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("%s: FAIL (%v)", r.Component, r.Err)
+	case !r.Tested:
+		return r.Component + ": not tested"
+	}
+	return r.Component + ": ok"
+}
+
+type component struct {
+	name     string
+	critical bool
+	device   interface{}
+}
+
+// errNoSelfTest fails a critical device that cannot test itself, since
+// nothing shows it works; errNotFitted one that is missing.
+var (
+	errNoSelfTest = newError(CodeHardware, "no self-test")
+	errNotFitted  = newError(CodeHardware, "not fitted")
+)
+
+// test is the self-test of the device, nil for a non-critical device
+// without one.
+func (c component) test() func() error {
+	if c.device == nil {
+		if c.critical {
+			return func() error { return errNotFitted }
+		}
+		return nil
+	}
+	if t, ok := c.device.(SelfTester); ok {
+		return t.SelfTest
+	}
+	if c.critical {
+		return func() error { return errNoSelfTest }
+	}
+	return nil
+}
+
+// components lists the machine's devices, each named after its field;
+// critical ones stop sales when they fail or are missing. The cash
+// validator is a check run on each insertion rather than a device, so it
+// is not critical. Without a coin acceptor or bill validator the host
+// feeds InsertMoney itself, so they are critical only when fitted.
+func (m *TicketMachine) components() []component {
+	return []component{
+		{"cash_validator", false, m.Validator},
+		{"coin_acceptor", m.Coins != nil, m.Coins},
+		{"bill_validator", m.Bills != nil, m.Bills},
+		{"ticket_printer", true, m.Printer},
+		{"receipt_printer", false, m.ReceiptPrinter},
+		{"nfc_reader", false, m.NFCReader},
+		{"card_reader", false, m.CardReader},
+		{"change_dispenser", false, m.ChangeDispenser},
+		{"card_gateway", false, m.Gateway},
+		{"fiscal_printer", false, m.Fiscal},
+		{"transit_card_reader", false, m.TransitCards},
+		{"qr_provider", false, m.QRProvider},
+	}
+}
+
+// RunDiagnostics self-tests every device. If a critical one fails the
+// machine goes out of service when the session ends.
+func (s *AdminSession) RunDiagnostics() ([]ComponentResult, error) {
+	if err := s.active(); err != nil {
+		return nil, err
+	}
+	if err := s.m.authorize(s.OperatorID, PermDiagnostics, "diagnostics"); err != nil {
+		return nil, err
+	}
+	var results []ComponentResult
+	var failed []string
+	for _, c := range s.m.components() {
+		r := ComponentResult{Component: c.name, Critical: c.critical}
+		if _, ok := c.device.(SelfTester); ok {
+			r.Tested = true
+		}
+		if test := c.test(); test != nil {
+			r.Err = test()
+		}
+		if r.Err != nil && r.Critical {
+			failed = append(failed, r.String())
+		}
+		results = append(results, r)
+		fmt.Fprintln(s.m.out(), r)
+	}
+	s.m.audit(s.OperatorID, "diagnostics", fmt.Sprintf("%d critical failures", len(failed)))
+	if len(failed) > 0 {
+		s.resume = &OutOfServiceState{Reason: ReasonHardwareFault, Detail: failed[0]}
+	}
+	return results, nil
+}
+
+func (r *MockNFCReader) SelfTest() error     { return r.Err }
+func (p *MockFiscalPrinter) SelfTest() error { return p.Err }
+func (d *MockTransitCards) SelfTest() error  { return nil }
+func (g *MockGateway) SelfTest() error       { return g.Fail }
+
+func (g *ResilientGateway) SelfTest() error {
+	if g.Breaker != nil && g.Breaker.Open() {
+		return ErrCircuitOpen
+	}
+	if t, ok := g.Gateway.(SelfTester); ok {
+		return t.SelfTest()
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDiagnosticsComponents(t *testing.T) {
+	tests := []struct {
+		name      string
+		strip     func(m *TicketMachine)
+		component string
+		passed    bool
+		canSell   bool
+	}{
+		{"ticket printer missing", func(m *TicketMachine) { m.Printer = nil }, "ticket_printer", false, false},
+		{"nfc reader failing", func(m *TicketMachine) { m.NFCReader.(*MockNFCReader).Err = errors.New("no field") },
+			"nfc_reader", false, true},
+		{"nfc reader missing", func(m *TicketMachine) { m.NFCReader = nil }, "nfc_reader", true, true},
+		{"card reader missing", func(m *TicketMachine) { m.CardReader = nil }, "card_reader", true, true},
+		{"cash validator missing", func(m *TicketMachine) { m.Validator = nil }, "cash_validator", true, true},
+		{"no coin acceptor", func(m *TicketMachine) { m.Coins = nil }, "coin_acceptor", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			tt.strip(m)
+			if r := m.Health(); r.CanSell != tt.canSell {
+				t.Errorf("can sell %v, want %v: %v", r.CanSell, tt.canSell, r.Reasons)
+			}
+			s, err := m.EnterAdminMode(Credentials{OperatorID: "admin", PIN: "0000"})
+			must(t, err)
+			results, err := s.RunDiagnostics()
+			must(t, err)
+			found := false
+			for _, r := range results {
+				if r.Component != tt.component {
+					continue
+				}
+				found = true
+				if r.Passed() != tt.passed {
+					t.Errorf("%s, want passed %v", r, tt.passed)
+				}
+			}
+			if !found {
+				t.Errorf("no %s in %v", tt.component, results)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// DispenseFault is a change dispenser failure.
+type DispenseFault string
+
+const (
+	FaultJam       DispenseFault = "jam"
+	FaultTubeEmpty DispenseFault = "tube_empty"
+)
+
+// DispenseError reports a change dispenser failure at Denomination, with
+// the coins and notes paid out before it.
+type DispenseError struct {
+	Fault        DispenseFault
+	Denomination Money
+	Paid         []TallyLine
+}
+
+func (e *DispenseError) ErrorCode() ErrorCode { return CodeHardware }
+
+func (e *DispenseError) Error() string {
+	return fmt.Sprintf("change dispenser %s at %s", e.Fault, e.Denomination)
+}
+
+// ChangeDispenser is the change hardware. DispenseAmount pays out amount
+// following the denomination plan worked out from the hopper.
+type ChangeDispenser interface {
+	DispenseAmount(amount Money, plan []TallyLine) error
+}
+
+// MockChangeDispenser pays out every plan, except that it jams at Jam or
+// finds the tube of a denomination in Empty empty.
+type MockChangeDispenser struct {
+	Jam       Money
+	Empty     map[Money]bool
+	Dispensed []TallyLine
+}
+
+func (d *MockChangeDispenser) DispenseAmount(amount Money, plan []TallyLine) error {
+	var paid []TallyLine
+	for _, l := range plan {
+		fault := DispenseFault("")
+		switch {
+		case l.Denomination == d.Jam:
+			fault = FaultJam
+		case d.Empty[l.Denomination]:
+			fault = FaultTubeEmpty
+		}
+		if fault != "" {
+			return &DispenseError{Fault: fault, Denomination: l.Denomination, Paid: paid}
+		}
+		paid = append(paid, l)
+		d.Dispensed = append(d.Dispensed, l)
+	}
+	return nil
+}
+
+func (d *MockChangeDispenser) SelfTest() error {
+	if d.Jam != 0 {
+		return &DispenseError{Fault: FaultJam, Denomination: d.Jam}
+	}
+	return nil
+}
+
+// ChangeVoucher records money owed to a rider: change the dispenser failed
+// to pay out, or cash taken before a coin jam. A printed voucher is
+// redeemed by the rider at the ticket office; otherwise an attendant is
+// called to the machine.
+type ChangeVoucher struct {
+	Code          string
+	TransactionID string
+	Amount        Money
+	IssuedAt      time.Time
+	Printed       bool
+	Redeemed      bool
+}
+
+func newVoucherCode() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "CV-" + hex.EncodeToString(b)
+}
+
+// dispenseChange pays out change through the dispenser, its coins taken
+// from stock. On a failure the coins it did not pay out go back into
+// stock, an empty tube is zeroed in the hopper, and the shortfall is owed
+// on a voucher.
+func (m *TicketMachine) dispenseChange(c *Change, stock map[Money]int) {
+	if m.ChangeDispenser == nil {
+		return
+	}
+	err := m.deviceCall("change.dispense", nil, func() error { return m.ChangeDispenser.DispenseAmount(c.Amount, c.Coins) })
+	if err == nil {
+		return
+	}
+	var paid []TallyLine
+	if de, ok := err.(*DispenseError); ok {
+		paid = de.Paid
+	}
+	paidCount := map[Money]int{}
+	var total Money
+	for _, l := range paid {
+		paidCount[l.Denomination] += l.Count
+		total += l.Denomination * Money(l.Count)
+	}
+	for _, l := range c.Coins {
+		stock[l.Denomination] += l.Count - paidCount[l.Denomination]
+	}
+	if de, ok := err.(*DispenseError); ok && de.Fault == FaultTubeEmpty {
+		m.Hopper[de.Denomination] = 0
+	}
+	m.audit("", "change_fault", err.Error())
+	m.notify(Alert{Kind: AlertChangeFault, Detail: err.Error()})
+	c.Coins = paid
+	m.show("Change could not be paid out.")
+	c.Voucher = m.issueVoucher(c.Amount - total)
+}
+
+// issueVoucher records money owed to the rider and prints a voucher for
+// it, or calls an attendant when no voucher can be printed.
+func (m *TicketMachine) issueVoucher(amount Money) *ChangeVoucher {
+	v := ChangeVoucher{Code: newVoucherCode(), TransactionID: m.TransactionID, Amount: amount, IssuedAt: m.Clock.Now()}
+	if m.Printer != nil && !m.Paper.out() {
+		text := fmt.Sprintf("VOUCHER %s\n%s owed for %s\nRedeem at the ticket office\n", v.Code, amount.In(m.Currency), v.TransactionID)
+		if err := m.deviceCall("printer.print", nil, func() error {
+			return m.Printer.Print(Ticket{ID: v.Code, IssuedAt: v.IssuedAt}, text)
+		}); err == nil {
+			m.usePaper(1)
+			v.Printed = true
+		}
+	}
+	m.Vouchers = append(m.Vouchers, v)
+	m.audit("", "change_voucher", fmt.Sprintf("%s %s", v.Code, amount))
+	if v.Printed {
+		m.show("Voucher %s issued for %s", v.Code, amount.In(m.Currency))
+	} else {
+		m.notify(Alert{Kind: AlertAttendant, Detail: fmt.Sprintf("%s change owed for %s", amount.In(m.Currency), v.TransactionID)})
+		m.show("Please wait, an attendant will bring your %s", amount.In(m.Currency))
+	}
+	return &v
+}
+
+// RedeemVoucher records that the change owed on a voucher was paid out by
+// hand.
+func (s *AdminSession) RedeemVoucher(code string) (ChangeVoucher, error) {
+	if err := s.active(); err != nil {
+		return ChangeVoucher{}, err
+	}
+	if err := s.m.authorize(s.OperatorID, PermCollectCash, "redeem_voucher"); err != nil {
+		return ChangeVoucher{}, err
+	}
+	for i := range s.m.Vouchers {
+		v := &s.m.Vouchers[i]
+		if v.Code != code {
+			continue
+		}
+		if v.Redeemed {
+			return ChangeVoucher{}, newError(CodeInvalidInput, "voucher already redeemed")
+		}
+		v.Redeemed = true
+		s.m.audit(s.OperatorID, "redeem_voucher", fmt.Sprintf("%s %s", code, v.Amount))
+		return *v, nil
+	}
+	return ChangeVoucher{}, newError(CodeInvalidInput, "unknown voucher")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPayoutsGoThroughDispenser(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, m *TicketMachine)
+		owed Money
+	}{
+		{"cancel", func(t *testing.T, m *TicketMachine) {
+			must(t, m.SelectTicket("metro", 1))
+			must(t, m.InsertMoney(KZT(200)))
+			must(t, m.Cancel())
+		}, KZT(200)},
+		{"refund", func(t *testing.T, m *TicketMachine) {
+			sellMetro(t, m)
+			m.StartOver()
+			if _, err := m.RefundTicket(m.Transactions[0].Tickets[0].ID); err != nil {
+				t.Fatal(err)
+			}
+		}, KZT(300)},
+		{"coin jam", func(t *testing.T, m *TicketMachine) {
+			must(t, m.SelectTicket("metro", 1))
+			must(t, m.InsertMoney(KZT(200)))
+			m.coinJam(errors.New("coin stuck"))
+		}, KZT(200)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			empty := map[Money]bool{}
+			for _, d := range m.Denominations {
+				empty[d] = true
+			}
+			m.ChangeDispenser = &MockChangeDispenser{Empty: empty}
+			tt.run(t, m)
+			if len(m.Vouchers) != 1 || m.Vouchers[0].Amount != tt.owed {
+				t.Errorf("vouchers %+v, want one for %s", m.Vouchers, tt.owed)
+			}
+		})
+	}
+}
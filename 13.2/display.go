@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// Selection is a ticket choice shown to the customer, with its price once
+// known and the next step when one is needed.
+type Selection struct {
+	TicketType string
+	Qty        int
+	Price      string
+	Prompt     string
+}
+
+// Balance is the money inserted against the price. Additional is set for
+// money inserted after the price was already covered.
+type Balance struct {
+	Inserted   Money
+	Total      Money
+	Price      Money
+	Currency   Currency
+	Additional bool
+}
+
+// Display is the customer-facing screen: a console, an LCD or a web front
+// end.
+type Display interface {
+	ShowPrice(s Selection)
+	ShowBalance(b Balance)
+	ShowError(err error)
+	// ShowIdle shows the ready screen with the configured welcome text.
+	ShowIdle(welcome string)
+	ShowMessage(text string)
+}
+
+// ConsoleDisplay prints to W, stdout when nil.
+type ConsoleDisplay struct {
+	W io.Writer
+}
+
+func (d ConsoleDisplay) ShowPrice(s Selection) {
+	line := "Ticket selected: " + s.TicketType
+	if s.Qty > 1 {
+		line = fmt.Sprintf("Tickets selected: %d x %s", s.Qty, s.TicketType)
+	}
+	if s.Price != "" {
+		line += " (" + s.Price + ")"
+	}
+	if s.Prompt != "" {
+		line += ". " + s.Prompt
+	}
+	fmt.Fprintln(orStdout(d.W), line)
+}
+
+func (d ConsoleDisplay) ShowBalance(b Balance) {
+	if b.Additional {
+		fmt.Fprintf(orStdout(d.W), "Additional funds inserted: %s\n", b.Inserted.In(b.Currency))
+		return
+	}
+	fmt.Fprintf(orStdout(d.W), "Inserted: %s (Total: %s)\n", b.Inserted.In(b.Currency), b.Total)
+	if b.Total >= b.Price {
+		fmt.Fprintln(orStdout(d.W), "Sufficient funds. Ready to dispense ticket.")
+	}
+}
+
+func (d ConsoleDisplay) ShowError(err error) { fmt.Fprintln(orStdout(d.W), "Error:", err) }
+
+func (d ConsoleDisplay) ShowIdle(welcome string) {
+	if welcome != "" {
+		fmt.Fprintln(orStdout(d.W), welcome)
+	}
+}
+
+func (d ConsoleDisplay) ShowMessage(text string) { fmt.Fprintln(orStdout(d.W), text) }
+
+func (m *TicketMachine) display() Display {
+	var d Display = ConsoleDisplay{W: m.Out}
+	if m.Display != nil {
+		d = m.Display
+	}
+	if m.Accessible && m.TTS != nil {
+		return speakingDisplay{Display: d, m: m}
+	}
+	return d
+}
+
+// show formats a message for the customer display.
+func (m *TicketMachine) show(format string, args ...interface{}) {
+	m.display().ShowMessage(fmt.Sprintf(format, args...))
+}
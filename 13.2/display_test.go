@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/TheStilk/templates-homework-13/13.2/machinetest/hwsim"
+)
+
+func TestDisplayFollowsSale(t *testing.T) {
+	m, _ := newTestMachine(t)
+	d := &hwsim.Display[Selection, Balance]{
+		FormatPrice:   func(s Selection) string { return "price " + s.TicketType },
+		FormatBalance: func(b Balance) string { return "balance " + b.Total.String() },
+	}
+	m.Display = d
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.InsertMoney(KZT(200)))
+	must(t, m.InsertMoney(KZT(100)))
+	for _, line := range []string{"price metro", "balance " + KZT(200).String(), "balance " + KZT(300).String()} {
+		if !d.Shown(line) {
+			t.Errorf("%q not shown; screens %q", line, d.Lines)
+		}
+	}
+}
+
+func TestConsoleDisplay(t *testing.T) {
+	tests := []struct {
+		name string
+		show func(Display)
+		want string
+	}{
+		{"one ticket", func(d Display) { d.ShowPrice(Selection{TicketType: "metro", Qty: 1, Price: "300.00 KZT"}) },
+			"Ticket selected: metro (300.00 KZT)\n"},
+		{"several tickets", func(d Display) { d.ShowPrice(Selection{TicketType: "bus", Qty: 2, Prompt: "Insert money"}) },
+			"Tickets selected: 2 x bus. Insert money\n"},
+		{"covered", func(d Display) {
+			d.ShowBalance(Balance{Inserted: KZT(300), Total: KZT(300), Price: KZT(300), Currency: CurrencyKZT})
+		}, "Inserted: " + KZT(300).In(CurrencyKZT) + " (Total: " + KZT(300).String() + ")\nSufficient funds. Ready to dispense ticket.\n"},
+		{"error", func(d Display) { d.ShowError(errors.New("no change")) }, "Error: no change\n"},
+		{"idle without welcome", func(d Display) { d.ShowIdle("") }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tt.show(ConsoleDisplay{W: &out})
+			if out.String() != tt.want {
+				t.Fatalf("shown %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
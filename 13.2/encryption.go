@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+// KeyProvider hands out data encryption keys, 16, 24 or 32 byte AES
+// keys, e.g. from a TPM or the fleet key service. CurrentKey names the key
+// new records are sealed under; it is asked for every record so a rotated
+// key takes effect at once. Key looks a key up by ID, so records sealed
+// before a rotation still open.
+type KeyProvider interface {
+	CurrentKey() (id string, err error)
+	Key(id string) ([]byte, error)
+}
+
+// StaticKey is a fixed key with ID "static", for tests and demos.
+type StaticKey []byte
+
+func (k StaticKey) CurrentKey() (string, error) { return "static", nil }
+
+func (k StaticKey) Key(id string) ([]byte, error) {
+	if id != "static" {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+	return k, nil
+}
+
+// KeyRing holds keys by ID and seals under Current. A key is rotated by
+// adding the new one and moving Current to it; the old one stays for as
+// long as records sealed under it are kept.
+type KeyRing struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+func (r *KeyRing) CurrentKey() (string, error) {
+	if _, ok := r.Keys[r.Current]; !ok {
+		return "", fmt.Errorf("unknown key %q", r.Current)
+	}
+	return r.Current, nil
+}
+
+func (r *KeyRing) Key(id string) ([]byte, error) {
+	key, ok := r.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+	return key, nil
+}
+
+// Sealer encrypts records at rest with AES-GCM under the keys of Keys. A
+// sealed record is one byte with the length of the key ID, the key ID,
+// the random nonce and the ciphertext; the key ID is authenticated with
+// it.
+type Sealer struct {
+	Keys KeyProvider
+}
+
+func (s *Sealer) aead(id string) (cipher.AEAD, error) {
+	key, err := s.Keys.Key(id)
+	if err != nil {
+		return nil, newErrorf(CodeStorage, "encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, newErrorf(CodeStorage, "encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts plain under the current key.
+func (s *Sealer) Seal(plain []byte) ([]byte, error) {
+	id, err := s.Keys.CurrentKey()
+	if err != nil {
+		return nil, newErrorf(CodeStorage, "encryption key: %w", err)
+	}
+	if id == "" || len(id) > 255 {
+		return nil, newErrorf(CodeStorage, "encryption key: bad key ID %q", id)
+	}
+	gcm, err := s.aead(id)
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte{byte(len(id))}, id...)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plain, []byte(id)), nil
+}
+
+// Open decrypts a sealed record under the key it names, failing if it was
+// tampered with or its key is not known.
+func (s *Sealer) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) == 0 || len(sealed) < 1+int(sealed[0]) {
+		return nil, newError(CodeStorage, "sealed record too short")
+	}
+	id, sealed := string(sealed[1:1+sealed[0]]), sealed[1+sealed[0]:]
+	gcm, err := s.aead(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, newError(CodeStorage, "sealed record too short")
+	}
+	nonce, ct := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ct, []byte(id))
+	if err != nil {
+		return nil, newError(CodeStorage, "sealed record cannot be decrypted")
+	}
+	return plain, nil
+}
+
+// sealLine seals one journal line as base64 text, keeping the journal
+// line-oriented.
+func (s *Sealer) sealLine(line []byte) ([]byte, error) {
+	sealed, err := s.Seal(line)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(out, sealed)
+	return out, nil
+}
+
+// OpenJournal decrypts a journal written with a Sealer so it can be
+// passed to Replay.
+func OpenJournal(r io.Reader, s *Sealer) (io.Reader, error) {
+	var out bytes.Buffer
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		sealed, err := base64.StdEncoding.DecodeString(sc.Text())
+		if err != nil {
+			return nil, newErrorf(CodeStorage, "journal: %w", err)
+		}
+		line, err := s.Open(sealed)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return nil, newErrorf(CodeStorage, "journal: %w", err)
+	}
+	return &out, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealerOpensAfterRotation(t *testing.T) {
+	ring := &KeyRing{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	s := &Sealer{Keys: ring}
+	old, err := s.Seal([]byte("before"))
+	must(t, err)
+	ring.Keys["k2"] = bytes.Repeat([]byte{2}, 32)
+	ring.Current = "k2"
+	fresh, err := s.Seal([]byte("after"))
+	must(t, err)
+	for sealed, want := range map[*[]byte]string{&old: "before", &fresh: "after"} {
+		plain, err := s.Open(*sealed)
+		must(t, err)
+		if string(plain) != want {
+			t.Errorf("opened %q, want %q", plain, want)
+		}
+	}
+	if !bytes.HasPrefix(fresh, []byte("\x02k2")) {
+		t.Errorf("record does not start with its key ID: %q", fresh[:3])
+	}
+
+	// Naming the other key in the header breaks authentication.
+	forged := append([]byte("\x02k1"), fresh[3:]...)
+	if _, err := s.Open(forged); err == nil {
+		t.Error("opened a record under a key ID it was not sealed with")
+	}
+	delete(ring.Keys, "k1")
+	if _, err := s.Open(old); err == nil {
+		t.Error("opened a record whose key was removed")
+	}
+}
+
+func TestOpenJournalAfterRotation(t *testing.T) {
+	ring := &KeyRing{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	var journal bytes.Buffer
+	j := &Journal{W: &journal, Sealer: &Sealer{Keys: ring}}
+	must(t, j.append(JournalEntry{Action: "select", TicketType: "metro", Qty: 1}))
+	ring.Keys["k2"], ring.Current = bytes.Repeat([]byte{2}, 32), "k2"
+	must(t, j.append(JournalEntry{Action: "insert", Amount: KZT(500)}))
+	plain, err := OpenJournal(&journal, j.Sealer)
+	must(t, err)
+	var b bytes.Buffer
+	b.ReadFrom(plain)
+	if n := bytes.Count(b.Bytes(), []byte("\n")); n != 2 {
+		t.Errorf("%d lines opened, want 2", n)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode is a stable identifier for a machine error that UIs and
+// monitoring can react to without parsing messages.
+type ErrorCode string
+
+const (
+	CodeInternal ErrorCode = "E_INTERNAL"
+
+	CodeNoTicketSelected        ErrorCode = "E_NO_TICKET_SELECTED"
+	CodeNoTransaction           ErrorCode = "E_NO_TRANSACTION"
+	CodeNotPaid                 ErrorCode = "E_NOT_PAID"
+	CodeAlreadySelected         ErrorCode = "E_ALREADY_SELECTED"
+	CodeAlreadyPaid             ErrorCode = "E_ALREADY_PAID"
+	CodeCollectPending          ErrorCode = "E_COLLECT_PENDING"
+	CodeTransactionComplete     ErrorCode = "E_TRANSACTION_COMPLETE"
+	CodeTransactionCanceled     ErrorCode = "E_TRANSACTION_CANCELED"
+	CodeBusy                    ErrorCode = "E_BUSY"
+	CodeStepRequired            ErrorCode = "E_STEP_REQUIRED"
+	CodeInvalidState            ErrorCode = "E_INVALID_STATE"
+	CodeInvalidInput            ErrorCode = "E_INVALID_INPUT"
+	CodeInvalidAmount           ErrorCode = "E_INVALID_AMOUNT"
+	CodeCart                    ErrorCode = "E_CART"
+	CodeInsufficientFunds       ErrorCode = "E_INSUFFICIENT_FUNDS"
+	CodeCannotMakeChange        ErrorCode = "E_CANNOT_MAKE_CHANGE"
+	CodeCashBoxFull             ErrorCode = "E_CASH_BOX_FULL"
+	CodeCashRejected            ErrorCode = "E_CASH_REJECTED"
+	CodeCurrency                ErrorCode = "E_CURRENCY"
+	CodeCardDeclined            ErrorCode = "E_CARD_DECLINED"
+	CodeCardRead                ErrorCode = "E_CARD_READ"
+	CodePaymentUnavailable      ErrorCode = "E_PAYMENT_UNAVAILABLE"
+	CodeQRPayment               ErrorCode = "E_QR_PAYMENT"
+	CodePromoUnknown            ErrorCode = "E_PROMO_UNKNOWN"
+	CodePromoUsed               ErrorCode = "E_PROMO_USED"
+	CodePromoExpired            ErrorCode = "E_PROMO_EXPIRED"
+	CodeTicketUnavailable       ErrorCode = "E_TICKET_UNAVAILABLE"
+	CodeUnknownProduct          ErrorCode = "E_UNKNOWN_PRODUCT"
+	CodeUnknownMachine          ErrorCode = "E_UNKNOWN_MACHINE"
+	CodeInsufficientStock       ErrorCode = "E_INSUFFICIENT_STOCK"
+	CodeSeatUnavailable         ErrorCode = "E_SEAT_UNAVAILABLE"
+	CodeNotOffered              ErrorCode = "E_NOT_OFFERED"
+	CodeMalformedTicket         ErrorCode = "E_MALFORMED_TICKET"
+	CodeUnknownKey              ErrorCode = "E_UNKNOWN_KEY"
+	CodeBadSignature            ErrorCode = "E_BAD_SIGNATURE"
+	CodeTicketExpired           ErrorCode = "E_TICKET_EXPIRED"
+	CodeTicketNotYetValid       ErrorCode = "E_TICKET_NOT_YET_VALID"
+	CodeUnknownTicket           ErrorCode = "E_UNKNOWN_TICKET"
+	CodeNotRefundable           ErrorCode = "E_NOT_REFUNDABLE"
+	CodeOutOfService            ErrorCode = "E_OUT_OF_SERVICE"
+	CodeSecurityLock            ErrorCode = "E_SECURITY_LOCK"
+	CodeHardware                ErrorCode = "E_HARDWARE"
+	CodeOutOfPaper              ErrorCode = "E_OUT_OF_PAPER"
+	CodePrintFailed             ErrorCode = "E_PRINT_FAILED"
+	CodeInvalidCredentials      ErrorCode = "E_INVALID_CREDENTIALS"
+	CodePermissionDenied        ErrorCode = "E_PERMISSION_DENIED"
+	CodeInvalidConfig           ErrorCode = "E_INVALID_CONFIG"
+	CodeInvalidFeed             ErrorCode = "E_INVALID_FEED"
+	CodeStorage                 ErrorCode = "E_STORAGE"
+	CodeRegistration            ErrorCode = "E_REGISTRATION"
+	CodeUnsupportedTender       ErrorCode = "E_UNSUPPORTED_TENDER"
+	CodeDeliveryUnavailable     ErrorCode = "E_DELIVERY_UNAVAILABLE"
+	CodeRiderUnidentified       ErrorCode = "E_RIDER_UNIDENTIFIED"
+	CodeFareCategory            ErrorCode = "E_FARE_CATEGORY"
+	CodeCardWrite               ErrorCode = "E_CARD_WRITE"
+	CodeUnsupportedCurrency     ErrorCode = "E_UNSUPPORTED_CURRENCY"
+	CodeNoTicketToReprint       ErrorCode = "E_NO_TICKET_TO_REPRINT"
+	CodeTicketUsed              ErrorCode = "E_TICKET_USED"
+	CodeTicketRefunded          ErrorCode = "E_TICKET_REFUNDED"
+	CodeRefundWindowPassed      ErrorCode = "E_REFUND_WINDOW_PASSED"
+	CodeUnsupportedDenomination ErrorCode = "E_UNSUPPORTED_DENOMINATION"
+	CodeAuditTampered           ErrorCode = "E_AUDIT_TAMPERED"
+)
+
+// ErrorCategory groups error codes by what the caller should do about them.
+type ErrorCategory string
+
+const (
+	CategoryState    ErrorCategory = "state"
+	CategoryInput    ErrorCategory = "input"
+	CategoryPayment  ErrorCategory = "payment"
+	CategoryStock    ErrorCategory = "stock"
+	CategoryTicket   ErrorCategory = "ticket"
+	CategoryService  ErrorCategory = "service"
+	CategoryHardware ErrorCategory = "hardware"
+	CategoryAuth     ErrorCategory = "auth"
+	CategoryConfig   ErrorCategory = "config"
+	CategoryInternal ErrorCategory = "internal"
+)
+
+var codeCategories = map[ErrorCode]ErrorCategory{
+	CodeNoTicketSelected:        CategoryState,
+	CodeNoTransaction:           CategoryState,
+	CodeNotPaid:                 CategoryState,
+	CodeAlreadySelected:         CategoryState,
+	CodeAlreadyPaid:             CategoryState,
+	CodeCollectPending:          CategoryState,
+	CodeTransactionComplete:     CategoryState,
+	CodeTransactionCanceled:     CategoryState,
+	CodeBusy:                    CategoryState,
+	CodeStepRequired:            CategoryState,
+	CodeInvalidState:            CategoryState,
+	CodeInvalidInput:            CategoryInput,
+	CodeInvalidAmount:           CategoryInput,
+	CodeCart:                    CategoryInput,
+	CodeFareCategory:            CategoryInput,
+	CodeNotOffered:              CategoryInput,
+	CodeUnknownMachine:          CategoryInput,
+	CodeInsufficientFunds:       CategoryPayment,
+	CodeCannotMakeChange:        CategoryPayment,
+	CodeCashBoxFull:             CategoryPayment,
+	CodeCashRejected:            CategoryPayment,
+	CodeCurrency:                CategoryPayment,
+	CodeUnsupportedCurrency:     CategoryPayment,
+	CodeUnsupportedDenomination: CategoryPayment,
+	CodeUnsupportedTender:       CategoryPayment,
+	CodeCardDeclined:            CategoryPayment,
+	CodeCardRead:                CategoryPayment,
+	CodePaymentUnavailable:      CategoryPayment,
+	CodeQRPayment:               CategoryPayment,
+	CodePromoUnknown:            CategoryPayment,
+	CodePromoUsed:               CategoryPayment,
+	CodePromoExpired:            CategoryPayment,
+	CodeRiderUnidentified:       CategoryPayment,
+	CodeTicketUnavailable:       CategoryStock,
+	CodeUnknownProduct:          CategoryStock,
+	CodeInsufficientStock:       CategoryStock,
+	CodeSeatUnavailable:         CategoryStock,
+	CodeMalformedTicket:         CategoryTicket,
+	CodeUnknownKey:              CategoryTicket,
+	CodeBadSignature:            CategoryTicket,
+	CodeTicketExpired:           CategoryTicket,
+	CodeTicketNotYetValid:       CategoryTicket,
+	CodeUnknownTicket:           CategoryTicket,
+	CodeTicketUsed:              CategoryTicket,
+	CodeTicketRefunded:          CategoryTicket,
+	CodeRefundWindowPassed:      CategoryTicket,
+	CodeNotRefundable:           CategoryTicket,
+	CodeNoTicketToReprint:       CategoryTicket,
+	CodeDeliveryUnavailable:     CategoryTicket,
+	CodeOutOfService:            CategoryService,
+	CodeSecurityLock:            CategoryService,
+	CodeHardware:                CategoryHardware,
+	CodeOutOfPaper:              CategoryHardware,
+	CodePrintFailed:             CategoryHardware,
+	CodeCardWrite:               CategoryHardware,
+	CodeStorage:                 CategoryHardware,
+	CodeInvalidCredentials:      CategoryAuth,
+	CodePermissionDenied:        CategoryAuth,
+	CodeAuditTampered:           CategoryAuth,
+	CodeInvalidConfig:           CategoryConfig,
+	CodeInvalidFeed:             CategoryConfig,
+	CodeRegistration:            CategoryConfig,
+}
+
+// Category returns the category of the code.
+func (c ErrorCode) Category() ErrorCategory {
+	if cat, ok := codeCategories[c]; ok {
+		return cat
+	}
+	return CategoryInternal
+}
+
+// MachineError is an error with a stable code and a user-facing message.
+// Err is the underlying cause, if any.
+type MachineError struct {
+	Code     ErrorCode
+	Category ErrorCategory
+	Message  string
+	Err      error
+}
+
+func (e *MachineError) Error() string { return e.Message }
+
+func (e *MachineError) Unwrap() error { return e.Err }
+
+// Is matches any machine error with the same code.
+func (e *MachineError) Is(target error) bool {
+	t, ok := target.(*MachineError)
+	return ok && t.Code == e.Code
+}
+
+func (e *MachineError) ErrorCode() ErrorCode { return e.Code }
+
+func newError(code ErrorCode, msg string) error {
+	return &MachineError{Code: code, Category: code.Category(), Message: msg}
+}
+
+// newErrorf formats like fmt.Errorf, keeping a %w operand as the cause.
+func newErrorf(code ErrorCode, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	return &MachineError{Code: code, Category: code.Category(), Message: err.Error(), Err: errors.Unwrap(err)}
+}
+
+// codedError is implemented by every error type that carries a code.
+type codedError interface {
+	error
+	ErrorCode() ErrorCode
+}
+
+// CodeOf returns the code of the outermost coded error in err's chain,
+// CodeInternal for other errors and "" for nil.
+func CodeOf(err error) ErrorCode {
+	if err == nil {
+		return ""
+	}
+	var c codedError
+	if errors.As(err, &c) {
+		return c.ErrorCode()
+	}
+	return CodeInternal
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestCodeOf(t *testing.T) {
+	cause := newErrorf(CodeStorage, "save failed: %w", io.ErrShortWrite)
+	tests := []struct {
+		name string
+		err  error
+		want ErrorCode
+	}{
+		{"nil", nil, ""},
+		{"plain error", io.EOF, CodeInternal},
+		{"machine error", newError(CodeNotPaid, "not paid"), CodeNotPaid},
+		{"wrapped", fmt.Errorf("dispense: %w", cause), CodeStorage},
+		{"outermost code wins", newErrorf(CodeHardware, "printer: %w", cause), CodeHardware},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CodeOf(tt.err); got != tt.want {
+				t.Fatalf("CodeOf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMachineError(t *testing.T) {
+	err := newErrorf(CodeStorage, "save failed: %w", io.ErrShortWrite)
+	var me *MachineError
+	if !errors.As(err, &me) || me.Category != CategoryHardware || me.Message != "save failed: short write" {
+		t.Fatalf("error = %+v", me)
+	}
+	if !errors.Is(err, io.ErrShortWrite) {
+		t.Error("cause lost")
+	}
+	if !errors.Is(err, &MachineError{Code: CodeStorage}) || errors.Is(err, &MachineError{Code: CodeHardware}) {
+		t.Error("errors.Is does not match on the code")
+	}
+	if got := ErrorCode("E_SOMETHING_NEW").Category(); got != CategoryInternal {
+		t.Errorf("unknown code category = %s, want %s", got, CategoryInternal)
+	}
+
+	m, _ := newTestMachine(t)
+	err = m.SelectTicket("ferry", 1)
+	if code := CodeOf(err); code != CodeTicketUnavailable || code.Category() != CategoryStock {
+		t.Fatalf("SelectTicket(ferry) = %v, code %s", err, code)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ExportSales writes the sales and refunds with from <= Time < to as CSV,
+// for the agency's accounting system; a zero bound is open. Sales come
+// from the Store when there is one, so the export survives a restart and
+// a Prune. Each sale has a row per cart line and each refund a row of its
+// own, with quantity -1 and the amounts negative. The tender columns split
+// each row's amount by how it was paid, net of change and donations.
+func (m *TicketMachine) ExportSales(from, to time.Time, w io.Writer) error {
+	sales, err := m.sales(from, to)
+	if err != nil {
+		return err
+	}
+	refunds, err := m.refunds(from, to)
+	if err != nil {
+		return err
+	}
+	of := map[string]TransactionRecord{}
+	for _, t := range sales {
+		of[t.ID] = t
+	}
+	for _, r := range refunds {
+		if _, ok := of[r.TransactionID]; ok {
+			continue
+		}
+		// The sale of a refund may be from before the range.
+		earlier, err := m.sales(time.Time{}, to)
+		if err != nil {
+			return err
+		}
+		for _, t := range earlier {
+			of[t.ID] = t
+		}
+		break
+	}
+	return writeSalesCSV(w, sales, refunds, of)
+}
+
+// writeSalesCSV writes the sales, one row per cart line, and then the
+// refunds, one row each with the amounts negative; of holds the sales
+// the refunds belong to, by ID.
+func writeSalesCSV(w io.Writer, sales []TransactionRecord, refunds []RefundRecord, of map[string]TransactionRecord) error {
+	tenders := []Tender{TenderCash, TenderCard, TenderQR}
+	cw := csv.NewWriter(w)
+	header := []string{"transaction_id", "time", "machine_id", "station_id", "product", "quantity",
+		"fare_category", "amount", "vat"}
+	for _, t := range tenders {
+		header = append(header, string(t))
+	}
+	cw.Write(header)
+	for _, t := range sales {
+		paid := netTenders(t)
+		lines := t.Lines
+		if len(lines) == 0 {
+			lines = []CartLine{{TicketType: t.Product, Qty: t.Quantity, UnitPrice: t.Price}}
+		}
+		for i, l := range lines {
+			row := []string{t.ID, t.Time.UTC().Format(time.RFC3339), t.MachineID, t.StationID,
+				l.TicketType, strconv.Itoa(l.Qty), string(t.Category),
+				allocate(t.Price, lines, i).String(), allocate(t.Tax.VAT, lines, i).String()}
+			for _, tender := range tenders {
+				row = append(row, allocate(paid[tender], lines, i).String())
+			}
+			cw.Write(row)
+		}
+	}
+	for _, r := range refunds {
+		t := of[r.TransactionID]
+		var vat Money
+		if t.Price > 0 {
+			vat = t.Tax.VAT * r.Amount / t.Price
+		}
+		row := []string{r.TransactionID, r.Time.UTC().Format(time.RFC3339), t.MachineID, t.StationID,
+			refundedType(t, r.TicketID), "-1", string(t.Category), (-r.Amount).String(), (-vat).String()}
+		parts := r.parts()
+		for _, tender := range tenders {
+			row = append(row, (-parts[tender]).String())
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// refundedType is the type of the refunded ticket of sale t.
+func refundedType(t TransactionRecord, ticketID string) string {
+	for _, tk := range t.Tickets {
+		if tk.ID == ticketID {
+			return tk.Type
+		}
+	}
+	if len(t.Lines) == 1 {
+		return t.Lines[0].TicketType
+	}
+	return t.Product
+}
+
+// netTenders is what a sale took per tender, cash net of change and
+// donation.
+func netTenders(t TransactionRecord) map[Tender]Money {
+	paid := map[Tender]Money{}
+	for tender, v := range t.Tenders {
+		paid[tender] = v
+	}
+	if _, ok := paid[TenderCash]; ok {
+		paid[TenderCash] -= t.Change + t.Donation
+	}
+	return paid
+}
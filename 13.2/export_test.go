@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportSalesAfterRestart(t *testing.T) {
+	store := &MemoryStore{}
+	m, clock := newTestMachine(t)
+	must(t, m.UseStore(store))
+	sellMetro(t, m)
+	sale := m.TransactionID
+	m.StartOver()
+	clock.Advance(10 * time.Minute)
+	if _, err := m.RefundTicket(m.Transactions[0].Tickets[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, _ := newTestMachine(t)
+	must(t, restarted.UseStore(store))
+	tests := []struct {
+		name string
+		from time.Time
+		want []string
+	}{
+		{"all", time.Time{}, []string{sale + ",2026-03-02T12:00:00Z,TM-0001,ALM,metro,1,adult,300.00,32.14,300.00,0.00,0.00",
+			sale + ",2026-03-02T12:10:00Z,TM-0001,ALM,metro,-1,adult,-300.00,-32.14,-300.00,0.00,0.00"}},
+		{"refund only", clock.Now(), []string{sale + ",2026-03-02T12:10:00Z,TM-0001,ALM,metro,-1,adult,-300.00,-32.14,-300.00,0.00,0.00"}},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		must(t, restarted.ExportSales(tt.from, time.Time{}, &out))
+		rows := strings.Split(strings.TrimSpace(out.String()), "\n")[1:]
+		if strings.Join(rows, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, strings.Join(rows, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+// FareCategory is the rider category a fare is sold at.
+type FareCategory string
+
+const (
+	FareAdult   FareCategory = "adult"
+	FareStudent FareCategory = "student"
+	FareSenior  FareCategory = "senior"
+	FareChild   FareCategory = "child"
+)
+
+// Discount reduces a unit price by a percentage (in basis points) and/or a
+// fixed amount. The result never goes below zero.
+type Discount struct {
+	PercentBP int
+	Fixed     Money
+}
+
+// Apply returns the discount amount on price.
+func (d Discount) Apply(price Money) Money {
+	off := price*Money(d.PercentBP)/10000 + d.Fixed
+	if off > price {
+		return price
+	}
+	return off
+}
+
+// AnyTicket is the FareDiscounts key matching every ticket type.
+const AnyTicket = "*"
+
+// EligibilityVerifier gates concession fares, e.g. by checking a student
+// card on the reader.
+type EligibilityVerifier interface {
+	Verify(category FareCategory) error
+}
+
+// DefaultFareDiscounts are the concessions of NewTicketMachine.
+func DefaultFareDiscounts() map[FareCategory]map[string]Discount {
+	return map[FareCategory]map[string]Discount{
+		FareStudent: {AnyTicket: {PercentBP: 5000}},
+		FareSenior:  {AnyTicket: {PercentBP: 5000}},
+		FareChild:   {AnyTicket: {PercentBP: 10000}, "train": {PercentBP: 5000}},
+	}
+}
+
+// discountFor returns the unit discount for ticketType at category.
+func (m *TicketMachine) discountFor(category FareCategory, ticketType string, price Money) Money {
+	return lookupDiscount(m.FareDiscounts, category, ticketType).Apply(price)
+}
+
+// lookupDiscount finds the discount for ticketType at category, falling
+// back to the AnyTicket entry.
+func lookupDiscount(discounts map[FareCategory]map[string]Discount, category FareCategory, ticketType string) Discount {
+	byType := discounts[category]
+	if d, ok := byType[ticketType]; ok {
+		return d
+	}
+	return byType[AnyTicket]
+}
+
+// SelectFareCategory applies a concession to the selected tickets. It is
+// allowed until the first payment is made.
+func (m *TicketMachine) SelectFareCategory(c FareCategory) error {
+	if err := m.logAction(JournalEntry{Action: "fare", Fare: c}); err != nil {
+		return err
+	}
+	switch m.State.(type) {
+	case *WaitingForMoneyState, *CartState:
+	default:
+		return newError(CodeInvalidState, "fare category cannot be changed now")
+	}
+	if m.PaidTotal() > 0 {
+		return newError(CodeAlreadyPaid, "payment already started")
+	}
+	if c != FareAdult {
+		if _, ok := m.FareDiscounts[c]; !ok {
+			return newErrorf(CodeFareCategory, "fare category %s not offered", c)
+		}
+		if m.Eligibility != nil {
+			if err := m.deviceCall("eligibility.verify", nil, func() error { return m.Eligibility.Verify(c) }); err != nil {
+				return newErrorf(CodeFareCategory, "not eligible for %s fare: %w", c, err)
+			}
+		}
+	}
+	m.FareCategory = c
+	m.repriceCart()
+	m.show("Fare category: %s (%s)", c, m.formatPrice(m.CurrentPrice))
+	return nil
+}
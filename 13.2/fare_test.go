@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDiscountApply(t *testing.T) {
+	tests := []struct {
+		name string
+		d    Discount
+		want Money
+	}{
+		{"none", Discount{}, 0},
+		{"half", Discount{PercentBP: 5000}, KZT(150)},
+		{"fixed", Discount{Fixed: KZT(50)}, KZT(50)},
+		{"both", Discount{PercentBP: 1000, Fixed: KZT(20)}, KZT(50)},
+		{"never below zero", Discount{Fixed: KZT(500)}, KZT(300)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.d.Apply(KZT(300)); got != tt.want {
+				t.Fatalf("Apply = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectFareCategory(t *testing.T) {
+	tests := []struct {
+		name     string
+		ticket   string
+		category FareCategory
+		eligible error
+		price    Money
+		code     ErrorCode
+	}{
+		{"student", "metro", FareStudent, nil, KZT(150), ""},
+		{"child rides free", "metro", FareChild, nil, 0, ""},
+		{"child train fare", "train", FareChild, nil, KZT(500), ""},
+		{"back to adult", "metro", FareAdult, nil, KZT(300), ""},
+		{"not eligible", "metro", FareSenior, errors.New("no senior card"), KZT(300), CodeFareCategory},
+		{"not offered", "metro", FareCategory("veteran"), nil, KZT(300), CodeFareCategory},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.Eligibility = eligibilityFunc(func(FareCategory) error { return tt.eligible })
+			must(t, m.SelectTicket(tt.ticket, 1))
+			if tt.ticket == "train" {
+				must(t, m.SkipSeat())
+			}
+			err := m.SelectFareCategory(tt.category)
+			if CodeOf(err) != tt.code {
+				t.Fatalf("SelectFareCategory = %v, want %q", err, tt.code)
+			}
+			if m.CurrentPrice != tt.price {
+				t.Fatalf("price = %s, want %s", m.CurrentPrice, tt.price)
+			}
+		})
+	}
+}
+
+func TestFareCategoryLockedOncePaid(t *testing.T) {
+	m, _ := newTestMachine(t)
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.InsertMoney(KZT(100)))
+	if err := m.SelectFareCategory(FareStudent); CodeOf(err) != CodeAlreadyPaid {
+		t.Fatalf("err = %v, want %s", err, CodeAlreadyPaid)
+	}
+}
+
+type eligibilityFunc func(FareCategory) error
+
+func (f eligibilityFunc) Verify(c FareCategory) error { return f(c) }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// FiscalSale is what is reported to the fiscal data operator for one sale.
+type FiscalSale struct {
+	TransactionID string
+	MachineID     string
+	StationID     string
+	Time          time.Time
+	Product       string
+	Tax           TaxBreakdown
+	Tenders       map[Tender]Money
+}
+
+// FiscalReceipt is the fiscal device's acknowledgement of a sale.
+type FiscalReceipt struct {
+	FiscalNumber string
+}
+
+// FiscalPrinter registers sales with the fiscal device (ОФД).
+type FiscalPrinter interface {
+	Register(sale FiscalSale) (FiscalReceipt, error)
+}
+
+// MockFiscalPrinter numbers sales sequentially, or fails with Err.
+type MockFiscalPrinter struct {
+	Err        error
+	Registered []FiscalSale
+}
+
+func (p *MockFiscalPrinter) Register(sale FiscalSale) (FiscalReceipt, error) {
+	if p.Err != nil {
+		return FiscalReceipt{}, p.Err
+	}
+	p.Registered = append(p.Registered, sale)
+	return FiscalReceipt{FiscalNumber: fmt.Sprintf("FN%08d", len(p.Registered))}, nil
+}
+
+func fiscalSale(r TransactionRecord) FiscalSale {
+	return FiscalSale{TransactionID: r.ID, MachineID: r.MachineID, StationID: r.StationID, Time: r.Time, Product: r.Product, Tax: r.Tax, Tenders: r.Tenders}
+}
+
+// register submits a sale, retrying per FiscalRetry.
+func (m *TicketMachine) register(sale FiscalSale) (FiscalReceipt, error) {
+	var (
+		rcpt FiscalReceipt
+		err  error
+	)
+	for attempt := 0; attempt < m.FiscalRetry.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			m.Sleep(m.FiscalRetry.delay(attempt - 1))
+		}
+		if err = m.deviceCall("fiscal.register", &rcpt, func() (err error) {
+			rcpt, err = m.Fiscal.Register(sale)
+			return err
+		}); err == nil {
+			return rcpt, nil
+		}
+	}
+	return FiscalReceipt{}, err
+}
+
+// fiscalize registers the sale just settled. If the fiscal device stays
+// unreachable the sale is queued and the ticket is still issued; queued
+// sales are resubmitted by FlushFiscalQueue.
+func (m *TicketMachine) fiscalize() {
+	if m.Fiscal == nil || len(m.Transactions) == 0 {
+		return
+	}
+	rec := &m.Transactions[len(m.Transactions)-1]
+	m.SetState(&FiscalizationPendingState{})
+	m.FlushFiscalQueue()
+	rcpt, err := m.register(fiscalSale(*rec))
+	if err != nil {
+		m.FiscalQueue = append(m.FiscalQueue, fiscalSale(*rec))
+		fmt.Fprintln(m.out(), "Fiscal device unavailable, sale queued:", err)
+		return
+	}
+	rec.FiscalNumber = rcpt.FiscalNumber
+}
+
+// FlushFiscalQueue resubmits queued sales and returns how many are still
+// waiting.
+func (m *TicketMachine) FlushFiscalQueue() int {
+	if m.Fiscal == nil {
+		return len(m.FiscalQueue)
+	}
+	var remaining []FiscalSale
+	for _, sale := range m.FiscalQueue {
+		rcpt, err := m.Fiscal.Register(sale)
+		if err != nil {
+			remaining = append(remaining, sale)
+			continue
+		}
+		for i := range m.Transactions {
+			if m.Transactions[i].ID == sale.TransactionID {
+				m.Transactions[i].FiscalNumber = rcpt.FiscalNumber
+			}
+		}
+	}
+	m.FiscalQueue = remaining
+	return len(remaining)
+}
+
+// FiscalizationPendingState is held while a sale is registered with the
+// fiscal device.
+type FiscalizationPendingState struct{}
+
+func (s *FiscalizationPendingState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeBusy, "registering sale, please wait")
+}
+func (s *FiscalizationPendingState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeBusy, "registering sale, please wait")
+}
+func (s *FiscalizationPendingState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeBusy, "registering sale, please wait")
+}
+func (s *FiscalizationPendingState) Cancel(m *TicketMachine) error {
+	return newError(CodeAlreadyPaid, "payment already taken")
+}
+func (s *FiscalizationPendingState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeBusy, "registering sale, please wait")
+}
+func (s *FiscalizationPendingState) Name() string { return "FiscalizationPending" }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFiscalize(t *testing.T) {
+	m, _ := newTestMachine(t)
+	p := &MockFiscalPrinter{}
+	m.Fiscal = p
+	sellMetro(t, m)
+	rec := m.Transactions[len(m.Transactions)-1]
+	if rec.FiscalNumber != "FN00000001" || len(m.FiscalQueue) != 0 {
+		t.Fatalf("fiscal number %q, queue %d", rec.FiscalNumber, len(m.FiscalQueue))
+	}
+	if len(p.Registered) != 1 || p.Registered[0].TransactionID != rec.ID || p.Registered[0].Tax != rec.Tax {
+		t.Fatalf("registered %+v", p.Registered)
+	}
+}
+
+func TestFiscalDeviceDownQueuesSale(t *testing.T) {
+	m, _ := newTestMachine(t)
+	p := &MockFiscalPrinter{Err: errors.New("no link to the operator")}
+	m.Fiscal = p
+	var sleeps int
+	m.Sleep = func(time.Duration) { sleeps++ }
+	sellMetro(t, m)
+	if sleeps != m.FiscalRetry.MaxAttempts-1 {
+		t.Fatalf("slept %d times between %d attempts", sleeps, m.FiscalRetry.MaxAttempts)
+	}
+	rec := m.Transactions[len(m.Transactions)-1]
+	if rec.FiscalNumber != "" || len(m.FiscalQueue) != 1 {
+		t.Fatalf("fiscal number %q, queue %d", rec.FiscalNumber, len(m.FiscalQueue))
+	}
+	if n := m.FlushFiscalQueue(); n != 1 {
+		t.Fatalf("still failing, %d queued, want 1", n)
+	}
+	p.Err = nil
+	if n := m.FlushFiscalQueue(); n != 0 {
+		t.Fatalf("%d still queued", n)
+	}
+	if got := m.Transactions[len(m.Transactions)-1].FiscalNumber; got != "FN00000001" {
+		t.Fatalf("fiscal number after flush = %q", got)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fleet owns the ticket machines of a network, addressed by machine ID.
+type Fleet struct {
+	machines map[string]*TicketMachine
+}
+
+func NewFleet(machines ...*TicketMachine) *Fleet {
+	f := &Fleet{machines: map[string]*TicketMachine{}}
+	for _, m := range machines {
+		f.Add(m)
+	}
+	return f
+}
+
+// Add puts m under fleet management, replacing a machine with the same ID.
+func (f *Fleet) Add(m *TicketMachine) {
+	f.machines[m.MachineID] = m
+}
+
+func (f *Fleet) Remove(machineID string) {
+	delete(f.machines, machineID)
+}
+
+// IDs lists the machine IDs in order.
+func (f *Fleet) IDs() []string {
+	ids := make([]string, 0, len(f.machines))
+	for id := range f.machines {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (f *Fleet) Machine(machineID string) (*TicketMachine, error) {
+	m, ok := f.machines[machineID]
+	if !ok {
+		return nil, newErrorf(CodeUnknownMachine, "unknown machine %s", machineID)
+	}
+	return m, nil
+}
+
+// Do runs fn on one machine.
+func (f *Fleet) Do(machineID string, fn func(*TicketMachine) error) error {
+	m, err := f.Machine(machineID)
+	if err != nil {
+		return err
+	}
+	return fn(m)
+}
+
+// FleetErrors maps machine IDs to the errors a fleet-wide operation hit.
+type FleetErrors map[string]error
+
+func (e FleetErrors) Error() string {
+	ids := make([]string, 0, len(e))
+	for id := range e {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%s: %v", id, e[id])
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Each runs fn on every machine in ID order and collects the failures.
+func (f *Fleet) Each(fn func(*TicketMachine) error) error {
+	errs := FleetErrors{}
+	for _, id := range f.IDs() {
+		if err := fn(f.machines[id]); err != nil {
+			errs[id] = err
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// PushPrice sets a product price on every machine through an admin
+// session. Machines busy with a customer report an error and keep their
+// old price.
+func (f *Fleet) PushPrice(c Credentials, ticketType string, price Money) error {
+	return f.Each(func(m *TicketMachine) error {
+		s, err := m.EnterAdminMode(c)
+		if err != nil {
+			return err
+		}
+		defer s.Exit()
+		return s.SetPrice(ticketType, price)
+	})
+}
+
+// DisableAll takes every machine out of service.
+func (f *Fleet) DisableAll(c Credentials, detail string) error {
+	return f.Each(func(m *TicketMachine) error {
+		return m.TakeOutOfService(c.OperatorID, c.PIN, detail)
+	})
+}
+
+// EnableAll returns every stopped machine to service.
+func (f *Fleet) EnableAll(c Credentials) error {
+	return f.Each(func(m *TicketMachine) error {
+		if m.inService() == nil {
+			return nil
+		}
+		return m.ReturnToService(c.OperatorID, c.PIN)
+	})
+}
+
+// Inventory sums the stock of active products across the fleet.
+func (f *Fleet) Inventory() []InventoryLine {
+	byType := map[string]*InventoryLine{}
+	var types []string
+	for _, id := range f.IDs() {
+		for _, l := range f.machines[id].InventoryReport() {
+			sum, ok := byType[l.TicketType]
+			if !ok {
+				sum = &InventoryLine{TicketType: l.TicketType, Price: l.Price}
+				byType[l.TicketType] = sum
+				types = append(types, l.TicketType)
+			}
+			sum.Count += l.Count
+			sum.Value += l.Value
+		}
+	}
+	sort.Strings(types)
+	lines := make([]InventoryLine, len(types))
+	for i, t := range types {
+		lines[i] = *byType[t]
+	}
+	return lines
+}
+
+// FleetSales sums the sales of all machines over a period.
+type FleetSales struct {
+	Transactions int
+	Revenue      Money
+	ByMachine    map[string]Money
+	// ByProduct counts tickets sold per product.
+	ByProduct map[string]int
+}
+
+// Sales reports the sales recorded in [from, to).
+func (f *Fleet) Sales(from, to time.Time) FleetSales {
+	s := FleetSales{ByMachine: map[string]Money{}, ByProduct: map[string]int{}}
+	for _, id := range f.IDs() {
+		for _, t := range f.machines[id].Transactions {
+			if t.Time.Before(from) || !t.Time.Before(to) {
+				continue
+			}
+			s.Transactions++
+			s.Revenue += t.Price
+			s.ByMachine[id] += t.Price
+			if len(t.Lines) == 0 {
+				s.ByProduct[t.Product] += t.Quantity
+			}
+			for _, l := range t.Lines {
+				s.ByProduct[l.TicketType] += l.Qty
+			}
+		}
+	}
+	return s
+}
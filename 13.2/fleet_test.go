@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestFleet(t *testing.T) (*Fleet, *TicketMachine, *TicketMachine) {
+	t.Helper()
+	a, _ := newTestMachine(t)
+	a.MachineID = "TM-A"
+	b, _ := newTestMachine(t)
+	b.MachineID = "TM-B"
+	return NewFleet(b, a), a, b
+}
+
+func TestFleetPushPrice(t *testing.T) {
+	f, a, b := newTestFleet(t)
+	admin := Credentials{OperatorID: "admin", PIN: "0000"}
+	if ids := f.IDs(); len(ids) != 2 || ids[0] != "TM-A" || ids[1] != "TM-B" {
+		t.Fatalf("IDs = %v", ids)
+	}
+	must(t, f.PushPrice(admin, "metro", KZT(320)))
+	if a.GetTicketPrice("metro") != KZT(320) || b.GetTicketPrice("metro") != KZT(320) {
+		t.Fatalf("prices %s, %s", a.GetTicketPrice("metro"), b.GetTicketPrice("metro"))
+	}
+
+	must(t, b.SelectTicket("bus", 1))
+	err := f.PushPrice(admin, "metro", KZT(340))
+	var errs FleetErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs["TM-B"] == nil {
+		t.Fatalf("PushPrice with a busy machine = %v", err)
+	}
+	if a.GetTicketPrice("metro") != KZT(340) || b.GetTicketPrice("metro") != KZT(320) {
+		t.Fatalf("prices %s, %s", a.GetTicketPrice("metro"), b.GetTicketPrice("metro"))
+	}
+	if _, err := f.Machine("TM-C"); CodeOf(err) != CodeUnknownMachine {
+		t.Fatalf("Machine(TM-C) = %v, want %s", err, CodeUnknownMachine)
+	}
+}
+
+func TestFleetReports(t *testing.T) {
+	f, a, b := newTestFleet(t)
+	start := a.Clock.Now()
+	stock := a.Catalog.Stock("metro")
+	sellMetro(t, a)
+	sellMetro(t, b)
+
+	sales := f.Sales(start, start.Add(time.Hour))
+	if sales.Transactions != 2 || sales.Revenue != KZT(600) || sales.ByProduct["metro"] != 2 {
+		t.Fatalf("sales = %+v", sales)
+	}
+	if sales.ByMachine["TM-A"] != KZT(300) || sales.ByMachine["TM-B"] != KZT(300) {
+		t.Fatalf("by machine = %v", sales.ByMachine)
+	}
+	if later := f.Sales(start.Add(time.Hour), start.Add(2*time.Hour)); later.Transactions != 0 {
+		t.Fatalf("sales outside the period = %+v", later)
+	}
+	for _, l := range f.Inventory() {
+		if l.TicketType == "metro" && (l.Count != 2*(stock-1) || l.Value != KZT(300)*Money(l.Count)) {
+			t.Fatalf("metro inventory = %+v", l)
+		}
+	}
+
+	f.Remove("TM-B")
+	if s := f.Sales(start, start.Add(time.Hour)); s.Transactions != 1 {
+		t.Fatalf("removed machine still reported: %+v", s)
+	}
+}
+
+func TestFleetDisableAll(t *testing.T) {
+	f, a, b := newTestFleet(t)
+	admin := Credentials{OperatorID: "admin", PIN: "0000"}
+	must(t, f.DisableAll(admin, "strike"))
+	for _, m := range []*TicketMachine{a, b} {
+		if err := m.SelectTicket("metro", 1); CodeOf(err) != CodeOutOfService {
+			t.Fatalf("%s: SelectTicket = %v, want %s", m.MachineID, err, CodeOutOfService)
+		}
+	}
+	must(t, b.ReturnToService("admin", "0000"))
+	must(t, f.EnableAll(admin))
+	for _, m := range []*TicketMachine{a, b} {
+		if err := m.SelectTicket("metro", 1); err != nil {
+			t.Fatalf("%s: SelectTicket after EnableAll = %v", m.MachineID, err)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/TheStilk/templates-homework-13/13.2
+
+go 1.22
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// GTFSFare is a row of a GTFS fare_attributes.txt.
+type GTFSFare struct {
+	ID       string
+	Price    Money
+	Currency Currency
+	// Transfers is the number of transfers allowed, -1 for unlimited.
+	Transfers        int
+	TransferDuration time.Duration
+}
+
+// GTFSFareRule is a row of a GTFS fare_rules.txt.
+type GTFSFareRule struct {
+	FareID        string
+	RouteID       string
+	OriginID      string
+	DestinationID string
+	ContainsID    string
+}
+
+// GTFSFares is the fare part of a GTFS feed.
+type GTFSFares struct {
+	Fares []GTFSFare
+	Rules []GTFSFareRule
+}
+
+// readGTFS reads a GTFS CSV file into rows keyed by column name.
+func readGTFS(r io.Reader, required ...string) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		header[0] = trimBOM(header[0])
+	}
+	have := map[string]bool{}
+	for _, h := range header {
+		have[h] = true
+	}
+	for _, h := range required {
+		if !have[h] {
+			return nil, newErrorf(CodeInvalidFeed, "missing column %s", h)
+		}
+	}
+	var rows []map[string]string
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := map[string]string{}
+		for i, v := range rec {
+			if i < len(header) {
+				row[header[i]] = v
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+func trimBOM(s string) string {
+	if len(s) >= 3 && s[:3] == "\xef\xbb\xbf" {
+		return s[3:]
+	}
+	return s
+}
+
+// ParseGTFSFares reads fare_attributes.txt and, when given, fare_rules.txt.
+func ParseGTFSFares(attributes, rules io.Reader) (GTFSFares, error) {
+	var feed GTFSFares
+	rows, err := readGTFS(attributes, "fare_id", "price", "currency_type")
+	if err != nil {
+		return feed, newErrorf(CodeInvalidFeed, "fare_attributes: %w", err)
+	}
+	for i, row := range rows {
+		f := GTFSFare{ID: row["fare_id"], Currency: Currency(row["currency_type"]), Transfers: -1}
+		if f.Price, err = ParseMoney(row["price"]); err != nil {
+			return feed, newErrorf(CodeInvalidFeed, "fare_attributes line %d: %w", i+2, err)
+		}
+		if v := row["transfers"]; v != "" {
+			if f.Transfers, err = strconv.Atoi(v); err != nil {
+				return feed, newErrorf(CodeInvalidFeed, "fare_attributes line %d: invalid transfers", i+2)
+			}
+		}
+		if v := row["transfer_duration"]; v != "" {
+			secs, err := strconv.Atoi(v)
+			if err != nil {
+				return feed, newErrorf(CodeInvalidFeed, "fare_attributes line %d: invalid transfer_duration", i+2)
+			}
+			f.TransferDuration = time.Duration(secs) * time.Second
+		}
+		feed.Fares = append(feed.Fares, f)
+	}
+	if rules == nil {
+		return feed, nil
+	}
+	rows, err = readGTFS(rules, "fare_id")
+	if err != nil {
+		return feed, newErrorf(CodeInvalidFeed, "fare_rules: %w", err)
+	}
+	for _, row := range rows {
+		feed.Rules = append(feed.Rules, GTFSFareRule{
+			FareID:        row["fare_id"],
+			RouteID:       row["route_id"],
+			OriginID:      row["origin_id"],
+			DestinationID: row["destination_id"],
+			ContainsID:    row["contains_id"],
+		})
+	}
+	return feed, nil
+}
+
+// ImportGTFSFares brings the catalog and zone fares in line with a feed.
+// Fares with origin/destination rules become zone fares of zoneProduct,
+// which must be in the catalog; every other fare is a catalog product
+// named by its fare_id, keeping the stock, VAT and metadata of an
+// existing product. Transfer durations become the ticket validity. A
+// machine without zone fares gets new ones whose Origin must still be set.
+func (m *TicketMachine) ImportGTFSFares(feed GTFSFares, zoneProduct string) error {
+	zoned := map[string][]GTFSFareRule{}
+	for _, r := range feed.Rules {
+		if r.OriginID != "" && r.DestinationID != "" {
+			zoned[r.FareID] = append(zoned[r.FareID], r)
+		}
+	}
+	if len(zoned) > 0 {
+		if _, ok := m.Catalog.Product(zoneProduct); !ok {
+			return newErrorf(CodeInvalidFeed, "zone product %s not in catalog", zoneProduct)
+		}
+	}
+	for _, f := range feed.Fares {
+		if f.Currency != m.Currency {
+			return newErrorf(CodeInvalidFeed, "fare %s is in %s, machine sells in %s", f.ID, f.Currency, m.Currency)
+		}
+		if f.ID == "" {
+			return newError(CodeInvalidFeed, "fare without fare_id")
+		}
+	}
+	for _, f := range feed.Fares {
+		if rules, ok := zoned[f.ID]; ok {
+			if m.ZoneFares == nil {
+				m.ZoneFares = &ZoneFares{}
+			}
+			if m.ZoneFares.Products == nil {
+				m.ZoneFares.Products = map[string]bool{}
+			}
+			if m.ZoneFares.Fares == nil {
+				m.ZoneFares.Fares = map[string]map[string]Money{}
+			}
+			m.ZoneFares.Products[zoneProduct] = true
+			for _, r := range rules {
+				if m.ZoneFares.Fares[r.OriginID] == nil {
+					m.ZoneFares.Fares[r.OriginID] = map[string]Money{}
+				}
+				m.ZoneFares.Fares[r.OriginID][r.DestinationID] = f.Price
+			}
+			continue
+		}
+		p, ok := m.Catalog.Product(f.ID)
+		if !ok {
+			p = Product{Type: f.ID, Name: f.ID, Validity: 90 * time.Minute}
+		}
+		p.Price = f.Price
+		if f.TransferDuration > 0 {
+			p.Validity = f.TransferDuration
+		}
+		if err := m.Catalog.RegisterProduct(p); err != nil {
+			return newErrorf(CodeInvalidFeed, "fare %s: %w", f.ID, err)
+		}
+		m.persist(f.ID)
+	}
+	fmt.Fprintf(m.out(), "Imported %d GTFS fares\n", len(feed.Fares))
+	return nil
+}
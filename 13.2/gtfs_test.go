@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const gtfsAttributes = "\xef\xbb\xbffare_id,price,currency_type,payment_method,transfers,transfer_duration\n" +
+	"metro,320.00,KZT,0,0,\n" +
+	"night_bus,400,KZT,0,,3600\n" +
+	"sub_ab,650,KZT,0,0,\n"
+
+const gtfsRules = "fare_id,route_id,origin_id,destination_id\n" +
+	"metro,M1,,\n" +
+	"sub_ab,,A,B\n"
+
+func TestParseGTFSFares(t *testing.T) {
+	feed, err := ParseGTFSFares(strings.NewReader(gtfsAttributes), strings.NewReader(gtfsRules))
+	must(t, err)
+	if len(feed.Fares) != 3 || len(feed.Rules) != 2 {
+		t.Fatalf("feed = %+v", feed)
+	}
+	if f := feed.Fares[0]; f.ID != "metro" || f.Price != KZT(320) || f.Currency != CurrencyKZT || f.Transfers != 0 {
+		t.Fatalf("first fare = %+v", f)
+	}
+	if f := feed.Fares[1]; f.Transfers != -1 || f.TransferDuration != time.Hour {
+		t.Fatalf("night bus = %+v", f)
+	}
+	if r := feed.Rules[1]; r.OriginID != "A" || r.DestinationID != "B" {
+		t.Fatalf("zone rule = %+v", r)
+	}
+}
+
+func TestParseGTFSFaresErrors(t *testing.T) {
+	for name, attributes := range map[string]string{
+		"missing column": "fare_id,price\nmetro,300\n",
+		"bad price":      "fare_id,price,currency_type\nmetro,cheap,KZT\n",
+		"bad transfers":  "fare_id,price,currency_type,transfers\nmetro,300,KZT,many\n",
+		"empty file":     "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseGTFSFares(strings.NewReader(attributes), nil); CodeOf(err) != CodeInvalidFeed {
+				t.Fatalf("err = %v, want %s", err, CodeInvalidFeed)
+			}
+		})
+	}
+}
+
+func TestImportGTFSFares(t *testing.T) {
+	m, _ := newTestMachine(t)
+	must(t, m.Catalog.RegisterProduct(Product{Type: "suburban", Name: "Suburban", Price: KZT(400), Stock: 10}))
+	feed, err := ParseGTFSFares(strings.NewReader(gtfsAttributes), strings.NewReader(gtfsRules))
+	must(t, err)
+	stock := m.Catalog.Stock("metro")
+	must(t, m.ImportGTFSFares(feed, "suburban"))
+	if m.GetTicketPrice("metro") != KZT(320) || m.Catalog.Stock("metro") != stock {
+		t.Fatalf("metro price %s, stock %d", m.GetTicketPrice("metro"), m.Catalog.Stock("metro"))
+	}
+	if p, ok := m.Catalog.Product("night_bus"); !ok || p.Price != KZT(400) || p.Validity != time.Hour {
+		t.Fatalf("night bus = %+v", p)
+	}
+	if f, ok := m.ZoneFares.Fare("B", "A"); !ok || f != KZT(650) || !m.isZoned("suburban") {
+		t.Fatalf("zone fare = %s, %v", f, ok)
+	}
+	if _, ok := m.Catalog.Product("sub_ab"); ok {
+		t.Fatal("zone fare imported as a product")
+	}
+}
+
+func TestImportGTFSFaresRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		feed  GTFSFares
+		zoned string
+	}{
+		{"foreign currency", GTFSFares{Fares: []GTFSFare{{ID: "metro", Price: 1, Currency: CurrencyUSD}}}, "suburban"},
+		{"no fare id", GTFSFares{Fares: []GTFSFare{{Price: KZT(300), Currency: CurrencyKZT}}}, "suburban"},
+		{"zone product missing", GTFSFares{
+			Fares: []GTFSFare{{ID: "sub_ab", Price: KZT(650), Currency: CurrencyKZT}},
+			Rules: []GTFSFareRule{{FareID: "sub_ab", OriginID: "A", DestinationID: "B"}},
+		}, "monorail"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			if err := m.ImportGTFSFares(tt.feed, tt.zoned); CodeOf(err) != CodeInvalidFeed {
+				t.Fatalf("err = %v, want %s", err, CodeInvalidFeed)
+			}
+			if m.GetTicketPrice("metro") != KZT(300) {
+				t.Fatalf("rejected feed changed the metro price to %s", m.GetTicketPrice("metro"))
+			}
+		})
+	}
+}
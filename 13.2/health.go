@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Pinger is implemented by storage that can check its connection.
+type Pinger interface {
+	Ping() error
+}
+
+func (s *SQLStorage) Ping() error { return s.DB.Ping() }
+
+func (s *SQLStore) Ping() error { return s.DB.Ping() }
+
+// Ping checks that the directory of the file is there.
+func (s *FileStorage) Ping() error {
+	_, err := os.Stat(filepath.Dir(s.Path))
+	return err
+}
+
+// HealthCheck is the status of one component or store: "ok", "fail" or
+// "untested".
+type HealthCheck struct {
+	Name     string `json:"name"`
+	Critical bool   `json:"critical,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// HealthReport is what the health and readiness endpoints serve.
+type HealthReport struct {
+	MachineID  string        `json:"machine_id"`
+	State      string        `json:"state"`
+	CanSell    bool          `json:"can_sell"`
+	Reasons    []string      `json:"reasons,omitempty"`
+	Components []HealthCheck `json:"components"`
+	Storage    []HealthCheck `json:"storage"`
+}
+
+func checkOf(name string, critical bool, test func() error) HealthCheck {
+	c := HealthCheck{Name: name, Critical: critical, Status: "untested"}
+	if test == nil {
+		return c
+	}
+	if err := test(); err != nil {
+		c.Status, c.Error = "fail", err.Error()
+	} else {
+		c.Status = "ok"
+	}
+	return c
+}
+
+// Health self-tests the fitted devices, pings the storage and reports
+// whether the machine can sell now, with the reasons when it cannot.
+func (m *TicketMachine) Health() HealthReport {
+	r := HealthReport{MachineID: m.MachineID, State: m.State.Name(), Components: []HealthCheck{}, Storage: []HealthCheck{}}
+	for _, c := range m.components() {
+		if c.device == nil && !c.critical {
+			continue
+		}
+		hc := checkOf(c.name, c.critical, c.test())
+		if hc.Status == "fail" && c.critical {
+			r.Reasons = append(r.Reasons, c.name+": "+hc.Error)
+		}
+		r.Components = append(r.Components, hc)
+	}
+	for _, s := range []struct {
+		name  string
+		store interface{}
+	}{{"storage", m.Storage}, {"store", m.Store}} {
+		if s.store == nil {
+			continue
+		}
+		var test func() error
+		if p, ok := s.store.(Pinger); ok {
+			test = p.Ping
+		}
+		hc := checkOf(s.name, true, test)
+		if hc.Status == "fail" {
+			r.Reasons = append(r.Reasons, s.name+": "+hc.Error)
+		}
+		r.Storage = append(r.Storage, hc)
+	}
+	if err := m.inService(); err != nil {
+		r.Reasons = append(r.Reasons, err.Error())
+	} else if m.draining != nil {
+		r.Reasons = append(r.Reasons, "going out of service")
+	}
+	if err := m.checkPaper(); err != nil {
+		r.Reasons = append(r.Reasons, err.Error())
+	}
+	stocked := false
+	for _, p := range m.Catalog.List() {
+		stocked = stocked || m.HasTicket(p.Type)
+	}
+	if !stocked {
+		r.Reasons = append(r.Reasons, "no tickets in stock")
+	}
+	r.CanSell = len(r.Reasons) == 0
+	return r
+}
+
+// HealthMonitor serves the health report of a machine. Health self-tests
+// the devices and pings the store, so the report is taken on the machine's
+// own goroutine on Tick, never inside a transition, and the handlers serve
+// the last one taken.
+type HealthMonitor struct {
+	mu     sync.Mutex
+	report HealthReport
+	stop   func()
+}
+
+// HealthMonitor starts keeping the machine's health report; Close stops
+// it.
+func (m *TicketMachine) HealthMonitor() *HealthMonitor {
+	h := &HealthMonitor{}
+	m.health = h
+	h.stop = func() {
+		if m.health == h {
+			m.health = nil
+		}
+	}
+	h.refresh(m)
+	return h
+}
+
+// Close detaches the monitor from the machine.
+func (h *HealthMonitor) Close() { h.stop() }
+
+func (h *HealthMonitor) refresh(m *TicketMachine) {
+	if h == nil {
+		return
+	}
+	r := m.Health()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.report = r
+}
+
+// Report returns the last report taken.
+func (h *HealthMonitor) Report() HealthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.report
+}
+
+// HealthHandler serves /healthz: the machine is up, and the report says
+// how it is doing.
+func (h *HealthMonitor) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, h.Report())
+	})
+}
+
+// ReadinessHandler serves /readyz: 200 when the machine can sell, 503
+// with the reasons otherwise, so a watchdog can take it off the fleet.
+func (h *HealthMonitor) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := h.Report()
+		status := http.StatusOK
+		if !report.CanSell {
+			status = http.StatusServiceUnavailable
+		}
+		writeHealth(w, status, report)
+	})
+}
+
+func writeHealth(w http.ResponseWriter, status int, h HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(h)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthMonitorServesLastReport(t *testing.T) {
+	m, _ := newTestMachine(t)
+	printer := &countingPrinter{}
+	m.Printer = printer
+	h := m.HealthMonitor()
+	defer h.Close()
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		h.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		return rec.Code
+	}
+	tests := 0
+	if code := ready(); code != http.StatusOK {
+		t.Fatalf("ready %d, want 200", code)
+	}
+	if printer.tests == tests {
+		t.Fatal("no self-test when the monitor started")
+	}
+	tests = printer.tests
+	for i := 0; i < 3; i++ {
+		ready()
+	}
+	if printer.tests != tests {
+		t.Errorf("requests ran %d self-tests", printer.tests-tests)
+	}
+	printer.err = errors.New("paper jam")
+	m.Tick()
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("ready %d after a printer failure, want 503", code)
+	}
+	printer.err = nil
+	tests = printer.tests
+	must(t, m.SelectTicket("bus", 1))
+	if printer.tests != tests {
+		t.Errorf("a state change ran %d self-tests", printer.tests-tests)
+	}
+	m.Tick()
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("ready %d after the next tick, want 200", code)
+	}
+}
+
+// countingPrinter counts its self-tests.
+type countingPrinter struct {
+	MockTicketPrinter
+	tests int
+	err   error
+}
+
+func (p *countingPrinter) SelfTest() error {
+	p.tests++
+	return p.err
+}
+
+// untestedPrinter prints but cannot test itself.
+type untestedPrinter struct{}
+
+func (untestedPrinter) Print(t Ticket, text string) error { return nil }
+
+func TestCriticalDeviceWithoutSelfTest(t *testing.T) {
+	m, _ := newTestMachine(t)
+	if r := m.Health(); !r.CanSell {
+		t.Fatalf("not ready with every critical device tested: %v", r.Reasons)
+	}
+	m.Printer = untestedPrinter{}
+	if r := m.Health(); r.CanSell {
+		t.Errorf("ready with an untested ticket printer: %+v", r.Components)
+	}
+	s, err := m.EnterAdminMode(Credentials{OperatorID: "admin", PIN: "0000"})
+	must(t, err)
+	results, err := s.RunDiagnostics()
+	must(t, err)
+	for _, r := range results {
+		if r.Component == "ticket_printer" && r.Passed() {
+			t.Errorf("%s passed diagnostics", r)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// newTestMachine is a default machine that prints nothing and runs on a
+// fake clock.
+func newTestMachine(t *testing.T) (*TicketMachine, *FakeClock) {
+	t.Helper()
+	m := NewTicketMachine(WithOutput(io.Discard))
+	clock := &FakeClock{T: time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)}
+	m.Clock = clock
+	m.LastActivity = clock.Now()
+	return m, clock
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
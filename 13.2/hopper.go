@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PlanChange works out which coins and notes from the hopper add up to
+// amount, preferring the larger denominations. It returns false if the
+// stock cannot make the exact amount. The search is a dynamic program
+// over the amounts up to amount, in steps of the greatest common divisor
+// of the denominations, so it runs in time linear in amount per
+// denomination whatever the hopper counts.
+func (m *TicketMachine) PlanChange(amount Money) ([]TallyLine, bool) {
+	if amount == 0 {
+		return nil, true
+	}
+	if amount < 0 || amount > m.HopperTotal() {
+		return nil, false
+	}
+	denoms := make([]Money, 0, len(m.Hopper))
+	unit := amount
+	for d, n := range m.Hopper {
+		if n > 0 {
+			denoms = append(denoms, d)
+			unit = gcd(unit, d)
+		}
+	}
+	sort.Slice(denoms, func(i, j int) bool { return denoms[i] > denoms[j] })
+
+	// last[v] is the denomination whose coin completed the plan for v
+	// units, -1 while none is known; a plan, once found, is kept. used[v]
+	// counts the coins of the denomination at hand in that plan.
+	size := int(amount / unit)
+	last := make([]int, size+1)
+	used := make([]int, size+1)
+	for v := 1; v <= size; v++ {
+		last[v] = -1
+	}
+	for i, d := range denoms {
+		step, limit := int(d/unit), m.Hopper[d]
+		clear(used)
+		for v := step; v <= size; v++ {
+			if last[v] < 0 && last[v-step] >= 0 && used[v-step] < limit {
+				last[v], used[v] = i, used[v-step]+1
+			}
+		}
+	}
+	if last[size] < 0 {
+		return nil, false
+	}
+	counts := make([]int, len(denoms))
+	for v := size; v > 0; v -= int(denoms[last[v]] / unit) {
+		counts[last[v]]++
+	}
+	var plan []TallyLine
+	for i, d := range denoms {
+		if counts[i] > 0 {
+			plan = append(plan, TallyLine{Denomination: d, Count: counts[i]})
+		}
+	}
+	return plan, true
+}
+
+func gcd(a, b Money) Money {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// CanMakeChange reports whether the hopper can pay out amount exactly.
+func (m *TicketMachine) CanMakeChange(amount Money) bool {
+	_, ok := m.PlanChange(amount)
+	return ok
+}
+
+// payOutChange removes the coins in plan from the hopper and alerts when
+// the change stock drops below the exact change threshold.
+func (m *TicketMachine) payOutChange(plan []TallyLine) {
+	before := m.HopperTotal()
+	for _, l := range plan {
+		m.Hopper[l.Denomination] -= l.Count
+	}
+	if after := m.HopperTotal(); after < m.ExactChangeThreshold && before >= m.ExactChangeThreshold {
+		m.notify(Alert{Kind: AlertLowChange, Detail: fmt.Sprintf("change stock %s, below %s",
+			after.In(m.Currency), m.ExactChangeThreshold.In(m.Currency))})
+	}
+}
+
+// payOut pays amount out of the hopper in the coins of plan.
+func (m *TicketMachine) payOut(amount Money, plan []TallyLine) Change {
+	c := Change{Amount: amount, Coins: plan}
+	m.payOutChange(plan)
+	m.dispenseChange(&c, m.Hopper)
+	return c
+}
+
+// HopperLevels returns the change stock per denomination.
+func (m *TicketMachine) HopperLevels() []TallyLine {
+	return tallyLines(m.Hopper)
+}
+
+// RefillHopper adds count coins or notes of denom to the change stock.
+func (m *TicketMachine) RefillHopper(denom Money, count int) error {
+	if !m.AcceptsDenomination(denom) {
+		return &UnsupportedDenominationError{Amount: denom}
+	}
+	if count <= 0 {
+		return newError(CodeInvalidInput, "refill count must be positive")
+	}
+	m.Hopper[denom] += count
+	fmt.Fprintf(m.out(), "Hopper refilled: %s x %d\n", denom, count)
+	return nil
+}
+
+// HopperTotal is the total value of the change stock.
+func (m *TicketMachine) HopperTotal() Money {
+	var total Money
+	for d, n := range m.Hopper {
+		total += d * Money(n)
+	}
+	return total
+}
+
+// SetExactChangeOnly toggles exact-change mode from the admin panel.
+func (m *TicketMachine) SetExactChangeOnly(on bool) {
+	m.ExactChangeOnly = on
+}
+
+// ExactChangeRequired reports whether the machine refuses to owe change,
+// either because an operator asked for it or because the hopper has run
+// below ExactChangeThreshold.
+func (m *TicketMachine) ExactChangeRequired() bool {
+	return m.ExactChangeOnly || m.HopperTotal() < m.ExactChangeThreshold
+}
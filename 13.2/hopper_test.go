@@ -0,0 +1,136 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		hopper map[Money]int
+		amount Money
+		want   []TallyLine
+		ok     bool
+	}{
+		{"nothing owed", map[Money]int{KZT(100): 1}, 0, nil, true},
+		{"largest first", map[Money]int{KZT(100): 5, KZT(50): 5}, KZT(250),
+			[]TallyLine{{KZT(100), 2}, {KZT(50), 1}}, true},
+		{"not greedy", map[Money]int{KZT(500): 1, KZT(200): 3}, KZT(600),
+			[]TallyLine{{KZT(200), 3}}, true},
+		{"counts bound", map[Money]int{KZT(200): 2, KZT(100): 1}, KZT(600), nil, false},
+		{"no exact amount", map[Money]int{KZT(200): 10}, KZT(300), nil, false},
+		{"more than in stock", map[Money]int{KZT(100): 2}, KZT(500), nil, false},
+		{"large stock", map[Money]int{KZT(5000): 1000, KZT(200): 100000, KZT(50): 100000, KZT(20): 100000},
+			KZT(19990), []TallyLine{{KZT(5000), 3}, {KZT(200), 24}, {KZT(50), 3}, {KZT(20), 2}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.Hopper = tt.hopper
+			got, ok := m.PlanChange(tt.amount)
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanChange(%s) = %v, %t, want %v, %t", tt.amount, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestInsertNeedsChangeInStock(t *testing.T) {
+	tests := []struct {
+		name   string
+		hopper map[Money]int
+		ok     bool
+	}{
+		{"change in stock", map[Money]int{KZT(200): 1}, true},
+		{"empty hopper", map[Money]int{}, false},
+		{"wrong coins", map[Money]int{KZT(500): 3}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.Hopper, m.ExactChangeThreshold = tt.hopper, 0
+			must(t, m.SelectTicket("metro", 1))
+			err := m.InsertMoney(KZT(500))
+			if tt.ok {
+				must(t, err)
+				return
+			}
+			if CodeOf(err) != CodeCannotMakeChange || m.InsertedMoney != 0 {
+				t.Errorf("InsertMoney = %v with %s inserted, want %s", err, m.InsertedMoney, CodeCannotMakeChange)
+			}
+			must(t, m.InsertMoney(KZT(200)))
+			must(t, m.InsertMoney(KZT(100)))
+		})
+	}
+}
+
+func TestRefillHopper(t *testing.T) {
+	tests := []struct {
+		name  string
+		denom Money
+		count int
+		code  ErrorCode
+	}{
+		{"coins", KZT(100), 10, ""},
+		{"not a denomination", 12345, 10, CodeUnsupportedDenomination},
+		{"no coins", KZT(100), 0, CodeInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			before := m.HopperTotal()
+			err := m.RefillHopper(tt.denom, tt.count)
+			if CodeOf(err) != tt.code {
+				t.Fatalf("RefillHopper = %v, want %q", err, tt.code)
+			}
+			want := before
+			if tt.code == "" {
+				want += tt.denom * Money(tt.count)
+			}
+			if m.HopperTotal() != want {
+				t.Errorf("hopper total %s, want %s", m.HopperTotal(), want)
+			}
+		})
+	}
+}
+
+func TestExactChangeOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(m *TicketMachine)
+		required bool
+	}{
+		{"change in stock", func(m *TicketMachine) {}, false},
+		{"operator switch", func(m *TicketMachine) { m.SetExactChangeOnly(true) }, true},
+		{"hopper below threshold", func(m *TicketMachine) {
+			m.Hopper = map[Money]int{KZT(200): 1}
+			m.ExactChangeThreshold = KZT(1000)
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			tt.setup(m)
+			if got := m.ExactChangeRequired(); got != tt.required {
+				t.Fatalf("ExactChangeRequired = %v, want %v", got, tt.required)
+			}
+			must(t, m.SelectTicket("metro", 1))
+			err := m.InsertMoney(KZT(500))
+			if !tt.required {
+				must(t, err)
+				return
+			}
+			if CodeOf(err) != CodeCannotMakeChange {
+				t.Fatalf("overpaying: %v, want %s", err, CodeCannotMakeChange)
+			}
+			must(t, m.InsertMoney(KZT(200)))
+			must(t, m.InsertMoney(KZT(100)))
+			d, err := m.DispenseTicket()
+			must(t, err)
+			if d.Change.Amount != 0 {
+				t.Errorf("change %s in exact-change mode", d.Change.Amount)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/TheStilk/templates-homework-13/13.2/machinetest/hwsim"
+)
+
+// HardwareSim is a full set of simulated devices for running purchase
+// flows without hardware.
+type HardwareSim struct {
+	Coins    *hwsim.Acceptor[CoinInserted]
+	Bills    *hwsim.Validator[BillEscrowed]
+	Cards    *hwsim.Acceptor[CardPresented]
+	NFC      *hwsim.Reader[CardDetails]
+	Printer  *hwsim.Printer[Ticket]
+	Receipts *hwsim.ReceiptPrinter[TransactionRecord]
+	Change   *hwsim.ChangeDispenser[Money, TallyLine]
+	Display  *hwsim.Display[Selection, Balance]
+}
+
+func NewHardwareSim() *HardwareSim {
+	return &HardwareSim{
+		Coins:    hwsim.NewAcceptor[CoinInserted](32),
+		Bills:    hwsim.NewValidator[BillEscrowed](),
+		Cards:    hwsim.NewAcceptor[CardPresented](1),
+		NFC:      &hwsim.Reader[CardDetails]{},
+		Printer:  &hwsim.Printer[Ticket]{},
+		Receipts: &hwsim.ReceiptPrinter[TransactionRecord]{},
+		Change:   &hwsim.ChangeDispenser[Money, TallyLine]{},
+		Display: &hwsim.Display[Selection, Balance]{
+			FormatPrice: func(s Selection) string {
+				return fmt.Sprintf("price %d x %s %s", s.Qty, s.TicketType, s.Price)
+			},
+			FormatBalance: func(b Balance) string {
+				return fmt.Sprintf("balance %s of %s", b.Total.In(b.Currency), b.Price.In(b.Currency))
+			},
+		},
+	}
+}
+
+// SetSleep replaces the delay function of every device, e.g. with a fake
+// clock's Advance.
+func (h *HardwareSim) SetSleep(sleep func(time.Duration)) {
+	for _, d := range []*hwsim.Device{&h.Coins.Device, &h.Bills.Device, &h.Cards.Device, &h.NFC.Device,
+		&h.Printer.Device, &h.Receipts.Device, &h.Change.Device, &h.Display.Device} {
+		d.Sleep = sleep
+	}
+}
+
+// Attach installs the simulated devices in m.
+func (h *HardwareSim) Attach(m *TicketMachine) {
+	m.Coins = h.Coins
+	m.Bills = h.Bills
+	m.CardReader = h.Cards
+	m.NFCReader = h.NFC
+	m.Printer = h.Printer
+	m.ReceiptPrinter = h.Receipts
+	m.ChangeDispenser = h.Change
+	m.Display = h.Display
+	m.syncCoinAcceptor()
+	m.syncBillValidator()
+	m.syncCardReader()
+}
+
+// SimStep is one step of a hardware script.
+type SimStep struct {
+	Name string
+	Do   func(m *TicketMachine, h *HardwareSim) error
+}
+
+// Run plays the steps in order, polling the devices after each, and stops
+// at the first failing step.
+func (h *HardwareSim) Run(m *TicketMachine, steps ...SimStep) error {
+	for i, s := range steps {
+		err := s.Do(m, h)
+		m.PollCoins()
+		m.PollBills()
+		m.PollCardReader()
+		if err != nil {
+			return newErrorf(CodeOf(err), "step %d (%s): %w", i+1, s.Name, err)
+		}
+	}
+	return nil
+}
+
+// Step builders for Run.
+
+func SimSelect(ticketType string, qty int) SimStep {
+	return SimStep{"select " + ticketType, func(m *TicketMachine, h *HardwareSim) error {
+		return m.SelectTicket(ticketType, qty)
+	}}
+}
+
+func SimCoin(amount Money) SimStep {
+	return SimStep{"coin " + amount.String(), func(m *TicketMachine, h *HardwareSim) error {
+		if !h.Coins.Send(CoinInserted{Amount: amount}) {
+			return newError(CodeInvalidState, "coin acceptor disabled")
+		}
+		return nil
+	}}
+}
+
+func SimNote(amount Money) SimStep {
+	return SimStep{"note " + amount.String(), func(m *TicketMachine, h *HardwareSim) error {
+		if !h.Bills.Insert(BillEscrowed{Amount: amount}) {
+			return newError(CodeInvalidState, "bill validator not ready")
+		}
+		return nil
+	}}
+}
+
+func SimTap(card CardDetails) SimStep {
+	return SimStep{"tap " + card.MaskedPAN, func(m *TicketMachine, h *HardwareSim) error {
+		card.EntryMode, card.EMV = EntryContactless, &EMVData{}
+		if !h.Cards.Send(CardPresented{Card: card}) {
+			return newError(CodeInvalidState, "card reader disabled")
+		}
+		return nil
+	}}
+}
+
+func SimDispense() SimStep {
+	return SimStep{"dispense", func(m *TicketMachine, h *HardwareSim) error {
+		_, err := m.DispenseTicket()
+		return err
+	}}
+}
+
+func SimCancel() SimStep {
+	return SimStep{"cancel", func(m *TicketMachine, h *HardwareSim) error { return m.Cancel() }}
+}
+
+// SimExpect checks the machine is in state.
+func SimExpect(state string) SimStep {
+	return SimStep{"expect " + state, func(m *TicketMachine, h *HardwareSim) error {
+		if got := m.GetCurrentState(); got != state {
+			return newErrorf(CodeInvalidState, "state %s, want %s", got, state)
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHardwareSimFlows(t *testing.T) {
+	tests := []struct {
+		name   string
+		script func(h *HardwareSim)
+		steps  []SimStep
+		fails  bool
+		check  func(t *testing.T, m *TicketMachine, h *HardwareSim)
+	}{
+		{"cash sale", nil,
+			[]SimStep{SimSelect("metro", 1), SimCoin(KZT(200)), SimCoin(KZT(100)), SimDispense(), SimExpect("TicketDispensed")},
+			false, func(t *testing.T, m *TicketMachine, h *HardwareSim) {
+				if len(h.Printer.Printed) != 1 {
+					t.Errorf("printed %d tickets, want 1", len(h.Printer.Printed))
+				}
+			}},
+		{"printer jam", func(h *HardwareSim) { h.Printer.FailNext("print", errors.New("paper jam"), 1) },
+			[]SimStep{SimSelect("metro", 1), SimNote(KZT(500)), SimDispense()},
+			true, func(t *testing.T, m *TicketMachine, h *HardwareSim) {
+				must(t, h.Run(m, SimExpect("PrintError"), SimDispense(), SimExpect("ChangeDispensed")))
+				if len(h.Printer.Printed) != 1 {
+					t.Errorf("printed %d tickets after the retry, want 1", len(h.Printer.Printed))
+				}
+			}},
+		{"dispenser jam", func(h *HardwareSim) {
+			h.Change.FailNext("dispense", &DispenseError{Fault: FaultJam, Denomination: KZT(200)}, 1)
+		},
+			[]SimStep{SimSelect("metro", 1), SimNote(KZT(500)), SimDispense(), SimExpect("ChangeDispensed")},
+			false, func(t *testing.T, m *TicketMachine, h *HardwareSim) {
+				if len(m.Vouchers) != 1 || m.Vouchers[0].Amount != KZT(200) {
+					t.Errorf("vouchers %+v, want one for the change", m.Vouchers)
+				}
+			}},
+		{"cancel", nil,
+			[]SimStep{SimSelect("metro", 1), SimCoin(KZT(200)), SimCancel(), SimExpect("TransactionCanceled")},
+			false, func(t *testing.T, m *TicketMachine, h *HardwareSim) {
+				if len(h.Change.Dispensed) != 1 || h.Change.Dispensed[0] != (TallyLine{KZT(200), 1}) {
+					t.Errorf("dispensed %v, want the inserted coin back", h.Change.Dispensed)
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			h := NewHardwareSim()
+			if tt.script != nil {
+				tt.script(h)
+			}
+			h.Attach(m)
+			if err := h.Run(m, tt.steps...); (err != nil) != tt.fails {
+				t.Fatalf("Run: %v, want failure %t", err, tt.fails)
+			}
+			tt.check(t, m, h)
+		})
+	}
+}
+
+func TestHardwareSimDelays(t *testing.T) {
+	m, clock := newTestMachine(t)
+	h := NewHardwareSim()
+	h.SetSleep(clock.Advance)
+	h.Printer.Delay("print", 2*time.Second)
+	h.Attach(m)
+	start := clock.Now()
+	must(t, h.Run(m, SimSelect("metro", 2), SimNote(KZT(500)), SimCoin(KZT(100)), SimDispense()))
+	if waited := clock.Now().Sub(start); waited != 4*time.Second {
+		t.Errorf("waited %s for two tickets, want 4s", waited)
+	}
+}
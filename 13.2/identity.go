@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Location is where a machine is installed.
+type Location struct {
+	StationID string
+	Station   string
+	Zone      string
+}
+
+// HardwareProfile describes the machine's build.
+type HardwareProfile struct {
+	Model    string
+	Serial   string
+	Firmware string
+}
+
+// MachineInfo identifies a machine to the central server.
+type MachineInfo struct {
+	MachineID string
+	Location  Location
+	Hardware  HardwareProfile
+	Products  []string
+}
+
+// Info describes the machine and the products it sells.
+func (m *TicketMachine) Info() MachineInfo {
+	info := MachineInfo{MachineID: m.MachineID, Location: m.Location, Hardware: m.Hardware}
+	for _, p := range m.Catalog.List() {
+		if p.Active {
+			info.Products = append(info.Products, p.Type)
+		}
+	}
+	return info
+}
+
+// Registration is the central server's acknowledgement of a machine.
+type Registration struct {
+	Token        string
+	RegisteredAt time.Time
+}
+
+// MachineRegistry is the central server's list of known machines.
+type MachineRegistry interface {
+	Register(info MachineInfo) (Registration, error)
+}
+
+// MockRegistry accepts any machine with an ID.
+type MockRegistry struct {
+	Machines map[string]MachineInfo
+}
+
+func (r *MockRegistry) Register(info MachineInfo) (Registration, error) {
+	if info.MachineID == "" {
+		return Registration{}, newError(CodeInvalidInput, "machine ID required")
+	}
+	if r.Machines == nil {
+		r.Machines = map[string]MachineInfo{}
+	}
+	r.Machines[info.MachineID] = info
+	return Registration{Token: "REG-" + info.MachineID, RegisteredAt: time.Now()}, nil
+}
+
+// Register announces the machine to the Registry.
+func (m *TicketMachine) Register() error {
+	if m.Registry == nil {
+		return newError(CodeInvalidConfig, "no machine registry configured")
+	}
+	reg, err := m.Registry.Register(m.Info())
+	if err != nil {
+		return newErrorf(CodeRegistration, "registration failed: %w", err)
+	}
+	m.Registration = &reg
+	m.audit("", "register", reg.Token)
+	fmt.Fprintf(m.out(), "Registered %s at %s\n", m.MachineID, m.Location.Station)
+	return nil
+}
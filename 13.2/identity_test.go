@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.Registry = nil
+	if err := m.Register(); CodeOf(err) != CodeInvalidConfig {
+		t.Fatalf("Register without a registry = %v, want %s", err, CodeInvalidConfig)
+	}
+
+	r := &MockRegistry{}
+	m.Registry = r
+	m.MachineID = "TM-7"
+	m.Location = Location{StationID: "ALM-01", Station: "Almaly", Zone: "A"}
+	m.Hardware = HardwareProfile{Model: "TVM-3", Serial: "SN-42", Firmware: "1.4.0"}
+	must(t, m.Catalog.Deactivate("train"))
+	must(t, m.Register())
+	if m.Registration == nil || m.Registration.Token != "REG-TM-7" {
+		t.Fatalf("registration = %+v", m.Registration)
+	}
+	info := r.Machines["TM-7"]
+	if info.Location != m.Location || info.Hardware != m.Hardware {
+		t.Fatalf("registered %+v", info)
+	}
+	for _, p := range info.Products {
+		if p == "train" {
+			t.Fatalf("withdrawn product registered: %v", info.Products)
+		}
+	}
+	if len(info.Products) == 0 {
+		t.Fatal("no products registered")
+	}
+
+	m.MachineID = ""
+	err := m.Register()
+	if CodeOf(err) != CodeRegistration || !errors.Is(err, &MachineError{Code: CodeInvalidInput}) {
+		t.Fatalf("Register without an ID = %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CatalogImport describes what an ImportCatalog changed.
+type CatalogImport struct {
+	Added     []string
+	Repriced  []string
+	Restocked []string
+}
+
+func (c CatalogImport) String() string {
+	var parts []string
+	for _, p := range []struct {
+		label string
+		list  []string
+	}{{"added", c.Added}, {"repriced", c.Repriced}, {"restocked", c.Restocked}} {
+		if len(p.list) > 0 {
+			parts = append(parts, p.label+" "+strings.Join(p.list, ", "))
+		}
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// importRow is one product of an import file; price and stock tell
+// whether the row sets them.
+type importRow struct {
+	at           string
+	spec         ProductSpec
+	price, stock bool
+}
+
+// ImportCatalog loads products, prices and stock counts from a file,
+// taking the format from its extension: .csv or .json.
+func (s *AdminSession) ImportCatalog(path string) (CatalogImport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return CatalogImport{}, err
+	}
+	defer f.Close()
+	return s.ImportCatalogFrom(f, strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// ImportCatalogFrom loads products, prices and stock counts in bulk. A
+// CSV import has a header row naming its columns: type, and any of name,
+// description, price, vat_bp, stock, low_stock_at, validity_minutes,
+// pass_days and pass_months; prices are in major units, as in "300.00". A
+// JSON import is a list of products as in a Config, prices in minor
+// units. Rows for known products set their price and stock count, and
+// put withdrawn products back on sale; other rows add products, which
+// must be complete. Every row is checked before any is applied, and the
+// import applies in full or not at all.
+func (s *AdminSession) ImportCatalogFrom(r io.Reader, format string) (CatalogImport, error) {
+	if err := s.active(); err != nil {
+		return CatalogImport{}, err
+	}
+	for _, p := range []Permission{PermSetPrice, PermRestock} {
+		if err := s.m.authorize(s.OperatorID, p, "import_catalog"); err != nil {
+			return CatalogImport{}, err
+		}
+	}
+	var rows []importRow
+	var err error
+	switch format {
+	case "csv":
+		rows, err = readImportCSV(r)
+	case "json":
+		rows, err = readImportJSON(r)
+	default:
+		err = newErrorf(CodeInvalidInput, "unsupported import format %q", format)
+	}
+	if err == nil {
+		err = s.m.checkImport(rows)
+	}
+	if err != nil {
+		s.m.audit(s.OperatorID, "import_rejected", err.Error())
+		return CatalogImport{}, err
+	}
+
+	var res CatalogImport
+	catalog := s.m.Catalog.clone()
+	deltas := map[string]int{}
+	for _, row := range rows {
+		t := row.spec.Type
+		old, ok := catalog.Product(t)
+		if !ok {
+			catalog.RegisterProduct(row.spec.product())
+			res.Added = append(res.Added, t)
+			deltas[t] = row.spec.Stock
+			continue
+		}
+		p := old
+		if row.price {
+			p.Price = row.spec.Price
+		}
+		if row.stock {
+			p.Stock = row.spec.Stock
+		}
+		catalog.RegisterProduct(p)
+		if !old.Active {
+			res.Added = append(res.Added, t)
+		}
+		if p.Price != old.Price {
+			res.Repriced = append(res.Repriced, fmt.Sprintf("%s %s -> %s", t, old.Price, p.Price))
+		}
+		if d := p.Stock - old.Stock; d != 0 {
+			res.Restocked = append(res.Restocked, fmt.Sprintf("%s %+d", t, d))
+			deltas[t] = d
+		}
+	}
+	s.m.Catalog = catalog
+	for _, row := range rows {
+		t := row.spec.Type
+		s.m.persist(t)
+		switch d := deltas[t]; {
+		case d > 0:
+			s.m.recordMovement(t, d, s.OperatorID, MoveRestock, "import")
+		case d < 0:
+			s.m.recordMovement(t, d, s.OperatorID, MoveShrinkage, "import")
+		}
+	}
+	s.m.audit(s.OperatorID, "import_catalog", res.String())
+	fmt.Fprintln(s.m.out(), "Catalog imported:", res)
+	return res, nil
+}
+
+// checkImport validates the rows against the catalog and reports every
+// problem found.
+func (m *TicketMachine) checkImport(rows []importRow) error {
+	var problems []string
+	types := map[string]bool{}
+	for _, p := range m.Catalog.List() {
+		types[p.Type] = true
+	}
+	for _, row := range rows {
+		types[row.spec.Type] = true
+	}
+	seen := map[string]bool{}
+	for _, row := range rows {
+		p := row.spec
+		if p.Type == "" {
+			problems = append(problems, row.at+": type is required")
+			continue
+		}
+		if seen[p.Type] {
+			problems = append(problems, row.at+": duplicate product")
+		}
+		seen[p.Type] = true
+		if _, ok := m.Catalog.Product(p.Type); !ok {
+			problems = append(problems, p.problems(row.at, types)...)
+			continue
+		}
+		if row.price && p.Price <= 0 {
+			problems = append(problems, row.at+": price must be positive")
+		}
+		if row.stock && p.Stock < 0 {
+			problems = append(problems, row.at+": stock must not be negative")
+		}
+	}
+	if len(rows) == 0 {
+		problems = append(problems, "no products")
+	}
+	if len(problems) > 0 {
+		return newError(CodeInvalidInput, "invalid import: "+strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func readImportCSV(r io.Reader) ([]importRow, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, newErrorf(CodeInvalidInput, "import: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, newError(CodeInvalidInput, "import: missing header")
+	}
+	header := records[0]
+	hasType := false
+	for _, col := range header {
+		switch col {
+		case "type":
+			hasType = true
+		case "name", "description", "price", "vat_bp", "stock", "low_stock_at",
+			"validity_minutes", "pass_days", "pass_months":
+		default:
+			return nil, newErrorf(CodeInvalidInput, "import: unknown column %q", col)
+		}
+	}
+	if !hasType {
+		return nil, newError(CodeInvalidInput, "import: type column is required")
+	}
+	var rows []importRow
+	var problems []string
+	for i, rec := range records[1:] {
+		row := importRow{at: fmt.Sprintf("line %d", i+2)}
+		for c, v := range rec {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			var n int
+			var err error
+			switch col := header[c]; col {
+			case "type":
+				row.spec.Type = v
+				row.at += " (" + v + ")"
+			case "name":
+				row.spec.Name = v
+			case "description":
+				row.spec.Description = v
+			case "price":
+				row.spec.Price, err = ParseMoney(v)
+				row.price = true
+			default:
+				if n, err = strconv.Atoi(v); err != nil {
+					break
+				}
+				switch col {
+				case "vat_bp":
+					row.spec.VATRate = n
+				case "stock":
+					row.spec.Stock, row.stock = n, true
+				case "low_stock_at":
+					row.spec.LowStockAt = n
+				case "validity_minutes":
+					row.spec.ValidityMinutes = n
+				case "pass_days":
+					row.spec.PassDays = n
+				case "pass_months":
+					row.spec.PassMonths = n
+				}
+			}
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("line %d: bad %s %q", i+2, header[c], v))
+			}
+		}
+		rows = append(rows, row)
+	}
+	if len(problems) > 0 {
+		return nil, newError(CodeInvalidInput, "invalid import: "+strings.Join(problems, "; "))
+	}
+	return rows, nil
+}
+
+func readImportJSON(r io.Reader) ([]importRow, error) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, newErrorf(CodeInvalidInput, "import: %w", err)
+	}
+	rows := make([]importRow, len(raw))
+	for i, doc := range raw {
+		row := importRow{at: fmt.Sprintf("products[%d]", i)}
+		dec := json.NewDecoder(bytes.NewReader(doc))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&row.spec); err != nil {
+			return nil, newErrorf(CodeInvalidInput, "import: %s: %w", row.at, err)
+		}
+		var fields map[string]json.RawMessage
+		json.Unmarshal(doc, &fields)
+		_, row.price = fields["price"]
+		_, row.stock = fields["stock"]
+		if row.spec.Type != "" {
+			row.at += " (" + row.spec.Type + ")"
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImportCatalog(t *testing.T) {
+	airport := "airport,Airport express,1500.00,1200,20,90\n"
+	tests := []struct {
+		name, format, data string
+		wantErr            bool
+		product            string
+		price              Money
+		stock              int // -1 for unchanged
+		added              int
+	}{
+		{"reprice and restock", "csv", "type,price,stock\nmetro,350.00,40\n", false, "metro", KZT(350), 40, 0},
+		{"new product", "csv", "type,name,price,vat_bp,stock,validity_minutes\n" + airport, false, "airport", KZT(1500), 20, 1},
+		{"new product as json", "json",
+			`[{"type":"airport","name":"Airport express","price":150000,"vat_bp":1200,"stock":20,"validity_minutes":90}]`,
+			false, "airport", KZT(1500), 20, 1},
+		{"incomplete new product", "csv", "type,price\nmetro,350.00\nairport,1500.00\n", true, "metro", KZT(300), -1, 0},
+		{"duplicate row", "csv", "type,price\nmetro,350.00\nmetro,400.00\n", true, "metro", KZT(300), -1, 0},
+		{"bad price", "csv", "type,price\nmetro,cheap\n", true, "metro", KZT(300), -1, 0},
+		{"unknown column", "csv", "type,colour\nmetro,red\n", true, "metro", KZT(300), -1, 0},
+		{"unsupported format", "xml", "<catalog/>", true, "metro", KZT(300), -1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			before := m.Catalog.Stock(tt.product)
+			s, err := m.EnterAdminMode(Credentials{OperatorID: "admin", PIN: "0000"})
+			must(t, err)
+			res, err := s.ImportCatalogFrom(strings.NewReader(tt.data), tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImportCatalogFrom: %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && CodeOf(err) != CodeInvalidInput {
+				t.Errorf("error %v, want invalid input", err)
+			}
+			p, _ := m.Catalog.Product(tt.product)
+			if p.Price != tt.price {
+				t.Errorf("%s price %s, want %s", tt.product, p.Price, tt.price)
+			}
+			if tt.stock < 0 {
+				tt.stock = before
+			}
+			if got := m.Catalog.Stock(tt.product); got != tt.stock {
+				t.Errorf("%s stock %d, want %d", tt.product, got, tt.stock)
+			}
+			if len(res.Added) != tt.added {
+				t.Errorf("added %v, want %d products", res.Added, tt.added)
+			}
+		})
+	}
+}
+
+func TestImportCatalogNeedsPermissions(t *testing.T) {
+	m, _ := newTestMachine(t)
+	s, err := m.EnterAdminMode(Credentials{OperatorID: "clerk", PIN: "1111"})
+	must(t, err)
+	if _, err := s.ImportCatalogFrom(strings.NewReader("type,price\nmetro,350.00\n"), "csv"); err == nil {
+		t.Fatal("refill clerk changed prices")
+	}
+	if p, _ := m.Catalog.Product("metro"); p.Price != KZT(300) {
+		t.Errorf("metro price %s after a denied import", p.Price)
+	}
+}
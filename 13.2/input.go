@@ -0,0 +1,96 @@
+package main
+
+import "strconv"
+
+// InputAction is a customer action on the keypad or touch screen.
+type InputAction string
+
+const (
+	InputSelect  InputAction = "select"
+	InputCancel  InputAction = "cancel"
+	InputConfirm InputAction = "confirm"
+)
+
+// InputEvent is an action from an input device. Product is the 1-based
+// position of the chosen product in ListAvailableProducts.
+type InputEvent struct {
+	Action  InputAction
+	Product int
+}
+
+// InputDevice is a physical keypad or touch front end. It maps buttons or
+// touch targets to input events, which the machine reads from Events.
+type InputDevice interface {
+	Events() <-chan InputEvent
+}
+
+// Keypad maps key labels to input events.
+type Keypad struct {
+	Keys   map[string]InputEvent
+	events chan InputEvent
+}
+
+// NewKeypad returns a keypad with keys "1" to "9" selecting products, "C"
+// canceling and "OK" confirming.
+func NewKeypad() *Keypad {
+	keys := map[string]InputEvent{
+		"C":  {Action: InputCancel},
+		"OK": {Action: InputConfirm},
+	}
+	for n := 1; n <= 9; n++ {
+		keys[strconv.Itoa(n)] = InputEvent{Action: InputSelect, Product: n}
+	}
+	return &Keypad{Keys: keys, events: make(chan InputEvent, 16)}
+}
+
+func (k *Keypad) Events() <-chan InputEvent { return k.events }
+
+// Press simulates a key press. It reports false for unmapped keys.
+func (k *Keypad) Press(key string) bool {
+	ev, ok := k.Keys[key]
+	if !ok {
+		return false
+	}
+	k.events <- ev
+	return true
+}
+
+// PollInput carries out the input events since the last call.
+func (m *TicketMachine) PollInput() {
+	if m.Input == nil {
+		return
+	}
+	for {
+		select {
+		case ev := <-m.Input.Events():
+			if err := m.HandleInput(ev); err != nil {
+				m.display().ShowError(err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// HandleInput maps an input event to the machine API. Confirm dispenses
+// the paid ticket, or starts over once the transaction is finished.
+func (m *TicketMachine) HandleInput(ev InputEvent) error {
+	switch ev.Action {
+	case InputSelect:
+		products := m.ListAvailableProducts()
+		if ev.Product < 1 || ev.Product > len(products) {
+			return newErrorf(CodeInvalidInput, "no product %d", ev.Product)
+		}
+		return m.SelectTicket(products[ev.Product-1].Type, 1)
+	case InputCancel:
+		return m.Cancel()
+	case InputConfirm:
+		switch m.State.(type) {
+		case *TicketDispensedState, *ChangeDispensedState, *TransactionCanceledState, *RefundIssuedState:
+			return m.StartOver()
+		}
+		_, err := m.DispenseTicket()
+		return err
+	}
+	return newErrorf(CodeInvalidInput, "unknown input %s", ev.Action)
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestKeypadSale(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.Gateway = &MockGateway{}
+	keys := NewKeypad()
+	m.Input = keys
+	first := m.ListAvailableProducts()[0]
+	if keys.Press("Z") {
+		t.Fatal("unmapped key pressed")
+	}
+	keys.Press("1")
+	m.PollInput()
+	if m.CurrentTicket != first.Type {
+		t.Fatalf("selected %q, want %q", m.CurrentTicket, first.Type)
+	}
+	must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}))
+	keys.Press("OK")
+	m.PollInput()
+	if _, ok := m.State.(*TicketDispensedState); !ok {
+		t.Fatalf("state %s after confirming a paid sale", m.State.Name())
+	}
+	keys.Press("OK")
+	m.PollInput()
+	if m.GetCurrentState() != (&IdleState{}).Name() {
+		t.Fatalf("state %s after confirming a finished sale", m.State.Name())
+	}
+}
+
+func TestHandleInput(t *testing.T) {
+	m, _ := newTestMachine(t)
+	if err := m.HandleInput(InputEvent{Action: InputSelect, Product: 99}); CodeOf(err) != CodeInvalidInput {
+		t.Fatalf("select product 99 = %v, want %s", err, CodeInvalidInput)
+	}
+	if err := m.HandleInput(InputEvent{Action: "help"}); CodeOf(err) != CodeInvalidInput {
+		t.Fatalf("unknown action = %v, want %s", err, CodeInvalidInput)
+	}
+	must(t, m.HandleInput(InputEvent{Action: InputSelect, Product: 1}))
+	must(t, m.HandleInput(InputEvent{Action: InputCancel}))
+	if _, ok := m.State.(*TransactionCanceledState); !ok {
+		t.Fatalf("state %s after cancel", m.State.Name())
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// JournalEntry is one line of the journal: a customer action, written
+// before it is applied; a call the action made to a device, gateway or
+// provider, with its outcome; or a state transition. Card is journaled
+// without its track data, which Replay does not need since it answers
+// card authorizations from the journal.
+type JournalEntry struct {
+	Seq           int             `json:"seq"`
+	Time          time.Time       `json:"time"`
+	TransactionID string          `json:"transaction_id,omitempty"`
+	Action        string          `json:"action,omitempty"`
+	TicketType    string          `json:"ticket_type,omitempty"`
+	Qty           int             `json:"qty,omitempty"`
+	Amount        Money           `json:"amount,omitempty"`
+	Currency      Currency        `json:"currency,omitempty"`
+	Card          *CardDetails    `json:"card,omitempty"`
+	PaymentID     string          `json:"payment_id,omitempty"`
+	Promo         string          `json:"promo,omitempty"`
+	Seat          *Seat           `json:"seat,omitempty"`
+	Fare          FareCategory    `json:"fare,omitempty"`
+	Zone          string          `json:"zone,omitempty"`
+	Journey       JourneyType     `json:"journey,omitempty"`
+	Channel       DeliveryChannel `json:"channel,omitempty"`
+	Address       string          `json:"address,omitempty"`
+	On            bool            `json:"on,omitempty"`
+	Call          string          `json:"call,omitempty"`
+	Result        json.RawMessage `json:"result,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	Code          ErrorCode       `json:"code,omitempty"`
+	Dispense      *DispenseError  `json:"dispense,omitempty"`
+	From          string          `json:"from,omitempty"`
+	To            string          `json:"to,omitempty"`
+}
+
+// Journal appends entries to W as JSON lines, each encrypted when Sealer
+// is set.
+type Journal struct {
+	W      io.Writer
+	Sealer *Sealer
+	seq    int
+}
+
+func (j *Journal) append(e JournalEntry) error {
+	j.seq++
+	e.Seq = j.seq
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if j.Sealer != nil {
+		if raw, err = j.Sealer.sealLine(raw); err != nil {
+			return err
+		}
+	}
+	_, err = j.W.Write(append(raw, '\n'))
+	return err
+}
+
+// logAction journals a customer action. An action that cannot be
+// journaled is refused.
+func (m *TicketMachine) logAction(e JournalEntry) error {
+	e.TransactionID = m.saleID()
+	m.logActionEntry(e)
+	if m.Journal == nil {
+		return nil
+	}
+	e.Time = m.Clock.Now()
+	if err := m.Journal.append(e); err != nil {
+		return newErrorf(CodeStorage, "journal: %w", err)
+	}
+	return nil
+}
+
+// deviceCall makes a call to a device, gateway or provider, through
+// traceCall, and journals its outcome: what call left in out, and the
+// error. While Replay runs the journaled outcome is returned instead and
+// nothing is called.
+func (m *TicketMachine) deviceCall(name string, out interface{}, call func() error, attrs ...slog.Attr) error {
+	if m.replay != nil {
+		return m.replay.outcome(name, out)
+	}
+	err := m.traceCall(name, call, attrs...)
+	if m.Journal == nil {
+		return err
+	}
+	e := JournalEntry{Time: m.Clock.Now(), TransactionID: m.saleID(), Call: name}
+	if out != nil {
+		e.Result, _ = json.Marshal(out)
+	}
+	if err != nil {
+		e.Error, e.Code = err.Error(), CodeOf(err)
+		errors.As(err, &e.Dispense)
+	}
+	if jerr := m.Journal.append(e); jerr != nil {
+		m.warn("journal: %v", jerr)
+	}
+	return err
+}
+
+func (m *TicketMachine) logTransition(from, to State) {
+	// A transition into or out of a sale belongs to it.
+	txID := ""
+	if inSale(from) || inSale(to) {
+		txID = m.TransactionID
+	}
+	attrs := []slog.Attr{slog.String("to", to.Name())}
+	if from != nil {
+		attrs = append(attrs, slog.String("from", from.Name()))
+	}
+	m.logTx(slog.LevelInfo, "transition", txID, attrs...)
+	if m.Journal == nil {
+		return
+	}
+	e := JournalEntry{Time: m.Clock.Now(), TransactionID: txID, To: to.Name()}
+	if from != nil {
+		e.From = from.Name()
+	}
+	if err := m.Journal.append(e); err != nil {
+		m.warn("journal: %v", err)
+	}
+}
+
+// journalReplay answers the device calls of the action being replayed
+// from the outcomes journaled after it.
+type journalReplay struct {
+	seq      int
+	outcomes []JournalEntry
+	err      error
+}
+
+func (r *journalReplay) outcome(name string, out interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.outcomes) == 0 || r.outcomes[0].Call != name {
+		r.err = newErrorf(CodeStorage, "journal entry %d: %s not journaled", r.seq, name)
+		return r.err
+	}
+	o := r.outcomes[0]
+	r.outcomes = r.outcomes[1:]
+	if out != nil && len(o.Result) > 0 {
+		if err := json.Unmarshal(o.Result, out); err != nil {
+			r.err = newErrorf(CodeStorage, "journal entry %d: %w", o.Seq, err)
+			return r.err
+		}
+	}
+	switch {
+	case o.Dispense != nil:
+		return o.Dispense
+	case o.Error == "":
+		return nil
+	case o.Code == "" || o.Code == CodeInternal:
+		return errors.New(o.Error)
+	}
+	return newError(o.Code, o.Error)
+}
+
+// replayDeliverer stands in for the e-ticket deliverers during Replay, as
+// the tickets were sent when the journal was written.
+type replayDeliverer struct{}
+
+func (replayDeliverer) Deliver(address string, tickets []Ticket) error { return nil }
+
+// Replay plays a journal back on m, a machine built with the same
+// configuration as the one that wrote it. Actions are applied in order,
+// on the journal's time when m runs on a FakeClock. Devices, gateways and
+// providers are not called again: each call an action makes is answered
+// with the outcome journaled for it, and e-tickets are not sent again.
+// The recorded transitions are checked, and replay stops where m diverges
+// from the journal. Actions that failed when journaled fail again and are
+// skipped; calls made outside an action, e.g. from Tick, are not replayed.
+func Replay(journal io.Reader, m *TicketMachine) error {
+	var entries []JournalEntry
+	sc := bufio.NewScanner(journal)
+	for sc.Scan() {
+		var e JournalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return newErrorf(CodeStorage, "journal: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return newErrorf(CodeStorage, "journal: %w", err)
+	}
+	saved, deliverers := m.Journal, m.Deliverers
+	m.Journal = nil
+	if deliverers != nil {
+		m.Deliverers = map[DeliveryChannel]TicketDeliverer{}
+		for c := range deliverers {
+			m.Deliverers[c] = replayDeliverer{}
+		}
+	}
+	r := &journalReplay{}
+	m.replay = r
+	defer func() { m.Journal, m.Deliverers, m.replay = saved, deliverers, nil }()
+	clock, _ := m.Clock.(*FakeClock)
+	want, seq := "", 0
+	check := func() error {
+		if want != "" && m.GetCurrentState() != want {
+			return newErrorf(CodeStorage, "journal entry %d: state %s, journal has %s", seq, m.GetCurrentState(), want)
+		}
+		return nil
+	}
+	for i, e := range entries {
+		if e.Action == "" {
+			if e.Call == "" {
+				want, seq = e.To, e.Seq
+			}
+			continue
+		}
+		if err := check(); err != nil {
+			return err
+		}
+		if clock != nil {
+			clock.T = e.Time
+		}
+		r.seq, r.outcomes = e.Seq, nil
+		for _, o := range entries[i+1:] {
+			if o.Action != "" {
+				break
+			}
+			if o.Call != "" {
+				r.outcomes = append(r.outcomes, o)
+			}
+		}
+		if err := m.replayAction(e); err != nil {
+			return err
+		}
+		if r.err != nil {
+			return r.err
+		}
+	}
+	return check()
+}
+
+// replayAction applies one journaled action; its own error is the one
+// journaled with it and is dropped.
+func (m *TicketMachine) replayAction(e JournalEntry) error {
+	switch e.Action {
+	case "select":
+		m.SelectTicket(e.TicketType, e.Qty)
+	case "cart_add":
+		m.AddToCart(e.TicketType, e.Qty)
+	case "cart_remove":
+		m.RemoveFromCart(e.TicketType)
+	case "checkout":
+		m.Checkout()
+	case "accessibility":
+		m.SetAccessibility(e.On)
+	case "fare":
+		m.SelectFareCategory(e.Fare)
+	case "destination":
+		m.SelectDestination(e.Zone)
+	case "journey":
+		m.SelectJourney(e.Journey)
+	case "seat":
+		if e.Seat != nil {
+			m.SelectSeat(*e.Seat)
+		}
+	case "skip_seat":
+		m.SkipSeat()
+	case "promo":
+		m.ApplyPromoCode(e.Promo)
+	case "link_rider":
+		m.LinkRider()
+	case "delivery":
+		m.ChooseDelivery(e.Channel, e.Address)
+	case "insert":
+		m.InsertMoney(e.Amount)
+	case "insert_in":
+		m.InsertMoneyIn(e.Amount, e.Currency)
+	case "card":
+		if e.Card != nil {
+			m.PayByCard(*e.Card)
+		}
+	case "tap":
+		m.TapToPay(e.TicketType)
+	case "qr":
+		m.PayByQR()
+	case "qr_poll":
+		m.PollQRPayment()
+	case "qr_confirm":
+		m.ConfirmQRPayment(e.PaymentID)
+	case "transit_card":
+		m.PresentTransitCard()
+	case "top_up":
+		m.SelectTopUp(e.Amount)
+	case "cancel":
+		m.Cancel()
+	case "dispense":
+		m.DispenseTicket()
+	case "receipt":
+		m.PrintReceipt()
+	case "start_over":
+		m.StartOver()
+	case "timeout":
+		m.timeOut()
+	default:
+		return newErrorf(CodeStorage, "journal entry %d: unknown action %s", e.Seq, e.Action)
+	}
+	return nil
+}
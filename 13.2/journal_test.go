@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// journaledSession runs a card sale and a QR cart sale on a journaling
+// machine and returns the machine and its journal.
+func journaledSession(t *testing.T) (*TicketMachine, []byte) {
+	t.Helper()
+	m, clock := newTestMachine(t)
+	qr := &MockQRProvider{}
+	m.Gateway, m.QRProvider = &MockGateway{}, qr
+	var journal bytes.Buffer
+	m.Journal = &Journal{W: &journal}
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242", EntryMode: EntryMagstripe, Track: "4242424242424242=2812"}))
+	_, err := m.DispenseTicket()
+	must(t, err)
+	must(t, m.StartOver())
+	clock.Advance(time.Minute)
+	must(t, m.AddToCart("bus", 2))
+	must(t, m.Checkout())
+	p, err := m.PayByQR()
+	must(t, err)
+	qr.MarkPaid(p.ID)
+	must(t, m.PollQRPayment())
+	_, err = m.DispenseTicket()
+	must(t, err)
+	return m, journal.Bytes()
+}
+
+func TestReplayUsesJournaledOutcomes(t *testing.T) {
+	want, journal := journaledSession(t)
+	if bytes.Contains(journal, []byte("2812")) {
+		t.Error("journal holds card track data")
+	}
+	m, _ := newTestMachine(t)
+	g := &MockGateway{Fail: errors.New("gateway down")}
+	m.Gateway, m.QRProvider = g, &MockQRProvider{}
+	must(t, Replay(bytes.NewReader(journal), m))
+	if got := m.GetCurrentState(); got != want.GetCurrentState() {
+		t.Errorf("state %s, want %s", got, want.GetCurrentState())
+	}
+	if len(m.Transactions) != 2 {
+		t.Fatalf("%d transactions, want 2", len(m.Transactions))
+	}
+	for i, rec := range m.Transactions {
+		w := want.Transactions[i]
+		if rec.Price != w.Price || len(rec.Tenders) != len(w.Tenders) {
+			t.Errorf("transaction %d: %s %v, want %s %v", i, rec.Price, rec.Tenders, w.Price, w.Tenders)
+		}
+	}
+	if len(g.Captured) != 0 {
+		t.Errorf("replay captured %d card payments", len(g.Captured))
+	}
+	for _, typ := range []string{"metro", "bus"} {
+		if got, w := m.Catalog.Stock(typ), want.Catalog.Stock(typ); got != w {
+			t.Errorf("%s stock %d, want %d", typ, got, w)
+		}
+	}
+}
+
+func TestReplayStopsWithoutOutcome(t *testing.T) {
+	_, journal := journaledSession(t)
+	var cut []string
+	for _, l := range strings.Split(string(journal), "\n") {
+		if !strings.Contains(l, `"call":"payment.authorize"`) {
+			cut = append(cut, l)
+		}
+	}
+	m, _ := newTestMachine(t)
+	m.QRProvider = &MockQRProvider{}
+	err := Replay(strings.NewReader(strings.Join(cut, "\n")), m)
+	if err == nil || !strings.Contains(err.Error(), "payment.authorize not journaled") {
+		t.Fatalf("err %v, want payment.authorize not journaled", err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import "time"
+
+// JourneyType is how a ticket may be travelled on.
+type JourneyType string
+
+const (
+	JourneySingle   JourneyType = "single"
+	JourneyReturn   JourneyType = "return"
+	JourneyTransfer JourneyType = "transfer"
+)
+
+// JourneyOption is a round-trip or transfer variant of a product. The
+// single journey at the product price is always offered.
+type JourneyOption struct {
+	Type  JourneyType
+	Price Money
+	// ReturnWithin is how long after issue the return leg may be used.
+	ReturnWithin time.Duration
+	// TransferWindow is how long after the first validation further
+	// boardings are free.
+	TransferWindow time.Duration
+}
+
+// Journey returns the option of type j, if the product offers it.
+func (p Product) Journey(j JourneyType) (JourneyOption, bool) {
+	for _, o := range p.Journeys {
+		if o.Type == j {
+			return o, true
+		}
+	}
+	return JourneyOption{}, false
+}
+
+// journeyTypes lists what the rider can choose for ticketType; it is empty
+// when the product has no journey options.
+func (m *TicketMachine) journeyTypes(ticketType string) []JourneyType {
+	p, _ := m.Catalog.Product(ticketType)
+	if len(p.Journeys) == 0 {
+		return nil
+	}
+	types := []JourneyType{JourneySingle}
+	for _, o := range p.Journeys {
+		types = append(types, o.Type)
+	}
+	return types
+}
+
+// SelectJourney chooses single, return or transfer for the selected
+// ticket and moves on to payment.
+func (m *TicketMachine) SelectJourney(j JourneyType) error {
+	if err := m.logAction(JournalEntry{Action: "journey", Journey: j}); err != nil {
+		return err
+	}
+	if _, ok := m.State.(*SelectJourneyState); !ok {
+		return newError(CodeInvalidState, "no journey to select")
+	}
+	l := &m.Cart[0]
+	p, _ := m.Catalog.Product(l.TicketType)
+	if _, ok := p.Journey(j); !ok && j != JourneySingle {
+		return newErrorf(CodeNotOffered, "%s journey not offered for %s", j, l.TicketType)
+	}
+	ctx := m.priceContext()
+	ctx.Journey = j
+	l.Journey = j
+	l.UnitPrice, _ = m.pricing().GetPrice(l.TicketType, ctx)
+	m.repriceCart()
+	m.show("Journey: %s (%s)", j, m.formatPrice(m.CurrentPrice))
+	if m.isSeated(l.TicketType) {
+		m.SetState(&SelectSeatState{})
+		return nil
+	}
+	m.SetState(&WaitingForMoneyState{})
+	return nil
+}
+
+// applyJourney sets the journey rules of a ticket issued for a line.
+// Passes have none.
+func (m *TicketMachine) applyJourney(t *Ticket, j JourneyType) {
+	if t.Kind == KindPass {
+		return
+	}
+	t.Journey, t.Legs = JourneySingle, 1
+	p, _ := m.Catalog.Product(t.Type)
+	o, ok := p.Journey(j)
+	if !ok {
+		return
+	}
+	t.Journey = j
+	switch j {
+	case JourneyReturn:
+		t.Legs = 2
+		if o.ReturnWithin > 0 {
+			t.ValidUntil = t.IssuedAt.Add(o.ReturnWithin)
+		}
+	case JourneyTransfer:
+		t.TransferWindow = o.TransferWindow
+	}
+}
+
+// SelectJourneyState asks whether the ticket is a single, return or
+// transfer journey.
+type SelectJourneyState struct{}
+
+func (s *SelectJourneyState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeStepRequired, "please select a journey")
+}
+func (s *SelectJourneyState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeStepRequired, "please select a journey first")
+}
+func (s *SelectJourneyState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeStepRequired, "please select a journey first")
+}
+func (s *SelectJourneyState) Cancel(m *TicketMachine) error {
+	m.SetState(&TransactionCanceledState{})
+	return nil
+}
+func (s *SelectJourneyState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeStepRequired, "please select a journey first")
+}
+func (s *SelectJourneyState) Name() string { return "SelectJourney" }
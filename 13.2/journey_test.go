@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelectJourney(t *testing.T) {
+	tests := []struct {
+		name     string
+		journey  JourneyType
+		price    Money
+		legs     int
+		validFor time.Duration
+		transfer time.Duration
+		code     ErrorCode
+	}{
+		{"single", JourneySingle, KZT(200), 1, 90 * time.Minute, 0, ""},
+		{"return", JourneyReturn, KZT(380), 2, 24 * time.Hour, 0, ""},
+		{"transfer", JourneyTransfer, KZT(300), 1, 90 * time.Minute, time.Hour, ""},
+		{"not offered", JourneyType("circle"), 0, 0, 0, 0, CodeNotOffered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			must(t, m.SelectTicket("tram", 1))
+			if m.GetCurrentState() != (&SelectJourneyState{}).Name() {
+				t.Fatalf("state = %s", m.GetCurrentState())
+			}
+			err := m.SelectJourney(tt.journey)
+			if CodeOf(err) != tt.code {
+				t.Fatalf("SelectJourney = %v, want %q", err, tt.code)
+			}
+			if tt.code != "" {
+				return
+			}
+			if m.CurrentPrice != tt.price {
+				t.Fatalf("price = %s, want %s", m.CurrentPrice, tt.price)
+			}
+			for m.InsertedMoney < tt.price {
+				must(t, m.InsertMoney(KZT(20)))
+			}
+			d, err := m.DispenseTicket()
+			must(t, err)
+			tk := d.Tickets[0]
+			if tk.Journey != tt.journey || tk.Legs != tt.legs || tk.TransferWindow != tt.transfer {
+				t.Fatalf("ticket journey %s, %d legs, transfer %s", tk.Journey, tk.Legs, tk.TransferWindow)
+			}
+			if got := tk.ValidUntil.Sub(tk.IssuedAt); got != tt.validFor {
+				t.Fatalf("valid for %s, want %s", got, tt.validFor)
+			}
+		})
+	}
+}
+
+func TestProductsWithoutJourneysSkipTheStep(t *testing.T) {
+	m, _ := newTestMachine(t)
+	must(t, m.SelectTicket("metro", 1))
+	if m.GetCurrentState() != (&WaitingForMoneyState{}).Name() {
+		t.Fatalf("state = %s", m.GetCurrentState())
+	}
+	if err := m.SelectJourney(JourneyReturn); CodeOf(err) != CodeInvalidState {
+		t.Fatalf("SelectJourney = %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// MachineEvent is what machine listeners receive: TicketSelected,
+// MoneyInserted, StateChanged, TicketDispensed, TransactionCanceled,
+// ProductSoldOut, HardwareFaulted or ConfigReloaded.
+type MachineEvent interface {
+	machineEvent()
+}
+
+// TicketSelected is sent when a selection is accepted.
+type TicketSelected struct {
+	TransactionID string `json:"transaction_id"`
+	TicketType    string `json:"ticket_type"`
+	Qty           int    `json:"qty"`
+	Price         Money  `json:"price"`
+}
+
+// MoneyInserted is sent for every coin or note accepted; Total is what
+// has been inserted in the transaction so far.
+type MoneyInserted struct {
+	TransactionID string `json:"transaction_id"`
+	Amount        Money  `json:"amount"`
+	Total         Money  `json:"total"`
+	// Foreign is set when the cash was in another currency; Amount is
+	// then its credited value.
+	Foreign *ForeignCash `json:"foreign,omitempty"`
+}
+
+// StateChanged is sent on every transition.
+type StateChanged struct {
+	TransactionID string `json:"transaction_id"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+}
+
+// TicketDispensed is sent when a sale is completed.
+type TicketDispensed struct {
+	TransactionID string   `json:"transaction_id"`
+	Tickets       []Ticket `json:"tickets"`
+	Change        Money    `json:"change"`
+}
+
+// TransactionCanceled is sent when the customer cancels or the sale times
+// out; Paid is the money inserted, returned or refunded.
+type TransactionCanceled struct {
+	TransactionID string `json:"transaction_id"`
+	Paid          Money  `json:"paid"`
+	TimedOut      bool   `json:"timed_out,omitempty"`
+}
+
+// ProductSoldOut is sent when the last ticket of a product is sold.
+type ProductSoldOut struct {
+	TransactionID string `json:"transaction_id"`
+	TicketType    string `json:"ticket_type"`
+}
+
+// HardwareFaulted is sent when a device failure takes the machine out of
+// service.
+type HardwareFaulted struct {
+	Component string `json:"component"`
+	Detail    string `json:"detail"`
+}
+
+// ConfigReloaded is sent when a Reload is applied, with what it changed.
+type ConfigReloaded struct {
+	ConfigChange
+}
+
+func (TicketSelected) machineEvent()      {}
+func (MoneyInserted) machineEvent()       {}
+func (StateChanged) machineEvent()        {}
+func (TicketDispensed) machineEvent()     {}
+func (TransactionCanceled) machineEvent() {}
+func (ProductSoldOut) machineEvent()      {}
+func (HardwareFaulted) machineEvent()     {}
+func (ConfigReloaded) machineEvent()      {}
+
+// Listener receives machine events, synchronously and in order.
+type Listener func(e MachineEvent)
+
+type subscription struct {
+	l Listener
+}
+
+// Subscribe adds a listener for every machine event; the returned func
+// removes it.
+func (m *TicketMachine) Subscribe(l Listener) (unsubscribe func()) {
+	sub := &subscription{l}
+	m.listeners = append(m.listeners, sub)
+	return func() {
+		for i, s := range m.listeners {
+			if s == sub {
+				m.listeners = append(m.listeners[:i:i], m.listeners[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (m *TicketMachine) emit(e MachineEvent) {
+	for _, s := range m.listeners {
+		s.l(e)
+	}
+}
+
+// newEventID identifies an event sent out of the machine, for consumers
+// that drop duplicates.
+func newEventID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "EV-" + hex.EncodeToString(b)
+}
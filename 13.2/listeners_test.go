@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// eventLog records the machine events a listener received, one line each.
+type eventLog []string
+
+func (l *eventLog) listen(e MachineEvent) {
+	switch ev := e.(type) {
+	case StateChanged:
+		*l = append(*l, fmt.Sprintf("state %s->%s", ev.From, ev.To))
+	case TicketSelected:
+		*l = append(*l, fmt.Sprintf("selected %d %s %s", ev.Qty, ev.TicketType, ev.Price))
+	case MoneyInserted:
+		*l = append(*l, fmt.Sprintf("inserted %s of %s", ev.Amount, ev.Total))
+	case TicketDispensed:
+		*l = append(*l, fmt.Sprintf("dispensed %d", len(ev.Tickets)))
+	case ProductSoldOut:
+		*l = append(*l, "sold out "+ev.TicketType)
+	case TransactionCanceled:
+		*l = append(*l, fmt.Sprintf("canceled %s", ev.Paid))
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	m, _ := newTestMachine(t)
+	must(t, m.Catalog.Adjust("metro", 1-m.Catalog.Stock("metro")))
+	var got eventLog
+	var txIDs []string
+	unsubscribe := m.Subscribe(got.listen)
+	m.Subscribe(func(e MachineEvent) {
+		if ev, ok := e.(StateChanged); ok {
+			txIDs = append(txIDs, ev.TransactionID)
+		}
+	})
+	sellMetro(t, m)
+	tx := m.TransactionID
+	want := eventLog{
+		"state Idle->WaitingForMoney",
+		"selected 1 metro " + KZT(300).String(),
+		"inserted " + KZT(200).String() + " of " + KZT(200).String(),
+		"state WaitingForMoney->MoneyReceived",
+		"inserted " + KZT(100).String() + " of " + KZT(300).String(),
+		"state MoneyReceived->FiscalizationPending",
+		"sold out metro",
+		"state FiscalizationPending->TicketDispensed",
+		"dispensed 1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events:\n%q\nwant:\n%q", got, want)
+	}
+	for _, id := range txIDs {
+		if id != tx {
+			t.Fatalf("transition of %s during %s", id, tx)
+		}
+	}
+
+	unsubscribe()
+	must(t, m.StartOver())
+	must(t, m.SelectTicket("bus", 1))
+	if len(got) != len(want) {
+		t.Fatalf("unsubscribed listener got %q", got[len(want):])
+	}
+	if len(txIDs) != 6 {
+		t.Fatalf("%d transitions seen by the remaining listener, want 6", len(txIDs))
+	}
+}
+
+func TestCancelEvent(t *testing.T) {
+	m, _ := newTestMachine(t)
+	var got eventLog
+	m.Subscribe(got.listen)
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.InsertMoney(KZT(200)))
+	must(t, m.Cancel())
+	if last := got[len(got)-1]; last != "canceled "+KZT(200).String() {
+		t.Fatalf("last event %q, events %q", last, got)
+	}
+}
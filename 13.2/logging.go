@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+)
+
+// WithLogHandler sends the machine's structured logs to h. By default
+// warnings are written as text to the machine output.
+func WithLogHandler(h slog.Handler) Option {
+	return func(o *machineOptions) { o.logHandler = h }
+}
+
+// log writes a structured record with the machine and station, the
+// current state and, during a sale, the transaction ID.
+func (m *TicketMachine) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	m.logTx(level, msg, m.saleID(), attrs...)
+}
+
+func (m *TicketMachine) logTx(level slog.Level, msg, txID string, attrs ...slog.Attr) {
+	l := m.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+	ctx := context.Background()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	base := []slog.Attr{slog.String("machine_id", m.MachineID)}
+	if m.Location.StationID != "" {
+		base = append(base, slog.String("station_id", m.Location.StationID))
+	}
+	if txID != "" {
+		base = append(base, slog.String("transaction_id", txID))
+	}
+	if m.State != nil {
+		base = append(base, slog.String("state", m.State.Name()))
+	}
+	l.LogAttrs(ctx, level, msg, append(base, attrs...)...)
+}
+
+// saleID is the ID of the transaction under way, empty between
+// transactions. The ID is drawn when a purchase begins and stays with the
+// sale until the machine is ready again.
+func (m *TicketMachine) saleID() string {
+	if inSale(m.State) {
+		return m.TransactionID
+	}
+	return ""
+}
+
+func inSale(s State) bool {
+	switch s.(type) {
+	case nil, *IdleState, *CashBoxFullState, *OutOfServiceState, *MaintenanceState, *CoinJamState,
+		*LockedState, *AdminState, *RestockingState, *CardPresentedState:
+		return false
+	}
+	return true
+}
+
+func (m *TicketMachine) logActionEntry(e JournalEntry) {
+	attrs := []slog.Attr{slog.String("action", e.Action)}
+	if e.TicketType != "" {
+		attrs = append(attrs, slog.String("ticket_type", e.TicketType), slog.Int("qty", e.Qty))
+	}
+	if e.Amount != 0 {
+		attrs = append(attrs, moneyAttr("amount", e.Amount))
+	}
+	if e.Card != nil {
+		attrs = append(attrs, slog.String("card", e.Card.MaskedPAN))
+	}
+	m.log(slog.LevelInfo, "action", attrs...)
+}
+
+func (m *TicketMachine) logSale(rec TransactionRecord) {
+	attrs := []slog.Attr{slog.String("product", rec.Product), slog.Int("quantity", rec.Quantity),
+		moneyAttr("price", rec.Price), moneyAttr("change", rec.Change)}
+	tenders := make([]string, 0, len(rec.Tenders))
+	for t := range rec.Tenders {
+		tenders = append(tenders, string(t))
+	}
+	sort.Strings(tenders)
+	var paid []interface{}
+	for _, t := range tenders {
+		paid = append(paid, moneyAttr(t, rec.Tenders[Tender(t)]))
+	}
+	attrs = append(attrs, slog.Group("tenders", paid...))
+	m.log(slog.LevelInfo, "sale", attrs...)
+}
+
+func moneyAttr(key string, v Money) slog.Attr {
+	return slog.String(key, v.String())
+}
+
+// warn logs a problem that does not stop the current operation.
+func (m *TicketMachine) warn(format string, args ...interface{}) {
+	m.log(slog.LevelWarn, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func decodeLogs(t *testing.T, raw *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var recs []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(raw.String()), "\n") {
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestStructuredLogs(t *testing.T) {
+	var raw bytes.Buffer
+	m, _ := newTestMachine(t)
+	m.Logger = slog.New(slog.NewJSONHandler(&raw, nil))
+	m.MachineID = "TM-9"
+	sellMetro(t, m)
+	tx := m.TransactionID
+	must(t, m.StartOver())
+
+	var sale, selected, toIdle map[string]interface{}
+	for _, rec := range decodeLogs(t, &raw) {
+		if rec["machine_id"] != "TM-9" || rec["station_id"] != m.Location.StationID {
+			t.Fatalf("record without the machine: %v", rec)
+		}
+		switch {
+		case rec["msg"] == "sale":
+			sale = rec
+		case rec["msg"] == "action" && rec["action"] == "select":
+			selected = rec
+		case rec["msg"] == "transition" && rec["to"] == "Idle":
+			toIdle = rec
+		}
+	}
+	if sale == nil || sale["transaction_id"] != tx || sale["product"] != "metro" || sale["price"] != KZT(300).String() {
+		t.Fatalf("sale record = %v", sale)
+	}
+	if tenders, _ := sale["tenders"].(map[string]interface{}); tenders["cash"] != KZT(300).String() {
+		t.Fatalf("sale tenders = %v", sale["tenders"])
+	}
+	if selected == nil || selected["ticket_type"] != "metro" || selected["state"] != "Idle" {
+		t.Fatalf("select record = %v", selected)
+	}
+	if toIdle == nil || toIdle["transaction_id"] != tx {
+		t.Fatalf("closing transition = %v, want it tied to %s", toIdle, tx)
+	}
+}
+
+func TestDefaultLogHandlerWarnsOnly(t *testing.T) {
+	var out bytes.Buffer
+	m := NewTicketMachine(WithOutput(&out))
+	m.log(slog.LevelInfo, "quiet")
+	m.warn("loud %d", 1)
+	if strings.Contains(out.String(), "quiet") || !strings.Contains(out.String(), "loud 1") {
+		t.Fatalf("output = %q", out.String())
+	}
+}
+
+func TestTransactionCorrelation(t *testing.T) {
+	m, _ := newTestMachine(t)
+	n := &recordingNotifier{}
+	m.Notifier = n
+	qr := &MockQRProvider{}
+	m.QRProvider = qr
+	jammed := &MockTicketPrinter{W: io.Discard, Err: errors.New("paper jam")}
+	m.Printer = jammed
+	must(t, m.Catalog.Adjust("metro", -7))
+
+	must(t, m.SelectTicket("metro", 1))
+	tx := m.TransactionID
+	p, err := m.PayByQR()
+	must(t, err)
+	if !strings.HasSuffix(p.Payload, "&order="+tx) {
+		t.Fatalf("QR payload %q does not reference %s", p.Payload, tx)
+	}
+	qr.MarkPaid(p.ID)
+	must(t, m.PollQRPayment())
+	if _, err := m.DispenseTicket(); err == nil {
+		t.Fatal("dispensed with a jammed printer")
+	}
+	jammed.Err = nil
+	d, err := m.DispenseTicket()
+	must(t, err)
+	if d.Tickets[0].TransactionID != tx || m.Transactions[0].ID != tx {
+		t.Fatalf("ticket %s and sale %s, want %s", d.Tickets[0].TransactionID, m.Transactions[0].ID, tx)
+	}
+	var failed *AuditEntry
+	for i, e := range m.AuditLog {
+		if e.Action == "print_failed" {
+			failed = &m.AuditLog[i]
+		}
+	}
+	if failed == nil || failed.TransactionID != tx {
+		t.Fatalf("print failure audit entry = %+v", failed)
+	}
+	if len(n.alerts) == 0 || n.alerts[0].Kind != AlertLowStock || n.alerts[0].TransactionID != tx {
+		t.Fatalf("alerts = %+v", n.alerts)
+	}
+
+	must(t, m.StartOver())
+	must(t, m.BeginRestock("clerk", "1111"))
+	if e := m.AuditLog[len(m.AuditLog)-1]; e.TransactionID != "" {
+		t.Fatalf("restock audited under transaction %s", e.TransactionID)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// machineLoop is the queue of work for the machine goroutine.
+type machineLoop struct {
+	mu      sync.Mutex
+	queue   []func()
+	running bool
+	wake    chan struct{}
+}
+
+// post queues f for the machine goroutine; it reports false, and does
+// not queue f, when Run is not running.
+func (l *machineLoop) post(f func()) bool {
+	l.mu.Lock()
+	if !l.running {
+		l.mu.Unlock()
+		return false
+	}
+	l.queue = append(l.queue, f)
+	l.mu.Unlock()
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (l *machineLoop) next() func() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.queue) == 0 {
+		return nil
+	}
+	f := l.queue[0]
+	l.queue = l.queue[1:]
+	return f
+}
+
+// Run makes the calling goroutine the machine goroutine until ctx is
+// done. The machine is not safe for concurrent use: while Run runs,
+// hardware events published from device goroutines are handled here, one
+// at a time, Tick runs every tick, and the host calls the machine through
+// Do. Work queued when ctx is done is still run.
+func (m *TicketMachine) Run(ctx context.Context, tick time.Duration) {
+	l := m.machineLoop()
+	l.mu.Lock()
+	l.running = true
+	l.mu.Unlock()
+	m.bus().RunOn(func(f func()) {
+		if !l.post(f) {
+			f()
+		}
+	})
+	defer func() {
+		m.bus().RunOn(nil)
+		l.mu.Lock()
+		l.running = false
+		l.mu.Unlock()
+		for f := l.next(); f != nil; f = l.next() {
+			f()
+		}
+	}()
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Tick()
+		case <-l.wake:
+			for f := l.next(); f != nil; f = l.next() {
+				f()
+			}
+		}
+	}
+}
+
+// Do runs f on the machine goroutine and waits for it. It fails when Run
+// is not running, and must not be called from the machine goroutine,
+// e.g. from a listener.
+func (m *TicketMachine) Do(f func(m *TicketMachine) error) error {
+	done := make(chan error, 1)
+	if !m.machineLoop().post(func() { done <- f(m) }) {
+		return newError(CodeInvalidState, "machine is not running")
+	}
+	return <-done
+}
+
+func (m *TicketMachine) machineLoop() *machineLoop {
+	m.loopOnce.Do(func() { m.loop = &machineLoop{wake: make(chan struct{}, 1)} })
+	return m.loop
+}
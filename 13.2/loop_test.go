@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunSerializesDeviceEvents(t *testing.T) {
+	m, _ := newTestMachine(t)
+	if err := m.Do(func(m *TicketMachine) error { return nil }); err == nil {
+		t.Fatal("Do ran without Run")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		m.Run(ctx, time.Hour)
+		close(stopped)
+	}()
+	for m.Do(func(m *TicketMachine) error { return m.SelectTicket("month_pass", 1) }) != nil {
+		time.Sleep(time.Millisecond)
+	}
+	var devices sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		devices.Add(1)
+		go func() {
+			defer devices.Done()
+			for j := 0; j < 10; j++ {
+				m.publish(HardwareEvent{Kind: EventCoinInserted, Device: "coin_acceptor", Amount: KZT(10)})
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		must(t, m.Do(func(m *TicketMachine) error { m.Outstanding(); return nil }))
+	}
+	devices.Wait()
+	var inserted Money
+	must(t, m.Do(func(m *TicketMachine) error { inserted = m.InsertedMoney; return nil }))
+	cancel()
+	<-stopped
+	if inserted != KZT(400) {
+		t.Errorf("inserted %s, want 400.00", inserted)
+	}
+}
@@ -0,0 +1,230 @@
+package hwsim
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoEscrow is returned by Stack and Return with no note in escrow.
+var ErrNoEscrow = errors.New("no note in escrow")
+
+// Acceptor is a device that reports events of type E, such as coins
+// inserted or cards presented, while enabled; ops "enable", "disable" and
+// "self_test".
+type Acceptor[E any] struct {
+	Device
+	Enabled bool
+	events  chan E
+}
+
+// NewAcceptor returns a disabled acceptor holding up to buffer events
+// the machine has not read yet.
+func NewAcceptor[E any](buffer int) *Acceptor[E] {
+	return &Acceptor[E]{events: make(chan E, buffer)}
+}
+
+func (a *Acceptor[E]) Enable() error {
+	if err := a.Call("enable"); err != nil {
+		return err
+	}
+	a.Enabled = true
+	return nil
+}
+
+func (a *Acceptor[E]) Disable() error {
+	if err := a.Call("disable"); err != nil {
+		return err
+	}
+	a.Enabled = false
+	return nil
+}
+
+func (a *Acceptor[E]) Events() <-chan E { return a.events }
+
+func (a *Acceptor[E]) SelfTest() error { return a.Call("self_test") }
+
+// Send reports ev, as a coin dropped in or a card held to the reader. It
+// is refused while the acceptor is disabled or its events are unread.
+func (a *Acceptor[E]) Send(ev E) bool {
+	if !a.Enabled {
+		return false
+	}
+	return a.Fault(ev)
+}
+
+// Fault reports ev whether or not the acceptor is enabled, as hardware
+// reports a jam.
+func (a *Acceptor[E]) Fault(ev E) bool {
+	select {
+	case a.events <- ev:
+		return true
+	default:
+		return false
+	}
+}
+
+// Validator is a bill validator holding one note of type E in escrow
+// until the machine stacks or returns it; ops as Acceptor, plus "stack"
+// and "return".
+type Validator[E any] struct {
+	*Acceptor[E]
+	Stacked  []E
+	Returned []E
+	escrow   E
+	held     bool
+}
+
+func NewValidator[E any]() *Validator[E] {
+	return &Validator[E]{Acceptor: NewAcceptor[E](1)}
+}
+
+// Insert feeds a note in. It is refused while the validator is disabled
+// or its escrow is occupied.
+func (v *Validator[E]) Insert(note E) bool {
+	if v.held || !v.Send(note) {
+		return false
+	}
+	v.escrow, v.held = note, true
+	return true
+}
+
+// InEscrow returns the note held in escrow, if any.
+func (v *Validator[E]) InEscrow() (E, bool) { return v.escrow, v.held }
+
+func (v *Validator[E]) Stack() error {
+	if err := v.Call("stack"); err != nil {
+		return err
+	}
+	note, err := v.release()
+	if err == nil {
+		v.Stacked = append(v.Stacked, note)
+	}
+	return err
+}
+
+func (v *Validator[E]) Return() error {
+	if err := v.Call("return"); err != nil {
+		return err
+	}
+	note, err := v.release()
+	if err == nil {
+		v.Returned = append(v.Returned, note)
+	}
+	return err
+}
+
+func (v *Validator[E]) release() (E, error) {
+	var none E
+	if !v.held {
+		return none, ErrNoEscrow
+	}
+	note := v.escrow
+	v.escrow, v.held = none, false
+	return note, nil
+}
+
+// Printer keeps what it prints of type T instead of writing it out; op
+// "print".
+type Printer[T any] struct {
+	Device
+	Printed []T
+	Text    []string
+}
+
+func (p *Printer[T]) Print(t T, text string) error {
+	if err := p.Call("print"); err != nil {
+		return err
+	}
+	p.Printed = append(p.Printed, t)
+	p.Text = append(p.Text, text)
+	return nil
+}
+
+func (p *Printer[T]) SelfTest() error { return p.Call("self_test") }
+
+// ReceiptPrinter keeps the receipts of type R it prints; op "print".
+type ReceiptPrinter[R any] struct {
+	Printer[R]
+}
+
+func (p *ReceiptPrinter[R]) PrintReceipt(r R, text string) error { return p.Print(r, text) }
+
+// ChangeDispenser pays out plans of L, e.g. denomination and count, for
+// amounts of M; op "dispense". A fault injected with FailNext pays out
+// nothing, whatever partial payout the error reports.
+type ChangeDispenser[M, L any] struct {
+	Device
+	Dispensed []L
+}
+
+func (d *ChangeDispenser[M, L]) DispenseAmount(amount M, plan []L) error {
+	if err := d.Call("dispense"); err != nil {
+		return err
+	}
+	d.Dispensed = append(d.Dispensed, plan...)
+	return nil
+}
+
+func (d *ChangeDispenser[M, L]) SelfTest() error { return d.Call("self_test") }
+
+// Display records the screens shown, one line each: prices of S and
+// balances of B through FormatPrice and FormatBalance, or with %v when
+// they are nil. Op "show" is only delayed; a display cannot fail.
+type Display[S, B any] struct {
+	Device
+	Lines         []string
+	FormatPrice   func(S) string
+	FormatBalance func(B) string
+}
+
+func (d *Display[S, B]) record(line string) {
+	d.Call("show")
+	d.Lines = append(d.Lines, line)
+}
+
+func (d *Display[S, B]) ShowPrice(s S) {
+	if d.FormatPrice != nil {
+		d.record(d.FormatPrice(s))
+		return
+	}
+	d.record(fmt.Sprintf("price %v", s))
+}
+
+func (d *Display[S, B]) ShowBalance(b B) {
+	if d.FormatBalance != nil {
+		d.record(d.FormatBalance(b))
+		return
+	}
+	d.record(fmt.Sprintf("balance %v", b))
+}
+
+func (d *Display[S, B]) ShowError(err error)     { d.record("error " + err.Error()) }
+func (d *Display[S, B]) ShowIdle(welcome string) { d.record("idle " + welcome) }
+func (d *Display[S, B]) ShowMessage(text string) { d.record(text) }
+
+// Shown reports whether line was shown.
+func (d *Display[S, B]) Shown(line string) bool {
+	for _, l := range d.Lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+// Reader reads Card, of type C, from a contactless card held to it; op
+// "read".
+type Reader[C any] struct {
+	Device
+	Card C
+}
+
+func (r *Reader[C]) ReadCard() (C, error) {
+	if err := r.Call("read"); err != nil {
+		var none C
+		return none, err
+	}
+	return r.Card, nil
+}
+
+func (r *Reader[C]) SelfTest() error { return r.Call("self_test") }
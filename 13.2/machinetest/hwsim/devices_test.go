@@ -0,0 +1,103 @@
+package hwsim
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAcceptor(t *testing.T) {
+	a := NewAcceptor[int](2)
+	if a.Send(1) {
+		t.Fatal("disabled acceptor took an event")
+	}
+	a.FailNext("enable", errors.New("no power"), 1)
+	if err := a.Enable(); err == nil || a.Enabled {
+		t.Fatalf("enable: %v, enabled %t, want a fault", err, a.Enabled)
+	}
+	if err := a.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Send(1) || !a.Send(2) || a.Send(3) {
+		t.Error("want two events buffered and the third refused")
+	}
+	if err := a.Disable(); err != nil {
+		t.Fatal(err)
+	}
+	<-a.Events()
+	if !a.Fault(4) {
+		t.Error("fault not reported while disabled")
+	}
+	if got := []int{<-a.Events(), <-a.Events()}; !reflect.DeepEqual(got, []int{2, 4}) {
+		t.Errorf("events %v, want [2 4]", got)
+	}
+}
+
+func TestValidatorEscrow(t *testing.T) {
+	v := NewValidator[string]()
+	if err := v.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	if !v.Insert("500") || v.Insert("1000") {
+		t.Fatal("want one note held in escrow and the next refused")
+	}
+	<-v.Events()
+	if note, ok := v.InEscrow(); !ok || note != "500" {
+		t.Errorf("escrow %q, %t", note, ok)
+	}
+	if err := v.Stack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Return(); err != ErrNoEscrow {
+		t.Errorf("return with escrow empty: %v, want %v", err, ErrNoEscrow)
+	}
+	v.Insert("1000")
+	v.FailNext("return", errors.New("motor stalled"), 1)
+	if err := v.Return(); err == nil {
+		t.Fatal("return fault not injected")
+	}
+	if err := v.Return(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v.Stacked, []string{"500"}) || !reflect.DeepEqual(v.Returned, []string{"1000"}) {
+		t.Errorf("stacked %v, returned %v", v.Stacked, v.Returned)
+	}
+}
+
+func TestPrintersAndDispenser(t *testing.T) {
+	var p ReceiptPrinter[string]
+	p.FailNext("print", errors.New("paper jam"), 1)
+	if err := p.PrintReceipt("TX-1", "receipt"); err == nil {
+		t.Fatal("print fault not injected")
+	}
+	if err := p.PrintReceipt("TX-1", "receipt"); err != nil || !reflect.DeepEqual(p.Printed, []string{"TX-1"}) {
+		t.Errorf("printed %v: %v", p.Printed, err)
+	}
+	var d ChangeDispenser[int, string]
+	d.FailNext("dispense", errors.New("jam"), 1)
+	d.DispenseAmount(150, []string{"100", "50"})
+	if err := d.DispenseAmount(150, []string{"100", "50"}); err != nil || len(d.Dispensed) != 2 {
+		t.Errorf("dispensed %v: %v", d.Dispensed, err)
+	}
+}
+
+func TestDisplayAndReader(t *testing.T) {
+	d := Display[int, string]{FormatPrice: func(n int) string { return "costs " + string(rune('0'+n)) }}
+	d.ShowPrice(3)
+	d.ShowBalance("half")
+	d.ShowError(errors.New("sold out"))
+	if want := []string{"costs 3", "balance half", "error sold out"}; !reflect.DeepEqual(d.Lines, want) {
+		t.Errorf("lines %q, want %q", d.Lines, want)
+	}
+	if !d.Shown("balance half") || d.Shown("idle") {
+		t.Error("Shown does not match the lines")
+	}
+	r := Reader[string]{Card: "**** 4242"}
+	r.FailNext("read", errors.New("no card"), 1)
+	if _, err := r.ReadCard(); err == nil {
+		t.Fatal("read fault not injected")
+	}
+	if card, err := r.ReadCard(); err != nil || card != "**** 4242" {
+		t.Errorf("read %q: %v", card, err)
+	}
+}
@@ -0,0 +1,55 @@
+// Package hwsim provides scriptable fakes of the ticket machine hardware
+// for development and tests: acceptors, a bill validator with escrow,
+// printers, a change dispenser, a customer display and a card reader.
+// Every device operation can be delayed and made to fail. The fakes are
+// generic over the machine's event and value types, so they satisfy its
+// device interfaces without this package depending on the machine.
+package hwsim
+
+import "time"
+
+// Device records the operations called on a simulated device and plays
+// back the delays and faults scripted for them.
+type Device struct {
+	// Sleep waits out delays; time.Sleep when nil.
+	Sleep  func(time.Duration)
+	Calls  []string
+	delays map[string]time.Duration
+	faults map[string][]error
+}
+
+// Delay makes every later call of op take dur.
+func (d *Device) Delay(op string, dur time.Duration) {
+	if d.delays == nil {
+		d.delays = map[string]time.Duration{}
+	}
+	d.delays[op] = dur
+}
+
+// FailNext makes the next n calls of op fail with err.
+func (d *Device) FailNext(op string, err error, n int) {
+	if d.faults == nil {
+		d.faults = map[string][]error{}
+	}
+	for i := 0; i < n; i++ {
+		d.faults[op] = append(d.faults[op], err)
+	}
+}
+
+// Call records a call of op, waits out its delay and returns the next
+// fault scripted for it.
+func (d *Device) Call(op string) error {
+	d.Calls = append(d.Calls, op)
+	if dur := d.delays[op]; dur > 0 {
+		sleep := d.Sleep
+		if sleep == nil {
+			sleep = time.Sleep
+		}
+		sleep(dur)
+	}
+	if q := d.faults[op]; len(q) > 0 {
+		d.faults[op] = q[1:]
+		return q[0]
+	}
+	return nil
+}
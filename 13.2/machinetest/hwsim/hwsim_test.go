@@ -0,0 +1,39 @@
+package hwsim
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeviceFailNext(t *testing.T) {
+	var d Device
+	jam := errors.New("jam")
+	d.FailNext("print", jam, 2)
+	var got []error
+	for i := 0; i < 3; i++ {
+		got = append(got, d.Call("print"))
+	}
+	if want := []error{jam, jam, nil}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls returned %v, want %v", got, want)
+	}
+	if err := d.Call("self_test"); err != nil {
+		t.Errorf("self_test: %v, want no fault", err)
+	}
+	if want := []string{"print", "print", "print", "self_test"}; !reflect.DeepEqual(d.Calls, want) {
+		t.Errorf("calls %v, want %v", d.Calls, want)
+	}
+}
+
+func TestDeviceDelay(t *testing.T) {
+	var slept []time.Duration
+	d := Device{Sleep: func(dur time.Duration) { slept = append(slept, dur) }}
+	d.Delay("dispense", 2*time.Second)
+	d.Call("dispense")
+	d.Call("self_test")
+	d.Call("dispense")
+	if want := []time.Duration{2 * time.Second, 2 * time.Second}; !reflect.DeepEqual(slept, want) {
+		t.Errorf("slept %v, want %v", slept, want)
+	}
+}
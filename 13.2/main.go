@@ -1,93 +1,198 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 // States
 type State interface {
-	SelectTicket(m *TicketMachine, ticketType string) error
-	InsertMoney(m *TicketMachine, amount float64) error
+	SelectTicket(m *TicketMachine, ticketType string, qty int) error
+	InsertMoney(m *TicketMachine, amount Money) error
+	PayByCard(m *TicketMachine, card CardDetails) error
 	Cancel(m *TicketMachine) error
-	DispenseTicket(m *TicketMachine) error
+	DispenseTicket(m *TicketMachine) (Dispensed, error)
 	Name() string
 }
 
+// Dispensed is what the customer takes out of the machine at the end of a
+// transaction. Tickets is empty for transactions that issue no ticket, such
+// as transit card top-ups.
+type Dispensed struct {
+	Tickets []Ticket
+	Change  Change
+}
+
+// Change is the money returned to the customer when they overpay.
+// Voucher is set when the dispenser failed to pay out all of Amount.
+type Change struct {
+	Amount  Money
+	Coins   []TallyLine
+	Voucher *ChangeVoucher
+}
+
 type IdleState struct{}
 
-func (s *IdleState) SelectTicket(m *TicketMachine, ticketType string) error {
-	if !m.HasTicket(ticketType) {
-		return errors.New("ticket unavailable")
+func (s *IdleState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	if err := m.beginTransaction(ticketType, qty); err != nil {
+		return err
+	}
+	if m.isZoned(ticketType) {
+		m.SetState(&SelectDestinationState{})
+		m.display().ShowPrice(Selection{TicketType: ticketType, Prompt: fmt.Sprintf("Please select destination zone %v", m.ZoneFares.Zones())})
+		return nil
+	}
+	if j := m.journeyTypes(ticketType); len(j) > 0 {
+		m.SetState(&SelectJourneyState{})
+		m.display().ShowPrice(Selection{TicketType: ticketType, Prompt: fmt.Sprintf("Please select journey %v", j)})
+		return nil
+	}
+	if m.isSeated(ticketType) {
+		m.SetState(&SelectSeatState{})
+		m.display().ShowPrice(Selection{TicketType: ticketType, Price: m.formatPrice(m.CurrentPrice), Prompt: "Please select a seat or skip"})
+		return nil
 	}
-	m.CurrentTicket = ticketType
-	m.CurrentPrice = m.GetTicketPrice(ticketType)
 	m.SetState(&WaitingForMoneyState{})
-	fmt.Printf("Ticket selected: %s (%.2f KZT)\n", ticketType, m.CurrentPrice)
+	m.display().ShowPrice(Selection{TicketType: ticketType, Qty: qty, Price: m.formatPrice(m.CurrentPrice)})
+	if m.ExactChangeRequired() {
+		m.show("Exact change only. Please insert exactly %v", m.CurrentPrice.In(m.Currency))
+	}
+	if !m.CardPaymentsAvailable() {
+		m.show("Card payments unavailable. Cash only.")
+	}
 	return nil
 }
 
-func (s *IdleState) InsertMoney(m *TicketMachine, amount float64) error {
-	return errors.New("please select a ticket first")
+func (s *IdleState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeNoTicketSelected, "please select a ticket first")
+}
+func (s *IdleState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeNoTicketSelected, "please select a ticket first")
 }
 func (s *IdleState) Cancel(m *TicketMachine) error {
-	return errors.New("no active transaction")
+	return newError(CodeNoTransaction, "no active transaction")
 }
-func (s *IdleState) DispenseTicket(m *TicketMachine) error {
-	return errors.New("no paid ticket")
+func (s *IdleState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeNotPaid, "no paid ticket")
 }
 func (s *IdleState) Name() string { return "Idle" }
 
 type WaitingForMoneyState struct{}
 
-func (s *WaitingForMoneyState) SelectTicket(m *TicketMachine, ticketType string) error {
-	return errors.New("ticket already selected")
+func (s *WaitingForMoneyState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeAlreadySelected, "ticket already selected")
 }
 
-func (s *WaitingForMoneyState) InsertMoney(m *TicketMachine, amount float64) error {
+func (s *WaitingForMoneyState) InsertMoney(m *TicketMachine, amount Money) error {
+	if err := m.admitCash(amount); err != nil {
+		return err
+	}
 	m.InsertedMoney += amount
-	fmt.Printf("Inserted: %.2f KZT (Total: %.2f)\n", amount, m.InsertedMoney)
-	if m.InsertedMoney >= m.CurrentPrice {
+	if m.PaidTotal() >= m.CurrentPrice {
+		m.Overpayment = m.PaidTotal() - m.CurrentPrice
 		m.SetState(&MoneyReceivedState{})
-		fmt.Println("Sufficient funds. Ready to dispense ticket.")
 	}
+	m.display().ShowBalance(Balance{Inserted: amount, Total: m.PaidTotal(), Price: m.CurrentPrice, Currency: m.Currency})
 	return nil
 }
 
+func (s *WaitingForMoneyState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return m.authorizeCard(card)
+}
+
 func (s *WaitingForMoneyState) Cancel(m *TicketMachine) error {
+	m.returnCash()
 	m.SetState(&TransactionCanceledState{})
 	return nil
 }
 
-func (s *WaitingForMoneyState) DispenseTicket(m *TicketMachine) error {
-	return errors.New("insufficient funds")
+func (s *WaitingForMoneyState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	// Nothing to pay, e.g. a free ticket once the fare cap is reached.
+	if m.CurrentPrice == 0 && m.TopUp == nil {
+		m.SetState(&MoneyReceivedState{})
+		return m.State.DispenseTicket(m)
+	}
+	return Dispensed{}, newError(CodeInsufficientFunds, "insufficient funds")
 }
 func (s *WaitingForMoneyState) Name() string { return "WaitingForMoney" }
 
 type MoneyReceivedState struct{}
 
-func (s *MoneyReceivedState) SelectTicket(m *TicketMachine, ticketType string) error {
-	return errors.New("ticket already selected")
+func (s *MoneyReceivedState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeAlreadySelected, "ticket already selected")
 }
 
-func (s *MoneyReceivedState) InsertMoney(m *TicketMachine, amount float64) error {
+func (s *MoneyReceivedState) InsertMoney(m *TicketMachine, amount Money) error {
+	if err := m.admitCash(amount); err != nil {
+		return err
+	}
 	m.InsertedMoney += amount
-	fmt.Printf("Additional funds inserted: %.2f KZT\n", amount)
+	m.Overpayment = m.PaidTotal() - m.CurrentPrice
+	m.display().ShowBalance(Balance{Inserted: amount, Total: m.PaidTotal(), Price: m.CurrentPrice, Currency: m.Currency, Additional: true})
 	return nil
 }
 
+func (s *MoneyReceivedState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeAlreadyPaid, "already paid")
+}
+
 func (s *MoneyReceivedState) Cancel(m *TicketMachine) error {
+	m.returnCash()
+	if err := m.refundQR(); err != nil {
+		return err
+	}
+	if m.CardAuth != nil {
+		return m.voidCard()
+	}
 	m.SetState(&TransactionCanceledState{})
 	return nil
 }
 
-func (s *MoneyReceivedState) DispenseTicket(m *TicketMachine) error {
-	m.SetState(&TicketDispensedState{})
-	m.Inventory[m.CurrentTicket]--
-	m.InsertedMoney = 0
+func (s *MoneyReceivedState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	if m.TopUp != nil {
+		return m.completeTopUp()
+	}
+	if m.Paper.out() && m.Delivery == nil {
+		return Dispensed{}, newError(CodeOutOfPaper, "out of ticket paper, please choose e-ticket delivery")
+	}
+	if err := m.checkChange(); err != nil {
+		return Dispensed{}, err
+	}
+	change, err := m.settle()
+	if err != nil {
+		return Dispensed{}, err
+	}
+	m.fiscalize()
+	m.redeemPromo()
+	m.recordCapSpend()
+	var tickets []Ticket
+	for _, l := range m.Cart {
+		tickets = append(tickets, m.issueLine(l)...)
+		m.takeStock(l.TicketType, l.Qty)
+	}
+	m.Transactions[len(m.Transactions)-1].Tickets = tickets
+	m.deliver(&m.Transactions[len(m.Transactions)-1])
 	m.CurrentTicket = ""
-	fmt.Println("Ticket dispensed!")
-	return nil
+	m.Cart = nil
+	d := Dispensed{Tickets: tickets, Change: change}
+	if pending, err := m.printTickets(tickets); err != nil {
+		return Dispensed{}, m.printFailed(d, pending, err)
+	}
+	m.completeSale(&d)
+	return d, nil
 }
 func (s *MoneyReceivedState) Name() string { return "MoneyReceived" }
 
@@ -95,107 +200,1710 @@ type TicketDispensedState struct{}
 
 func (s *TicketDispensedState) handle() {}
 
-func (s *TicketDispensedState) SelectTicket(m *TicketMachine, ticketType string) error {
-	return errors.New("please take your ticket and start over")
+func (s *TicketDispensedState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeCollectPending, "please take your ticket and start over")
+}
+func (s *TicketDispensedState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeCollectPending, "please take your ticket")
 }
-func (s *TicketDispensedState) InsertMoney(m *TicketMachine, amount float64) error {
-	return errors.New("please take your ticket")
+func (s *TicketDispensedState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeCollectPending, "please take your ticket")
 }
 func (s *TicketDispensedState) Cancel(m *TicketMachine) error {
-	return errors.New("transaction complete")
+	return newError(CodeTransactionComplete, "transaction complete")
 }
-func (s *TicketDispensedState) DispenseTicket(m *TicketMachine) error {
-	return errors.New("ticket already dispensed")
+func (s *TicketDispensedState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeTransactionComplete, "ticket already dispensed")
 }
 func (s *TicketDispensedState) Name() string { return "TicketDispensed" }
 
+// ChangeDispensedState is entered instead of TicketDispensedState when the
+// customer overpaid and change was handed out together with the ticket.
+type ChangeDispensedState struct {
+	Change Change
+}
+
+func (s *ChangeDispensedState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeCollectPending, "please take your ticket and change and start over")
+}
+func (s *ChangeDispensedState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeCollectPending, "please take your change")
+}
+func (s *ChangeDispensedState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeCollectPending, "please take your change")
+}
+func (s *ChangeDispensedState) Cancel(m *TicketMachine) error {
+	return newError(CodeTransactionComplete, "transaction complete")
+}
+func (s *ChangeDispensedState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeTransactionComplete, "ticket already dispensed")
+}
+func (s *ChangeDispensedState) Name() string { return "ChangeDispensed" }
+
 type TransactionCanceledState struct{}
 
 func (s *TransactionCanceledState) handle() {}
 
-func (s *TransactionCanceledState) SelectTicket(m *TicketMachine, ticketType string) error {
-	return errors.New("transaction canceled. Please start over")
+func (s *TransactionCanceledState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeTransactionCanceled, "transaction canceled. Please start over")
+}
+func (s *TransactionCanceledState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeTransactionCanceled, "transaction canceled")
 }
-func (s *TransactionCanceledState) InsertMoney(m *TicketMachine, amount float64) error {
-	return errors.New("transaction canceled")
+func (s *TransactionCanceledState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeTransactionCanceled, "transaction canceled")
 }
 func (s *TransactionCanceledState) Cancel(m *TicketMachine) error {
-	return errors.New("already canceled")
+	return newError(CodeTransactionCanceled, "already canceled")
 }
-func (s *TransactionCanceledState) DispenseTicket(m *TicketMachine) error {
-	return errors.New("no ticket")
+func (s *TransactionCanceledState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeNotPaid, "no ticket")
 }
 func (s *TransactionCanceledState) Name() string { return "TransactionCanceled" }
 
 // Machine
 
 type TicketMachine struct {
-	State         State
+	// Display shows prompts and errors to the customer.
+	Display Display
+	// Out receives operator and console messages, stdout when nil.
+	Out io.Writer
+	// Tracing, when set, traces transactions; see WithTracerProvider.
+	Tracing TracerProvider
+	// Metrics, when set, counts sales, failures and state occupancy; see
+	// EnableMetrics.
+	Metrics *Metrics
+	// Logger receives structured logs of every action, transition, sale
+	// and audit entry; slog.Default when nil.
+	Logger *slog.Logger
+	// Bus carries the events of the hardware drivers to the machine.
+	Bus *EventBus
+	// Audio, when set, voices the customer flow with AudioPrompts in
+	// AudioLocale.
+	Audio        AudioSink
+	AudioPrompts map[string]map[AudioEvent]AudioPrompt
+	AudioLocale  string
+	// Accessible is set for a rider in accessibility mode; TTS speaks
+	// their prompts and AccessibleTimeoutFactor lengthens their timeouts.
+	Accessible              bool
+	TTS                     TextToSpeech
+	AccessibleTimeoutFactor int
+	// MachineID and Location are stamped on every ticket, record, event
+	// and log line of the machine.
+	MachineID string
+	Location  Location
+	Hardware  HardwareProfile
+	// Registry is the central server the machine registers with.
+	Registry     MachineRegistry
+	Registration *Registration
+	State        State
+	// TransactionID identifies the current (or last finished) transaction.
+	TransactionID string
 	CurrentTicket string
-	CurrentPrice  float64
-	InsertedMoney float64
-	Inventory     map[string]int
-	TicketPrices  map[string]float64
+	// Cart holds the ticket lines of the current transaction; a plain
+	// SelectTicket is a cart with a single line.
+	Cart          []CartLine
+	CurrentPrice  Money
+	CurrentTax    TaxBreakdown
+	FareCategory  FareCategory
+	Promo         *Promo
+	PromoDiscount Money
+	// RiderID identifies a linked rider; CapDiscount is what fare capping
+	// waives for them.
+	RiderID       string
+	CapDiscount   Money
+	InsertedMoney Money
+	Overpayment   Money
+	// Catalog holds the products on sale with their price, VAT, validity
+	// and stock; Storage, when set, persists stock and prices.
+	Catalog *TicketCatalog
+	Storage Storage
+	// Journal, when set, logs every customer action before it is applied
+	// and every state transition.
+	Journal *Journal
+	// Store, when set, records sales, refunds, stock movements and config.
+	Store Store
+	// Movements logs every change of stock.
+	Movements MovementLog
+	// Schedule, when set, overrides catalog prices by time of day and weekday.
+	Schedule *PriceSchedule
+	// Pricing, when set, replaces the default strategy built from
+	// ZoneFares, Schedule and the catalog.
+	Pricing PricingStrategy
+	// FareDiscounts are the concessions per fare category and ticket type;
+	// Eligibility, when set, must approve a concession before it applies.
+	FareDiscounts map[FareCategory]map[string]Discount
+	Eligibility   EligibilityVerifier
+	Promos        PromoProvider
+	Riders        RiderIdentifier
+	Capping       FareCapper
+	// Seats, when set, offers seat reservation for seated products.
+	Seats SeatInventory
+	// ZoneFares, when set, prices some products by destination zone.
+	ZoneFares *ZoneFares
+	// Templates lay out tickets for the Printer. Without templates only a
+	// summary line is shown.
+	Templates *TicketTemplates
+	Printer   TicketPrinter
+	// ReceiptPrinter, when set, offers a purchase receipt after each sale.
+	ReceiptPrinter ReceiptPrinter
+	// Paper, when set, tracks the ticket paper left in the printer.
+	Paper *PaperSupply
+	// TicketSigner signs ticket QR payloads; QRRenderer draws them.
+	TicketSigner TicketSigner
+	QRRenderer   QRRenderer
+
+	// Currency is the currency of catalog prices and all internal amounts.
+	Currency           Currency
+	AcceptedCurrencies []Currency
+	DisplayCurrencies  []Currency
+	Rates              RateProvider
+
+	// Denominations are the coins and notes InsertMoney accepts;
+	// SessionTally counts those inserted in the current transaction.
+	Denominations []Money
+	SessionTally  map[Money]int
+	// ForeignDenominations are the coins and notes InsertMoneyIn accepts
+	// per currency; SessionForeign holds those inserted in the current
+	// transaction.
+	ForeignDenominations map[Currency][]Money
+	SessionForeign       []ForeignCash
+	Validator            CashValidator
+	CashStats            CashStats
+	// Coins and Bills, when set, are the coin acceptor and bill validator
+	// feeding InsertMoney; escrow is the note held until the sale commits.
+	Coins  CoinAcceptor
+	Bills  BillValidator
+	escrow Money
+
+	// Hopper is the change stock per denomination.
+	Hopper map[Money]int
+
+	// ExactChangeOnly is the admin toggle for exact-change mode; the mode
+	// is also forced when the hopper total drops below ExactChangeThreshold.
+	ExactChangeOnly      bool
+	ExactChangeThreshold Money
+
+	OverpaymentPolicy OverpaymentPolicy
+	Donations         Money
+
+	CashBox     *CashBox
+	Collections []CashCollection
+
+	Clock        Clock
+	Timeout      time.Duration
+	LastActivity time.Time
+	// ConfigVerifier holds the keys trusted for pushed configuration and
+	// remote commands; ConfigVersion and CommandSeq are the last version
+	// applied and command seen. Messages are the texts for the customer
+	// display, by key.
+	ConfigVerifier *TicketVerifier
+	ConfigVersion  int
+	CommandSeq     int
+	Messages       map[string]string
+	Auth           Authenticator
+	Authz          Authorizer
+	AuditLog       []AuditEntry
+	// AuditSigner, when set, signs a checkpoint of the audit chain every
+	// AuditCheckpointEvery entries into AuditCheckpoints.
+	AuditSigner          TicketSigner
+	AuditCheckpointEvery int
+	AuditCheckpoints     []AuditCheckpoint
+	// Incidents are the security sensor events, cleared or not.
+	Incidents []SecurityIncident
+	// Notifier receives operational alerts such as low stock.
+	Notifier Notifier
+	// PaymentFailureAlertAt is the number of failed card payments in a
+	// row that raises an alert; 0 turns the alert off.
+	PaymentFailureAlertAt int
+
+	Gateway   PaymentGateway
+	CardAuth  *Authorization
+	NFCReader NFCReader
+	// CardReader, when set, is the chip, magstripe and contactless reader
+	// feeding PayByCard.
+	CardReader CardReader
+	// Input, when set, is the keypad or touch front end.
+	Input InputDevice
+
+	QRProvider QRPaymentProvider
+	QRPaid     *QRPayment
+
+	TransitCards TransitCardDevice
+	TopUpAmounts []Money
+	TopUp        *TopUp
+
+	// ChangeDispenser, when set, pays out change; Vouchers record the
+	// change it failed to pay.
+	ChangeDispenser ChangeDispenser
+	Vouchers        []ChangeVoucher
+	// OnChangeDispensed is called whenever change is handed out, so
+	// integrators can drive physical change hardware.
+	OnChangeDispensed func(c Change)
+	// OnCashRejected is called for every coin or note the validator refuses.
+	OnCashRejected func(r CashRejectedError)
+
+	// Transactions are the records of completed sales; Retention limits
+	// how long they and the logs are kept.
+	Transactions []TransactionRecord
+	Retention    RetentionPolicy
+	// Backups, when set, backs up the machine data on a schedule.
+	Backups *BackupScheduler
+	// Events, when set, publishes the machine events to a message bus;
+	// see PublishEvents.
+	Events *EventPublisher
+
+	// RefundWindow is how long after issue an unused ticket may be
+	// returned; Usage, when set, reports whether it was used.
+	RefundWindow time.Duration
+	Usage        TicketUsage
+	Refunds      []RefundRecord
+
+	// Deliverers send e-tickets per channel; Delivery is the current
+	// sale's request.
+	Deliverers    map[DeliveryChannel]TicketDeliverer
+	DeliveryRetry RetryPolicy
+	Delivery      *DeliveryRequest
+
+	Fiscal      FiscalPrinter
+	FiscalRetry RetryPolicy
+	FiscalQueue []FiscalSale
+	Sleep       func(time.Duration)
+
+	dispensed      map[string]Dispensed
+	dispensedOrder []string
+	deliveries     sync.WaitGroup
+	// draining is the out-of-service state entered once the current
+	// transaction ends; pendingReload is a Reload waiting for the same.
+	draining      *OutOfServiceState
+	pendingReload *Config
+	saleStarted   time.Time
+	trace         *trace
+	listeners     []*subscription
+	// paymentFailures counts failed card payments in a row.
+	paymentFailures int
+	dashboard       *Dashboard
+	health          *HealthMonitor
+	loop            *machineLoop
+	loopOnce        sync.Once
+	replay          *journalReplay
+	// auditSeq and auditHash are the head of the audit chain.
+	auditSeq  int
+	auditHash string
+	// cardAttempts numbers the card authorizations of the transaction.
+	cardAttempts int
 }
 
-func NewTicketMachine() *TicketMachine {
-	return &TicketMachine{
-		State:        &IdleState{},
-		Inventory:    map[string]int{"metro": 10, "bus": 15, "train": 5},
-		TicketPrices: map[string]float64{"metro": 300.0, "bus": 250.0, "train": 1000.0},
+// NewTicketMachine returns a machine built from DefaultConfig.
+func NewTicketMachine(opts ...Option) *TicketMachine {
+	return newTicketMachine(DefaultConfig(), opts...)
+}
+
+func newTicketMachine(cfg Config, opts ...Option) *TicketMachine {
+	o := machineOptions{out: os.Stdout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.logHandler == nil {
+		o.logHandler = slog.NewTextHandler(o.out, &slog.HandlerOptions{Level: slog.LevelWarn})
+	}
+	pins, roles := cfg.operators()
+	hopper := map[Money]int{}
+	for d, n := range cfg.Hopper {
+		hopper[d] = n
+	}
+	m := &TicketMachine{
+		Display:       ConsoleDisplay{W: o.out},
+		Out:           o.out,
+		Logger:        slog.New(o.logHandler),
+		Tracing:       o.tracing,
+		AudioPrompts:  DefaultAudioPrompts(),
+		AudioLocale:   cfg.Locale,
+		TTS:           LogTextToSpeech{W: o.out},
+		MachineID:     cfg.MachineID,
+		Location:      Location{StationID: cfg.Location.StationID, Station: cfg.Location.Station, Zone: cfg.Location.Zone},
+		Hardware:      HardwareProfile{Model: "TM-200", Serial: "SN-0001", Firmware: "1.0"},
+		Registry:      &MockRegistry{},
+		State:         &IdleState{},
+		Catalog:       cfg.catalog(),
+		Templates:     DefaultTicketTemplates(),
+		Printer:       &MockTicketPrinter{W: o.out},
+		Paper:         &PaperSupply{Remaining: 500, Capacity: 500, LowAt: 50},
+		Notifier:      LogNotifier{W: o.out},
+		Schedule:      cfg.schedule(),
+		FareDiscounts: DefaultFareDiscounts(),
+		Promos:        &MemoryPromoProvider{Promos: map[string]Promo{}},
+		Capping:       &FareCaps{Daily: KZT(1000), Weekly: KZT(5000)},
+		TicketSigner:  &HMACSigner{ID: cfg.TicketKeyID, Key: []byte(cfg.TicketKey)},
+		QRRenderer:    PlainQRRenderer{},
+
+		Currency:             CurrencyKZT,
+		AcceptedCurrencies:   []Currency{CurrencyKZT},
+		DisplayCurrencies:    []Currency{CurrencyKZT, CurrencyUSD, CurrencyRUB},
+		Rates:                DefaultRates(),
+		Denominations:        append([]Money(nil), cfg.Denominations...),
+		SessionTally:         map[Money]int{},
+		ForeignDenominations: DefaultForeignDenominations(),
+		Hopper:               hopper,
+
+		ExactChangeThreshold:    cfg.ExactChangeThreshold,
+		CashBox:                 NewCashBox(cfg.CashBoxCapacity),
+		Clock:                   systemClock{},
+		Timeout:                 time.Duration(cfg.TimeoutSeconds) * time.Second,
+		AccessibleTimeoutFactor: 3,
+		PaymentFailureAlertAt:   3,
+		RefundWindow:            time.Duration(cfg.RefundWindowMinutes) * time.Minute,
+		Retention:               RetentionPolicy{Keep: 90 * 24 * time.Hour},
+		Usage:                   MockTicketUsage{},
+		Deliverers: map[DeliveryChannel]TicketDeliverer{
+			ChannelEmail: &MockDeliverer{}, ChannelSMS: &MockDeliverer{}, ChannelPush: &MockDeliverer{},
+		},
+		DeliveryRetry: RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 30 * time.Second},
+		Fiscal:        &MockFiscalPrinter{},
+		FiscalRetry:   RetryPolicy{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second},
+		Sleep:         time.Sleep,
+		Auth:          pins,
+		Authz:         &RoleAuthorizer{Roles: DefaultRoles(), Operators: roles},
+		Gateway:       NewResilientGateway(&MockGateway{}, systemClock{}),
+		NFCReader:     &MockNFCReader{Card: CardDetails{Token: "tok_nfc", MaskedPAN: "**** 0001", EntryMode: EntryContactless}},
+		QRProvider:    &MockQRProvider{},
+		TopUpAmounts:  append([]Money(nil), cfg.TopUpAmounts...),
+	}
+	if seats := cfg.seats(); seats != nil {
+		m.Seats = seats
 	}
+	if o.transitCards != nil {
+		m.TransitCards = o.transitCards
+		m.Riders = TransitCardRiders{Cards: o.transitCards}
+	}
+	m.bus()
+	return m
 }
 
 func (m *TicketMachine) SetState(s State) {
+	m.logTransition(m.State, s)
+	from := ""
+	if m.State != nil {
+		from = m.State.Name()
+	}
+	defer m.emit(StateChanged{TransactionID: m.TransactionID, From: from, To: s.Name()})
+	m.Metrics.transition(s.Name(), m.Clock.Now())
+	m.traceState(s)
 	m.State = s
+	m.LastActivity = m.Clock.Now()
+	switch s.(type) {
+	case *IdleState:
+		m.Accessible = false
+		m.display().ShowIdle(m.Messages["welcome"])
+	case *CashBoxFullState:
+		m.Accessible = false
+	}
+	m.syncCoinAcceptor()
+	m.syncBillValidator()
+	m.syncCardReader()
+	m.cueState(s)
+}
+
+// readyState is the state a machine returns to between transactions. A
+// deferred Reload is applied on the way.
+func (m *TicketMachine) readyState() State {
+	if cfg := m.pendingReload; cfg != nil {
+		m.pendingReload = nil
+		m.applyReload(*cfg)
+	}
+	if s := m.draining; s != nil {
+		m.draining = nil
+		m.audit("", "remote_disable", string(s.Reason))
+		m.show("Out of service: %v", s.err())
+		return s
+	}
+	if m.CashBox.IsFull() {
+		return &CashBoxFullState{}
+	}
+	return &IdleState{}
 }
 
 func (m *TicketMachine) GetCurrentState() string {
 	return m.State.Name()
 }
 
-func (m *TicketMachine) GetTicketPrice(ticketType string) float64 {
-	return m.TicketPrices[ticketType]
+// GetTicketPrice returns the current price of ticketType from the pricing
+// strategy.
+func (m *TicketMachine) GetTicketPrice(ticketType string) Money {
+	p, _ := m.pricing().GetPrice(ticketType, m.priceContext())
+	return p
 }
 
 func (m *TicketMachine) HasTicket(ticketType string) bool {
-	return m.Inventory[ticketType] > 0
+	return m.Catalog.Stock(ticketType) > 0
+}
+
+// checkChange verifies the change owed can be paid out before anything is
+// handed to the customer.
+func (m *TicketMachine) checkChange() error {
+	owed := m.changeOwed()
+	if owed > 0 && m.ExactChangeRequired() {
+		return newError(CodeCannotMakeChange, "exact change only, no change can be given")
+	}
+	if !m.CanMakeChange(owed) {
+		return newError(CodeCannotMakeChange, "cannot make change")
+	}
+	return nil
+}
+
+// settle captures the payment, pays out change and moves the inserted cash
+// into the cash box.
+func (m *TicketMachine) settle() (Change, error) {
+	change := Change{Amount: m.changeOwed()}
+	plan, ok := m.PlanChange(change.Amount)
+	if !ok {
+		return Change{}, newError(CodeCannotMakeChange, "cannot make change")
+	}
+	rec := m.newRecord()
+	rec.Change = change.Amount
+	rec.Donation = m.Overpayment - change.Amount
+	if err := m.captureCard(); err != nil {
+		return Change{}, err
+	}
+	if err := m.saveSale(rec); err != nil {
+		return Change{}, err
+	}
+	m.stackEscrow()
+	change.Coins = plan
+	m.payOutChange(plan)
+	m.deposit(m.SessionTally)
+	m.depositForeign(m.SessionForeign)
+	m.SessionTally = map[Money]int{}
+	m.SessionForeign = nil
+	if donated := m.Overpayment - change.Amount; donated > 0 {
+		m.Donations += donated
+		m.show("Thank you for your donation of %s", donated.In(m.Currency))
+	}
+	m.Transactions = append(m.Transactions, rec)
+	m.logSale(rec)
+	m.Metrics.sale(rec, rec.Time.Sub(m.saleStarted))
+	m.QRPaid = nil
+	m.InsertedMoney = 0
+	m.Overpayment = 0
+	return change, nil
 }
 
-func (m *TicketMachine) SelectTicket(ticketType string) error {
-	return m.State.SelectTicket(m, ticketType)
+// finish hands out the change of a completed transaction and records it.
+// A change voucher issued on a dispenser failure is added to d.
+func (m *TicketMachine) finish(d *Dispensed) {
+	if d.Change.Amount > 0 {
+		m.show("Change: %s", d.Change.Amount.In(m.Currency))
+		m.dispenseChange(&d.Change, m.Hopper)
+	}
+	m.recordDispensed(*d)
+	if d.Change.Amount > 0 {
+		m.SetState(&ChangeDispensedState{Change: d.Change})
+		if m.OnChangeDispensed != nil {
+			m.OnChangeDispensed(d.Change)
+		}
+	} else {
+		m.SetState(&TicketDispensedState{})
+	}
+	m.offerReceipt()
+}
+
+// beginTransaction sets up a new sale of qty tickets of ticketType.
+func (m *TicketMachine) beginTransaction(ticketType string, qty int) error {
+	if err := m.checkPaper(); err != nil {
+		return err
+	}
+	m.startSale()
+	return m.addLine(ticketType, qty)
+}
+
+// startSale resets the per-transaction fields for a new sale.
+func (m *TicketMachine) startSale() {
+	m.releaseSeats()
+	m.TransactionID = newTransactionID()
+	m.saleStarted = m.Clock.Now()
+	m.TopUp = nil
+	m.Cart = nil
+	m.CurrentTicket = ""
+	m.CurrentPrice = 0
+	m.CurrentTax = TaxBreakdown{}
+	m.FareCategory = FareAdult
+	m.Promo = nil
+	m.PromoDiscount = 0
+	m.RiderID = ""
+	m.CapDiscount = 0
+	m.Delivery = nil
+	m.SessionTally = map[Money]int{}
+	m.SessionForeign = nil
+	m.cardAttempts = 0
+}
+
+// InventoryLine is one row of an inventory report.
+type InventoryLine struct {
+	TicketType string
+	Count      int
+	Price      Money
+	Value      Money
+}
+
+// InventoryReport lists the stock of active products and its value at
+// current prices, sorted by ticket type.
+func (m *TicketMachine) InventoryReport() []InventoryLine {
+	var lines []InventoryLine
+	for _, p := range m.Catalog.List() {
+		if !p.Active {
+			continue
+		}
+		price := m.GetTicketPrice(p.Type)
+		lines = append(lines, InventoryLine{
+			TicketType: p.Type,
+			Count:      p.Stock,
+			Price:      price,
+			Value:      price * Money(p.Stock),
+		})
+	}
+	return lines
+}
+
+// SelectTicket chooses qty tickets of ticketType for one transaction.
+func (m *TicketMachine) SelectTicket(ticketType string, qty int) (err error) {
+	defer m.endAction(m.startAction("select"), &err)
+	if err := m.logAction(JournalEntry{Action: "select", TicketType: ticketType, Qty: qty}); err != nil {
+		return err
+	}
+	return m.selectTicket(ticketType, qty)
+}
+
+// selectTicket is SelectTicket without the journal entry.
+func (m *TicketMachine) selectTicket(ticketType string, qty int) error {
+	if err := m.checkAccessibleQty(qty); err != nil {
+		return err
+	}
+	if err := m.State.SelectTicket(m, ticketType, qty); err != nil {
+		return err
+	}
+	m.emit(TicketSelected{TransactionID: m.TransactionID, TicketType: ticketType, Qty: qty, Price: m.CurrentPrice})
+	return nil
+}
+
+// InsertMoney inserts a single coin or banknote in the machine currency.
+func (m *TicketMachine) InsertMoney(amount Money) (err error) {
+	defer m.endAction(m.startAction("insert"), &err)
+	if err := m.logAction(JournalEntry{Action: "insert", Amount: amount}); err != nil {
+		return err
+	}
+	return m.insertCash(amount, m.Currency)
+}
+
+// insertCash takes one coin or note of amount in c and credits its value in
+// the machine currency to the current state.
+func (m *TicketMachine) insertCash(amount Money, c Currency) error {
+	if err := m.inService(); err != nil {
+		return err
+	}
+	if !m.AcceptsDenominationIn(amount, c) {
+		return &UnsupportedDenominationError{Amount: amount}
+	}
+	if !m.canAcceptCash() {
+		return newError(CodeCashBoxFull, "cash box full, please pay by card")
+	}
+	if err := m.validateCash(amount); err != nil {
+		return err
+	}
+	credit, err := m.convertCash(amount, c)
+	if err != nil {
+		return err
+	}
+	if err := m.State.InsertMoney(m, credit); err != nil {
+		return err
+	}
+	m.CashStats.Accepted++
+	ev := MoneyInserted{TransactionID: m.TransactionID, Amount: credit}
+	if c == m.Currency {
+		m.SessionTally[amount]++
+	} else {
+		f := ForeignCash{Amount: amount, Currency: c, Credited: credit}
+		m.SessionForeign = append(m.SessionForeign, f)
+		ev.Foreign = &f
+	}
+	m.cue(AudioCoinAccepted)
+	m.LastActivity = m.Clock.Now()
+	ev.Total = m.InsertedMoney
+	m.emit(ev)
+	return nil
+}
+
+// PayByCard pays for the selected ticket with a card instead of cash.
+func (m *TicketMachine) PayByCard(card CardDetails) (err error) {
+	defer m.endAction(m.startAction("card"), &err)
+	if err := m.logAction(JournalEntry{Action: "card", Card: &card}); err != nil {
+		return err
+	}
+	return m.State.PayByCard(m, card)
+}
+
+func (m *TicketMachine) Cancel() (err error) {
+	defer m.endAction(m.startAction("cancel"), &err)
+	if err := m.logAction(JournalEntry{Action: "cancel"}); err != nil {
+		return err
+	}
+	return m.cancel()
+}
+
+// cancel calls off the transaction in flight and gives the money back.
+func (m *TicketMachine) cancel() error {
+	paid := m.PaidTotal()
+	if err := m.State.Cancel(m); err != nil {
+		return err
+	}
+	m.releaseSeats()
+	m.emit(TransactionCanceled{TransactionID: m.TransactionID, Paid: paid})
+	return nil
 }
 
-func (m *TicketMachine) InsertMoney(amount float64) error {
-	return m.State.InsertMoney(m, amount)
+func (m *TicketMachine) DispenseTicket() (d Dispensed, err error) {
+	defer m.endAction(m.startAction("dispense"), &err)
+	if err := m.logAction(JournalEntry{Action: "dispense"}); err != nil {
+		return Dispensed{}, err
+	}
+	if d, err = m.State.DispenseTicket(m); err != nil {
+		return d, err
+	}
+	m.emit(TicketDispensed{TransactionID: m.TransactionID, Tickets: d.Tickets, Change: d.Change.Amount})
+	return d, nil
 }
 
-func (m *TicketMachine) Cancel() error {
-	return m.State.Cancel(m)
+// StartOver returns a finished or canceled machine to its ready state.
+func (m *TicketMachine) StartOver() (err error) {
+	defer m.endAction(m.startAction("start_over"), &err)
+	if err := m.logAction(JournalEntry{Action: "start_over"}); err != nil {
+		return err
+	}
+	switch m.State.(type) {
+	case *TicketDispensedState, *ChangeDispensedState, *TransactionCanceledState, *RefundIssuedState:
+		m.SetState(m.readyState())
+		return nil
+	}
+	return newError(CodeBusy, "transaction in progress")
 }
 
-func (m *TicketMachine) DispenseTicket() error {
-	return m.State.DispenseTicket(m)
+// demoTransitCards holds one card with a small balance to the reader.
+func demoTransitCards() *MockTransitCards {
+	return &MockTransitCards{Balances: map[string]Money{"ONAY-0001": KZT(150)}, Presented: "ONAY-0001"}
 }
 
 func main() {
-	machine := NewTicketMachine()
+	cfg, err := LoadLayeredConfig(os.Args[1:], os.Getenv, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	machine := newTicketMachine(cfg)
+	if err := machine.Register(); err != nil {
+		machine.Display.ShowError(err)
+	}
+	stopAPI, err := machine.ServeAPI(cfg.APIPort)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer stopAPI()
+	// The API serves from its own goroutines, so the served machine runs
+	// on its machine goroutine and is driven through Do.
+	ctx, stopMachine := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func(m *TicketMachine) {
+		m.Run(ctx, time.Second)
+		close(stopped)
+	}(machine)
+	defer func() {
+		stopMachine()
+		<-stopped
+	}()
+	for machine.Do(func(*TicketMachine) error { return nil }) != nil {
+		time.Sleep(time.Millisecond)
+	}
 
 	fmt.Println("--- Successful Purchase ---")
-	machine.SelectTicket("metro")
-	machine.InsertMoney(300.0)
+	machine.Do(func(machine *TicketMachine) error {
+		machine.SelectTicket("metro", 1)
+		machine.InsertMoney(KZT(200))
+		machine.InsertMoney(KZT(100))
+		d, err := machine.DispenseTicket()
+		if err != nil {
+			return err
+		}
+		t := d.Tickets[0]
+		fmt.Printf("Ticket %s (%s) valid until %s\n", t.ID, t.Type, t.ValidUntil.Format("15:04"))
+		gate := NewTicketVerifier(systemClock{})
+		gate.AddHMACKey(cfg.TicketKeyID, []byte(cfg.TicketKey))
+		if _, err := gate.VerifyTicket(t.QRPayload); err != nil {
+			fmt.Println("Gate rejected ticket:", err)
+		} else {
+			fmt.Println("Gate accepted ticket.")
+		}
+		return nil
+	})
+
+	fmt.Println("\n--- Purchase With Change ---")
+	machine = NewTicketMachine()
+	machine.SelectTicket("metro", 1)
+	machine.InsertMoney(KZT(500))
+	machine.DispenseTicket()
+	fmt.Printf("State: %s\n", machine.GetCurrentState())
+
+	fmt.Println("\n--- Card Payment ---")
+	machine = NewTicketMachine()
+	machine.SelectTicket("train", 1)
+	machine.SelectSeat(Seat{Coach: "2", Number: "14"})
+	machine.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"})
 	machine.DispenseTicket()
 
+	fmt.Println("\n--- Tap To Pay ---")
+	machine = NewTicketMachine()
+	machine.TapToPay("metro")
+	machine.DispenseTicket()
+
+	fmt.Println("\n--- Kaspi QR ---")
+	machine = NewTicketMachine()
+	machine.SelectTicket("bus", 1)
+	if p, err := machine.PayByQR(); err == nil {
+		machine.QRProvider.(*MockQRProvider).MarkPaid(p.ID)
+		machine.PollQRPayment()
+	}
+	machine.DispenseTicket()
+
+	fmt.Println("\n--- Split Tender ---")
+	machine = NewTicketMachine()
+	machine.SelectTicket("train", 1)
+	machine.SkipSeat()
+	machine.InsertMoney(KZT(500))
+	fmt.Printf("Outstanding: %s\n", machine.Outstanding().In(machine.Currency))
+	machine.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"})
+	machine.DispenseTicket()
+
+	fmt.Println("\n--- Inactivity Timeout ---")
+	clock := &FakeClock{T: time.Now()}
+	machine = NewTicketMachine()
+	machine.Clock = clock
+	machine.SelectTicket("metro", 1)
+	machine.InsertMoney(KZT(100))
+	clock.Advance(2 * time.Minute)
+	machine.Tick()
+	fmt.Printf("State: %s\n", machine.GetCurrentState())
+
+	fmt.Println("\n--- Transit Card Top-Up ---")
+	machine = NewTicketMachine(WithTransitCards(demoTransitCards()))
+	machine.CheckBalance()
+	machine.PresentTransitCard()
+	machine.SelectTopUp(KZT(1000))
+	machine.InsertMoney(KZT(1000))
+	machine.DispenseTicket()
+
+	fmt.Println("\n--- Cart ---")
+	machine = NewTicketMachine()
+	machine.AddToCart("metro", 2)
+	machine.AddToCart("train", 1)
+	machine.Checkout()
+	machine.InsertMoney(KZT(2000))
+	machine.DispenseTicket()
+
+	fmt.Println("\n--- Carnet ---")
+	machine = NewTicketMachine()
+	machine.SelectTicket("metro_carnet", 1)
+	machine.InsertMoney(KZT(2000))
+	machine.InsertMoney(KZT(500))
+	if d, err := machine.DispenseTicket(); err == nil {
+		fmt.Printf("%d %s ride credits at %s each\n", len(d.Tickets), d.Tickets[0].Type, d.Tickets[0].PricePaid)
+	}
+
+	fmt.Println("\n--- Return Journey ---")
+	machine = NewTicketMachine()
+	machine.SelectTicket("tram", 1)
+	machine.SelectJourney(JourneyReturn)
+	machine.InsertMoney(KZT(200))
+	machine.InsertMoney(KZT(200))
+	if d, err := machine.DispenseTicket(); err == nil {
+		t := d.Tickets[0]
+		fmt.Printf("%s %s ticket, %d legs, valid until %s\n", t.Type, t.Journey, t.Legs, t.ValidUntil.Format(time.RFC3339))
+	}
+
+	fmt.Println("\n--- Fare Capping ---")
+	machine = NewTicketMachine(WithTransitCards(demoTransitCards()))
+	for i := 0; i < 5; i++ {
+		machine.SelectTicket("bus", 1)
+		machine.LinkRider()
+		if machine.CurrentPrice > 0 {
+			machine.InsertMoney(KZT(200))
+			machine.InsertMoney(KZT(50))
+		}
+		machine.DispenseTicket()
+		machine.StartOver()
+	}
+
+	fmt.Println("\n--- E-Ticket ---")
+	machine = NewTicketMachine()
+	machine.SelectTicket("bus", 1)
+	machine.ChooseDelivery(ChannelEmail, "rider@example.com")
+	machine.InsertMoney(KZT(200))
+	machine.InsertMoney(KZT(50))
+	machine.DispenseTicket()
+	machine.WaitForDeliveries()
+	status, attempts, _ := machine.Transactions[0].Delivery.Status()
+	fmt.Printf("Delivery: %s after %d attempt(s)\n", status, attempts)
+
+	fmt.Println("\n--- Paper Supply ---")
+	machine = NewTicketMachine()
+	machine.Paper.Remaining = 1
+	for i := 0; i < 2; i++ {
+		machine.SelectTicket("bus", 1)
+		machine.InsertMoney(KZT(200))
+		machine.InsertMoney(KZT(50))
+		if _, err := machine.DispenseTicket(); err != nil {
+			machine.Display.ShowError(err)
+			machine.ChooseDelivery(ChannelEmail, "rider@example.com")
+			machine.DispenseTicket()
+		}
+		machine.StartOver()
+	}
+	machine.WaitForDeliveries()
+	if s, err := machine.EnterAdminMode(Credentials{OperatorID: "admin", PIN: "0000"}); err == nil {
+		s.ReplacePaper()
+		if p, err := s.PaperLevel(); err == nil {
+			fmt.Printf("Paper: %d of %d\n", p.Remaining, p.Capacity)
+		} else {
+			machine.Display.ShowError(err)
+		}
+		s.Exit()
+	}
+
+	fmt.Println("\n--- Out of Paper Mid-Print ---")
+	machine = NewTicketMachine()
+	machine.Paper.Remaining = 1
+	machine.SelectTicket("bus", 2)
+	machine.InsertMoney(KZT(500))
+	if _, err := machine.DispenseTicket(); err != nil {
+		machine.Display.ShowError(err)
+		machine.ChooseDelivery(ChannelSMS, "+77010000000")
+		machine.DispenseTicket()
+	}
+	machine.WaitForDeliveries()
+	machine = NewTicketMachine()
+	machine.Deliverers = nil
+	machine.Paper.Remaining = 1
+	machine.SelectTicket("bus", 2)
+	machine.InsertMoney(KZT(500))
+	machine.DispenseTicket()
+	machine.Cancel()
+	machine.StartOver()
+	fmt.Println("State:", machine.GetCurrentState())
+	if s, err := machine.EnterAdminMode(Credentials{OperatorID: "clerk", PIN: "1111"}); err == nil {
+		s.ReplacePaper()
+		s.Exit()
+	}
+	fmt.Println("State:", machine.GetCurrentState())
+
+	fmt.Println("\n--- Ticket Refund ---")
+	machine = NewTicketMachine()
+	machine.SelectTicket("bus", 1)
+	machine.InsertMoney(KZT(200))
+	machine.InsertMoney(KZT(50))
+	if d, err := machine.DispenseTicket(); err == nil {
+		machine.StartOver()
+		if _, err := machine.RefundTicket(d.Tickets[0].ID); err != nil {
+			machine.Display.ShowError(err)
+		}
+		machine.StartOver()
+		if _, err := machine.RefundTicket(d.Tickets[0].ID); err != nil {
+			machine.Display.ShowError(err)
+		}
+	}
+
 	fmt.Println("\n--- Cancellation Before Payment ---")
 	machine = NewTicketMachine()
-	machine.SelectTicket("bus")
+	machine.SelectTicket("bus", 1)
 	machine.Cancel()
 	fmt.Printf("State: %s\n", machine.GetCurrentState())
 
 	fmt.Println("\n--- Cancellation After Payment ---")
 	machine = NewTicketMachine()
-	machine.SelectTicket("train")
-	machine.InsertMoney(1000.0)
+	machine.SelectTicket("train", 1)
+	machine.SelectSeat(Seat{Coach: "1", Number: "1"})
+	machine.InsertMoney(KZT(1000))
 	machine.Cancel()
 	fmt.Printf("State: %s\n", machine.GetCurrentState())
+
+	fmt.Println("\n--- Foreign Currency ---")
+	machine = NewTicketMachine()
+	machine.SelectTicket("bus", 1)
+	if err := machine.InsertMoneyIn(KZT(5), CurrencyUSD); err != nil {
+		machine.Display.ShowError(err)
+	}
+
+	fmt.Println("\n--- Unsupported Denomination ---")
+	machine = NewTicketMachine()
+	machine.SelectTicket("metro", 1)
+	if err := machine.InsertMoney(12345); err != nil {
+		machine.Display.ShowError(err)
+	}
+	machine.InsertMoney(KZT(200))
+	machine.InsertMoney(KZT(100))
+	for _, t := range machine.Tally() {
+		fmt.Printf("%s x %d\n", t.Denomination, t.Count)
+	}
+
+	fmt.Println("\n--- Hardware Fault ---")
+	machine = NewTicketMachine()
+	machine.SelectTicket("metro", 1)
+	machine.InsertMoney(KZT(200))
+	machine.HardwareFault("printer", errors.New("paper jam"))
+	if err := machine.SelectTicket("metro", 1); err != nil {
+		machine.Display.ShowError(err)
+	}
+	machine.ReturnToService("admin", "0000")
+
+	fmt.Println("\n--- Admin Session ---")
+	if s, err := machine.EnterAdminMode(Credentials{OperatorID: "admin", PIN: "0000"}); err == nil {
+		s.SetPrice("metro", KZT(320))
+		s.Restock("train", 5)
+		if d, err := s.Diagnostics(); err == nil {
+			fmt.Printf("State %s, cash box %s, hopper %s\n", d.State, d.CashBox, d.Hopper)
+		}
+		if err := machine.SelectTicket("bus", 1); err != nil {
+			machine.Display.ShowError(err)
+		}
+		s.Exit()
+	}
+	if s, err := machine.EnterAdminMode(Credentials{OperatorID: "admin", PIN: "0000"}); err == nil {
+		machine.Fiscal.(*MockFiscalPrinter).Err = errors.New("no paper")
+		s.RunDiagnostics()
+		machine.Fiscal.(*MockFiscalPrinter).Err = nil
+		s.Exit()
+	}
+	if s, err := machine.EnterAdminMode(Credentials{OperatorID: "clerk", PIN: "1111"}); err == nil {
+		if err := s.SetPrice("metro", KZT(100)); err != nil {
+			machine.Display.ShowError(err)
+		}
+		s.Exit()
+	}
+
+	fmt.Println("\n--- Remote Configuration ---")
+	fleetKey := &HMACSigner{ID: "fleet-1", Key: []byte("fleet-secret")}
+	machine.ConfigVerifier = NewTicketVerifier(machine.Clock)
+	machine.ConfigVerifier.AddHMACKey(fleetKey.ID, fleetKey.Key)
+	busPrice := KZT(270)
+	if doc, err := SignConfig(MachineConfig{Version: 1, Products: []ProductConfig{{Type: "bus", Price: &busPrice}},
+		Messages: map[string]string{"welcome": "Welcome to City Transit"}}, fleetKey); err == nil {
+		if err := machine.ApplyConfig(doc); err != nil {
+			machine.Display.ShowError(err)
+		}
+		if err := machine.ApplyConfig(doc); err != nil {
+			machine.Display.ShowError(err)
+		}
+	}
+	fmt.Println("Bus price:", machine.GetTicketPrice("bus"))
+
+	fmt.Println("\n--- Remote Disable ---")
+	machine.SelectTicket("metro", 1)
+	machine.InsertMoney(KZT(200))
+	drain, _ := SignCommand(RemoteCommand{Seq: 1, Action: CommandDisable, Reason: ReasonIncident, Detail: "station closed", Drain: true}, fleetKey)
+	machine.HandleCommand(drain)
+	machine.InsertMoney(KZT(100))
+	machine.DispenseTicket()
+	machine.StartOver()
+	if err := machine.SelectTicket("metro", 1); err != nil {
+		machine.Display.ShowError(err)
+	}
+	if err := machine.HandleCommand(drain); err != nil {
+		machine.Display.ShowError(err)
+	}
+	enable, _ := SignCommand(RemoteCommand{Seq: 2, Action: CommandEnable}, fleetKey)
+	machine.HandleCommand(enable)
+
+	fmt.Println("\n--- Coin Acceptor ---")
+	machine = NewTicketMachine()
+	coins := NewSimulatedCoinAcceptor()
+	machine.Coins = coins
+	fmt.Println("Coin accepted while idle:", coins.Insert(KZT(100)))
+	machine.SelectTicket("bus", 1)
+	coins.Insert(KZT(200))
+	coins.Insert(KZT(3))
+	coins.Insert(KZT(50))
+	machine.PollCoins()
+	machine.DispenseTicket()
+	fmt.Println("Coin acceptor enabled:", coins.Enabled)
+	machine.StartOver()
+
+	fmt.Println("\n--- Coin Jam ---")
+	machine = NewTicketMachine()
+	coins = NewSimulatedCoinAcceptor()
+	machine.Coins = coins
+	machine.SelectTicket("bus", 1)
+	coins.Insert(KZT(100))
+	coins.Insert(KZT(50))
+	coins.Jam(errors.New("coin stuck in validator"))
+	machine.PollCoins()
+	if s, ok := machine.State.(*CoinJamState); ok && s.Refund != nil {
+		fmt.Printf("Refund owed: %s on %s\n", s.Refund.Amount.In(machine.Currency), s.Refund.Code)
+	}
+	if err := machine.SelectTicket("bus", 1); err != nil {
+		machine.Display.ShowError(err)
+	}
+	if err := machine.ClearJam("clerk", "1111"); err != nil {
+		machine.Display.ShowError(err)
+	}
+	machine.ClearJam("admin", "0000")
+	fmt.Println("State:", machine.GetCurrentState())
+
+	fmt.Println("\n--- Bill Escrow ---")
+	machine = NewTicketMachine()
+	bills := NewSimulatedBillValidator()
+	machine.Bills = bills
+	machine.SelectTicket("metro", 1)
+	bills.Insert(KZT(500))
+	machine.PollBills()
+	machine.Cancel()
+	machine.StartOver()
+	machine.SelectTicket("metro", 1)
+	bills.Insert(KZT(200))
+	machine.PollBills()
+	bills.Insert(KZT(100))
+	machine.PollBills()
+	machine.DispenseTicket()
+	fmt.Printf("Notes stacked: %v, returned: %v\n", bills.Stacked, bills.Returned)
+	machine.StartOver()
+
+	fmt.Println("\n--- Card Reader ---")
+	machine = NewTicketMachine()
+	reader := NewSimulatedCardReader()
+	machine.CardReader = reader
+	fmt.Println("Card accepted while idle:", reader.Tap(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}, EMVData{}))
+	machine.SelectTicket("metro", 1)
+	reader.Insert(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"},
+		EMVData{AID: "A0000000031010", Label: "VISA CREDIT", Cryptogram: "9F26-ARQC", CVM: "pin"})
+	machine.PollCardReader()
+	machine.DispenseTicket()
+	fmt.Println("Card reader enabled:", reader.Enabled)
+	machine.StartOver()
+
+	fmt.Println("\n--- Keypad ---")
+	machine = NewTicketMachine()
+	keypad := NewKeypad()
+	machine.Input = keypad
+	keypad.Press("1")
+	machine.PollInput()
+	machine.InsertMoney(KZT(500))
+	keypad.Press("OK")
+	keypad.Press("OK")
+	machine.PollInput()
+	fmt.Println("State:", machine.GetCurrentState())
+	keypad.Press("4")
+	keypad.Press("C")
+	machine.PollInput()
+	fmt.Println("State:", machine.GetCurrentState())
+
+	fmt.Println("\n--- Change Dispenser Fault ---")
+	machine = NewTicketMachine()
+	machine.ChangeDispenser = &MockChangeDispenser{Jam: KZT(50)}
+	machine.SelectTicket("bus", 1)
+	machine.InsertMoney(KZT(500))
+	if d, err := machine.DispenseTicket(); err == nil && d.Change.Voucher != nil {
+		fmt.Printf("Paid out: %v, voucher %s for %s\n", d.Change.Coins, d.Change.Voucher.Code, d.Change.Voucher.Amount.In(machine.Currency))
+		machine.StartOver()
+		if s, err := machine.EnterAdminMode(Credentials{OperatorID: "collector", PIN: "2222"}); err == nil {
+			if v, err := s.RedeemVoucher(d.Change.Voucher.Code); err == nil {
+				fmt.Printf("Voucher %s redeemed: %s\n", v.Code, v.Amount.In(machine.Currency))
+			}
+			s.Exit()
+		}
+	}
+
+	fmt.Println("\n--- Hardware Simulator ---")
+	machine = NewTicketMachine()
+	hw := NewHardwareSim()
+	var waited time.Duration
+	hw.SetSleep(func(d time.Duration) { waited += d })
+	hw.Printer.Delay("print", 2*time.Second)
+	hw.Printer.FailNext("print", errors.New("paper jam"), 1)
+	hw.Attach(machine)
+	err = hw.Run(machine,
+		SimSelect("metro", 1),
+		SimCoin(KZT(200)),
+		SimNote(KZT(100)),
+		SimDispense(),
+	)
+	fmt.Println("Script:", err)
+	err = hw.Run(machine, SimDispense(), SimExpect("TicketDispensed"))
+	fmt.Printf("Script: %v, printed %d, waited %s\n", err, len(hw.Printer.Printed), waited)
+	fmt.Printf("Display: %q\n", hw.Display.Lines[:3])
+
+	fmt.Println("\n--- Event Bus ---")
+	machine = NewTicketMachine()
+	machine.Bus.Subscribe(EventJam, func(ev HardwareEvent) {
+		fmt.Printf("Technician paged: %s %v\n", ev.Device, ev.Err)
+	})
+	machine.Bus.Publish(HardwareEvent{Kind: EventJam, Device: "ticket_printer", Err: errors.New("paper jam")})
+	fmt.Println("State:", machine.GetCurrentState())
+
+	fmt.Println("\n--- Receipt ---")
+	machine = NewTicketMachine()
+	machine.ReceiptPrinter = &MockReceiptPrinter{}
+	machine.SelectTicket("metro", 2)
+	machine.InsertMoney(KZT(200))
+	machine.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"})
+	machine.DispenseTicket()
+	machine.PrintReceipt()
+	if err := machine.PrintReceipt(); err != nil {
+		machine.Display.ShowError(err)
+	}
+	machine.StartOver()
+
+	fmt.Println("\n--- Audio Prompts ---")
+	machine = NewTicketMachine()
+	speaker := &MockAudioSink{}
+	machine.Audio = speaker
+	machine.AudioLocale = "kk"
+	machine.SelectTicket("metro", 1)
+	machine.InsertMoney(KZT(200))
+	machine.InsertMoney(KZT(100))
+	machine.DispenseTicket()
+	for _, p := range speaker.Played {
+		fmt.Printf("Audio %s: %s %q\n", p.Event, p.Sound, p.Speech)
+	}
+	machine.StartOver()
+
+	fmt.Println("\n--- Accessibility Mode ---")
+	machine = NewTicketMachine()
+	clock = &FakeClock{T: time.Now()}
+	machine.Clock = clock
+	machine.SetAccessibility(true)
+	if err := machine.SelectTicket("metro", 2); err != nil {
+		machine.Display.ShowError(err)
+	}
+	machine.SelectTicket("metro", 1)
+	clock.Advance(2 * time.Minute)
+	machine.Tick()
+	fmt.Println("State after 2 minutes:", machine.GetCurrentState())
+	clock.Advance(2 * time.Minute)
+	machine.Tick()
+	fmt.Println("Accessibility after timeout:", machine.Accessible)
+
+	fmt.Println("\n--- Snapshot and Restore ---")
+	machine = NewTicketMachine()
+	machine.SelectTicket("metro", 2)
+	machine.InsertMoney(KZT(200))
+	checkpoint, _ := machine.MarshalJSON()
+	fmt.Printf("Checkpoint: %d bytes\n", len(checkpoint))
+	restored := NewTicketMachine()
+	if err := restored.UnmarshalJSON(checkpoint); err != nil {
+		restored.Display.ShowError(err)
+	}
+	fmt.Printf("Restored: %s, %s of %s inserted\n", restored.GetCurrentState(),
+		restored.InsertedMoney.In(restored.Currency), restored.CurrentPrice.In(restored.Currency))
+	restored.InsertMoney(KZT(200))
+	restored.InsertMoney(KZT(100))
+	restored.DispenseTicket()
+	restored.StartOver()
+
+	fmt.Println("\n--- Journal Replay ---")
+	var journal bytes.Buffer
+	machine = NewTicketMachine()
+	machine.Clock = &FakeClock{T: time.Now()}
+	machine.Journal = &Journal{W: &journal}
+	machine.SelectTicket("metro", 1)
+	machine.InsertMoney(KZT(200))
+	machine.InsertMoney(KZT(100))
+	machine.DispenseTicket()
+	fmt.Printf("Journal: %d lines\n", bytes.Count(journal.Bytes(), []byte("\n")))
+	replayed := NewTicketMachine()
+	replayed.Clock = &FakeClock{}
+	if err := Replay(&journal, replayed); err != nil {
+		replayed.Display.ShowError(err)
+	}
+	fmt.Printf("Replayed: %s, metro stock %d\n", replayed.GetCurrentState(), replayed.Catalog.Stock("metro"))
+
+	fmt.Println("\n--- Encrypted Journal ---")
+	journal.Reset()
+	sealer := &Sealer{Keys: StaticKey(bytes.Repeat([]byte{7}, 32))}
+	machine = NewTicketMachine()
+	machine.Journal = &Journal{W: &journal, Sealer: sealer}
+	machine.SelectTicket("metro", 1)
+	machine.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"})
+	fmt.Println("Card token in journal:", bytes.Contains(journal.Bytes(), []byte("tok_visa")))
+	if plain, err := OpenJournal(&journal, sealer); err != nil {
+		machine.Display.ShowError(err)
+	} else if err := Replay(plain, NewTicketMachine()); err != nil {
+		machine.Display.ShowError(err)
+	}
+
+	fmt.Println("\n--- Sales Store ---")
+	machine = NewTicketMachine()
+	ledger := &MemoryStore{}
+	if err := machine.UseStore(ledger); err != nil {
+		machine.Display.ShowError(err)
+	}
+	machine.SelectTicket("metro", 1)
+	machine.InsertMoney(KZT(200))
+	machine.InsertMoney(KZT(100))
+	machine.DispenseTicket()
+	machine.StartOver()
+	for _, rec := range ledger.Transactions {
+		fmt.Printf("Stored sale %s: %d x %s, tenders %v\n", rec.ID, rec.Quantity, rec.Product, rec.Tenders)
+	}
+	for _, mv := range ledger.Movements {
+		fmt.Printf("Stored movement: %s %+d (%s)\n", mv.TicketType, mv.Delta, mv.Reason)
+	}
+
+	fmt.Println("\n--- Sales Export ---")
+	if err := machine.ExportSales(time.Time{}, time.Time{}, os.Stdout); err != nil {
+		machine.Display.ShowError(err)
+	}
+
+	fmt.Println("\n--- Sales Report ---")
+	if report, err := machine.SalesReport(time.Now().Add(-24*time.Hour), time.Now().Add(time.Hour)); err != nil {
+		machine.Display.ShowError(err)
+	} else {
+		report.WriteJSON(os.Stdout)
+	}
+
+	fmt.Println("\n--- Data Retention ---")
+	machine = NewTicketMachine()
+	clock = &FakeClock{T: time.Date(2026, 1, 5, 13, 0, 0, 0, time.UTC)}
+	machine.Clock = clock
+	machine.Retention.Archive = os.Stdout
+	for _, collect := range []bool{true, false} {
+		machine.SelectTicket("metro", 1)
+		machine.InsertMoney(KZT(200))
+		machine.InsertMoney(KZT(100))
+		machine.DispenseTicket()
+		machine.StartOver()
+		if collect {
+			clock.Advance(time.Hour)
+			machine.CollectCash("collector", "2222")
+		}
+		clock.Advance(100 * 24 * time.Hour)
+	}
+	if pr, err := machine.Prune(); err != nil {
+		machine.Display.ShowError(err)
+	} else {
+		fmt.Printf("Pruned %d sale(s) before %s, %d kept\n", pr.Transactions, pr.Cutoff.Format("2006-01-02"), len(machine.Transactions))
+	}
+
+	fmt.Println("\n--- Config Loading ---")
+	bad := `{"machine_id": "TM-0002", "locale": "de", "products": [{"type": "bus", "name": "Bus", "price": 0,
+		"validity_minutes": 90, "alternatives": ["tram"]}], "denominations": [10000], "hopper": {"5000": 3},
+		"cash_box_capacity": 200, "timeout_seconds": 45, "ticket_key_id": "k1", "ticket_key": "3f9c1e7a5b2d4068"}`
+	if _, err := ReadConfig(strings.NewReader(bad), "json"); err != nil {
+		fmt.Println("Error:", err)
+	}
+	good := strings.NewReplacer(`"de"`, `"ru"`, `"price": 0`, `"price": 25000`, `"tram"`, `"bus"`, `"5000"`, `"10000"`).Replace(bad)
+	if cfg, err := ReadConfig(strings.NewReader(good), "json"); err != nil {
+		fmt.Println("Error:", err)
+	} else if configured, err := NewTicketMachineFrom(cfg); err == nil {
+		fmt.Printf("Configured %s: %d product(s), timeout %s, locale %s\n", configured.MachineID,
+			len(configured.Catalog.List()), configured.Timeout, configured.AudioLocale)
+	}
+	yamlDoc := `machine_id: TM-0003
+locale: kk
+location: {station_id: ABY, station: Abay}
+products:
+  - type: bus
+    name: Bus
+    price: 25000
+    stock: 20
+    validity_minutes: 90
+denominations: [10000, 20000]
+hopper:
+  10000: 5
+cash_box_capacity: 200
+timeout_seconds: 45
+operators:
+  - id: admin
+    pin: "4821"
+    roles: [supervisor]
+ticket_key_id: k1
+ticket_key: 3f9c1e7a5b2d4068
+`
+	if cfg, err := ReadConfig(strings.NewReader(yamlDoc), "yaml"); err != nil {
+		fmt.Println("Error:", err)
+	} else if configured, err := NewTicketMachineFrom(cfg); err == nil {
+		fmt.Printf("Configured %s at %s from YAML: %d product(s), locale %s\n", configured.MachineID,
+			configured.Location.Station, len(configured.Catalog.List()), configured.AudioLocale)
+	}
+
+	fmt.Println("\n--- Config Reload ---")
+	machine = NewTicketMachine()
+	machine.SelectTicket("metro", 1)
+	reload := DefaultConfig()
+	reload.Products[0].Price = KZT(350)
+	reload.Products[1] = ProductSpec{Type: "airport", Name: "Airport express", Price: KZT(1500), VATRate: 1200, Stock: 10, ValidityMinutes: 120}
+	reload.TimeoutSeconds = 90
+	machine.Reload(reload)
+	machine.InsertMoney(KZT(200))
+	machine.InsertMoney(KZT(100))
+	machine.DispenseTicket()
+	machine.StartOver()
+	fmt.Println("Bus on sale:", machine.HasTicket("bus"), "- airport stock:", machine.Catalog.Stock("airport"))
+
+	fmt.Println("\n--- Schema Migrations ---")
+	if list, err := Migrations(); err != nil {
+		fmt.Println("Error:", err)
+	} else {
+		for _, mg := range list {
+			fmt.Printf("Migration %d: %s\n", mg.Version, mg.Name)
+		}
+	}
+
+	fmt.Println("\n--- Scheduled Backups ---")
+	machine = NewTicketMachine()
+	clock = &FakeClock{T: time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)}
+	machine.Clock = clock
+	vault := &MemoryDestination{}
+	machine.Backups = &BackupScheduler{Dest: vault, Interval: time.Hour, Keep: 2, Last: clock.T}
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Hour)
+		machine.Tick()
+	}
+	kept, _ := vault.List()
+	sort.Strings(kept)
+	fmt.Println("Backups kept:", kept)
+	fmt.Println("Verify:", VerifyBackup(vault, kept[0]))
+	vault.Backups[kept[0]][20] ^= 0xff
+	fmt.Println("Verify after corruption:", VerifyBackup(vault, kept[0]))
+
+	fmt.Println("\n--- Catalog Import ---")
+	machine = NewTicketMachine()
+	if s, err := machine.EnterAdminMode(Credentials{OperatorID: "admin", PIN: "0000"}); err == nil {
+		bad := "type,price,stock\nmetro,320.00,-5\nferry,150.00,10\n"
+		if _, err := s.ImportCatalogFrom(strings.NewReader(bad), "csv"); err != nil {
+			fmt.Println("Error:", err)
+		}
+		good := "type,name,price,vat_bp,stock,validity_minutes\nmetro,,320.00,,80,\nferry,Ferry,150.00,1200,10,60\n"
+		s.ImportCatalogFrom(strings.NewReader(good), "csv")
+		s.ImportCatalogFrom(strings.NewReader(`[{"type": "bus", "stock": 0}]`), "json")
+		s.Exit()
+	}
+	fmt.Println("Ferry on sale:", machine.HasTicket("ferry"), "- bus stock:", machine.Catalog.Stock("bus"))
+
+	fmt.Println("\n--- Output Writer ---")
+	var console bytes.Buffer
+	machine = NewTicketMachine(WithOutput(&console))
+	machine.SelectTicket("metro", 1)
+	machine.InsertMoney(KZT(200))
+	machine.InsertMoney(KZT(100))
+	machine.DispenseTicket()
+	fmt.Printf("Captured %d lines of machine output\n", strings.Count(console.String(), "\n"))
+
+	fmt.Println("\n--- Structured Logs ---")
+	var logs bytes.Buffer
+	machine = NewTicketMachine(WithLogHandler(slog.NewJSONHandler(&logs, nil)))
+	machine.SelectTicket("metro", 1)
+	machine.InsertMoney(KZT(500))
+	if d, err := machine.DispenseTicket(); err == nil {
+		for _, line := range strings.Split(logs.String(), "\n") {
+			if strings.Contains(line, `"msg":"sale"`) {
+				fmt.Println(line)
+			}
+		}
+		fmt.Printf("%d log records, %d for ticket %s's transaction\n", strings.Count(logs.String(), "\n"),
+			strings.Count(logs.String(), d.Tickets[0].TransactionID), d.Tickets[0].ID)
+	}
+
+	fmt.Println("\n--- Metrics ---")
+	machine = NewTicketMachine(WithOutput(io.Discard))
+	clock = &FakeClock{T: time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)}
+	machine.Clock = clock
+	machine.EnableMetrics()
+	machine.InsertMoney(KZT(100))
+	machine.SelectTicket("metro", 1)
+	clock.Advance(12 * time.Second)
+	machine.InsertMoney(KZT(500))
+	machine.DispenseTicket()
+	machine.StartOver()
+	scrape := httptest.NewRecorder()
+	machine.MetricsHandler().ServeHTTP(scrape, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range strings.Split(scrape.Body.String(), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") && !strings.Contains(line, "_bucket") &&
+			!strings.HasPrefix(line, "ticket_machine_action_duration_seconds_sum") {
+			fmt.Println(line)
+		}
+	}
+
+	fmt.Println("\n--- Transaction Tracing ---")
+	clock = &FakeClock{T: time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)}
+	tracer := &MemoryTracer{Clock: clock}
+	machine = NewTicketMachine(WithOutput(io.Discard), WithTracerProvider(tracer))
+	machine.Clock = clock
+	machine.Gateway = NewResilientGateway(&MockGateway{Latency: 3 * time.Second, Sleep: clock.Advance}, clock)
+	machine.SelectTicket("metro", 1)
+	clock.Advance(5 * time.Second)
+	machine.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"})
+	machine.DispenseTicket()
+	machine.StartOver()
+	for _, s := range tracer.Spans {
+		fmt.Printf("%s%s %s\n", strings.Repeat("  ", s.Depth()), s.Name, s.Finish.Sub(s.Start))
+	}
+
+	fmt.Println("\n--- Health Checks ---")
+	machine = NewTicketMachine(WithOutput(io.Discard))
+	probe := func(h http.Handler, path string) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var report HealthReport
+		json.Unmarshal(rec.Body.Bytes(), &report)
+		fmt.Printf("%s %d: state %s, can sell %v. %s\n", path, rec.Code, report.State, report.CanSell,
+			strings.Join(report.Reasons, "; "))
+	}
+	health := machine.HealthMonitor()
+	probe(health.ReadinessHandler(), "/readyz")
+	machine.Printer.(*MockTicketPrinter).Err = errors.New("paper jam")
+	machine.EnterMaintenance("admin", "0000", "printer")
+	machine.Tick()
+	probe(health.HealthHandler(), "/healthz")
+	probe(health.ReadinessHandler(), "/readyz")
+	health.Close()
+
+	fmt.Println("\n--- Machine Listeners ---")
+	machine = NewTicketMachine(WithOutput(io.Discard))
+	unsubscribe := machine.Subscribe(func(e MachineEvent) {
+		switch e := e.(type) {
+		case TicketSelected:
+			fmt.Printf("selected %d x %s for %s\n", e.Qty, e.TicketType, e.Price)
+		case MoneyInserted:
+			fmt.Printf("inserted %s, total %s\n", e.Amount, e.Total)
+		case StateChanged:
+			fmt.Printf("state %s -> %s\n", e.From, e.To)
+		case TicketDispensed:
+			fmt.Printf("dispensed %d ticket(s), change %s\n", len(e.Tickets), e.Change)
+		case TransactionCanceled:
+			fmt.Printf("canceled, %s returned\n", e.Paid)
+		}
+	})
+	machine.SelectTicket("bus", 1)
+	machine.InsertMoney(KZT(100))
+	machine.Cancel()
+	machine.StartOver()
+	unsubscribe()
+	machine.SelectTicket("bus", 1)
+
+	fmt.Println("\n--- Webhooks ---")
+	machine = NewTicketMachine(WithOutput(io.Discard))
+	secret := []byte("back-office-secret")
+	var hooks []string
+	failNext := true
+	backOffice := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if failNext {
+			failNext = false
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		hooks = append(hooks, fmt.Sprintf("%s signed=%t", r.Header.Get("X-Webhook-Event"), VerifyWebhook(secret, r.Header, body)))
+	}))
+	gone := httptest.NewServer(http.NotFoundHandler())
+	var deadLetters bytes.Buffer
+	stopHooks := machine.EnableWebhooks(&WebhookDispatcher{
+		Endpoints: []WebhookEndpoint{
+			{URL: backOffice.URL, Secret: secret},
+			{URL: gone.URL, Secret: secret, Events: []string{WebhookFault}},
+		},
+		Sleep:       func(time.Duration) {},
+		DeadLetters: &deadLetters,
+	})
+	machine.SelectTicket("bus", 1)
+	machine.InsertMoney(KZT(500))
+	machine.DispenseTicket()
+	machine.HardwareFault("printer", errors.New("paper jam"))
+	stopHooks()
+	backOffice.Close()
+	gone.Close()
+	for _, h := range hooks {
+		fmt.Println("received", h)
+	}
+	var dl WebhookDeadLetter
+	json.Unmarshal(deadLetters.Bytes(), &dl)
+	fmt.Printf("dead letter: %s after %d attempt(s): %s\n", dl.Payload.Event, dl.Attempts, dl.Error)
+
+	fmt.Println("\n--- Event Publishing ---")
+	machine = NewTicketMachine(WithOutput(io.Discard))
+	broker := &MemoryBus{}
+	stopPublishing, _ := machine.PublishEvents(&EventPublisher{Bus: broker})
+	machine.SelectTicket("bus", 1)
+	machine.Events.Flush()
+	broker.Down = true
+	machine.InsertMoney(KZT(500))
+	machine.DispenseTicket()
+	machine.Events.Flush()
+	fmt.Printf("bus down: %d published, %d buffered\n", len(broker.Messages["ticketmachine.events."+machine.MachineID]), machine.Events.Buffered())
+	broker.Down = false
+	machine.Tick()
+	machine.Events.Flush()
+	for _, raw := range broker.Messages["ticketmachine.events."+machine.MachineID] {
+		var msg EventMessage
+		json.Unmarshal(raw, &msg)
+		fmt.Printf("#%d %s\n", msg.Seq, msg.Type)
+	}
+	stopPublishing()
+
+	fmt.Println("\n--- Audit Chain ---")
+	machine = NewTicketMachine(WithOutput(io.Discard))
+	auditKey := &HMACSigner{ID: "audit-1", Key: []byte("audit-secret")}
+	machine.AuditSigner, machine.AuditCheckpointEvery = auditKey, 2
+	machine.TakeOutOfService("admin", "0000", "cleaning")
+	machine.ReturnToService("admin", "0000")
+	machine.CollectCash("admin", "0000")
+	auditors := NewTicketVerifier(machine.Clock)
+	auditors.AddHMACKey(auditKey.ID, auditKey.Key)
+	fmt.Printf("%d entries, %d checkpoint(s), verify: %v\n", len(machine.AuditLog), len(machine.AuditCheckpoints),
+		VerifyAuditLog(machine.AuditLog, machine.AuditCheckpoints, auditors))
+	machine.AuditLog[1].Detail = "nothing to see"
+	fmt.Println("after edit:", VerifyAuditLog(machine.AuditLog, machine.AuditCheckpoints, auditors))
+	machine.AuditLog[1].Hash = machine.AuditLog[1].chainHash()
+	fmt.Println("after rehash:", VerifyAuditLog(machine.AuditLog, machine.AuditCheckpoints, auditors))
+	fmt.Println("after cut:", VerifyAuditLog(machine.AuditLog[:1], machine.AuditCheckpoints, auditors))
+
+	fmt.Println("\n--- Alert Routing ---")
+	machine = NewTicketMachine(WithOutput(io.Discard))
+	alertClock := &FakeClock{T: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)}
+	machine.Clock = alertClock
+	var pages []string
+	pager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct{ Text string }
+		json.NewDecoder(r.Body).Decode(&msg)
+		pages = append(pages, msg.Text)
+	}))
+	pagerQueue := NewAlertQueue(SlackNotifier{WebhookURL: pager.URL}, 0)
+	machine.Notifier = &ThrottledNotifier{
+		Next: AlertRouter{
+			{Notifier: LogNotifier{}},
+			{MinSeverity: SeverityCritical, Notifier: pagerQueue},
+		},
+		Clock:  alertClock,
+		Window: 10 * time.Minute,
+	}
+	machine.Gateway = &MockGateway{Fail: errors.New("gateway timeout")}
+	for i := 0; i < 3; i++ {
+		machine.SelectTicket("bus", 1)
+		machine.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"})
+		machine.Cancel()
+		machine.StartOver()
+	}
+	for i := 0; i < 3; i++ {
+		machine.coinJam(errors.New("coin stuck"))
+		machine.ClearJam("admin", "0000")
+		alertClock.Advance(6 * time.Minute)
+	}
+	pagerQueue.Close()
+	pager.Close()
+	for _, p := range pages {
+		fmt.Println("paged:", p)
+	}
+
+	fmt.Println("\n--- Live Dashboard ---")
+	machine = NewTicketMachine(WithOutput(io.Discard))
+	dashboard := machine.Dashboard(5)
+	dashSrv := httptest.NewServer(dashboard)
+	machine.SelectTicket("metro", 1)
+	machine.InsertMoney(KZT(100))
+	dashGet := func(path string) (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodGet, dashSrv.URL+path, nil)
+		req.SetBasicAuth("admin", "0000")
+		return http.DefaultClient.Do(req)
+	}
+	var view DashboardView
+	if resp, err := dashGet("/state"); err == nil {
+		json.NewDecoder(resp.Body).Decode(&view)
+		resp.Body.Close()
+	}
+	fmt.Printf("%s %s: %s due %s, %d events\n", view.MachineID, view.State, view.Transaction.TicketType,
+		view.Transaction.Outstanding, len(view.Events))
+	for _, p := range view.Inventory[:2] {
+		fmt.Printf("  %s stock %d\n", p.Type, p.Stock)
+	}
+	if resp, err := dashGet("/events"); err == nil {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		fmt.Println("stream:", strings.HasPrefix(line, "data: {"), resp.Header.Get("Content-Type"))
+		resp.Body.Close()
+	}
+	dashboard.Close()
+	dashSrv.Close()
+
+	fmt.Println("\n--- Printer Failure ---")
+	machine = NewTicketMachine()
+	printer := machine.Printer.(*MockTicketPrinter)
+	printer.Err = errors.New("paper jam")
+	machine.SelectTicket("bus", 2)
+	machine.InsertMoney(KZT(500))
+	if _, err := machine.DispenseTicket(); err != nil {
+		machine.Display.ShowError(err)
+	}
+	printer.Err = nil
+	if d, err := machine.DispenseTicket(); err == nil {
+		fmt.Printf("%d tickets printed on retry\n", len(d.Tickets))
+	}
+	machine.StartOver()
+	printer.Err = errors.New("paper jam")
+	machine.SelectTicket("bus", 1)
+	machine.InsertMoney(KZT(500))
+	machine.DispenseTicket()
+	machine.Cancel()
+	fmt.Println("State:", machine.GetCurrentState())
+	machine.StartOver()
+
+	fmt.Println("\n--- Tamper Detection ---")
+	machine = NewTicketMachine()
+	door := &MockSecuritySensor{}
+	machine.WatchSensor(door)
+	machine.SelectTicket("metro", 1)
+	machine.InsertMoney(KZT(200))
+	door.Trigger(SensorDoorOpen, "front panel")
+	if err := machine.ReturnToService("admin", "0000"); err != nil {
+		machine.Display.ShowError(err)
+	}
+	if err := machine.ClearSecurityIncident("clerk", "1111", "checked"); err != nil {
+		machine.Display.ShowError(err)
+	}
+	machine.ClearSecurityIncident("admin", "0000", "panel was not latched")
+	machine.ReturnToService("admin", "0000")
+	fmt.Printf("Incidents: %d, cleared by %s\n", len(machine.Incidents), machine.Incidents[0].ClearedBy)
+
+	fmt.Println("\n--- Fleet ---")
+	north, south := NewTicketMachine(), NewTicketMachine()
+	north.MachineID, south.MachineID = "TM-0001", "TM-0002"
+	fleet := NewFleet(north, south)
+	fleet.Do("TM-0002", func(m *TicketMachine) error {
+		m.SelectTicket("bus", 2)
+		m.InsertMoney(KZT(500))
+		if _, err := m.DispenseTicket(); err != nil {
+			return err
+		}
+		return m.StartOver()
+	})
+	admin := Credentials{OperatorID: "admin", PIN: "0000"}
+	if err := fleet.PushPrice(admin, "bus", KZT(270)); err != nil {
+		machine.Display.ShowError(err)
+	}
+	fleet.DisableAll(admin, "network maintenance")
+	fleet.EnableAll(admin)
+	sales := fleet.Sales(time.Time{}, time.Now().Add(time.Hour))
+	fmt.Printf("Fleet sales: %d transactions, %s\n", sales.Transactions, sales.Revenue.Format())
+	for _, l := range fleet.Inventory() {
+		if l.TicketType == "bus" {
+			fmt.Printf("Fleet stock of bus: %d\n", l.Count)
+		}
+	}
+	if err := fleet.Do("TM-0009", func(m *TicketMachine) error { return nil }); err != nil {
+		machine.Display.ShowError(err)
+	}
+
+	fmt.Println("\n--- Unavailable Product ---")
+	machine = NewTicketMachine()
+	machine.Catalog.Deactivate("bus")
+	if err := machine.SelectTicket("bus", 1); err != nil {
+		fmt.Printf("Error %s (%s): %v\n", CodeOf(err), CodeOf(err).Category(), err)
+	}
+
+	fmt.Println("\n--- Inventory ---")
+	for _, l := range machine.InventoryReport() {
+		fmt.Printf("%-6s %3d x %s = %s\n", l.TicketType, l.Count, l.Price, l.Value.Format())
+	}
 }
@@ -0,0 +1,99 @@
+package main
+
+import "testing"
+
+func hopperValue(m *TicketMachine) Money {
+	var v Money
+	for d, n := range m.Hopper {
+		v += d * Money(n)
+	}
+	return v
+}
+
+func TestOverpaymentChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		insert []Money
+		change Money
+		state  string
+	}{
+		{"exact amount", []Money{KZT(200), KZT(100)}, 0, (&TicketDispensedState{}).Name()},
+		{"one note over", []Money{KZT(500)}, KZT(200), (&ChangeDispensedState{}).Name()},
+		{"several coins over", []Money{KZT(200), KZT(200)}, KZT(100), (&ChangeDispensedState{}).Name()},
+		{"large note", []Money{KZT(1000)}, KZT(700), (&ChangeDispensedState{}).Name()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			before := hopperValue(m)
+			must(t, m.SelectTicket("metro", 1))
+			for _, a := range tt.insert {
+				must(t, m.InsertMoney(a))
+			}
+			d, err := m.DispenseTicket()
+			must(t, err)
+			if d.Change.Amount != tt.change {
+				t.Errorf("change %s, want %s", d.Change.Amount, tt.change)
+			}
+			var paid Money
+			for _, l := range d.Change.Coins {
+				paid += l.Denomination * Money(l.Count)
+			}
+			if paid != tt.change || hopperValue(m) != before-tt.change {
+				t.Errorf("coins %v for %s, hopper down by %s", d.Change.Coins, tt.change, before-hopperValue(m))
+			}
+			if m.GetCurrentState() != tt.state {
+				t.Errorf("state %s, want %s", m.GetCurrentState(), tt.state)
+			}
+			if m.Transactions[0].Change != tt.change {
+				t.Errorf("recorded change %s, want %s", m.Transactions[0].Change, tt.change)
+			}
+		})
+	}
+}
+
+func TestMultiQuantityPurchase(t *testing.T) {
+	tests := []struct {
+		name string
+		qty  int
+		code ErrorCode
+	}{
+		{"one", 1, ""},
+		{"three", 3, ""},
+		{"whole stock", 10, ""},
+		{"zero", 0, CodeInvalidInput},
+		{"more than in stock", 11, CodeInsufficientStock},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			stock := m.Catalog.Stock("metro")
+			err := m.SelectTicket("metro", tt.qty)
+			if CodeOf(err) != tt.code {
+				t.Fatalf("SelectTicket = %v, want %q", err, tt.code)
+			}
+			if tt.code != "" {
+				return
+			}
+			price := KZT(300) * Money(tt.qty)
+			if m.CurrentPrice != price {
+				t.Fatalf("price = %s, want %s", m.CurrentPrice, price)
+			}
+			for m.InsertedMoney < price {
+				must(t, m.InsertMoney(KZT(100)))
+			}
+			d, err := m.DispenseTicket()
+			must(t, err)
+			ids := map[string]bool{}
+			for _, tk := range d.Tickets {
+				ids[tk.ID] = true
+			}
+			if len(d.Tickets) != tt.qty || len(ids) != tt.qty {
+				t.Fatalf("got %d tickets, %d distinct, want %d", len(d.Tickets), len(ids), tt.qty)
+			}
+			if left := m.Catalog.Stock("metro"); left != stock-tt.qty {
+				t.Fatalf("stock = %d, want %d", left, stock-tt.qty)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DurationBuckets are the upper bounds, in seconds, of the transaction
+// duration histogram.
+var DurationBuckets = []float64{5, 10, 20, 30, 60, 120, 300}
+
+// ActionBuckets are the upper bounds, in seconds, of the customer action
+// latency histogram.
+var ActionBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics counts sales, customer actions by outcome and latency, and time
+// spent per state, and serves
+// them in the Prometheus text format. It is safe to scrape while the
+// machine runs.
+type Metrics struct {
+	mu        sync.Mutex
+	machineID string
+	stationID string
+	currency  Currency
+	sold      map[string]int
+	revenue   Money
+	failed    map[[2]string]int
+	actions   map[actionKey]int
+	latency   map[[2]string]*histogram
+	durations *histogram
+	occupancy map[string]time.Duration
+	state     string
+	entered   time.Time
+}
+
+// EnableMetrics starts collecting metrics for the machine.
+func (m *TicketMachine) EnableMetrics() *Metrics {
+	m.Metrics = &Metrics{
+		machineID: m.MachineID,
+		stationID: m.Location.StationID,
+		currency:  m.Currency,
+		sold:      map[string]int{},
+		failed:    map[[2]string]int{},
+		actions:   map[actionKey]int{},
+		latency:   map[[2]string]*histogram{},
+		durations: newHistogram(DurationBuckets),
+		occupancy: map[string]time.Duration{},
+		state:     m.State.Name(),
+		entered:   m.Clock.Now(),
+	}
+	return m.Metrics
+}
+
+func (x *Metrics) sale(rec TransactionRecord, took time.Duration) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if len(rec.Lines) == 0 {
+		x.sold[rec.Product] += rec.Quantity
+	}
+	for _, l := range rec.Lines {
+		x.sold[l.TicketType] += l.Qty
+	}
+	x.revenue += rec.Price
+	x.durations.observe(took.Seconds())
+}
+
+// actionKey labels an action outcome: the action, the state it was
+// called in and the error code, empty when it succeeded.
+type actionKey struct {
+	action, state, code string
+}
+
+// histogram counts observations per bucket, cumulatively, with their sum
+// and count.
+type histogram struct {
+	buckets []float64
+	counts  []int
+	sum     float64
+	n       int
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]int, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range h.buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.n++
+}
+
+// write puts out the series of h; labels, when set, end in a comma.
+func (h *histogram) write(b *strings.Builder, name, labels string) {
+	for i, le := range h.buckets {
+		fmt.Fprintf(b, "%s_bucket{%sle=\"%g\"} %d\n", name, labels, le, h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.n)
+	if labels != "" {
+		labels = "{" + strings.TrimSuffix(labels, ",") + "}"
+	}
+	fmt.Fprintf(b, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(b, "%s_count%s %d\n", name, labels, h.n)
+}
+
+// action counts a customer action by outcome and records how long it took.
+func (x *Metrics) action(a action, took time.Duration, err error) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	k := actionKey{action: a.name, state: a.state}
+	if err != nil {
+		k.code = string(CodeOf(err))
+		x.failed[[2]string{a.name, k.code}]++
+	}
+	x.actions[k]++
+	h := x.latency[[2]string{a.name, a.state}]
+	if h == nil {
+		h = newHistogram(ActionBuckets)
+		x.latency[[2]string{a.name, a.state}] = h
+	}
+	h.observe(took.Seconds())
+}
+
+func (x *Metrics) transition(to string, now time.Time) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.occupancy[x.state] += now.Sub(x.entered)
+	x.state, x.entered = to, now
+}
+
+// action is a customer action under way.
+type action struct {
+	name, state string
+	start       time.Time
+}
+
+func (m *TicketMachine) startAction(name string) action {
+	return action{name: name, state: m.State.Name(), start: time.Now()}
+}
+
+// endAction counts a customer action by outcome and times it, on the wall
+// clock so that a fake machine clock does not hide slow devices; the
+// public actions defer it on their named error result.
+func (m *TicketMachine) endAction(a action, err *error) {
+	m.Metrics.action(a, time.Since(a.start), *err)
+}
+
+// write puts out the metrics in the Prometheus text exposition format;
+// now closes the time spent in the current state.
+func (x *Metrics) write(w io.Writer, now time.Time) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var b strings.Builder
+	family := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	family("ticket_machine_info", "gauge", "Machine identity.")
+	fmt.Fprintf(&b, "ticket_machine_info{machine_id=%q,station_id=%q} 1\n", x.machineID, x.stationID)
+
+	family("ticket_machine_tickets_sold_total", "counter", "Tickets sold by ticket type.")
+	types := make([]string, 0, len(x.sold))
+	for t := range x.sold {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(&b, "ticket_machine_tickets_sold_total{type=%q} %d\n", t, x.sold[t])
+	}
+	family("ticket_machine_revenue_total", "counter", "Revenue in major units of the machine currency.")
+	fmt.Fprintf(&b, "ticket_machine_revenue_total{currency=%q} %s\n", x.currency, x.revenue)
+
+	family("ticket_machine_failed_actions_total", "counter", "Customer actions that failed, by error code.")
+	keys := make([][2]string, 0, len(x.failed))
+	for k := range x.failed {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "ticket_machine_failed_actions_total{action=%q,code=%q} %d\n", k[0], k[1], x.failed[k])
+	}
+
+	family("ticket_machine_actions_total", "counter", "Customer actions by the state they were called in and outcome.")
+	akeys := make([]actionKey, 0, len(x.actions))
+	for k := range x.actions {
+		akeys = append(akeys, k)
+	}
+	sort.Slice(akeys, func(i, j int) bool {
+		a, b := akeys[i], akeys[j]
+		if a.action != b.action {
+			return a.action < b.action
+		}
+		if a.state != b.state {
+			return a.state < b.state
+		}
+		return a.code < b.code
+	})
+	for _, k := range akeys {
+		outcome := "ok"
+		if k.code != "" {
+			outcome = "error"
+		}
+		fmt.Fprintf(&b, "ticket_machine_actions_total{action=%q,state=%q,outcome=%q,code=%q} %d\n",
+			k.action, k.state, outcome, k.code, x.actions[k])
+	}
+
+	family("ticket_machine_action_duration_seconds", "histogram", "Time taken by customer actions, by the state they were called in.")
+	lkeys := make([][2]string, 0, len(x.latency))
+	for k := range x.latency {
+		lkeys = append(lkeys, k)
+	}
+	sort.Slice(lkeys, func(i, j int) bool {
+		return lkeys[i][0] < lkeys[j][0] || lkeys[i][0] == lkeys[j][0] && lkeys[i][1] < lkeys[j][1]
+	})
+	for _, k := range lkeys {
+		x.latency[k].write(&b, "ticket_machine_action_duration_seconds", fmt.Sprintf("action=%q,state=%q,", k[0], k[1]))
+	}
+
+	family("ticket_machine_transaction_duration_seconds", "histogram", "Time from the start of a sale to its payment.")
+	x.durations.write(&b, "ticket_machine_transaction_duration_seconds", "")
+
+	family("ticket_machine_state_seconds_total", "counter", "Time spent in each state.")
+	occupancy := map[string]time.Duration{x.state: now.Sub(x.entered)}
+	for s, d := range x.occupancy {
+		occupancy[s] += d
+	}
+	states := make([]string, 0, len(occupancy))
+	for s := range occupancy {
+		states = append(states, s)
+	}
+	sort.Strings(states)
+	for _, s := range states {
+		fmt.Fprintf(&b, "ticket_machine_state_seconds_total{state=%q} %g\n", s, occupancy[s].Seconds())
+	}
+	family("ticket_machine_state", "gauge", "The current state.")
+	fmt.Fprintf(&b, "ticket_machine_state{state=%q} 1\n", x.state)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// MetricsHandler serves the machine metrics for Prometheus at /metrics.
+// It keeps the metrics and clock the machine has when it is built, so it
+// never reads the machine from the server goroutine; it answers 404 when
+// metrics were not enabled by then.
+func (m *TicketMachine) MetricsHandler() http.Handler {
+	x, clock := m.Metrics, m.Clock
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if x == nil {
+			http.Error(w, "metrics not enabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		x.write(w, clock.Now())
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsSaleDurations(t *testing.T) {
+	m, _ := newTestMachine(t)
+	x := m.EnableMetrics()
+	for _, took := range []time.Duration{3 * time.Second, 8 * time.Second, 8 * time.Second, 10 * time.Minute} {
+		x.sale(TransactionRecord{Product: "metro", Quantity: 1, Price: KZT(250)}, took)
+	}
+	var b strings.Builder
+	must(t, x.write(&b, m.Clock.Now()))
+	for _, want := range []string{
+		`ticket_machine_transaction_duration_seconds_bucket{le="5"} 1`,
+		`ticket_machine_transaction_duration_seconds_bucket{le="10"} 3`,
+		`ticket_machine_transaction_duration_seconds_bucket{le="300"} 3`,
+		`ticket_machine_transaction_duration_seconds_bucket{le="+Inf"} 4`,
+		`ticket_machine_transaction_duration_seconds_sum 619`,
+		`ticket_machine_transaction_duration_seconds_count 4`,
+		`ticket_machine_tickets_sold_total{type="metro"} 4`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
+
+func TestMetricsHandlerBeforeEnable(t *testing.T) {
+	m, _ := newTestMachine(t)
+	before := m.MetricsHandler()
+	scrape := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec.Code
+	}
+	if code := scrape(before); code != http.StatusNotFound {
+		t.Errorf("before EnableMetrics: %d, want 404", code)
+	}
+	m.EnableMetrics()
+	if code := scrape(before); code != http.StatusNotFound {
+		t.Errorf("handler built before EnableMetrics: %d, want 404", code)
+	}
+	if code := scrape(m.MetricsHandler()); code != http.StatusOK {
+		t.Errorf("after EnableMetrics: %d, want 200", code)
+	}
+}
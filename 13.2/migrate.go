@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrations are the schema changes of the SQL storage, one file per
+// version named NNN_description.sql. Released files must not be edited;
+// a schema change ships as a new file.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations lists the embedded migrations in version order.
+func Migrations() ([]Migration, error) {
+	files, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	var list []Migration
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		v, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, newErrorf(CodeStorage, "migration %s: no version number", f.Name())
+		}
+		raw, err := migrations.ReadFile(path.Join("migrations", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Migration{Version: v, Name: name, SQL: string(raw)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	for i := 1; i < len(list); i++ {
+		if list[i].Version == list[i-1].Version {
+			return nil, newErrorf(CodeStorage, "migrations %s and %s share a version", list[i-1].Name, list[i].Name)
+		}
+	}
+	return list, nil
+}
+
+// Migrate brings the schema of db up to date, applying each pending
+// migration in its own transaction and recording it in
+// schema_migrations. It returns the schema version reached.
+func Migrate(db *sql.DB) (int, error) {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return 0, err
+	}
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return 0, err
+	}
+	list, err := Migrations()
+	if err != nil {
+		return current, err
+	}
+	for _, mg := range list {
+		if mg.Version <= current {
+			continue
+		}
+		if err := applyMigration(db, mg); err != nil {
+			return current, newErrorf(CodeStorage, "migration %s: %w", mg.Name, err)
+		}
+		current = mg.Version
+	}
+	return current, nil
+}
+
+func applyMigration(db *sql.DB, mg Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// Not every driver runs several statements in one Exec.
+	for _, stmt := range splitSQL(mg.SQL) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		mg.Version, mg.Name, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// splitSQL splits a script into statements at the semicolons that end
+// them: not those in quoted strings and identifiers, in comments, or
+// between the BEGIN and END of a trigger body.
+func splitSQL(script string) []string {
+	var list []string
+	start, depth, trigger := 0, 0, false
+	add := func(end int) {
+		if stmt := strings.TrimSpace(script[start:end]); stmt != "" {
+			list = append(list, stmt)
+		}
+		start, depth, trigger = end+1, 0, false
+	}
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// A doubled quote inside is read as the end and
+			// start of two quoted runs, which is the same thing.
+			j := strings.IndexByte(script[i+1:], c)
+			if j < 0 {
+				i = len(script)
+			} else {
+				i += j + 1
+			}
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			j := strings.IndexByte(script[i:], '\n')
+			if j < 0 {
+				i = len(script)
+			} else {
+				i += j
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			j := strings.Index(script[i+2:], "*/")
+			if j < 0 {
+				i = len(script)
+			} else {
+				i += j + 3
+			}
+		case isSQLWordByte(c) && (i == 0 || !isSQLWordByte(script[i-1])):
+			j := i
+			for j < len(script) && isSQLWordByte(script[j]) {
+				j++
+			}
+			switch strings.ToUpper(script[i:j]) {
+			case "TRIGGER":
+				trigger = true
+			case "BEGIN":
+				if trigger {
+					depth++
+				}
+			case "CASE":
+				depth++
+			case "END":
+				if depth > 0 {
+					depth--
+				}
+			}
+			i = j - 1
+		case c == ';' && depth == 0:
+			add(i)
+		}
+	}
+	if start < len(script) {
+		add(len(script))
+	}
+	return list
+}
+
+func isSQLWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSQL(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"statements", "CREATE TABLE a (x INTEGER);\n\nCREATE TABLE b (y INTEGER);\n",
+			[]string{"CREATE TABLE a (x INTEGER)", "CREATE TABLE b (y INTEGER)"}},
+		{"no final semicolon", "SELECT 1", []string{"SELECT 1"}},
+		{"literal", "INSERT INTO a VALUES ('x;y', 'it''s;');",
+			[]string{"INSERT INTO a VALUES ('x;y', 'it''s;')"}},
+		{"quoted identifier", `CREATE TABLE "a;b" (x INTEGER);`, []string{`CREATE TABLE "a;b" (x INTEGER)`}},
+		{"comments", "-- drop; it\nSELECT 1; /* two; */ SELECT 2;", []string{"-- drop; it\nSELECT 1", "/* two; */ SELECT 2"}},
+		{"trigger", "CREATE TRIGGER t AFTER INSERT ON a BEGIN\n\tUPDATE b SET y = CASE WHEN y > 0 THEN y ELSE 0 END;\n\tDELETE FROM c;\nEND;\nSELECT 1;",
+			[]string{"CREATE TRIGGER t AFTER INSERT ON a BEGIN\n\tUPDATE b SET y = CASE WHEN y > 0 THEN y ELSE 0 END;\n\tDELETE FROM c;\nEND", "SELECT 1"}},
+		{"transaction", "BEGIN; SELECT 1; END;", []string{"BEGIN", "SELECT 1", "END"}},
+	}
+	for _, tt := range tests {
+		if got := splitSQL(tt.script); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMigrationsSplit(t *testing.T) {
+	list, err := Migrations()
+	must(t, err)
+	for _, mg := range list {
+		if len(splitSQL(mg.SQL)) == 0 {
+			t.Errorf("%s: no statements", mg.Name)
+		}
+	}
+}
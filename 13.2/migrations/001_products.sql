@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS products (
+	type TEXT PRIMARY KEY,
+	price INTEGER NOT NULL,
+	stock INTEGER NOT NULL
+);
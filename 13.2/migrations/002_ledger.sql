@@ -0,0 +1,54 @@
+CREATE TABLE IF NOT EXISTS transactions (
+	id TEXT PRIMARY KEY,
+	time TIMESTAMP NOT NULL,
+	machine_id TEXT NOT NULL,
+	product TEXT NOT NULL,
+	quantity INTEGER NOT NULL,
+	category TEXT NOT NULL,
+	price INTEGER NOT NULL,
+	vat INTEGER NOT NULL,
+	promo_code TEXT NOT NULL,
+	promo_discount INTEGER NOT NULL,
+	change INTEGER NOT NULL,
+	donation INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS transaction_lines (
+	transaction_id TEXT NOT NULL REFERENCES transactions(id),
+	line INTEGER NOT NULL,
+	ticket_type TEXT NOT NULL,
+	qty INTEGER NOT NULL,
+	unit_price INTEGER NOT NULL,
+	discount INTEGER NOT NULL,
+	PRIMARY KEY (transaction_id, line)
+);
+
+CREATE TABLE IF NOT EXISTS tenders (
+	transaction_id TEXT NOT NULL REFERENCES transactions(id),
+	tender TEXT NOT NULL,
+	amount INTEGER NOT NULL,
+	PRIMARY KEY (transaction_id, tender)
+);
+
+CREATE TABLE IF NOT EXISTS refunds (
+	ticket_id TEXT PRIMARY KEY,
+	transaction_id TEXT NOT NULL,
+	time TIMESTAMP NOT NULL,
+	amount INTEGER NOT NULL,
+	tender TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS movements (
+	time TIMESTAMP NOT NULL,
+	ticket_type TEXT NOT NULL,
+	delta INTEGER NOT NULL,
+	balance INTEGER NOT NULL,
+	actor TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	reference TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS config (
+	version INTEGER PRIMARY KEY,
+	document TEXT NOT NULL
+);
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS transactions_time ON transactions (time);
+
+CREATE INDEX IF NOT EXISTS movements_time ON movements (time);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS refund_parts (
+	ticket_id TEXT NOT NULL REFERENCES refunds(ticket_id),
+	tender TEXT NOT NULL,
+	amount INTEGER NOT NULL,
+	PRIMARY KEY (ticket_id, tender)
+);
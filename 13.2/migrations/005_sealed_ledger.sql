@@ -0,0 +1,3 @@
+ALTER TABLE transactions ADD COLUMN sealed BLOB;
+
+ALTER TABLE refunds ADD COLUMN sealed BLOB;
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in minor currency units (tiyn for KZT), so prices and
+// inserted cash can be added and compared without float rounding errors.
+type Money int64
+
+// MinorUnits is the number of minor units in one major unit.
+const MinorUnits = 100
+
+// KZT converts a whole number of tenge into Money.
+func KZT(tenge int64) Money {
+	return Money(tenge * MinorUnits)
+}
+
+// Major returns the whole part of the amount, e.g. 300 for 300.50.
+func (m Money) Major() int64 { return int64(m) / MinorUnits }
+
+// Minor returns the fractional part of the amount in minor units.
+func (m Money) Minor() int64 { return int64(m) % MinorUnits }
+
+// String formats the amount with two decimals, e.g. "300.00".
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/MinorUnits, v%MinorUnits)
+}
+
+// Format formats the amount in tenge, e.g. "300.00 KZT".
+func (m Money) Format() string {
+	return m.In(CurrencyKZT)
+}
+
+// ParseMoney parses a decimal string such as "300", "300.5" or "300.50".
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, frac, _ := strings.Cut(s, ".")
+	if !digits(whole) || len(frac) > 2 || (frac != "" && !digits(frac)) {
+		return 0, newError(CodeInvalidAmount, "invalid amount")
+	}
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, newError(CodeInvalidAmount, "invalid amount")
+	}
+	var f int64
+	if frac != "" {
+		for len(frac) < 2 {
+			frac += "0"
+		}
+		if f, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return 0, newError(CodeInvalidAmount, "invalid amount")
+		}
+	}
+	v := Money(w*MinorUnits + f)
+	if neg {
+		v = -v
+	}
+	return v, nil
+}
+
+// digits reports whether s is a non-empty run of ASCII digits.
+func digits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
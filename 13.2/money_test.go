@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		ok   bool
+	}{
+		{"300", KZT(300), true},
+		{"300.5", 30050, true},
+		{"300.50", 30050, true},
+		{" 3.05 ", 305, true},
+		{"-3.5", -350, true},
+		{"", 0, false},
+		{".5", 0, false},
+		{"3.505", 0, false},
+		{"3.-5", 0, false},
+		{"3.+5", 0, false},
+		{"+3", 0, false},
+		{"--3", 0, false},
+		{"3a", 0, false},
+		{"3.5a", 0, false},
+		{"1_000", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseMoney(tt.in)
+			if !tt.ok {
+				if CodeOf(err) != CodeInvalidAmount {
+					t.Fatalf("ParseMoney(%q) = %s, %v; want %s", tt.in, got, err, CodeInvalidAmount)
+				}
+				return
+			}
+			must(t, err)
+			if got != tt.want {
+				t.Errorf("ParseMoney(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// MovementReason is why stock changed.
+type MovementReason string
+
+const (
+	MoveSale      MovementReason = "sale"
+	MoveRestock   MovementReason = "restock"
+	MoveShrinkage MovementReason = "shrinkage"
+	MoveRefund    MovementReason = "refund"
+)
+
+// StockMovement is one change of a product's stock.
+type StockMovement struct {
+	Time       time.Time
+	TicketType string
+	Delta      int
+	// Balance is the stock after the change.
+	Balance int
+	Actor   string
+	Reason  MovementReason
+	// Reference is the transaction, ticket or note behind the change.
+	Reference string
+}
+
+// MovementLog is the append-only record of stock movements.
+type MovementLog struct {
+	entries []StockMovement
+}
+
+func (l *MovementLog) append(e StockMovement) {
+	l.entries = append(l.entries, e)
+}
+
+// prune drops the movements before cutoff and returns how many.
+func (l *MovementLog) prune(cutoff time.Time) int {
+	kept := l.entries[:0]
+	for _, e := range l.entries {
+		if !e.Time.Before(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	n := len(l.entries) - len(kept)
+	l.entries = kept
+	return n
+}
+
+// Query returns the movements of ticketType (every product when empty)
+// with from <= Time < to; a zero bound is open.
+func (l *MovementLog) Query(ticketType string, from, to time.Time) []StockMovement {
+	var out []StockMovement
+	for _, e := range l.entries {
+		if ticketType != "" && e.TicketType != ticketType {
+			continue
+		}
+		if !from.IsZero() && e.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !e.Time.Before(to) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// recordMovement logs a stock change that has just been made.
+func (m *TicketMachine) recordMovement(ticketType string, delta int, actor string, reason MovementReason, ref string) {
+	p, _ := m.Catalog.Product(ticketType)
+	mv := StockMovement{
+		Time:       m.Clock.Now(),
+		TicketType: ticketType,
+		Delta:      delta,
+		Balance:    p.Stock,
+		Actor:      actor,
+		Reason:     reason,
+		Reference:  ref,
+	}
+	m.Movements.append(mv)
+	m.store("stock movement", func(s Store) error { return s.SaveMovement(mv) })
+}
+
+// AdjustStock corrects stock after a count, e.g. for lost or damaged
+// tickets; delta is usually negative.
+func (s *AdminSession) AdjustStock(ticketType string, delta int, note string) error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	if err := s.m.authorize(s.OperatorID, PermRestock, "adjust_stock"); err != nil {
+		return err
+	}
+	if note == "" {
+		return newError(CodeInvalidInput, "adjustment needs a note")
+	}
+	if err := s.m.Catalog.Adjust(ticketType, delta); err != nil {
+		return err
+	}
+	s.m.persist(ticketType)
+	s.m.recordMovement(ticketType, delta, s.OperatorID, MoveShrinkage, note)
+	s.m.audit(s.OperatorID, "adjust_stock", fmt.Sprintf("%s %+d: %s", ticketType, delta, note))
+	return nil
+}
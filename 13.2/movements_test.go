@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMovementLog(t *testing.T) {
+	m, clock := newTestMachine(t)
+	start := m.Catalog.Stock("metro")
+	sellMetro(t, m)
+	must(t, m.StartOver())
+	clock.Advance(time.Hour)
+	restocked := clock.Now()
+	s, err := m.EnterAdminMode(Credentials{OperatorID: "admin", PIN: "0000"})
+	must(t, err)
+	must(t, s.Restock("metro", 3))
+	if err := s.AdjustStock("metro", -1, ""); CodeOf(err) != CodeInvalidInput {
+		t.Fatalf("AdjustStock without a note = %v, want %s", err, CodeInvalidInput)
+	}
+	must(t, s.AdjustStock("metro", -1, "torn"))
+	must(t, s.Restock("bus", 1))
+	must(t, s.Exit())
+
+	want := []StockMovement{
+		{Delta: -1, Balance: start - 1, Actor: "customer", Reason: MoveSale},
+		{Delta: 3, Balance: start + 2, Actor: "admin", Reason: MoveRestock},
+		{Delta: -1, Balance: start + 1, Actor: "admin", Reason: MoveShrinkage, Reference: "torn"},
+	}
+	got := m.Movements.Query("metro", time.Time{}, time.Time{})
+	if len(got) != len(want) {
+		t.Fatalf("metro movements = %+v", got)
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.TicketType != "metro" || g.Delta != w.Delta || g.Balance != w.Balance || g.Actor != w.Actor || g.Reason != w.Reason {
+			t.Errorf("movement %d = %+v, want %+v", i, g, w)
+		}
+		if w.Reference != "" && g.Reference != w.Reference {
+			t.Errorf("movement %d reference %q, want %q", i, g.Reference, w.Reference)
+		}
+	}
+	if got[0].Reference == "" {
+		t.Error("sale movement has no transaction reference")
+	}
+
+	if n := len(m.Movements.Query("", time.Time{}, time.Time{})); n != 4 {
+		t.Errorf("%d movements in total, want 4", n)
+	}
+	if n := len(m.Movements.Query("metro", time.Time{}, restocked)); n != 1 {
+		t.Errorf("%d metro movements before the restock, want 1", n)
+	}
+	if n := len(m.Movements.Query("metro", restocked, time.Time{})); n != 2 {
+		t.Errorf("%d metro movements from the restock on, want 2", n)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+// Card entry modes reported in CardDetails.EntryMode.
+const (
+	EntryChip        = "chip"
+	EntryMagstripe   = "magstripe"
+	EntryContactless = "contactless"
+)
+
+// NFCReader reads a contactless card or phone wallet held to the reader.
+type NFCReader interface {
+	ReadCard() (CardDetails, error)
+}
+
+// MockNFCReader always reads Card, or fails with Err when it is set.
+type MockNFCReader struct {
+	Card CardDetails
+	Err  error
+}
+
+func (r *MockNFCReader) ReadCard() (CardDetails, error) {
+	if r.Err != nil {
+		return CardDetails{}, r.Err
+	}
+	return r.Card, nil
+}
+
+// TapToPay pays with a contactless tap. From the ready state it selects
+// ticketType as SelectTicket does and pays in the same step; when the
+// ticket needs a destination, journey or seat first, the selection stays
+// and the customer taps again once it is made. Once a ticket is selected
+// it behaves like PayByCard with the tapped card.
+func (m *TicketMachine) TapToPay(ticketType string) (err error) {
+	defer m.endAction(m.startAction("tap"), &err)
+	if err := m.logAction(JournalEntry{Action: "tap", TicketType: ticketType}); err != nil {
+		return err
+	}
+	if err := m.inService(); err != nil {
+		return err
+	}
+	if m.NFCReader == nil {
+		return newError(CodePaymentUnavailable, "contactless payments not available")
+	}
+	switch m.State.(type) {
+	case *IdleState, *CashBoxFullState:
+		if err := m.selectTicket(ticketType, 1); err != nil {
+			return err
+		}
+		if _, ok := m.State.(*WaitingForMoneyState); !ok {
+			return newError(CodeStepRequired, "please complete the selection, then tap again")
+		}
+	}
+	card, err := m.readNFC()
+	if err != nil {
+		return newError(CodeCardRead, "card could not be read")
+	}
+	return m.State.PayByCard(m, card)
+}
+
+func (m *TicketMachine) readNFC() (CardDetails, error) {
+	var card CardDetails
+	err := m.deviceCall("nfc.read", &card, func() (err error) {
+		card, err = m.NFCReader.ReadCard()
+		return err
+	})
+	return card, err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTapToPay(t *testing.T) {
+	tests := []struct {
+		name       string
+		ticketType string
+		readErr    error
+		code       ErrorCode
+		state      string
+		paid       bool
+	}{
+		{"metro pays in one tap", "metro", nil, "", "", true},
+		{"tram asks for the journey", "tram", nil, CodeStepRequired, (&SelectJourneyState{}).Name(), false},
+		{"train asks for the seat", "train", nil, CodeStepRequired, (&SelectSeatState{}).Name(), false},
+		{"unreadable card keeps the selection", "metro", errors.New("no card"), CodeCardRead, (&WaitingForMoneyState{}).Name(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.NFCReader.(*MockNFCReader).Err = tt.readErr
+			selected := 0
+			m.Subscribe(func(e MachineEvent) {
+				if _, ok := e.(TicketSelected); ok {
+					selected++
+				}
+			})
+			err := m.TapToPay(tt.ticketType)
+			if tt.code == "" {
+				must(t, err)
+			} else if CodeOf(err) != tt.code {
+				t.Fatalf("err = %v, want %s", err, tt.code)
+			}
+			if selected != 1 || m.CurrentTicket != tt.ticketType {
+				t.Errorf("%d TicketSelected events, current ticket %q", selected, m.CurrentTicket)
+			}
+			if tt.state != "" && m.GetCurrentState() != tt.state {
+				t.Errorf("state %s, want %s", m.GetCurrentState(), tt.state)
+			}
+			if paid := m.Outstanding() == 0; paid != tt.paid {
+				t.Errorf("paid = %v, want %v", paid, tt.paid)
+			}
+		})
+	}
+}
+
+func TestTapToPayAfterSeat(t *testing.T) {
+	m, _ := newTestMachine(t)
+	if err := m.TapToPay("train"); CodeOf(err) != CodeStepRequired {
+		t.Fatalf("err = %v, want %s", err, CodeStepRequired)
+	}
+	must(t, m.SelectSeat(Seat{Coach: "1", Number: "1"}))
+	must(t, m.TapToPay("train"))
+	d, err := m.DispenseTicket()
+	must(t, err)
+	if len(d.Tickets) != 1 || d.Tickets[0].Seat == "" {
+		t.Errorf("tickets = %+v, want one seated ticket", d.Tickets)
+	}
+}
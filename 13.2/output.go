@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Option configures a machine built by NewTicketMachine or
+// NewTicketMachineFrom.
+type Option func(*machineOptions)
+
+type machineOptions struct {
+	out          io.Writer
+	logHandler   slog.Handler
+	tracing      TracerProvider
+	transitCards TransitCardDevice
+}
+
+// WithOutput sends the console output of the machine and of its console
+// and mock devices to w instead of stdout.
+func WithOutput(w io.Writer) Option {
+	return func(o *machineOptions) { o.out = w }
+}
+
+// out is where the machine writes operator messages.
+func (m *TicketMachine) out() io.Writer { return orStdout(m.Out) }
+
+func orStdout(w io.Writer) io.Writer {
+	if w == nil {
+		return os.Stdout
+	}
+	return w
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithOutput(t *testing.T) {
+	var console bytes.Buffer
+	m := NewTicketMachine(WithOutput(&console))
+	m.Clock = &FakeClock{T: time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)}
+	m.LastActivity = m.Clock.Now()
+	sellMetro(t, m)
+	must(t, m.StartOver())
+	must(t, m.BeginRestock("clerk", "1111"))
+	for _, want := range []string{"Ticket selected: metro", "Ticket: metro", "Restocking. Out of service."} {
+		if !strings.Contains(console.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, console.String())
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+// OverpaymentPolicy decides what happens to money inserted beyond the price.
+type OverpaymentPolicy int
+
+const (
+	// ReturnChange accepts excess cash and pays it back as change.
+	ReturnChange OverpaymentPolicy = iota
+	// RejectExcess refuses any coin or note that would exceed the price.
+	RejectExcess
+	// DonateRemainder accepts excess cash and keeps it as a donation.
+	DonateRemainder
+)
+
+func (p OverpaymentPolicy) String() string {
+	switch p {
+	case ReturnChange:
+		return "ReturnChange"
+	case RejectExcess:
+		return "RejectExcess"
+	case DonateRemainder:
+		return "DonateRemainder"
+	}
+	return "Unknown"
+}
+
+// overpaymentPolicy is the policy in force right now. Exact-change mode
+// turns ReturnChange into RejectExcess, since no change can be given.
+func (m *TicketMachine) overpaymentPolicy() OverpaymentPolicy {
+	if m.OverpaymentPolicy == ReturnChange && m.ExactChangeRequired() {
+		return RejectExcess
+	}
+	return m.OverpaymentPolicy
+}
+
+// admitCash applies the overpayment policy to a coin or note about to be
+// credited.
+func (m *TicketMachine) admitCash(amount Money) error {
+	over := m.PaidTotal() + amount - m.CurrentPrice
+	if over <= 0 {
+		return nil
+	}
+	switch m.overpaymentPolicy() {
+	case RejectExcess:
+		if m.ExactChangeRequired() {
+			return newError(CodeCannotMakeChange, "exact change only")
+		}
+		return newError(CodeInvalidAmount, "amount exceeds price, please insert a smaller amount")
+	case ReturnChange:
+		if !m.CanMakeChange(over) {
+			return newError(CodeCannotMakeChange, "cannot make change, please insert a smaller amount")
+		}
+	}
+	return nil
+}
+
+// changeOwed is the part of the overpayment to be paid back as change.
+func (m *TicketMachine) changeOwed() Money {
+	if m.overpaymentPolicy() == DonateRemainder {
+		return 0
+	}
+	return m.Overpayment
+}
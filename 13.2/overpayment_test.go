@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestOverpaymentPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   OverpaymentPolicy
+		exact    bool
+		code     ErrorCode
+		change   Money
+		donation Money
+	}{
+		{"return change", ReturnChange, false, "", KZT(100), 0},
+		{"reject excess", RejectExcess, false, CodeInvalidAmount, 0, 0},
+		{"donate remainder", DonateRemainder, false, "", 0, KZT(100)},
+		{"exact change turns return into reject", ReturnChange, true, CodeCannotMakeChange, 0, 0},
+		{"exact change still takes donations", DonateRemainder, true, "", 0, KZT(100)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.OverpaymentPolicy = tt.policy
+			m.SetExactChangeOnly(tt.exact)
+			must(t, m.SelectTicket("metro", 1))
+			must(t, m.InsertMoney(KZT(200)))
+			err := m.InsertMoney(KZT(200))
+			if CodeOf(err) != tt.code {
+				t.Fatalf("InsertMoney = %v, want %q", err, tt.code)
+			}
+			if tt.code != "" {
+				if m.InsertedMoney != KZT(200) {
+					t.Fatalf("refused coin credited: inserted %s", m.InsertedMoney)
+				}
+				return
+			}
+			d, err := m.DispenseTicket()
+			must(t, err)
+			if d.Change.Amount != tt.change || m.Donations != tt.donation {
+				t.Fatalf("change %s, donations %s; want %s, %s", d.Change.Amount, m.Donations, tt.change, tt.donation)
+			}
+			if rec := m.Transactions[len(m.Transactions)-1]; rec.Donation != tt.donation {
+				t.Fatalf("recorded donation = %s", rec.Donation)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strconv"
+)
+
+// Overrides are the settings taken from the environment and the command
+// line, layered over the configuration file so one binary serves every
+// kiosk. Empty strings and a nil APIPort leave the file's value.
+type Overrides struct {
+	ConfigPath string
+	MachineID  string
+	Locale     string
+	// APIPort is set when the environment or the command line gives a
+	// port, 0 included.
+	APIPort *int
+}
+
+// Environment variables read by ParseOverrides; flags of the same name
+// (-config, -machine-id, -locale, -api-port) take precedence.
+const (
+	EnvConfigPath = "TM_CONFIG"
+	EnvMachineID  = "TM_MACHINE_ID"
+	EnvLocale     = "TM_LOCALE"
+	EnvAPIPort    = "TM_API_PORT"
+)
+
+// ParseOverrides reads the overrides from getenv, usually os.Getenv, and
+// then from args, usually os.Args[1:].
+func ParseOverrides(args []string, getenv func(string) string, usage io.Writer) (Overrides, error) {
+	o := Overrides{
+		ConfigPath: getenv(EnvConfigPath),
+		MachineID:  getenv(EnvMachineID),
+		Locale:     getenv(EnvLocale),
+	}
+	if v := getenv(EnvAPIPort); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return Overrides{}, newErrorf(CodeInvalidConfig, "%s: %q is not a port", EnvAPIPort, v)
+		}
+		o.APIPort = &port
+	}
+	fs := flag.NewFlagSet("ticketmachine", flag.ContinueOnError)
+	fs.SetOutput(usage)
+	fs.StringVar(&o.ConfigPath, "config", o.ConfigPath, "configuration file (.json, .yaml)")
+	fs.StringVar(&o.MachineID, "machine-id", o.MachineID, "machine ID")
+	fs.StringVar(&o.Locale, "locale", o.Locale, "customer locale")
+	port := fs.Int("api-port", 0, "port of the local API, 0 to disable")
+	if err := fs.Parse(args); err != nil {
+		return Overrides{}, newErrorf(CodeInvalidConfig, "command line: %w", err)
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "api-port" {
+			o.APIPort = port
+		}
+	})
+	return o, nil
+}
+
+// Apply layers the overrides over cfg.
+func (o Overrides) Apply(cfg *Config) {
+	if o.MachineID != "" {
+		cfg.MachineID = o.MachineID
+	}
+	if o.Locale != "" {
+		cfg.Locale = o.Locale
+	}
+	if o.APIPort != nil {
+		cfg.APIPort = *o.APIPort
+	}
+}
+
+// LoadLayeredConfig builds the configuration of a kiosk: the file named
+// by the overrides, DefaultConfig when none, with the overrides on top.
+func LoadLayeredConfig(args []string, getenv func(string) string, usage io.Writer) (Config, error) {
+	o, err := ParseOverrides(args, getenv, usage)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg := DefaultConfig()
+	if o.ConfigPath != "" {
+		if cfg, err = LoadConfigFile(o.ConfigPath); err != nil {
+			return Config{}, err
+		}
+	}
+	o.Apply(&cfg)
+	return cfg, cfg.Validate()
+}
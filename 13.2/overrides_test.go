@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOverridesAPIPort(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  string
+		want int
+	}{
+		{"file", nil, "", 9000},
+		{"environment", nil, "8080", 8080},
+		{"flag over environment", []string{"-api-port", "8081"}, "8080", 8081},
+		{"flag disables", []string{"-api-port=0"}, "", 0},
+		{"environment disables", nil, "0", 0},
+	}
+	for _, tt := range tests {
+		getenv := func(k string) string {
+			if k == EnvAPIPort {
+				return tt.env
+			}
+			return ""
+		}
+		o, err := ParseOverrides(tt.args, getenv, io.Discard)
+		must(t, err)
+		cfg := Config{APIPort: 9000}
+		o.Apply(&cfg)
+		if cfg.APIPort != tt.want {
+			t.Errorf("%s: api port %d, want %d", tt.name, cfg.APIPort, tt.want)
+		}
+	}
+}
+
+func TestAPIRoutes(t *testing.T) {
+	m, _ := newTestMachine(t)
+	a := m.API()
+	defer a.Close()
+	for path, want := range map[string]int{"/healthz": http.StatusOK, "/metrics": http.StatusNotFound, "/state": http.StatusUnauthorized} {
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != want {
+			t.Errorf("%s: status %d, want %d", path, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import "fmt"
+
+// ReasonOutOfPaper is the reason code of a machine that ran out of ticket
+// paper with no e-ticket delivery to sell instead.
+const ReasonOutOfPaper ReasonCode = "out_of_paper"
+
+// PaperSupply tracks the ticket paper left in the printer, counted in
+// tickets.
+type PaperSupply struct {
+	Remaining int
+	Capacity  int
+	// LowAt is the level at which a low-paper alert is raised.
+	LowAt int
+}
+
+// PaperLevel is the paper state reported to operators.
+type PaperLevel struct {
+	Remaining int
+	Capacity  int
+	Low       bool
+	Out       bool
+}
+
+func (p *PaperSupply) out() bool { return p != nil && p.Remaining <= 0 }
+
+// checkPaper refuses a new ticket sale when the printer is out of paper
+// and no e-ticket delivery is available to take its place.
+func (m *TicketMachine) checkPaper() error {
+	if m.Paper.out() && len(m.Deliverers) == 0 {
+		return newError(CodeOutOfPaper, "out of ticket paper")
+	}
+	return nil
+}
+
+// usePaper takes paper for n printed tickets and returns how many can
+// actually be printed, alerting when paper runs low or out.
+func (m *TicketMachine) usePaper(n int) int {
+	p := m.Paper
+	if p == nil {
+		return n
+	}
+	before := p.Remaining
+	if n > p.Remaining {
+		n = p.Remaining
+	}
+	p.Remaining -= n
+	switch {
+	case p.Remaining <= 0 && before > 0:
+		m.publish(HardwareEvent{Kind: EventPaperOut, Device: "ticket_printer", Level: p.Remaining})
+	case p.Remaining <= p.LowAt && before > p.LowAt:
+		m.publish(HardwareEvent{Kind: EventPaperLow, Device: "ticket_printer", Level: p.Remaining})
+	}
+	return n
+}
+
+// paperOut alerts operators and, when tickets cannot be delivered
+// digitally either, takes the machine out of service once the current
+// transaction ends.
+func (m *TicketMachine) paperOut(ev HardwareEvent) {
+	m.notify(Alert{Kind: AlertPaperOut, TicketType: "paper", Stock: ev.Level})
+	if len(m.Deliverers) > 0 || m.inService() != nil {
+		return
+	}
+	oos := &OutOfServiceState{Reason: ReasonOutOfPaper, Detail: "replace the paper roll"}
+	switch m.State.(type) {
+	case *IdleState, *CashBoxFullState:
+		m.audit("", "out_of_paper", "")
+		m.stopService(oos)
+	default:
+		m.audit("", "out_of_paper", "after current transaction")
+		m.draining = oos
+	}
+}
+
+// PaperLevel reports the ticket paper left in the printer.
+func (s *AdminSession) PaperLevel() (PaperLevel, error) {
+	if err := s.active(); err != nil {
+		return PaperLevel{}, err
+	}
+	if err := s.m.authorize(s.OperatorID, PermDiagnostics, "paper_level"); err != nil {
+		return PaperLevel{}, err
+	}
+	p := s.m.Paper
+	if p == nil {
+		return PaperLevel{}, newError(CodeInvalidState, "paper is not tracked")
+	}
+	return PaperLevel{Remaining: p.Remaining, Capacity: p.Capacity, Low: p.Remaining <= p.LowAt, Out: p.out()}, nil
+}
+
+// ReplacePaper records that a full paper roll was loaded. A machine taken
+// out of service for lack of paper goes back into service on Exit.
+func (s *AdminSession) ReplacePaper() error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	if err := s.m.authorize(s.OperatorID, PermRestock, "replace_paper"); err != nil {
+		return err
+	}
+	p := s.m.Paper
+	if p == nil {
+		return newError(CodeInvalidState, "paper is not tracked")
+	}
+	p.Remaining = p.Capacity
+	if oos, ok := s.resume.(*OutOfServiceState); ok && oos.Reason == ReasonOutOfPaper {
+		s.resume = nil
+	}
+	s.m.audit(s.OperatorID, "replace_paper", fmt.Sprintf("paper replaced, %d tickets", p.Capacity))
+	fmt.Fprintf(s.m.out(), "Paper replaced: %d tickets\n", p.Capacity)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+func TestOutOfPaperRecovery(t *testing.T) {
+	m, _ := newTestMachine(t)
+	n := &recordingNotifier{}
+	m.Notifier = n
+	m.Deliverers = nil
+	m.Templates = DefaultTicketTemplates()
+	m.Printer = &MockTicketPrinter{W: io.Discard}
+	m.Paper = &PaperSupply{Remaining: 1, Capacity: 5, LowAt: 2}
+	sellMetro(t, m)
+	if len(n.alerts) != 1 || n.alerts[0].Kind != AlertPaperOut {
+		t.Fatalf("alerts = %+v", n.alerts)
+	}
+	must(t, m.StartOver())
+	if s, ok := m.State.(*OutOfServiceState); !ok || s.Reason != ReasonOutOfPaper {
+		t.Fatalf("state %s after the last ticket of paper", m.State.Name())
+	}
+	if err := m.SelectTicket("metro", 1); CodeOf(err) != CodeOutOfService {
+		t.Fatalf("SelectTicket without paper = %v", err)
+	}
+
+	s, err := m.EnterAdminMode(Credentials{OperatorID: "clerk", PIN: "1111"})
+	must(t, err)
+	if _, err := s.PaperLevel(); CodeOf(err) != CodePermissionDenied {
+		t.Fatalf("clerk read the paper level: %v", err)
+	}
+	must(t, s.ReplacePaper())
+	must(t, s.Exit())
+	if m.GetCurrentState() != (&IdleState{}).Name() || m.Paper.Remaining != 5 {
+		t.Fatalf("state %s with %d tickets of paper after the roll change", m.State.Name(), m.Paper.Remaining)
+	}
+}
+
+func TestPaperRunsOutMidSale(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.Templates = DefaultTicketTemplates()
+	m.Printer = &MockTicketPrinter{W: io.Discard}
+	m.Paper = &PaperSupply{Remaining: 1, Capacity: 5}
+	must(t, m.SelectTicket("metro", 2))
+	must(t, m.InsertMoney(KZT(500)))
+	must(t, m.InsertMoney(KZT(100)))
+	if _, err := m.DispenseTicket(); CodeOf(err) != CodeOutOfPaper {
+		t.Fatalf("DispenseTicket = %v, want %s", err, CodeOutOfPaper)
+	}
+	ps, ok := m.State.(*PrintErrorState)
+	if !ok || len(ps.Pending) != 1 {
+		t.Fatalf("state %s, want PrintError with one ticket pending", m.State.Name())
+	}
+	must(t, m.Cancel())
+	if len(m.Refunds) != 1 || m.Refunds[0].Amount != KZT(300) {
+		t.Fatalf("refunds = %+v, want the unprinted ticket", m.Refunds)
+	}
+	// E-ticket delivery keeps the machine selling without paper.
+	must(t, m.StartOver())
+	if m.GetCurrentState() != (&IdleState{}).Name() {
+		t.Fatalf("state %s with e-ticket delivery available", m.State.Name())
+	}
+}
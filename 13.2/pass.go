@@ -0,0 +1,41 @@
+package main
+
+import "time"
+
+// ProductKind distinguishes single-ride tickets from time-based passes.
+type ProductKind string
+
+const (
+	KindSingle ProductKind = "single"
+	KindPass   ProductKind = "pass"
+)
+
+// PassPeriod is how long a pass is valid from the moment it is issued.
+// Calendar arithmetic is used, so a month pass bought on 31 January runs
+// until 3 March in a non-leap year, exactly like time.AddDate.
+type PassPeriod struct {
+	Days   int
+	Months int
+}
+
+// Until returns the end of the period starting at from.
+func (p PassPeriod) Until(from time.Time) time.Time {
+	return from.AddDate(0, p.Months, p.Days)
+}
+
+// Kind returns the product kind of ticketType.
+func (m *TicketMachine) Kind(ticketType string) ProductKind {
+	if p, _ := m.Catalog.Product(ticketType); p.Pass != nil {
+		return KindPass
+	}
+	return KindSingle
+}
+
+// validUntil computes the end of validity for a ticket issued at from.
+func (m *TicketMachine) validUntil(ticketType string, from time.Time) time.Time {
+	p, _ := m.Catalog.Product(ticketType)
+	if p.Pass != nil {
+		return p.Pass.Until(from)
+	}
+	return from.Add(p.Validity)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPassPeriodUntil(t *testing.T) {
+	tests := []struct {
+		name   string
+		period PassPeriod
+		from   time.Time
+		want   time.Time
+	}{
+		{"day", PassPeriod{Days: 1}, time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC), time.Date(2026, 3, 3, 12, 0, 0, 0, time.UTC)},
+		{"week", PassPeriod{Days: 7}, time.Date(2026, 12, 28, 8, 0, 0, 0, time.UTC), time.Date(2027, 1, 4, 8, 0, 0, 0, time.UTC)},
+		{"month", PassPeriod{Months: 1}, time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2026, 4, 2, 0, 0, 0, 0, time.UTC)},
+		{"month from 31 January", PassPeriod{Months: 1}, time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.period.Until(tt.from); !got.Equal(tt.want) {
+				t.Fatalf("Until = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSellPass(t *testing.T) {
+	tests := []struct {
+		product string
+		price   Money
+		days    int
+		months  int
+	}{
+		{"day_pass", KZT(1000), 1, 0},
+		{"week_pass", KZT(4000), 7, 0},
+		{"month_pass", KZT(12000), 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.product, func(t *testing.T) {
+			m, clock := newTestMachine(t)
+			if m.Kind(tt.product) != KindPass {
+				t.Fatalf("kind = %s", m.Kind(tt.product))
+			}
+			must(t, m.SelectTicket(tt.product, 1))
+			for m.InsertedMoney < tt.price {
+				must(t, m.InsertMoney(KZT(1000)))
+			}
+			d, err := m.DispenseTicket()
+			must(t, err)
+			tk := d.Tickets[0]
+			if tk.Kind != KindPass || !tk.ValidUntil.Equal(clock.Now().AddDate(0, tt.months, tt.days)) {
+				t.Fatalf("ticket kind %s, valid until %s", tk.Kind, tk.ValidUntil)
+			}
+			c, err := DecodeTicketQR(tk.QRPayload)
+			must(t, err)
+			if c.Kind != string(KindPass) || c.Expires != tk.ValidUntil.Unix() {
+				t.Fatalf("claims = %+v", c)
+			}
+		})
+	}
+}
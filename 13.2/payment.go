@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CardDetails identifies the card presented by the customer. Only a token
+// and the masked number are kept; the PAN never reaches the machine.
+// Readers add the chip data, or the encrypted track of a swiped card, for
+// the gateway.
+type CardDetails struct {
+	Token     string   `json:"token"`
+	MaskedPAN string   `json:"masked_pan"`
+	EntryMode string   `json:"entry_mode,omitempty"`
+	EMV       *EMVData `json:"emv,omitempty"`
+	// Track is raw magstripe data and is never serialized.
+	Track string `json:"-"`
+}
+
+// Authorization is the gateway's answer to an authorization request.
+type Authorization struct {
+	ID            string `json:"id"`
+	Amount        Money  `json:"amount"`
+	Approved      bool   `json:"approved"`
+	DeclineReason string `json:"decline_reason,omitempty"`
+}
+
+// PaymentGateway talks to the card acquirer. Every call carries an
+// idempotency key; repeating a call with the same key must not charge or
+// refund the customer twice. Void releases an authorization that was
+// never captured; Refund returns money from a captured one.
+type PaymentGateway interface {
+	Authorize(key string, amount Money, card CardDetails) (Authorization, error)
+	Capture(key string, auth Authorization) error
+	Void(key string, auth Authorization) error
+	Refund(key string, auth Authorization, amount Money) error
+}
+
+// MockGateway approves every card except those listed in Decline. It
+// records captures, voids and refunds so tests can inspect them. Latency, when
+// set, is slept through Sleep on each authorization. Approvals are
+// remembered by key; declines are not, as a real acquirer would take the
+// next attempt afresh.
+type MockGateway struct {
+	Decline  map[string]string
+	Fail     error
+	Latency  time.Duration
+	Sleep    func(time.Duration)
+	Captured []Authorization
+	Voided   []Authorization
+	Refunded []Authorization
+	next     int
+	seen     map[string]Authorization
+	done     map[string]bool
+}
+
+func (g *MockGateway) Authorize(key string, amount Money, card CardDetails) (Authorization, error) {
+	if g.Latency > 0 && g.Sleep != nil {
+		g.Sleep(g.Latency)
+	}
+	if g.Fail != nil {
+		return Authorization{}, g.Fail
+	}
+	if auth, ok := g.seen[key]; ok {
+		return auth, nil
+	}
+	if g.seen == nil {
+		g.seen = map[string]Authorization{}
+	}
+	g.next++
+	auth := Authorization{ID: fmt.Sprintf("AUTH%06d", g.next), Amount: amount, Approved: true}
+	if reason, ok := g.Decline[card.Token]; ok {
+		auth.Approved = false
+		auth.DeclineReason = reason
+		return auth, nil
+	}
+	g.seen[key] = auth
+	return auth, nil
+}
+
+// once reports whether key is new and marks it as used.
+func (g *MockGateway) once(key string) bool {
+	if g.done == nil {
+		g.done = map[string]bool{}
+	}
+	if g.done[key] {
+		return false
+	}
+	g.done[key] = true
+	return true
+}
+
+func (g *MockGateway) Capture(key string, auth Authorization) error {
+	if g.Fail != nil {
+		return g.Fail
+	}
+	if g.once("capture:" + key) {
+		g.Captured = append(g.Captured, auth)
+	}
+	return nil
+}
+
+func (g *MockGateway) Void(key string, auth Authorization) error {
+	if g.Fail != nil {
+		return g.Fail
+	}
+	if g.once("void:" + key) {
+		g.Voided = append(g.Voided, auth)
+	}
+	return nil
+}
+
+func (g *MockGateway) Refund(key string, auth Authorization, amount Money) error {
+	if g.Fail != nil {
+		return g.Fail
+	}
+	if !g.once("refund:" + key) {
+		return nil
+	}
+	g.Refunded = append(g.Refunded, Authorization{ID: auth.ID, Amount: amount, Approved: true})
+	return nil
+}
+
+// authorizeCard runs a card authorization for the outstanding balance, so
+// cash already inserted is kept and the card pays the rest. The gateway
+// answers before it returns, so the machine goes straight to MoneyReceived
+// or CardDeclined.
+func (m *TicketMachine) authorizeCard(card CardDetails) error {
+	if !m.CardPaymentsAvailable() {
+		return ErrCircuitOpen
+	}
+	if m.CardAuth != nil {
+		return newError(CodeAlreadyPaid, "card already authorized")
+	}
+	amount := m.Outstanding()
+	m.show("Authorizing card %s for %s...", card.MaskedPAN, amount.In(m.Currency))
+	var auth Authorization
+	m.cardAttempts++
+	key := m.gatewayKey(fmt.Sprintf("auth/%d", m.cardAttempts))
+	err := m.deviceCall("payment.authorize", &auth, func() (err error) {
+		auth, err = m.Gateway.Authorize(key, amount, card)
+		return err
+	}, moneyAttr("amount", amount))
+	m.countPaymentFailure(err)
+	if errors.Is(err, ErrCircuitOpen) {
+		m.SetState(&WaitingForMoneyState{})
+		m.show("Card payments unavailable. Please pay cash.")
+		return err
+	}
+	if err != nil {
+		m.SetState(&CardDeclinedState{Reason: err.Error()})
+		return newErrorf(CodeCardDeclined, "card authorization failed: %w", err)
+	}
+	if !auth.Approved {
+		m.SetState(&CardDeclinedState{Reason: auth.DeclineReason})
+		m.show("Card declined: %v", auth.DeclineReason)
+		return newError(CodeCardDeclined, "card declined")
+	}
+	m.CardAuth = &auth
+	m.SetState(&MoneyReceivedState{})
+	m.show("Card approved. Ready to dispense ticket.")
+	return nil
+}
+
+// gatewayKey is the idempotency key of one gateway operation of the
+// current transaction: each authorization attempt, the capture and the
+// refund get their own, so a retry repeats only the same operation.
+func (m *TicketMachine) gatewayKey(op string) string {
+	return m.TransactionID + "/" + op
+}
+
+// captureCard settles the card authorization of the current transaction.
+func (m *TicketMachine) captureCard() error {
+	if m.CardAuth == nil {
+		return nil
+	}
+	if err := m.deviceCall("payment.capture", nil, func() error {
+		return m.Gateway.Capture(m.gatewayKey("capture"), *m.CardAuth)
+	}); err != nil {
+		return newErrorf(CodeCardDeclined, "card capture failed: %w", err)
+	}
+	m.CardAuth = nil
+	return nil
+}
+
+// CardDeclinedState follows a declined authorization. The customer may try
+// another card, pay cash or cancel.
+type CardDeclinedState struct {
+	Reason string
+}
+
+func (s *CardDeclinedState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeAlreadySelected, "ticket already selected")
+}
+func (s *CardDeclinedState) InsertMoney(m *TicketMachine, amount Money) error {
+	m.SetState(&WaitingForMoneyState{})
+	return m.State.InsertMoney(m, amount)
+}
+func (s *CardDeclinedState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return m.authorizeCard(card)
+}
+func (s *CardDeclinedState) Cancel(m *TicketMachine) error {
+	m.returnCash()
+	m.SetState(&TransactionCanceledState{})
+	return nil
+}
+func (s *CardDeclinedState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeCardDeclined, "card declined: "+s.Reason)
+}
+func (s *CardDeclinedState) Name() string { return "CardDeclined" }
+
+// voidCard releases the card authorization of a canceled transaction,
+// which was never captured. The machine stays in CardRefundPendingState
+// until the gateway confirms, and Cancel retries the void from there.
+func (m *TicketMachine) voidCard() error {
+	auth := *m.CardAuth
+	m.SetState(&CardRefundPendingState{Auth: auth})
+	m.show("Releasing %s on card...", auth.Amount.In(m.Currency))
+	if err := m.deviceCall("payment.void", nil, func() error {
+		return m.Gateway.Void(m.gatewayKey("void"), auth)
+	}, moneyAttr("amount", auth.Amount)); err != nil {
+		m.show("Card release pending: %v", err)
+		return newErrorf(CodeCardDeclined, "card void failed: %w", err)
+	}
+	m.CardAuth = nil
+	m.SetState(&TransactionCanceledState{})
+	m.show("Card payment released.")
+	return nil
+}
+
+// CardRefundPendingState waits for the gateway to release the card
+// authorization of a canceled transaction.
+type CardRefundPendingState struct {
+	Auth Authorization
+}
+
+func (s *CardRefundPendingState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeBusy, "card refund in progress")
+}
+func (s *CardRefundPendingState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeBusy, "card refund in progress")
+}
+func (s *CardRefundPendingState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeBusy, "card refund in progress")
+}
+func (s *CardRefundPendingState) Cancel(m *TicketMachine) error {
+	return m.voidCard()
+}
+func (s *CardRefundPendingState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeTransactionCanceled, "transaction canceled")
+}
+func (s *CardRefundPendingState) Name() string { return "CardRefundPending" }
+
+// countPaymentFailure counts card payments in a row the gateway could not
+// process, declines aside, and alerts at PaymentFailureAlertAt.
+func (m *TicketMachine) countPaymentFailure(err error) {
+	if err == nil {
+		m.paymentFailures = 0
+		return
+	}
+	m.paymentFailures++
+	if m.PaymentFailureAlertAt > 0 && m.paymentFailures == m.PaymentFailureAlertAt {
+		m.notify(Alert{Kind: AlertPaymentFailures, Detail: fmt.Sprintf("%d card payments failed in a row: %v", m.paymentFailures, err)})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSecondCardAfterDecline(t *testing.T) {
+	m, _ := newTestMachine(t)
+	g := &MockGateway{Decline: map[string]string{"tok_declined": "insufficient funds"}}
+	m.Gateway = g
+	must(t, m.SelectTicket("metro", 1))
+	if err := m.PayByCard(CardDetails{Token: "tok_declined", MaskedPAN: "**** 0002"}); err == nil {
+		t.Fatal("declined card accepted")
+	}
+	must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}))
+	if _, err := m.DispenseTicket(); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Captured) != 1 || !g.Captured[0].Approved {
+		t.Fatalf("captured %+v, want the approved authorization", g.Captured)
+	}
+}
+
+func TestGatewayKeysPerOperation(t *testing.T) {
+	m, _ := newTestMachine(t)
+	g := &recordingGateway{}
+	m.Gateway = g
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}))
+	must(t, m.Cancel())
+	tx := m.TransactionID
+	want := []string{"authorize " + tx + "/auth/1", "void " + tx + "/void"}
+	if len(g.calls) != len(want) {
+		t.Fatalf("calls %v, want %v", g.calls, want)
+	}
+	for i := range want {
+		if g.calls[i] != want[i] {
+			t.Errorf("call %d = %q, want %q", i, g.calls[i], want[i])
+		}
+	}
+}
+
+// recordingGateway approves everything and records the idempotency keys.
+type recordingGateway struct {
+	calls []string
+}
+
+func (g *recordingGateway) Authorize(key string, amount Money, card CardDetails) (Authorization, error) {
+	g.calls = append(g.calls, "authorize "+key)
+	return Authorization{ID: "A1", Amount: amount, Approved: true}, nil
+}
+
+func (g *recordingGateway) Capture(key string, auth Authorization) error {
+	g.calls = append(g.calls, "capture "+key)
+	return nil
+}
+
+func (g *recordingGateway) Void(key string, auth Authorization) error {
+	g.calls = append(g.calls, "void "+key)
+	return nil
+}
+
+func (g *recordingGateway) Refund(key string, auth Authorization, amount Money) error {
+	g.calls = append(g.calls, "refund "+key)
+	return nil
+}
+
+func TestCancelReleasesCard(t *testing.T) {
+	tests := []struct {
+		name      string
+		voidFails int
+	}{
+		{"gateway confirms", 0},
+		{"retried until confirmed", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			g := &flakyVoidGateway{fails: tt.voidFails}
+			m.Gateway = g
+			must(t, m.SelectTicket("metro", 1))
+			must(t, m.InsertMoney(KZT(100)))
+			must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}))
+			for i := 0; i < tt.voidFails; i++ {
+				if err := m.Cancel(); err == nil {
+					t.Fatal("cancel succeeded while the gateway was failing")
+				}
+				if m.GetCurrentState() != (&CardRefundPendingState{}).Name() || m.CardAuth == nil {
+					t.Fatalf("state %s after a failed void", m.GetCurrentState())
+				}
+				if err := m.SelectTicket("bus", 1); CodeOf(err) != CodeBusy {
+					t.Fatalf("select during a pending void = %v", err)
+				}
+			}
+			must(t, m.Cancel())
+			if m.GetCurrentState() != (&TransactionCanceledState{}).Name() || m.CardAuth != nil {
+				t.Fatalf("state %s, card auth %v", m.GetCurrentState(), m.CardAuth)
+			}
+			if len(g.Voided) != 1 || g.Voided[0].Amount != KZT(200) || len(g.Captured) != 0 {
+				t.Fatalf("voided %+v, captured %+v", g.Voided, g.Captured)
+			}
+			if m.CashBox.Total() != 0 {
+				t.Fatalf("cash box kept %s", m.CashBox.Total())
+			}
+		})
+	}
+}
+
+// flakyVoidGateway fails the first fails voids.
+type flakyVoidGateway struct {
+	MockGateway
+	fails int
+}
+
+func (g *flakyVoidGateway) Void(key string, auth Authorization) error {
+	if g.fails > 0 {
+		g.fails--
+		return errors.New("gateway unreachable")
+	}
+	return g.MockGateway.Void(key, auth)
+}
@@ -0,0 +1,87 @@
+package main
+
+import "time"
+
+// PriceContext is what a PricingStrategy may take into account.
+type PriceContext struct {
+	Time     time.Time
+	Category FareCategory
+	// FromZone and ToZone are set once a zoned trip is known.
+	FromZone string
+	ToZone   string
+	// Journey is set once the rider has chosen a journey type.
+	Journey JourneyType
+}
+
+// PricingStrategy prices one unit of a ticket type. ok is false when the
+// strategy has no price for it, letting a Layered strategy fall through.
+type PricingStrategy interface {
+	GetPrice(ticketType string, ctx PriceContext) (price Money, ok bool)
+}
+
+// FlatPricing is a fixed price per ticket type.
+type FlatPricing map[string]Money
+
+func (f FlatPricing) GetPrice(ticketType string, ctx PriceContext) (Money, bool) {
+	p, ok := f[ticketType]
+	return p, ok
+}
+
+// GetPrice prices ticketType by the schedule rule in force at ctx.Time.
+// Rules price single journeys only.
+func (s *PriceSchedule) GetPrice(ticketType string, ctx PriceContext) (Money, bool) {
+	if s == nil || (ctx.Journey != "" && ctx.Journey != JourneySingle) {
+		return 0, false
+	}
+	return s.Price(ticketType, ctx.Time)
+}
+
+// GetPrice prices a zoned product once its trip is known.
+func (z *ZoneFares) GetPrice(ticketType string, ctx PriceContext) (Money, bool) {
+	if z == nil || !z.Products[ticketType] || ctx.ToZone == "" {
+		return 0, false
+	}
+	return z.Fare(ctx.FromZone, ctx.ToZone)
+}
+
+// Layered asks each strategy in turn and uses the first price found.
+type Layered []PricingStrategy
+
+func (l Layered) GetPrice(ticketType string, ctx PriceContext) (Money, bool) {
+	for _, s := range l {
+		if p, ok := s.GetPrice(ticketType, ctx); ok {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// Discounted takes fare category discounts off a base strategy. The machine
+// already applies FareDiscounts per cart line, so use it only with an empty
+// FareDiscounts.
+type Discounted struct {
+	Base      PricingStrategy
+	Discounts map[FareCategory]map[string]Discount
+}
+
+func (d Discounted) GetPrice(ticketType string, ctx PriceContext) (Money, bool) {
+	p, ok := d.Base.GetPrice(ticketType, ctx)
+	if !ok {
+		return 0, false
+	}
+	return p - lookupDiscount(d.Discounts, ctx.Category, ticketType).Apply(p), true
+}
+
+// pricing returns the machine's strategy: Pricing when set, else zone
+// fares, then Schedule, then the flat catalog price.
+func (m *TicketMachine) pricing() PricingStrategy {
+	if m.Pricing != nil {
+		return m.Pricing
+	}
+	return Layered{m.ZoneFares, m.Schedule, m.Catalog}
+}
+
+// priceContext describes the current transaction for pricing.
+func (m *TicketMachine) priceContext() PriceContext {
+	return PriceContext{Time: m.Clock.Now(), Category: m.FareCategory}
+}
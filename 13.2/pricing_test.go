@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestLayeredPricing(t *testing.T) {
+	zones := &ZoneFares{Products: map[string]bool{"suburban": true}, Fares: map[string]map[string]Money{"A": {"B": KZT(600)}}}
+	l := Layered{zones, FlatPricing{"metro": KZT(250), "suburban": KZT(400)}}
+	tests := []struct {
+		name   string
+		ticket string
+		ctx    PriceContext
+		price  Money
+		ok     bool
+	}{
+		{"flat", "metro", PriceContext{}, KZT(250), true},
+		{"zone fare first", "suburban", PriceContext{FromZone: "A", ToZone: "B"}, KZT(600), true},
+		{"falls through before the trip is known", "suburban", PriceContext{}, KZT(400), true},
+		{"nobody prices it", "tram", PriceContext{}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if p, ok := l.GetPrice(tt.ticket, tt.ctx); p != tt.price || ok != tt.ok {
+				t.Fatalf("GetPrice = %s, %v; want %s, %v", p, ok, tt.price, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDiscountedPricing(t *testing.T) {
+	d := Discounted{Base: FlatPricing{"metro": KZT(300)}, Discounts: DefaultFareDiscounts()}
+	if p, _ := d.GetPrice("metro", PriceContext{Category: FareStudent}); p != KZT(150) {
+		t.Fatalf("student price = %s", p)
+	}
+	if p, _ := d.GetPrice("metro", PriceContext{Category: FareAdult}); p != KZT(300) {
+		t.Fatalf("adult price = %s", p)
+	}
+	if _, ok := d.GetPrice("bus", PriceContext{}); ok {
+		t.Fatal("priced a ticket the base does not know")
+	}
+}
+
+func TestMachineUsesPricingStrategy(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.Pricing = FlatPricing{"metro": KZT(180)}
+	must(t, m.SelectTicket("metro", 2))
+	if m.CurrentPrice != KZT(360) {
+		t.Fatalf("price = %s, want 360", m.CurrentPrice)
+	}
+}
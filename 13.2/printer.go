@@ -0,0 +1,158 @@
+package main
+
+import "io"
+
+// TicketPrinter is the ticket printer driver. text is the ticket as laid
+// out by the ticket templates.
+type TicketPrinter interface {
+	Print(t Ticket, text string) error
+}
+
+// WriterPrinter prints ticket text to W, stdout when nil.
+type WriterPrinter struct {
+	W io.Writer
+}
+
+func (p WriterPrinter) Print(t Ticket, text string) error {
+	_, err := io.WriteString(orStdout(p.W), text)
+	return err
+}
+
+// MockTicketPrinter prints to W, stdout when nil, or fails with Err, e.g.
+// a paper jam.
+type MockTicketPrinter struct {
+	W       io.Writer
+	Err     error
+	Printed []string
+}
+
+func (p *MockTicketPrinter) Print(t Ticket, text string) error {
+	if p.Err != nil {
+		return p.Err
+	}
+	p.Printed = append(p.Printed, t.ID)
+	io.WriteString(orStdout(p.W), text)
+	return nil
+}
+
+func (p *MockTicketPrinter) SelfTest() error { return p.Err }
+
+// completeSale finishes a sale once its tickets are printed.
+func (m *TicketMachine) completeSale(d *Dispensed) {
+	m.printTax(m.CurrentTax)
+	m.finish(d)
+}
+
+// printFailed holds the sale in PrintErrorState with the unprinted tickets.
+func (m *TicketMachine) printFailed(d Dispensed, pending []Ticket, err error) error {
+	m.SetState(&PrintErrorState{Dispensed: d, Pending: pending})
+	m.audit("", "print_failed", err.Error())
+	if CodeOf(err) == CodeOutOfPaper {
+		offer := "Cancel for a refund."
+		if len(m.Deliverers) > 0 {
+			offer = "Choose e-ticket delivery or cancel for a refund."
+		}
+		m.show("Out of paper: %d ticket(s) not printed. %s", len(pending), offer)
+		return err
+	}
+	m.show("Printer error: %d ticket(s) not printed. Retry or cancel for a refund.", len(pending))
+	return newErrorf(CodePrintFailed, "ticket printing failed: %w", err)
+}
+
+// refundUnprinted refunds and restocks the tickets a printer failure left
+// unprinted, then hands out the change of the sale. Each ticket leaves
+// Pending as its refund is paid, so a failed refund can be retried
+// without paying the earlier ones twice.
+func (m *TicketMachine) refundUnprinted(s *PrintErrorState) error {
+	rec := &m.Transactions[len(m.Transactions)-1]
+	for len(s.Pending) > 0 {
+		t := s.Pending[0]
+		if err := m.payRefund(t, rec); err != nil {
+			return err
+		}
+		s.Pending = s.Pending[1:]
+		s.Refunded = append(s.Refunded, t)
+		m.releaseSeat(t)
+	}
+	refunded := map[string]bool{}
+	units := map[string]int{}
+	var products []string
+	for _, t := range s.Refunded {
+		refunded[t.ID] = true
+		product := t.Type
+		if t.Bundle != "" {
+			product = t.Bundle
+		}
+		if _, ok := units[product]; !ok {
+			products = append(products, product)
+		}
+		units[product]++
+	}
+	for _, product := range products {
+		n := units[product]
+		if b := m.bundleOf(product); b != nil && b.Rides > 0 {
+			// A bundle goes back to stock only when none of its ride
+			// credits was printed.
+			n /= b.Rides
+		}
+		if n == 0 {
+			continue
+		}
+		m.Catalog.Restock(product, n)
+		m.persist(product)
+		m.recordMovement(product, n, "customer", MoveRefund, rec.ID)
+	}
+	var total Money
+	for _, r := range m.Refunds {
+		if refunded[r.TicketID] {
+			total += r.Amount
+		}
+	}
+	m.show("Refunded %s for %d unprinted ticket(s)", total.In(m.Currency), len(s.Refunded))
+	d := s.Dispensed
+	d.Tickets = nil
+	for _, t := range s.Dispensed.Tickets {
+		if !refunded[t.ID] {
+			d.Tickets = append(d.Tickets, t)
+		}
+	}
+	m.finish(&d)
+	return nil
+}
+
+// PrintErrorState is entered when the printer fails or runs out of paper
+// after payment. The customer either retries printing with DispenseTicket,
+// chooses e-ticket delivery and dispenses, or cancels for a refund of the
+// unprinted tickets.
+type PrintErrorState struct {
+	Dispensed Dispensed
+	Pending   []Ticket
+	// Refunded are the unprinted tickets already refunded on cancel.
+	Refunded []Ticket
+}
+
+func (s *PrintErrorState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodePrintFailed, "printer error, retry or cancel for a refund")
+}
+func (s *PrintErrorState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodePrintFailed, "printer error, retry or cancel for a refund")
+}
+func (s *PrintErrorState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodePrintFailed, "printer error, retry or cancel for a refund")
+}
+func (s *PrintErrorState) Cancel(m *TicketMachine) error {
+	return m.refundUnprinted(s)
+}
+func (s *PrintErrorState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	if m.Delivery != nil {
+		m.deliver(&m.Transactions[len(m.Transactions)-1])
+		m.completeSale(&s.Dispensed)
+		return s.Dispensed, nil
+	}
+	if pending, err := m.printTickets(s.Pending); err != nil {
+		return Dispensed{}, m.printFailed(s.Dispensed, pending, err)
+	}
+	m.completeSale(&s.Dispensed)
+	return s.Dispensed, nil
+}
+func (s *PrintErrorState) Name() string { return "PrintError" }
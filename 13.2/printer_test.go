@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/TheStilk/templates-homework-13/13.2/machinetest/hwsim"
+)
+
+func TestCancelAfterPrintFailure(t *testing.T) {
+	tests := []struct {
+		product string
+		qty     int
+		pay     []Money
+		stock   map[string]int
+	}{
+		{"metro", 2, []Money{KZT(500), KZT(100)}, map[string]int{"metro": 10}},
+		{"metro_carnet", 1, []Money{KZT(2000), KZT(500)}, map[string]int{"metro_carnet": 10, "metro": 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.product, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.Templates = DefaultTicketTemplates()
+			m.Printer = &MockTicketPrinter{W: io.Discard, Err: errors.New("paper jam")}
+			must(t, m.SelectTicket(tt.product, tt.qty))
+			for _, v := range tt.pay {
+				must(t, m.InsertMoney(v))
+			}
+			if _, err := m.DispenseTicket(); err == nil {
+				t.Fatal("dispensed with a jammed printer")
+			}
+			s, ok := m.State.(*PrintErrorState)
+			if !ok {
+				t.Fatalf("state %s, want PrintError", m.State.Name())
+			}
+			must(t, m.Cancel())
+			if len(s.Pending) != 0 {
+				t.Errorf("%d tickets still pending", len(s.Pending))
+			}
+			var paid Money
+			for _, r := range m.Refunds {
+				paid += r.Parts[TenderCash]
+			}
+			if price := m.Transactions[0].Price; paid != price {
+				t.Errorf("refunded %s in cash, want the price %s", paid, price)
+			}
+			for product, want := range tt.stock {
+				if p, _ := m.Catalog.Product(product); p.Stock != want {
+					t.Errorf("%s stock %d, want %d", product, p.Stock, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrintRetryTakesPaperOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		qty      int
+		failures int
+		pay      []Money
+	}{
+		{"one ticket", 1, 1, []Money{KZT(200), KZT(100)}},
+		{"two tickets", 2, 1, []Money{KZT(500), KZT(100)}},
+		{"three failed prints", 2, 3, []Money{KZT(500), KZT(100)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.Templates = DefaultTicketTemplates()
+			printer := &hwsim.Printer[Ticket]{}
+			printer.FailNext("print", errors.New("paper jam"), tt.failures)
+			m.Printer = printer
+			m.Paper = &PaperSupply{Remaining: 5, Capacity: 5}
+			must(t, m.SelectTicket("metro", tt.qty))
+			for _, v := range tt.pay {
+				must(t, m.InsertMoney(v))
+			}
+			var err error
+			for i := 0; i <= tt.failures; i++ {
+				if _, err = m.DispenseTicket(); err == nil {
+					break
+				}
+				if m.Paper.Remaining != 5 {
+					t.Fatalf("%d tickets of paper left after %d failed prints, want 5", m.Paper.Remaining, i+1)
+				}
+			}
+			must(t, err)
+			if m.Paper.Remaining != 5-tt.qty || len(printer.Printed) != tt.qty {
+				t.Errorf("%d tickets of paper left after printing %d, want %d", m.Paper.Remaining, len(printer.Printed), 5-tt.qty)
+			}
+		})
+	}
+}
+
+func TestRenderErrorLeavesTicketPending(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.Templates = DefaultTicketTemplates()
+	m.Templates.Layouts["metro"] = "{{.Missing}}"
+	m.Printer = &MockTicketPrinter{W: io.Discard}
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.InsertMoney(KZT(200)))
+	must(t, m.InsertMoney(KZT(100)))
+	if _, err := m.DispenseTicket(); CodeOf(err) != CodePrintFailed {
+		t.Fatalf("DispenseTicket: %v, want a print failure", err)
+	}
+	s, ok := m.State.(*PrintErrorState)
+	if !ok || len(s.Pending) != 1 {
+		t.Fatalf("state %s, want PrintError with the ticket pending", m.State.Name())
+	}
+}
+
+func TestPrintTickets(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.Templates = DefaultTicketTemplates()
+	var paper bytes.Buffer
+	printer := &MockTicketPrinter{W: &paper}
+	m.Printer = printer
+	must(t, m.SelectTicket("metro", 2))
+	must(t, m.InsertMoney(KZT(500)))
+	must(t, m.InsertMoney(KZT(100)))
+	d, err := m.DispenseTicket()
+	must(t, err)
+	if len(printer.Printed) != 2 || printer.Printed[0] != d.Tickets[0].ID || printer.Printed[1] != d.Tickets[1].ID {
+		t.Fatalf("printed %v, dispensed %+v", printer.Printed, d.Tickets)
+	}
+	if !strings.Contains(paper.String(), d.Tickets[0].ID) {
+		t.Errorf("ticket text does not carry its ID:\n%s", paper.String())
+	}
+	if _, ok := m.State.(*TicketDispensedState); !ok {
+		t.Errorf("state %s after printing", m.State.Name())
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import "time"
+
+// PromoKind is how a promo code reduces the price.
+type PromoKind string
+
+const (
+	PromoFixed      PromoKind = "fixed"
+	PromoPercent    PromoKind = "percent"
+	PromoFreeTicket PromoKind = "free_ticket"
+)
+
+// Promo is a redeemable promo code.
+type Promo struct {
+	Code      string
+	Kind      PromoKind
+	Amount    Money
+	PercentBP int
+	ExpiresAt time.Time
+}
+
+// Discount returns how much the promo takes off a cart.
+func (p Promo) Discount(cart []CartLine, total Money) Money {
+	var off Money
+	switch p.Kind {
+	case PromoFixed:
+		off = p.Amount
+	case PromoPercent:
+		off = total * Money(p.PercentBP) / 10000
+	case PromoFreeTicket:
+		for _, l := range cart {
+			if l.Price() > off {
+				off = l.Price()
+			}
+		}
+	}
+	if off > total {
+		off = total
+	}
+	return off
+}
+
+var (
+	ErrPromoUnknown = newError(CodePromoUnknown, "unknown promo code")
+	ErrPromoUsed    = newError(CodePromoUsed, "promo code already used")
+	ErrPromoExpired = newError(CodePromoExpired, "promo code expired")
+)
+
+// PromoProvider looks up promo codes and enforces that each is redeemed
+// only once.
+type PromoProvider interface {
+	Lookup(code string) (Promo, error)
+	Redeem(code, transactionID string) error
+}
+
+// MemoryPromoProvider keeps promo codes in memory.
+type MemoryPromoProvider struct {
+	Promos map[string]Promo
+	used   map[string]string
+}
+
+func (p *MemoryPromoProvider) Lookup(code string) (Promo, error) {
+	promo, ok := p.Promos[code]
+	if !ok {
+		return Promo{}, ErrPromoUnknown
+	}
+	if _, used := p.used[code]; used {
+		return Promo{}, ErrPromoUsed
+	}
+	return promo, nil
+}
+
+func (p *MemoryPromoProvider) Redeem(code, transactionID string) error {
+	if p.used == nil {
+		p.used = map[string]string{}
+	}
+	if tx, used := p.used[code]; used && tx != transactionID {
+		return ErrPromoUsed
+	}
+	p.used[code] = transactionID
+	return nil
+}
+
+// ApplyPromoCode reduces the price of the selected tickets. It is allowed
+// while waiting for money, before any payment has been made.
+func (m *TicketMachine) ApplyPromoCode(code string) error {
+	if err := m.logAction(JournalEntry{Action: "promo", Promo: code}); err != nil {
+		return err
+	}
+	if _, ok := m.State.(*WaitingForMoneyState); !ok {
+		return newError(CodeInvalidState, "promo codes can only be applied before payment")
+	}
+	if m.PaidTotal() > 0 {
+		return newError(CodeAlreadyPaid, "payment already started")
+	}
+	if m.Promos == nil {
+		return newError(CodePaymentUnavailable, "promo codes not accepted")
+	}
+	var promo Promo
+	err := m.deviceCall("promos.lookup", &promo, func() (err error) {
+		promo, err = m.Promos.Lookup(code)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !promo.ExpiresAt.IsZero() && !m.Clock.Now().Before(promo.ExpiresAt) {
+		return ErrPromoExpired
+	}
+	m.Promo = &promo
+	m.repriceCart()
+	m.show("Promo %s applied: -%s (%s)", code, m.PromoDiscount.In(m.Currency), m.formatPrice(m.CurrentPrice))
+	return nil
+}
+
+// redeemPromo marks the applied promo code as used by this transaction.
+func (m *TicketMachine) redeemPromo() {
+	if m.Promo == nil {
+		return
+	}
+	if err := m.Promos.Redeem(m.Promo.Code, m.TransactionID); err != nil {
+		m.warn("promo redemption failed: %v", err)
+	}
+}
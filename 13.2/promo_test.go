@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPromoDiscount(t *testing.T) {
+	cart := []CartLine{{TicketType: "metro", Qty: 2, UnitPrice: KZT(300)}, {TicketType: "train", Qty: 1, UnitPrice: KZT(1000)}}
+	tests := []struct {
+		name  string
+		promo Promo
+		total Money
+		want  Money
+	}{
+		{"fixed", Promo{Kind: PromoFixed, Amount: KZT(100)}, KZT(1600), KZT(100)},
+		{"percent", Promo{Kind: PromoPercent, PercentBP: 2500}, KZT(1600), KZT(400)},
+		{"free ticket takes the dearest", Promo{Kind: PromoFreeTicket}, KZT(1600), KZT(1000)},
+		{"capped at the total", Promo{Kind: PromoFixed, Amount: KZT(5000)}, KZT(1600), KZT(1600)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.promo.Discount(cart, tt.total); got != tt.want {
+				t.Fatalf("Discount = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPromoCode(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		code  string
+		want  error
+		price Money
+	}{
+		{"valid", "SPRING", nil, KZT(200)},
+		{"unknown", "NOPE", ErrPromoUnknown, KZT(300)},
+		{"expired", "OLD", ErrPromoExpired, KZT(300)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.Promos = &MemoryPromoProvider{Promos: map[string]Promo{
+				"SPRING": {Code: "SPRING", Kind: PromoFixed, Amount: KZT(100)},
+				"OLD":    {Code: "OLD", Kind: PromoFixed, Amount: KZT(100), ExpiresAt: now},
+			}}
+			must(t, m.SelectTicket("metro", 1))
+			if err := m.ApplyPromoCode(tt.code); !errors.Is(err, tt.want) {
+				t.Fatalf("ApplyPromoCode = %v, want %v", err, tt.want)
+			}
+			if m.CurrentPrice != tt.price {
+				t.Fatalf("price = %s, want %s", m.CurrentPrice, tt.price)
+			}
+		})
+	}
+}
+
+func TestPromoRedeemedOnce(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.Promos = &MemoryPromoProvider{Promos: map[string]Promo{"ONCE": {Code: "ONCE", Kind: PromoFixed, Amount: KZT(100)}}}
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.ApplyPromoCode("ONCE"))
+	must(t, m.InsertMoney(KZT(200)))
+	_, err := m.DispenseTicket()
+	must(t, err)
+	must(t, m.StartOver())
+	must(t, m.SelectTicket("metro", 1))
+	if err := m.ApplyPromoCode("ONCE"); !errors.Is(err, ErrPromoUsed) {
+		t.Fatalf("second use = %v, want ErrPromoUsed", err)
+	}
+}
+
+func TestPromoCanceledSaleNotRedeemed(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.Promos = &MemoryPromoProvider{Promos: map[string]Promo{"ONCE": {Code: "ONCE", Kind: PromoFixed, Amount: KZT(100)}}}
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.ApplyPromoCode("ONCE"))
+	must(t, m.Cancel())
+	must(t, m.StartOver())
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.ApplyPromoCode("ONCE"))
+}
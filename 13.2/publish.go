@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MessageBus publishes messages to a topic: an adapter over a NATS
+// connection or a Kafka producer plugs in here. Key is the machine ID, for
+// Kafka partitioning; NATS ignores it. Publish returns once the broker has
+// the message, e.g. after a JetStream ack or a Kafka produce with acks.
+type MessageBus interface {
+	Publish(topic, key string, data []byte) error
+}
+
+// EventMessage is a machine event as published. ID stays the same when a
+// buffered message is sent again, so consumers can drop duplicates; Seq
+// orders the messages of a machine.
+type EventMessage struct {
+	ID        string          `json:"id"`
+	Seq       int64           `json:"seq"`
+	Type      string          `json:"type"`
+	Time      time.Time       `json:"time"`
+	MachineID string          `json:"machine_id"`
+	StationID string          `json:"station_id,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// EventPublisher sends every machine event to the topic TopicPrefix and
+// the machine ID, "ticketmachine.events.TM-0001" by default, at least
+// once. Events are buffered, in order, and sent from a background
+// goroutine so the customer never waits for the broker; messages the bus
+// does not take are sent again before the next event and on every Tick.
+// Spool, when set, keeps the buffer and the last Seq in a file so both
+// survive a restart. Past MaxBuffered messages, 10000 when 0, the oldest
+// are dropped and counted.
+type EventPublisher struct {
+	Bus         MessageBus
+	TopicPrefix string
+	Spool       string
+	MaxBuffered int
+
+	topic  string
+	key    string
+	logger *slog.Logger
+	wake   chan struct{}
+	flush  chan chan struct{}
+	quit   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+
+	mu       sync.Mutex
+	seq      int64
+	buffered []EventMessage
+	dropped  int
+	dirty    bool
+	down     bool
+}
+
+// eventSpool is the spool file: the buffered messages and the last Seq
+// given out.
+type eventSpool struct {
+	Seq      int64          `json:"seq"`
+	Messages []EventMessage `json:"messages"`
+}
+
+// PublishEvents starts publishing the machine's events through p; the
+// returned func stops it, after a last attempt to send the buffer.
+// Messages spooled by an earlier run are sent first, and numbering goes
+// on from the last Seq spooled.
+func (m *TicketMachine) PublishEvents(p *EventPublisher) (stop func(), err error) {
+	prefix := p.TopicPrefix
+	if prefix == "" {
+		prefix = "ticketmachine.events"
+	}
+	p.topic, p.key = prefix+"."+m.MachineID, m.MachineID
+	if p.Spool != "" {
+		raw, err := os.ReadFile(p.Spool)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, newErrorf(CodeStorage, "event spool: %w", err)
+		}
+		if err := p.load(raw); err != nil {
+			return nil, newErrorf(CodeStorage, "event spool: %w", err)
+		}
+	}
+	p.logger = m.Logger
+	if p.logger == nil {
+		p.logger = slog.Default()
+	}
+	p.logger = p.logger.With(slog.String("machine_id", m.MachineID))
+	p.wake, p.flush = make(chan struct{}, 1), make(chan chan struct{})
+	p.quit, p.done = make(chan struct{}), make(chan struct{})
+	go p.run()
+	m.Events = p
+	p.kick()
+	unsubscribe := m.Subscribe(func(e MachineEvent) { m.publishEvent(p, e) })
+	return func() {
+		unsubscribe()
+		if m.Events == p {
+			m.Events = nil
+		}
+		p.once.Do(func() { close(p.quit) })
+		<-p.done
+	}, nil
+}
+
+// load reads a spool, also one written as a bare list of messages by
+// earlier versions.
+func (p *EventPublisher) load(raw []byte) error {
+	raw = bytes.TrimSpace(raw)
+	var sp eventSpool
+	switch {
+	case len(raw) == 0:
+	case raw[0] == '[':
+		if err := json.Unmarshal(raw, &sp.Messages); err != nil {
+			return err
+		}
+	default:
+		if err := json.Unmarshal(raw, &sp); err != nil {
+			return err
+		}
+	}
+	p.seq, p.buffered = sp.Seq, sp.Messages
+	if n := len(p.buffered); n > 0 && p.buffered[n-1].Seq > p.seq {
+		p.seq = p.buffered[n-1].Seq
+	}
+	return nil
+}
+
+// Buffered is the number of messages waiting for the bus.
+func (p *EventPublisher) Buffered() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.buffered)
+}
+
+// Dropped is the number of messages dropped because the buffer was full.
+func (p *EventPublisher) Dropped() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dropped
+}
+
+// Flush sends what the bus takes now and returns when it is done.
+func (p *EventPublisher) Flush() {
+	done := make(chan struct{})
+	select {
+	case p.flush <- done:
+		<-done
+	case <-p.done:
+	}
+}
+
+func (p *EventPublisher) kick() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *TicketMachine) publishEvent(p *EventPublisher, e MachineEvent) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		m.warn("event not published: %v", err)
+		return
+	}
+	max := p.MaxBuffered
+	if max <= 0 {
+		max = 10000
+	}
+	p.mu.Lock()
+	p.seq++
+	p.buffered = append(p.buffered, EventMessage{
+		ID:        newEventID(),
+		Seq:       p.seq,
+		Type:      eventType(e),
+		Time:      m.Clock.Now(),
+		MachineID: m.MachineID,
+		StationID: m.Location.StationID,
+		Data:      data,
+	})
+	n := len(p.buffered) - max
+	if n > 0 {
+		p.dropped += n
+		p.buffered = append([]EventMessage(nil), p.buffered[n:]...)
+	}
+	p.dirty = true
+	p.mu.Unlock()
+	if n > 0 {
+		m.warn("event buffer full: %d oldest events dropped", n)
+	}
+	p.kick()
+}
+
+// flushEvents has the buffered messages sent again.
+func (m *TicketMachine) flushEvents() {
+	if p := m.Events; p != nil {
+		p.kick()
+	}
+}
+
+func (p *EventPublisher) run() {
+	defer close(p.done)
+	for {
+		select {
+		case <-p.wake:
+			p.send()
+		case done := <-p.flush:
+			select {
+			case <-p.wake:
+			default:
+			}
+			p.send()
+			close(done)
+		case <-p.quit:
+			p.send()
+			return
+		}
+	}
+}
+
+// send publishes the buffered messages in order, stopping at the first
+// the bus does not take, and spools what is left.
+func (p *EventPublisher) send() {
+	p.mu.Lock()
+	pending := append([]EventMessage(nil), p.buffered...)
+	down := p.down
+	p.mu.Unlock()
+	var last int64
+	sent := 0
+	var err error
+	for _, msg := range pending {
+		raw, _ := json.Marshal(msg)
+		if err = p.Bus.Publish(p.topic, p.key, raw); err != nil {
+			break
+		}
+		last = msg.Seq
+		sent++
+	}
+	switch {
+	case err != nil && !down:
+		p.logger.Warn("event bus unavailable, buffering", slog.String("error", err.Error()))
+	case err == nil && down && sent > 0:
+		p.logger.Info("event bus back", slog.Int("sent", sent))
+	}
+	p.mu.Lock()
+	p.down = err != nil
+	i := 0
+	for i < len(p.buffered) && p.buffered[i].Seq <= last {
+		i++
+	}
+	p.buffered = p.buffered[i:]
+	spool := p.Spool != "" && (sent > 0 || p.dirty)
+	p.dirty = false
+	sp := eventSpool{Seq: p.seq, Messages: append([]EventMessage{}, p.buffered...)}
+	p.mu.Unlock()
+	if spool {
+		if err := p.writeSpool(sp); err != nil {
+			p.logger.Warn("event spool", slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (p *EventPublisher) writeSpool(sp eventSpool) error {
+	raw, err := json.Marshal(sp)
+	if err != nil {
+		return err
+	}
+	return DirDestination{Dir: filepath.Dir(p.Spool)}.Put(filepath.Base(p.Spool), raw)
+}
+
+func eventType(e MachineEvent) string {
+	switch e.(type) {
+	case TicketSelected:
+		return "ticket_selected"
+	case MoneyInserted:
+		return "money_inserted"
+	case StateChanged:
+		return "state_changed"
+	case TicketDispensed:
+		return "ticket_dispensed"
+	case TransactionCanceled:
+		return "transaction_canceled"
+	case ProductSoldOut:
+		return "product_sold_out"
+	case HardwareFaulted:
+		return "hardware_faulted"
+	case ConfigReloaded:
+		return "config_reloaded"
+	}
+	return "unknown"
+}
+
+// MemoryBus keeps published messages by topic, for tests and demos; while
+// Down it refuses them.
+type MemoryBus struct {
+	Down     bool
+	Messages map[string][][]byte
+}
+
+func (b *MemoryBus) Publish(topic, key string, data []byte) error {
+	if b.Down {
+		return errors.New("bus down")
+	}
+	if b.Messages == nil {
+		b.Messages = map[string][][]byte{}
+	}
+	b.Messages[topic] = append(b.Messages[topic], append([]byte(nil), data...))
+	return nil
+}
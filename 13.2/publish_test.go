@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func publishedSeqs(t *testing.T, bus *MemoryBus, topic string) []int64 {
+	t.Helper()
+	var seqs []int64
+	for _, raw := range bus.Messages[topic] {
+		var msg EventMessage
+		must(t, json.Unmarshal(raw, &msg))
+		seqs = append(seqs, msg.Seq)
+	}
+	return seqs
+}
+
+func TestEventSeqSurvivesRestart(t *testing.T) {
+	spool := filepath.Join(t.TempDir(), "events.json")
+	bus := &MemoryBus{}
+	var topic string
+	for run := 0; run < 2; run++ {
+		m, _ := newTestMachine(t)
+		topic = "ticketmachine.events." + m.MachineID
+		stop, err := m.PublishEvents(&EventPublisher{Bus: bus, Spool: spool})
+		must(t, err)
+		must(t, m.SelectTicket("metro", 1))
+		stop()
+	}
+	seqs := publishedSeqs(t, bus, topic)
+	if len(seqs) < 2 {
+		t.Fatalf("published %v", seqs)
+	}
+	for i := 1; i < len(seqs); i++ {
+		if seqs[i] != seqs[i-1]+1 {
+			t.Fatalf("seqs %v do not go on after the restart", seqs)
+		}
+	}
+}
+
+// slowBus takes a message only when released.
+type slowBus struct {
+	release chan struct{}
+	MemoryBus
+}
+
+func (b *slowBus) Publish(topic, key string, data []byte) error {
+	<-b.release
+	return b.MemoryBus.Publish(topic, key, data)
+}
+
+func TestPublishDoesNotBlockTheSale(t *testing.T) {
+	m, _ := newTestMachine(t)
+	bus := &slowBus{release: make(chan struct{})}
+	stop, err := m.PublishEvents(&EventPublisher{Bus: bus})
+	must(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.SelectTicket("metro", 1)
+		m.Cancel()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sale waited for the bus")
+	}
+	close(bus.release)
+	stop()
+	if n := len(bus.Messages["ticketmachine.events."+m.MachineID]); n == 0 {
+		t.Error("nothing published after the bus came back")
+	}
+}
+
+func TestEventBufferCap(t *testing.T) {
+	m, _ := newTestMachine(t)
+	bus := &MemoryBus{Down: true}
+	p := &EventPublisher{Bus: bus, MaxBuffered: 3}
+	stop, err := m.PublishEvents(p)
+	must(t, err)
+	defer stop()
+	for i := 0; i < 5; i++ {
+		m.publishEvent(p, StateChanged{From: "Idle", To: "Idle"})
+	}
+	p.Flush()
+	if p.Buffered() != 3 || p.Dropped() != 2 {
+		t.Errorf("buffered %d, dropped %d; want 3 and 2", p.Buffered(), p.Dropped())
+	}
+	bus.Down = false
+	p.Flush()
+	seqs := publishedSeqs(t, bus, "ticketmachine.events."+m.MachineID)
+	if len(seqs) != 3 || seqs[0] != 3 || seqs[2] != 5 {
+		t.Errorf("published %v, want [3 4 5]", seqs)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import "fmt"
+
+// QRStatus is the state of a QR payment on the provider side.
+type QRStatus string
+
+const (
+	QRPending  QRStatus = "pending"
+	QRPaid     QRStatus = "paid"
+	QRExpired  QRStatus = "expired"
+	QRCanceled QRStatus = "canceled"
+)
+
+// QRPayment is a payment request shown to the customer as a QR code.
+type QRPayment struct {
+	ID      string
+	Amount  Money
+	Payload string
+}
+
+// QRPaymentProvider creates QR payment requests (e.g. Kaspi QR) and reports
+// whether they were paid. A payment request carries the transaction ID as
+// its order reference. RefundPayment returns part or all of a paid
+// payment to the customer's account.
+type QRPaymentProvider interface {
+	CreatePayment(txID string, amount Money, currency Currency) (QRPayment, error)
+	Status(paymentID string) (QRStatus, error)
+	CancelPayment(paymentID string) error
+	RefundPayment(paymentID string, amount Money) error
+}
+
+// MockQRProvider issues Kaspi-style payloads and considers a payment paid
+// once MarkPaid has been called for it. Refunded sums the refunds by
+// payment.
+type MockQRProvider struct {
+	Refunded map[string]Money
+
+	statuses map[string]QRStatus
+	amounts  map[string]Money
+	next     int
+}
+
+func (p *MockQRProvider) CreatePayment(txID string, amount Money, currency Currency) (QRPayment, error) {
+	if p.statuses == nil {
+		p.statuses = map[string]QRStatus{}
+	}
+	p.next++
+	id := fmt.Sprintf("QR%06d", p.next)
+	p.statuses[id] = QRPending
+	if p.amounts == nil {
+		p.amounts = map[string]Money{}
+	}
+	p.amounts[id] = amount
+	return QRPayment{
+		ID:      id,
+		Amount:  amount,
+		Payload: fmt.Sprintf("https://pay.kaspi.kz/pay/%s?amount=%s&currency=%s&order=%s", id, amount, currency, txID),
+	}, nil
+}
+
+func (p *MockQRProvider) Status(paymentID string) (QRStatus, error) {
+	st, ok := p.statuses[paymentID]
+	if !ok {
+		return "", newError(CodeQRPayment, "unknown QR payment")
+	}
+	return st, nil
+}
+
+func (p *MockQRProvider) CancelPayment(paymentID string) error {
+	if _, ok := p.statuses[paymentID]; !ok {
+		return newError(CodeQRPayment, "unknown QR payment")
+	}
+	p.statuses[paymentID] = QRCanceled
+	return nil
+}
+
+func (p *MockQRProvider) RefundPayment(paymentID string, amount Money) error {
+	if p.statuses[paymentID] != QRPaid {
+		return newError(CodeQRPayment, "QR payment not paid")
+	}
+	if p.Refunded[paymentID]+amount > p.amounts[paymentID] {
+		return newError(CodeQRPayment, "refund exceeds QR payment")
+	}
+	if p.Refunded == nil {
+		p.Refunded = map[string]Money{}
+	}
+	p.Refunded[paymentID] += amount
+	return nil
+}
+
+// MarkPaid simulates the customer confirming the payment in the app.
+func (p *MockQRProvider) MarkPaid(paymentID string) {
+	p.statuses[paymentID] = QRPaid
+}
+
+// PayByQR creates a QR payment for the selected ticket. The returned
+// payload is rendered on screen; the machine waits in QRPaymentPendingState
+// until PollQRPayment or ConfirmQRPayment sees it paid.
+func (m *TicketMachine) PayByQR() (QRPayment, error) {
+	if err := m.logAction(JournalEntry{Action: "qr"}); err != nil {
+		return QRPayment{}, err
+	}
+	if err := m.inService(); err != nil {
+		return QRPayment{}, err
+	}
+	if m.QRProvider == nil {
+		return QRPayment{}, newError(CodePaymentUnavailable, "QR payments not available")
+	}
+	if _, ok := m.State.(*WaitingForMoneyState); !ok {
+		return QRPayment{}, newError(CodeInvalidState, "QR payment not possible now")
+	}
+	var p QRPayment
+	err := m.deviceCall("qr.create_payment", &p, func() (err error) {
+		p, err = m.QRProvider.CreatePayment(m.TransactionID, m.Outstanding(), m.Currency)
+		return err
+	})
+	if err != nil {
+		return QRPayment{}, newErrorf(CodeQRPayment, "cannot create QR payment: %w", err)
+	}
+	m.SetState(&QRPaymentPendingState{Payment: p})
+	m.show("Scan to pay: %v", p.Payload)
+	return p, nil
+}
+
+// PollQRPayment asks the provider whether the pending QR payment was paid.
+func (m *TicketMachine) PollQRPayment() error {
+	if err := m.logAction(JournalEntry{Action: "qr_poll"}); err != nil {
+		return err
+	}
+	s, ok := m.State.(*QRPaymentPendingState)
+	if !ok {
+		return newError(CodeQRPayment, "no QR payment pending")
+	}
+	var st QRStatus
+	err := m.deviceCall("qr.status", &st, func() (err error) {
+		st, err = m.QRProvider.Status(s.Payment.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return s.apply(m, st)
+}
+
+// ConfirmQRPayment handles the provider's payment callback.
+func (m *TicketMachine) ConfirmQRPayment(paymentID string) error {
+	if err := m.logAction(JournalEntry{Action: "qr_confirm", PaymentID: paymentID}); err != nil {
+		return err
+	}
+	s, ok := m.State.(*QRPaymentPendingState)
+	if !ok || s.Payment.ID != paymentID {
+		return newError(CodeQRPayment, "no such QR payment pending")
+	}
+	return s.apply(m, QRPaid)
+}
+
+// refundQR returns a QR payment taken for a transaction that was called
+// off. QRPaid stays set when the provider fails, so a retry refunds it.
+func (m *TicketMachine) refundQR() error {
+	p := m.QRPaid
+	if p == nil {
+		return nil
+	}
+	if m.QRProvider == nil {
+		return newError(CodeQRPayment, "QR refund failed: no QR provider")
+	}
+	if err := m.deviceCall("qr.refund_payment", nil, func() error {
+		return m.QRProvider.RefundPayment(p.ID, p.Amount)
+	}, moneyAttr("amount", p.Amount)); err != nil {
+		return newErrorf(CodeQRPayment, "QR refund failed: %w", err)
+	}
+	m.QRPaid = nil
+	m.show("Refunded %s by QR.", p.Amount.In(m.Currency))
+	return nil
+}
+
+// QRPaymentPendingState waits for the customer to pay the shown QR code.
+type QRPaymentPendingState struct {
+	Payment QRPayment
+}
+
+func (s *QRPaymentPendingState) apply(m *TicketMachine, st QRStatus) error {
+	switch st {
+	case QRPaid:
+		m.QRPaid = &s.Payment
+		m.SetState(&MoneyReceivedState{})
+		m.show("QR payment received. Ready to dispense ticket.")
+	case QRExpired, QRCanceled:
+		m.SetState(&WaitingForMoneyState{})
+		return newError(CodeQRPayment, "QR payment "+string(st))
+	}
+	return nil
+}
+
+func (s *QRPaymentPendingState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeAlreadySelected, "ticket already selected")
+}
+func (s *QRPaymentPendingState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeBusy, "QR payment in progress")
+}
+func (s *QRPaymentPendingState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeBusy, "QR payment in progress")
+}
+
+// Cancel calls off the QR payment, or refunds it if the customer paid
+// before the machine saw it.
+func (s *QRPaymentPendingState) Cancel(m *TicketMachine) error {
+	var st QRStatus
+	if err := m.deviceCall("qr.status", &st, func() (err error) {
+		st, err = m.QRProvider.Status(s.Payment.ID)
+		return err
+	}); err == nil && st == QRPaid {
+		m.QRPaid = &s.Payment
+		m.SetState(&MoneyReceivedState{})
+		return m.State.Cancel(m)
+	}
+	if err := m.deviceCall("qr.cancel_payment", nil, func() error { return m.QRProvider.CancelPayment(s.Payment.ID) }); err != nil {
+		return newErrorf(CodeQRPayment, "cannot cancel QR payment: %w", err)
+	}
+	m.returnCash()
+	m.SetState(&TransactionCanceledState{})
+	return nil
+}
+func (s *QRPaymentPendingState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeBusy, "waiting for QR payment")
+}
+func (s *QRPaymentPendingState) Name() string { return "QRPaymentPending" }
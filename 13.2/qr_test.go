@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanceledQRPaymentRefunded(t *testing.T) {
+	for _, timedOut := range []bool{false, true} {
+		name := "cancel"
+		if timedOut {
+			name = "timeout"
+		}
+		t.Run(name, func(t *testing.T) {
+			m, clock := newTestMachine(t)
+			qr := &MockQRProvider{}
+			m.QRProvider = qr
+			must(t, m.SelectTicket("metro", 1))
+			p, err := m.PayByQR()
+			must(t, err)
+			qr.MarkPaid(p.ID)
+			if timedOut {
+				clock.Advance(m.Timeout + time.Second)
+				m.Tick()
+			} else {
+				must(t, m.PollQRPayment())
+				must(t, m.Cancel())
+			}
+			if qr.Refunded[p.ID] != p.Amount || m.QRPaid != nil {
+				t.Errorf("QR refunded %s, want %s", qr.Refunded[p.ID], p.Amount)
+			}
+			if _, ok := m.State.(*MoneyReceivedState); ok {
+				t.Error("transaction still paid")
+			}
+		})
+	}
+}
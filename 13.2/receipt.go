@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ReceiptPrinter is the receipt printer channel, separate from the ticket
+// printer. text is the receipt laid out for printing.
+type ReceiptPrinter interface {
+	PrintReceipt(rec TransactionRecord, text string) error
+}
+
+// MockReceiptPrinter prints receipts to W, stdout when nil, or fails with
+// Err.
+type MockReceiptPrinter struct {
+	W       io.Writer
+	Err     error
+	Printed []string
+}
+
+func (p *MockReceiptPrinter) PrintReceipt(rec TransactionRecord, text string) error {
+	if p.Err != nil {
+		return p.Err
+	}
+	p.Printed = append(p.Printed, rec.ID)
+	io.WriteString(orStdout(p.W), text)
+	return nil
+}
+
+func (p *MockReceiptPrinter) SelfTest() error { return p.Err }
+
+// offerReceipt prompts the rider for a receipt once a sale is finished.
+func (m *TicketMachine) offerReceipt() {
+	if m.ReceiptPrinter != nil {
+		m.show("Print receipt?")
+	}
+}
+
+// PrintReceipt prints the purchase receipt of the sale just finished. It
+// is available until the next transaction starts, once per sale.
+func (m *TicketMachine) PrintReceipt() error {
+	if err := m.logAction(JournalEntry{Action: "receipt"}); err != nil {
+		return err
+	}
+	if m.ReceiptPrinter == nil {
+		return newError(CodeNotOffered, "receipts not available")
+	}
+	switch m.State.(type) {
+	case *TicketDispensedState, *ChangeDispensedState:
+	default:
+		return newError(CodeInvalidState, "no finished sale")
+	}
+	rec := &m.Transactions[len(m.Transactions)-1]
+	if rec.ID != m.TransactionID {
+		return newError(CodeInvalidState, "no finished sale")
+	}
+	if rec.ReceiptPrinted {
+		return newError(CodeInvalidState, "receipt already printed")
+	}
+	if err := m.deviceCall("receipt.print", nil, func() error {
+		return m.ReceiptPrinter.PrintReceipt(*rec, m.receiptText(*rec))
+	}); err != nil {
+		m.audit("", "receipt_failed", err.Error())
+		return newErrorf(CodePrintFailed, "receipt printing failed: %w", err)
+	}
+	rec.ReceiptPrinted = true
+	return nil
+}
+
+// receiptText lays out the receipt of rec: items, totals, tax, tenders and
+// the machine and transaction IDs.
+func (m *TicketMachine) receiptText(rec TransactionRecord) string {
+	var b strings.Builder
+	money := func(v Money) string { return v.In(m.Currency) }
+	fmt.Fprintf(&b, "RECEIPT\n%s\nMachine: %s %s\nTransaction: %s\n", rec.Time.Format("2006-01-02 15:04"), rec.StationID, rec.MachineID, rec.ID)
+	if len(rec.Lines) == 0 {
+		fmt.Fprintf(&b, "  %s  %s\n", rec.Product, money(rec.Price))
+	}
+	for _, l := range rec.Lines {
+		fmt.Fprintf(&b, "  %d x %s  %s\n", l.Qty, l.TicketType, money((l.UnitPrice-l.Discount)*Money(l.Qty)))
+	}
+	if rec.PromoDiscount > 0 {
+		fmt.Fprintf(&b, "  Promo %s  -%s\n", rec.PromoCode, money(rec.PromoDiscount))
+	}
+	if rec.CapDiscount > 0 {
+		fmt.Fprintf(&b, "  Fare cap  -%s\n", money(rec.CapDiscount))
+	}
+	fmt.Fprintf(&b, "Total: %s\n", money(rec.Price))
+	if rec.Tax.VAT > 0 {
+		fmt.Fprintf(&b, "Net: %s, VAT %s: %s\n", money(rec.Tax.Net), rec.Tax.Rate(), money(rec.Tax.VAT))
+	}
+	tenders := make([]string, 0, len(rec.Tenders))
+	for t := range rec.Tenders {
+		tenders = append(tenders, string(t))
+	}
+	sort.Strings(tenders)
+	for _, t := range tenders {
+		fmt.Fprintf(&b, "Paid %s: %s\n", t, money(rec.Tenders[Tender(t)]))
+	}
+	if rec.Change > 0 {
+		fmt.Fprintf(&b, "Change: %s\n", money(rec.Change))
+	}
+	if rec.FiscalNumber != "" {
+		fmt.Fprintf(&b, "Fiscal no.: %s\n", rec.FiscalNumber)
+	}
+	return b.String()
+}
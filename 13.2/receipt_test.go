@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPrintReceipt(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.ReceiptPrinter = nil
+	sellMetro(t, m)
+	if err := m.PrintReceipt(); CodeOf(err) != CodeNotOffered {
+		t.Fatalf("PrintReceipt without a printer = %v, want %s", err, CodeNotOffered)
+	}
+	must(t, m.StartOver())
+
+	var paper bytes.Buffer
+	receipts := &MockReceiptPrinter{W: &paper, Err: errors.New("paper jam")}
+	tickets := &MockTicketPrinter{W: io.Discard}
+	m.ReceiptPrinter, m.Printer = receipts, tickets
+	m.Templates = DefaultTicketTemplates()
+	if err := m.PrintReceipt(); CodeOf(err) != CodeInvalidState {
+		t.Fatalf("PrintReceipt before a sale = %v, want %s", err, CodeInvalidState)
+	}
+	sellMetro(t, m)
+	if len(tickets.Printed) != 1 || len(receipts.Printed) != 0 {
+		t.Fatalf("tickets %v, receipts %v: receipt printed unasked", tickets.Printed, receipts.Printed)
+	}
+	if err := m.PrintReceipt(); CodeOf(err) != CodePrintFailed {
+		t.Fatalf("PrintReceipt on a jammed printer = %v, want %s", err, CodePrintFailed)
+	}
+	receipts.Err = nil
+	must(t, m.PrintReceipt())
+	rec := m.Transactions[len(m.Transactions)-1]
+	if len(receipts.Printed) != 1 || receipts.Printed[0] != rec.ID || !rec.ReceiptPrinted {
+		t.Fatalf("receipts %v for %s", receipts.Printed, rec.ID)
+	}
+	for _, want := range []string{"Transaction: " + rec.ID, "Total: " + KZT(300).In(m.Currency)} {
+		if !strings.Contains(paper.String(), want) {
+			t.Errorf("receipt lacks %q:\n%s", want, paper.String())
+		}
+	}
+	if err := m.PrintReceipt(); CodeOf(err) != CodeInvalidState {
+		t.Fatalf("second receipt = %v, want %s", err, CodeInvalidState)
+	}
+	must(t, m.StartOver())
+	if err := m.PrintReceipt(); CodeOf(err) != CodeInvalidState {
+		t.Fatalf("receipt after StartOver = %v, want %s", err, CodeInvalidState)
+	}
+}
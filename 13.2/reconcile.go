@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ReconciliationLine sums the sales of one product.
+type ReconciliationLine struct {
+	Product string           `json:"product"`
+	Count   int              `json:"count"`
+	Revenue Money            `json:"revenue"`
+	Tenders map[Tender]Money `json:"tenders"`
+}
+
+// ReconciliationReport compares the cash the sales records say the machine
+// took with what the cash box counts. Tenders are net of change and
+// donations, as in SalesReport. ExpectedCash is the net cash taken less
+// cash refunds; CountedCash is the cash box less what it holds that is not
+// sales money: cash kept against vouchers and donations, and the change
+// and refunds paid out of the hopper. Amounts are in minor units.
+type ReconciliationReport struct {
+	From            time.Time            `json:"from"`
+	To              time.Time            `json:"to"`
+	ExpectedCash    Money                `json:"expected_cash"`
+	CountedCash     Money                `json:"counted_cash"`
+	Difference      Money                `json:"difference"`
+	ChangeGiven     Money                `json:"change_given"`
+	Donations       Money                `json:"donations"`
+	Refunded        Money                `json:"refunded"`
+	VoucherDeposits Money                `json:"voucher_deposits"`
+	ByTender        map[Tender]Money     `json:"by_tender"`
+	ByProduct       []ReconciliationLine `json:"by_product"`
+}
+
+// Reconcile reports on the sales and refunds since the last cash
+// collection, read from the Store when one is set.
+func (m *TicketMachine) Reconcile() (ReconciliationReport, error) {
+	r := ReconciliationReport{
+		To:       m.Clock.Now(),
+		ByTender: map[Tender]Money{},
+	}
+	if n := len(m.Collections); n > 0 {
+		r.From = m.Collections[n-1].Time
+	}
+	sales, err := m.sales(r.From, time.Time{})
+	if err != nil {
+		return ReconciliationReport{}, err
+	}
+	refunds, err := m.refunds(r.From, time.Time{})
+	if err != nil {
+		return ReconciliationReport{}, err
+	}
+	lines := map[string]*ReconciliationLine{}
+	line := func(product string) *ReconciliationLine {
+		l, ok := lines[product]
+		if !ok {
+			l = &ReconciliationLine{Product: product, Tenders: map[Tender]Money{}}
+			lines[product] = l
+		}
+		return l
+	}
+	sold := map[string]bool{}
+	for _, t := range sales {
+		sold[t.ID] = true
+		tenders := netTenders(t)
+		for tender, v := range tenders {
+			r.ByTender[tender] += v
+		}
+		if len(t.Lines) == 0 {
+			l := line(t.Product)
+			l.Count += t.Quantity
+			l.Revenue += t.Price
+			for tender, v := range tenders {
+				l.Tenders[tender] += v
+			}
+		}
+		for i, cl := range t.Lines {
+			l := line(cl.TicketType)
+			l.Count += cl.Qty
+			l.Revenue += allocate(t.Price, t.Lines, i)
+			for tender, v := range tenders {
+				l.Tenders[tender] += allocate(v, t.Lines, i)
+			}
+		}
+		r.ExpectedCash += tenders[TenderCash]
+		r.ChangeGiven += t.Change
+		r.Donations += t.Donation
+	}
+	for _, f := range refunds {
+		r.Refunded += f.parts()[TenderCash]
+	}
+	// Vouchers for the change of a sale are covered by ChangeGiven; the
+	// others are for cash kept in the box on a failed return.
+	for _, v := range m.Vouchers {
+		if !v.IssuedAt.Before(r.From) && !sold[v.TransactionID] {
+			r.VoucherDeposits += v.Amount
+		}
+	}
+	for _, l := range lines {
+		r.ByProduct = append(r.ByProduct, *l)
+	}
+	sort.Slice(r.ByProduct, func(i, j int) bool { return r.ByProduct[i].Product < r.ByProduct[j].Product })
+	r.ExpectedCash -= r.Refunded
+	r.CountedCash = m.CashBox.Total() - r.VoucherDeposits - r.Donations - r.ChangeGiven - r.Refunded
+	r.Difference = r.CountedCash - r.ExpectedCash
+	return r, nil
+}
+
+// allocate splits a tender amount over cart lines in proportion to their
+// totals; the last line takes the rounding remainder.
+func allocate(v Money, lines []CartLine, i int) Money {
+	var total Money
+	for _, l := range lines {
+		total += l.Total()
+	}
+	if total == 0 {
+		return 0
+	}
+	if i < len(lines)-1 {
+		return v * lines[i].Total() / total
+	}
+	var given Money
+	for _, l := range lines[:i] {
+		given += v * l.Total() / total
+	}
+	return v - given
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r ReconciliationReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes one row per product followed by a totals row.
+func (r ReconciliationReport) WriteCSV(w io.Writer) error {
+	tenders := []Tender{TenderCash, TenderCard, TenderQR}
+	cw := csv.NewWriter(w)
+	header := []string{"product", "count", "revenue"}
+	for _, t := range tenders {
+		header = append(header, string(t))
+	}
+	cw.Write(header)
+	for _, l := range r.ByProduct {
+		row := []string{l.Product, strconv.Itoa(l.Count), l.Revenue.String()}
+		for _, t := range tenders {
+			row = append(row, l.Tenders[t].String())
+		}
+		cw.Write(row)
+	}
+	cw.Write([]string{"expected_cash", "", r.ExpectedCash.String()})
+	cw.Write([]string{"counted_cash", "", r.CountedCash.String()})
+	cw.Write([]string{"difference", "", r.Difference.String()})
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name     string
+		run      func(t *testing.T, m *TicketMachine)
+		expected Money
+		vouchers Money
+		refunded Money
+	}{
+		{"sale with change", func(t *testing.T, m *TicketMachine) {
+			must(t, m.SelectTicket("metro", 1))
+			must(t, m.InsertMoney(KZT(500)))
+			_, err := m.DispenseTicket()
+			must(t, err)
+		}, KZT(300), 0, 0},
+		{"refunded sale", func(t *testing.T, m *TicketMachine) {
+			must(t, m.SelectTicket("metro", 1))
+			must(t, m.InsertMoney(KZT(500)))
+			d, err := m.DispenseTicket()
+			must(t, err)
+			must(t, m.StartOver())
+			_, err = m.RefundTicket(d.Tickets[0].ID)
+			must(t, err)
+		}, 0, 0, KZT(300)},
+		{"cancel the dispenser cannot return", func(t *testing.T, m *TicketMachine) {
+			m.ChangeDispenser = &MockChangeDispenser{Jam: KZT(100)}
+			must(t, m.SelectTicket("metro", 1))
+			must(t, m.InsertMoney(KZT(100)))
+			must(t, m.Cancel())
+		}, 0, KZT(100), 0},
+		{"sales read from the store", func(t *testing.T, m *TicketMachine) {
+			m.Store = &MemoryStore{}
+			must(t, m.SelectTicket("metro", 1))
+			must(t, m.InsertMoney(KZT(200)))
+			must(t, m.InsertMoney(KZT(100)))
+			_, err := m.DispenseTicket()
+			must(t, err)
+			m.Transactions = nil
+		}, KZT(300), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			tt.run(t, m)
+			r, err := m.Reconcile()
+			must(t, err)
+			if r.ExpectedCash != tt.expected || r.VoucherDeposits != tt.vouchers || r.Refunded != tt.refunded {
+				t.Errorf("expected %s, vouchers %s, refunded %s; want %s, %s, %s",
+					r.ExpectedCash, r.VoucherDeposits, r.Refunded, tt.expected, tt.vouchers, tt.refunded)
+			}
+			if r.Difference != 0 {
+				t.Errorf("difference %s: counted %s, expected %s", r.Difference, r.CountedCash, r.ExpectedCash)
+			}
+			if r.ByTender[TenderCash] != tt.expected+tt.refunded {
+				t.Errorf("cash tender %s, want %s", r.ByTender[TenderCash], tt.expected+tt.refunded)
+			}
+		})
+	}
+}
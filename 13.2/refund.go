@@ -0,0 +1,253 @@
+package main
+
+import "time"
+
+// TicketUsage tells whether a ticket has been validated at a gate. It is
+// backed by the validator back office.
+type TicketUsage interface {
+	Used(ticketID string) (bool, error)
+}
+
+// MockTicketUsage is a TicketUsage backed by a set of used ticket IDs.
+type MockTicketUsage map[string]bool
+
+func (u MockTicketUsage) Used(ticketID string) (bool, error) { return u[ticketID], nil }
+
+// RefundRecord is a ticket returned after sale. Parts splits Amount by
+// tender; Tender is the tender of the largest part.
+type RefundRecord struct {
+	Time          time.Time
+	TicketID      string
+	TransactionID string
+	Amount        Money
+	Tender        Tender
+	Parts         map[Tender]Money
+	Coins         []TallyLine
+}
+
+// parts is the refund by tender, for records kept before Parts was.
+func (r RefundRecord) parts() map[Tender]Money {
+	if r.Parts == nil {
+		return map[Tender]Money{r.Tender: r.Amount}
+	}
+	return r.Parts
+}
+
+// RefundTicket takes back an unused ticket within RefundWindow of its
+// issue, restocks it and refunds what was paid for it to the tenders that
+// paid: the card, the QR provider and the hopper.
+func (m *TicketMachine) RefundTicket(ticketID string) (RefundRecord, error) {
+	if err := m.inService(); err != nil {
+		return RefundRecord{}, err
+	}
+	switch m.State.(type) {
+	case *IdleState, *CashBoxFullState:
+	default:
+		return RefundRecord{}, newError(CodeInvalidState, "refunds are only possible when idle")
+	}
+	m.SetState(&RefundValidationState{TicketID: ticketID})
+	t, rec, err := m.validateRefund(ticketID)
+	if err == nil {
+		err = m.payRefund(t, rec)
+	}
+	if err != nil {
+		m.SetState(m.readyState())
+		return RefundRecord{}, err
+	}
+	r := m.Refunds[len(m.Refunds)-1]
+	m.Catalog.Restock(t.Type, 1)
+	m.persist(t.Type)
+	m.recordMovement(t.Type, 1, "customer", MoveRefund, t.ID)
+	m.releaseSeat(t)
+	m.SetState(&RefundIssuedState{Refund: r})
+	m.show("Ticket %s refunded: %s (%s)", ticketID, r.Amount.In(m.Currency), r.Tender)
+	return r, nil
+}
+
+// validateRefund finds the ticket in the journal and checks it may be
+// returned.
+func (m *TicketMachine) validateRefund(ticketID string) (Ticket, *TransactionRecord, error) {
+	for _, r := range m.Refunds {
+		if r.TicketID == ticketID {
+			return Ticket{}, nil, newError(CodeTicketRefunded, "ticket already refunded")
+		}
+	}
+	for i := len(m.Transactions) - 1; i >= 0; i-- {
+		rec := &m.Transactions[i]
+		for _, t := range rec.Tickets {
+			if t.ID != ticketID {
+				continue
+			}
+			if t.Bundle != "" {
+				return t, rec, newError(CodeNotRefundable, "ride credits of a bundle cannot be refunded")
+			}
+			if m.Clock.Now().Sub(t.IssuedAt) > m.RefundWindow {
+				return t, rec, newError(CodeRefundWindowPassed, "refund window has passed")
+			}
+			if m.Usage != nil {
+				used, err := m.Usage.Used(ticketID)
+				if err != nil {
+					return t, rec, newErrorf(CodeHardware, "cannot check ticket usage: %w", err)
+				}
+				if used {
+					return t, rec, newError(CodeTicketUsed, "ticket has been used")
+				}
+			}
+			return t, rec, nil
+		}
+	}
+	return Ticket{}, nil, newError(CodeUnknownTicket, "unknown ticket")
+}
+
+// refundTenders is the order a split refund is paid in: the electronic
+// parts, which the gateway and provider can pay again safely on a retry,
+// before the cash leaves the hopper.
+var refundTenders = []Tender{TenderCard, TenderQR, TenderCash}
+
+// payRefund returns the ticket price, never more than is left of the
+// sale after earlier refunds, and records the refund. A sale paid with
+// several tenders is refunded pro rata to each, capped at what that
+// tender paid net of change and earlier refunds.
+func (m *TicketMachine) payRefund(t Ticket, rec *TransactionRecord) error {
+	paid := netTenders(*rec)
+	var total Money
+	for _, v := range paid {
+		total += v
+	}
+	left := map[Tender]Money{}
+	for tender, v := range paid {
+		left[tender] = v
+	}
+	for _, r := range m.Refunds {
+		if r.TransactionID == rec.ID {
+			for tender, v := range r.parts() {
+				left[tender] -= v
+			}
+		}
+	}
+	r := RefundRecord{Time: m.Clock.Now(), TicketID: t.ID, TransactionID: rec.ID, Parts: map[Tender]Money{}}
+	var rest Money
+	for _, tender := range refundTenders {
+		rest += left[tender]
+	}
+	amount := t.PricePaid
+	if amount > rest {
+		amount = rest
+	}
+	if amount <= 0 {
+		return newError(CodeTicketRefunded, "nothing left to refund")
+	}
+	assigned := Money(0)
+	for _, tender := range refundTenders {
+		if left[tender] <= 0 {
+			continue
+		}
+		part := amount * paid[tender] / total
+		if part > left[tender] {
+			part = left[tender]
+		}
+		r.Parts[tender] = part
+		assigned += part
+	}
+	for _, tender := range refundTenders {
+		if assigned == amount {
+			break
+		}
+		if room := left[tender] - r.Parts[tender]; room > 0 {
+			if room > amount-assigned {
+				room = amount - assigned
+			}
+			r.Parts[tender] += room
+			assigned += room
+		}
+	}
+	for _, tender := range refundTenders {
+		if v := r.Parts[tender]; v == 0 {
+			delete(r.Parts, tender)
+		} else if r.Tender == "" || v > r.Parts[r.Tender] {
+			r.Tender = tender
+		}
+	}
+	r.Amount = amount
+	var plan []TallyLine
+	if cash := r.Parts[TenderCash]; cash > 0 {
+		var ok bool
+		if plan, ok = m.PlanChange(cash); !ok {
+			return newError(CodeCannotMakeChange, "cannot refund in cash")
+		}
+	}
+	if card := r.Parts[TenderCard]; card > 0 {
+		if rec.Card == nil {
+			return newError(CodeCardDeclined, "card refund failed: no card authorization")
+		}
+		if err := m.deviceCall("payment.refund", nil, func() error {
+			return m.Gateway.Refund(rec.ID+"/refund/"+t.ID, *rec.Card, card)
+		}, moneyAttr("amount", card)); err != nil {
+			return newErrorf(CodeCardDeclined, "card refund failed: %w", err)
+		}
+	}
+	if qr := r.Parts[TenderQR]; qr > 0 {
+		if rec.QR == nil || m.QRProvider == nil {
+			return newError(CodeQRPayment, "QR refund failed: no QR payment")
+		}
+		if err := m.deviceCall("qr.refund_payment", nil, func() error {
+			return m.QRProvider.RefundPayment(rec.QR.ID, qr)
+		}, moneyAttr("amount", qr)); err != nil {
+			return newErrorf(CodeQRPayment, "QR refund failed: %w", err)
+		}
+	}
+	if cash := r.Parts[TenderCash]; cash > 0 {
+		c := m.payOut(cash, plan)
+		r.Coins = c.Coins
+		if m.OnChangeDispensed != nil {
+			m.OnChangeDispensed(c)
+		}
+	}
+	m.Refunds = append(m.Refunds, r)
+	m.store("refund", func(s Store) error { return s.SaveRefund(r) })
+	return nil
+}
+
+// RefundValidationState is held while a returned ticket is checked.
+type RefundValidationState struct {
+	TicketID string
+}
+
+func (s *RefundValidationState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeBusy, "refund in progress")
+}
+func (s *RefundValidationState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeBusy, "refund in progress")
+}
+func (s *RefundValidationState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeBusy, "refund in progress")
+}
+func (s *RefundValidationState) Cancel(m *TicketMachine) error {
+	return newError(CodeBusy, "refund in progress")
+}
+func (s *RefundValidationState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeBusy, "refund in progress")
+}
+func (s *RefundValidationState) Name() string { return "RefundValidation" }
+
+// RefundIssuedState is entered once a refund has been paid out.
+type RefundIssuedState struct {
+	Refund RefundRecord
+}
+
+func (s *RefundIssuedState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeCollectPending, "please take your refund and start over")
+}
+func (s *RefundIssuedState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeCollectPending, "please take your refund")
+}
+func (s *RefundIssuedState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeCollectPending, "please take your refund")
+}
+func (s *RefundIssuedState) Cancel(m *TicketMachine) error {
+	return newError(CodeTransactionComplete, "refund complete")
+}
+func (s *RefundIssuedState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeNotPaid, "no paid ticket")
+}
+func (s *RefundIssuedState) Name() string { return "RefundIssued" }
@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+func TestPayRefundSplitsAcrossTenders(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenders map[Tender]Money
+		change  Money
+		tickets []Money
+		earlier map[Tender]Money
+		want    map[Tender]Money
+	}{
+		{"cash", map[Tender]Money{TenderCash: KZT(300)}, KZT(50), []Money{KZT(250)}, nil,
+			map[Tender]Money{TenderCash: KZT(250)}},
+		{"card", map[Tender]Money{TenderCard: KZT(250)}, 0, []Money{KZT(250)}, nil,
+			map[Tender]Money{TenderCard: KZT(250)}},
+		{"qr", map[Tender]Money{TenderQR: KZT(250)}, 0, []Money{KZT(250)}, nil,
+			map[Tender]Money{TenderQR: KZT(250)}},
+		{"cash and card", map[Tender]Money{TenderCash: KZT(100), TenderCard: KZT(150)}, 0, []Money{KZT(250)}, nil,
+			map[Tender]Money{TenderCash: KZT(100), TenderCard: KZT(150)}},
+		{"one of two tickets", map[Tender]Money{TenderCash: KZT(200), TenderCard: KZT(300)}, KZT(100),
+			[]Money{KZT(200), KZT(200)}, nil, map[Tender]Money{TenderCash: KZT(50), TenderCard: KZT(150)}},
+		{"after an earlier refund", map[Tender]Money{TenderCash: KZT(100), TenderCard: KZT(150)}, 0,
+			[]Money{KZT(125), KZT(125)}, map[Tender]Money{TenderCash: KZT(100), TenderCard: KZT(25)},
+			map[Tender]Money{TenderCard: KZT(125)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			g := &MockGateway{}
+			qr := &MockQRProvider{}
+			m.Gateway, m.QRProvider = g, qr
+			p, err := qr.CreatePayment("TX-1", tt.tenders[TenderQR], m.Currency)
+			must(t, err)
+			qr.MarkPaid(p.ID)
+			rec := TransactionRecord{ID: "TX-1", Tenders: tt.tenders, Change: tt.change,
+				Card: &Authorization{ID: "A1", Approved: true}, QR: &p}
+			for i, price := range tt.tickets {
+				rec.Price += price
+				rec.Tickets = append(rec.Tickets, Ticket{ID: "T" + string(rune('1'+i)), PricePaid: price})
+			}
+			if tt.earlier != nil {
+				m.Refunds = append(m.Refunds, RefundRecord{TicketID: "T2", TransactionID: rec.ID, Parts: tt.earlier})
+			}
+			hopper := m.HopperTotal()
+			must(t, m.payRefund(rec.Tickets[0], &rec))
+			r := m.Refunds[len(m.Refunds)-1]
+			if len(r.Parts) != len(tt.want) {
+				t.Fatalf("parts %v, want %v", r.Parts, tt.want)
+			}
+			var sum Money
+			for tender, v := range tt.want {
+				if r.Parts[tender] != v {
+					t.Errorf("%s part = %s, want %s", tender, r.Parts[tender], v)
+				}
+				sum += v
+			}
+			if r.Amount != sum {
+				t.Errorf("amount %s, want %s", r.Amount, sum)
+			}
+			if paid := hopper - m.HopperTotal(); paid != tt.want[TenderCash] {
+				t.Errorf("hopper paid %s, want %s", paid, tt.want[TenderCash])
+			}
+			var card Money
+			for _, a := range g.Refunded {
+				card += a.Amount
+			}
+			if card != tt.want[TenderCard] {
+				t.Errorf("card refunded %s, want %s", card, tt.want[TenderCard])
+			}
+			if qr.Refunded[p.ID] != tt.want[TenderQR] {
+				t.Errorf("QR refunded %s, want %s", qr.Refunded[p.ID], tt.want[TenderQR])
+			}
+		})
+	}
+}
+
+func TestPayRefundNothingLeft(t *testing.T) {
+	m, _ := newTestMachine(t)
+	rec := TransactionRecord{ID: "TX-1", Price: KZT(250), Tenders: map[Tender]Money{TenderCash: KZT(250)},
+		Tickets: []Ticket{{ID: "T1", PricePaid: KZT(250)}}}
+	m.Refunds = append(m.Refunds, RefundRecord{TicketID: "T0", TransactionID: rec.ID, Amount: KZT(250), Tender: TenderCash})
+	if err := m.payRefund(rec.Tickets[0], &rec); err == nil {
+		t.Fatal("refunded more than the sale")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ConfigChange describes what a Reload changed. Settings names the
+// machine settings that changed, e.g. "timeout".
+type ConfigChange struct {
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	Repriced []string `json:"repriced,omitempty"`
+	Settings []string `json:"settings,omitempty"`
+}
+
+func (c ConfigChange) String() string {
+	var parts []string
+	for _, p := range []struct {
+		label string
+		list  []string
+	}{{"added", c.Added}, {"removed", c.Removed}, {"repriced", c.Repriced}, {"settings", c.Settings}} {
+		if len(p.list) > 0 {
+			parts = append(parts, p.label+" "+strings.Join(p.list, ", "))
+		}
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Reload applies a new configuration to the running machine: products,
+// prices, the price schedule, denominations, top-up amounts, timeouts and
+// locale. Stock on hand, the hopper and the cash box are kept, and so are
+// the operators and the ticket key, which take a restart. Between transactions it applies at once;
+// during a sale it is deferred until the machine is ready again, so the
+// customer pays the price they were shown. Products left out of cfg are
+// withdrawn from sale.
+func (m *TicketMachine) Reload(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		m.audit("", "reload_rejected", err.Error())
+		return err
+	}
+	switch m.State.(type) {
+	case *IdleState, *CashBoxFullState, *OutOfServiceState, *MaintenanceState, *CoinJamState, *LockedState:
+		m.applyReload(cfg)
+		return nil
+	}
+	m.pendingReload = &cfg
+	m.audit("", "reload_deferred", "")
+	fmt.Fprintln(m.out(), "Configuration reload deferred until the current sale ends")
+	return nil
+}
+
+func (m *TicketMachine) applyReload(cfg Config) {
+	var ch ConfigChange
+	catalog := m.Catalog.clone()
+	listed := map[string]bool{}
+	for _, s := range cfg.Products {
+		listed[s.Type] = true
+		p := s.product()
+		old, ok := catalog.Product(s.Type)
+		switch {
+		case !ok || !old.Active:
+			ch.Added = append(ch.Added, s.Type)
+		case old.Price != p.Price:
+			ch.Repriced = append(ch.Repriced, fmt.Sprintf("%s %s -> %s", s.Type, old.Price, p.Price))
+		}
+		if ok {
+			p.Stock = old.Stock
+		}
+		catalog.RegisterProduct(p)
+	}
+	for _, p := range catalog.List() {
+		if p.Active && !listed[p.Type] {
+			catalog.Deactivate(p.Type)
+			ch.Removed = append(ch.Removed, p.Type)
+		}
+	}
+	if !sameMoney(m.Denominations, cfg.Denominations) {
+		m.Denominations = append([]Money(nil), cfg.Denominations...)
+		ch.Settings = append(ch.Settings, "denominations")
+	}
+	set := func(name string, changed bool) {
+		if changed {
+			ch.Settings = append(ch.Settings, name)
+		}
+	}
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	window := time.Duration(cfg.RefundWindowMinutes) * time.Minute
+	set("timeout", m.Timeout != timeout)
+	set("refund_window", m.RefundWindow != window)
+	set("exact_change_threshold", m.ExactChangeThreshold != cfg.ExactChangeThreshold)
+	set("locale", m.AudioLocale != cfg.Locale)
+	schedule := cfg.schedule()
+	set("schedule", !reflect.DeepEqual(m.Schedule, schedule))
+	set("top_up_amounts", !sameMoney(m.TopUpAmounts, cfg.TopUpAmounts))
+	m.Catalog = catalog
+	m.Schedule = schedule
+	m.TopUpAmounts = append([]Money(nil), cfg.TopUpAmounts...)
+	m.Timeout, m.RefundWindow = timeout, window
+	m.ExactChangeThreshold = cfg.ExactChangeThreshold
+	m.AudioLocale = cfg.Locale
+	for _, s := range cfg.Products {
+		m.persist(s.Type)
+	}
+	m.audit("", "reload", ch.String())
+	fmt.Fprintln(m.out(), "Configuration reloaded:", ch)
+	m.emit(ConfigReloaded{ConfigChange: ch})
+}
+
+func sameMoney(a, b []Money) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
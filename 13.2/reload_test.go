@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestReloadEmitsConfigReloaded(t *testing.T) {
+	m, _ := newTestMachine(t)
+	bus := &MemoryBus{}
+	stop, err := m.PublishEvents(&EventPublisher{Bus: bus})
+	must(t, err)
+	var got []ConfigChange
+	unsubscribe := m.Subscribe(func(e MachineEvent) {
+		if r, ok := e.(ConfigReloaded); ok {
+			got = append(got, r.ConfigChange)
+		}
+	})
+	defer unsubscribe()
+
+	cfg := DefaultConfig()
+	cfg.TimeoutSeconds++
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.Reload(cfg))
+	if len(got) != 0 {
+		t.Fatalf("reload during a sale sent %+v", got)
+	}
+	must(t, m.Cancel())
+	must(t, m.StartOver())
+	want := []ConfigChange{{Settings: []string{"timeout"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events %+v, want %+v", got, want)
+	}
+	stop()
+	var types []string
+	for _, raw := range bus.Messages["ticketmachine.events."+m.MachineID] {
+		var msg EventMessage
+		must(t, json.Unmarshal(raw, &msg))
+		if msg.Type == "config_reloaded" {
+			return
+		}
+		types = append(types, msg.Type)
+	}
+	t.Errorf("published %v, no config_reloaded", types)
+}
+
+func TestReload(t *testing.T) {
+	spec := func(cfg *Config, productType string) *ProductSpec {
+		for i := range cfg.Products {
+			if cfg.Products[i].Type == productType {
+				return &cfg.Products[i]
+			}
+		}
+		t.Fatalf("no %s in the default config", productType)
+		return nil
+	}
+	tests := []struct {
+		name    string
+		edit    func(cfg *Config)
+		inSale  bool
+		wantErr bool
+		check   func(t *testing.T, m *TicketMachine)
+	}{
+		{"reprice", func(cfg *Config) { spec(cfg, "metro").Price = KZT(350) }, false, false,
+			func(t *testing.T, m *TicketMachine) {
+				if p, _ := m.Catalog.Product("metro"); p.Price != KZT(350) {
+					t.Errorf("metro price %s, want 350.00", p.Price)
+				}
+			}},
+		{"remove product", func(cfg *Config) {
+			products := cfg.Products[:0]
+			for _, p := range cfg.Products {
+				if p.Type != "bus" {
+					products = append(products, p)
+				}
+			}
+			cfg.Products = products
+		}, false, false,
+			func(t *testing.T, m *TicketMachine) {
+				if err := m.SelectTicket("bus", 1); err == nil {
+					t.Error("removed product still on sale")
+				}
+			}},
+		{"invalid config", func(cfg *Config) { spec(cfg, "metro").Price = 0 }, false, true,
+			func(t *testing.T, m *TicketMachine) {
+				if p, _ := m.Catalog.Product("metro"); p.Price != KZT(300) {
+					t.Errorf("metro price %s after a rejected reload", p.Price)
+				}
+			}},
+		{"deferred during a sale", func(cfg *Config) { spec(cfg, "metro").Price = KZT(350) }, true, false,
+			func(t *testing.T, m *TicketMachine) {
+				if p, _ := m.Catalog.Product("metro"); p.Price != KZT(300) {
+					t.Fatalf("metro repriced to %s during a sale", p.Price)
+				}
+				must(t, m.Cancel())
+				must(t, m.StartOver())
+				if p, _ := m.Catalog.Product("metro"); p.Price != KZT(350) {
+					t.Errorf("metro price %s after the sale, want 350.00", p.Price)
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			cfg := DefaultConfig()
+			tt.edit(&cfg)
+			if tt.inSale {
+				must(t, m.SelectTicket("metro", 1))
+			}
+			if err := m.Reload(cfg); (err != nil) != tt.wantErr {
+				t.Fatalf("Reload: %v, want error %t", err, tt.wantErr)
+			}
+			tt.check(t, m)
+		})
+	}
+}
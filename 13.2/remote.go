@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ReasonIncident is the reason code for machines stopped during an
+// incident, e.g. a station evacuation.
+const ReasonIncident ReasonCode = "incident"
+
+// CommandAction is what a remote command asks the machine to do.
+type CommandAction string
+
+const (
+	CommandDisable CommandAction = "disable"
+	CommandEnable  CommandAction = "enable"
+)
+
+// RemoteCommand is an operation invoked by the central server. Seq must
+// increase with every command; MachineID, when set, restricts it to one
+// machine. A disable with Drain lets the current customer finish instead
+// of canceling the transaction and returning their money.
+type RemoteCommand struct {
+	Seq       int           `json:"seq"`
+	MachineID string        `json:"machine_id,omitempty"`
+	Action    CommandAction `json:"action"`
+	Reason    ReasonCode    `json:"reason,omitempty"`
+	Detail    string        `json:"detail,omitempty"`
+	Drain     bool          `json:"drain,omitempty"`
+}
+
+// SignedCommand is the envelope a remote command travels in; the
+// signature is over the raw Command bytes.
+type SignedCommand struct {
+	KeyID     string `json:"kid"`
+	Command   []byte `json:"cmd"`
+	Signature []byte `json:"sig"`
+}
+
+// SignCommand builds a signed command envelope.
+func SignCommand(cmd RemoteCommand, signer TicketSigner) ([]byte, error) {
+	raw, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.Sign(raw)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(SignedCommand{KeyID: signer.KeyID(), Command: raw, Signature: sig})
+}
+
+// HandleCommand verifies a signed remote command with the ConfigVerifier
+// keys and carries it out.
+func (m *TicketMachine) HandleCommand(envelope []byte) error {
+	cmd, err := m.verifyCommand(envelope)
+	if err == nil {
+		m.CommandSeq = cmd.Seq
+		switch cmd.Action {
+		case CommandDisable:
+			err = m.remoteDisable(cmd)
+		case CommandEnable:
+			err = m.remoteEnable(cmd)
+		default:
+			err = newErrorf(CodeInvalidInput, "unknown command %s", cmd.Action)
+		}
+	}
+	if err != nil {
+		m.audit("", "command_rejected", err.Error())
+	}
+	return err
+}
+
+func (m *TicketMachine) verifyCommand(envelope []byte) (RemoteCommand, error) {
+	var env SignedCommand
+	if err := json.Unmarshal(envelope, &env); err != nil {
+		return RemoteCommand{}, newError(CodeInvalidInput, "malformed command envelope")
+	}
+	if m.ConfigVerifier == nil {
+		return RemoteCommand{}, newError(CodeInvalidConfig, "remote commands not enabled")
+	}
+	if err := m.ConfigVerifier.VerifySignature(env.KeyID, env.Command, env.Signature); err != nil {
+		return RemoteCommand{}, newErrorf(CodeInvalidInput, "command signature: %w", err)
+	}
+	var cmd RemoteCommand
+	if err := json.Unmarshal(env.Command, &cmd); err != nil {
+		return RemoteCommand{}, newErrorf(CodeInvalidInput, "malformed command: %w", err)
+	}
+	if cmd.Seq <= m.CommandSeq {
+		return RemoteCommand{}, newErrorf(CodeInvalidInput, "command %d already seen", cmd.Seq)
+	}
+	if cmd.MachineID != "" && cmd.MachineID != m.MachineID {
+		return RemoteCommand{}, newErrorf(CodeInvalidInput, "command for %s", cmd.MachineID)
+	}
+	return cmd, nil
+}
+
+func (m *TicketMachine) remoteDisable(cmd RemoteCommand) error {
+	reason := cmd.Reason
+	if reason == "" {
+		reason = ReasonOperator
+	}
+	oos := &OutOfServiceState{Reason: reason, Detail: cmd.Detail}
+	switch s := m.State.(type) {
+	case *IdleState, *CashBoxFullState, *OutOfServiceState, *MaintenanceState:
+	case *LockedState:
+		return m.checkUnlocked()
+	case *CoinJamState:
+		m.draining = oos
+		m.audit("", "remote_drain", string(reason))
+		return nil
+	case *AdminState:
+		s.Session.resume = oos
+		m.audit("", "remote_disable", string(reason))
+		return nil
+	default:
+		if cmd.Drain {
+			m.draining = oos
+			m.audit("", "remote_drain", string(reason))
+			fmt.Fprintln(m.out(), "Going out of service after the current transaction.")
+			return nil
+		}
+	}
+	m.audit("", "remote_disable", string(reason))
+	m.stopService(oos)
+	fmt.Fprintln(m.out(), "Out of service:", oos.err())
+	return nil
+}
+
+func (m *TicketMachine) remoteEnable(cmd RemoteCommand) error {
+	if m.draining != nil {
+		m.draining = nil
+		m.audit("", "remote_enable", "drain canceled")
+		return nil
+	}
+	if s, ok := m.State.(*AdminState); ok {
+		s.Session.resume = nil
+		m.audit("", "remote_enable", "")
+		return nil
+	}
+	if m.inService() == nil {
+		return newError(CodeInvalidState, "machine is in service")
+	}
+	if err := m.checkUnlocked(); err != nil {
+		return err
+	}
+	m.audit("", "remote_enable", "")
+	m.SetState(m.readyState())
+	fmt.Fprintln(m.out(), "Back in service.")
+	return nil
+}
+
+// Command sends a signed remote command to one machine, or to every
+// machine when machineID is empty.
+func (f *Fleet) Command(machineID string, envelope []byte) error {
+	if machineID != "" {
+		return f.Do(machineID, func(m *TicketMachine) error { return m.HandleCommand(envelope) })
+	}
+	return f.Each(func(m *TicketMachine) error { return m.HandleCommand(envelope) })
+}
@@ -0,0 +1,100 @@
+package main
+
+import "testing"
+
+func newCommandMachine(t *testing.T) (*TicketMachine, *HMACSigner) {
+	t.Helper()
+	hq := &HMACSigner{ID: "hq-1", Key: []byte("hq-secret")}
+	m, _ := newTestMachine(t)
+	m.MachineID = "TM-1"
+	m.ConfigVerifier = NewTicketVerifier(m.Clock)
+	m.ConfigVerifier.AddHMACKey(hq.ID, hq.Key)
+	return m, hq
+}
+
+func signCommand(t *testing.T, cmd RemoteCommand, signer TicketSigner) []byte {
+	t.Helper()
+	env, err := SignCommand(cmd, signer)
+	must(t, err)
+	return env
+}
+
+func TestHandleCommand(t *testing.T) {
+	m, hq := newCommandMachine(t)
+	must(t, m.HandleCommand(signCommand(t, RemoteCommand{Seq: 1, Action: CommandDisable, Reason: ReasonIncident}, hq)))
+	s, ok := m.State.(*OutOfServiceState)
+	if !ok || s.Reason != ReasonIncident {
+		t.Fatalf("state %s after disable", m.State.Name())
+	}
+
+	rejected := []struct {
+		name string
+		env  []byte
+	}{
+		{"replayed", signCommand(t, RemoteCommand{Seq: 1, Action: CommandEnable}, hq)},
+		{"other machine", signCommand(t, RemoteCommand{Seq: 2, MachineID: "TM-2", Action: CommandEnable}, hq)},
+		{"untrusted key", signCommand(t, RemoteCommand{Seq: 3, Action: CommandEnable}, &HMACSigner{ID: "hq-1", Key: []byte("guess")})},
+		{"unknown action", signCommand(t, RemoteCommand{Seq: 4, Action: "reboot"}, hq)},
+		{"malformed", []byte("enable")},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.HandleCommand(tt.env); CodeOf(err) != CodeInvalidInput {
+				t.Fatalf("HandleCommand = %v, want %s", err, CodeInvalidInput)
+			}
+			if _, ok := m.State.(*OutOfServiceState); !ok {
+				t.Fatalf("state %s after a rejected command", m.State.Name())
+			}
+		})
+	}
+
+	must(t, m.HandleCommand(signCommand(t, RemoteCommand{Seq: 5, MachineID: "TM-1", Action: CommandEnable}, hq)))
+	if m.GetCurrentState() != (&IdleState{}).Name() {
+		t.Fatalf("state %s after enable", m.State.Name())
+	}
+	if err := m.HandleCommand(signCommand(t, RemoteCommand{Seq: 6, Action: CommandEnable}, hq)); CodeOf(err) != CodeInvalidState {
+		t.Fatalf("enable in service = %v, want %s", err, CodeInvalidState)
+	}
+}
+
+func TestRemoteDisableDuringSale(t *testing.T) {
+	for _, drain := range []bool{false, true} {
+		name := "immediate"
+		if drain {
+			name = "drain"
+		}
+		t.Run(name, func(t *testing.T) {
+			m, hq := newCommandMachine(t)
+			must(t, m.SelectTicket("metro", 1))
+			must(t, m.InsertMoney(KZT(200)))
+			must(t, m.HandleCommand(signCommand(t, RemoteCommand{Seq: 1, Action: CommandDisable, Drain: drain}, hq)))
+			if !drain {
+				if _, ok := m.State.(*OutOfServiceState); !ok || m.InsertedMoney != 0 {
+					t.Fatalf("state %s, %s still inserted", m.State.Name(), m.InsertedMoney)
+				}
+				return
+			}
+			must(t, m.InsertMoney(KZT(100)))
+			_, err := m.DispenseTicket()
+			must(t, err)
+			must(t, m.StartOver())
+			if _, ok := m.State.(*OutOfServiceState); !ok {
+				t.Fatalf("state %s after the drained sale", m.State.Name())
+			}
+		})
+	}
+}
+
+func TestFleetCommand(t *testing.T) {
+	f, a, b := newTestFleet(t)
+	hq := &HMACSigner{ID: "hq-1", Key: []byte("hq-secret")}
+	for _, m := range []*TicketMachine{a, b} {
+		m.ConfigVerifier = NewTicketVerifier(m.Clock)
+		m.ConfigVerifier.AddHMACKey(hq.ID, hq.Key)
+	}
+	must(t, f.Command("", signCommand(t, RemoteCommand{Seq: 1, Action: CommandDisable}, hq)))
+	must(t, f.Command("TM-B", signCommand(t, RemoteCommand{Seq: 2, Action: CommandEnable}, hq)))
+	if _, ok := a.State.(*OutOfServiceState); !ok || b.GetCurrentState() != (&IdleState{}).Name() {
+		t.Fatalf("states %s, %s", a.State.Name(), b.State.Name())
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+)
+
+// SalesGroup sums the sales of one product, hour, day or tender.
+type SalesGroup struct {
+	Key     string `json:"key"`
+	Tickets int    `json:"tickets"`
+	Revenue Money  `json:"revenue"`
+}
+
+// SalesReport aggregates the sales of a date range. Hours are hours of
+// the day, 00 to 23; days are dates, both in the machine's time zone.
+// Tender revenue is net of change and donations.
+type SalesReport struct {
+	From         time.Time    `json:"from"`
+	To           time.Time    `json:"to"`
+	Transactions int          `json:"transactions"`
+	Tickets      int          `json:"tickets"`
+	Revenue      Money        `json:"revenue"`
+	ByProduct    []SalesGroup `json:"by_product"`
+	ByHour       []SalesGroup `json:"by_hour"`
+	ByDay        []SalesGroup `json:"by_day"`
+	ByTender     []SalesGroup `json:"by_tender"`
+}
+
+// SalesReport reports on the sales with from <= Time < to, read from the
+// Store when one is set and from Transactions otherwise; a zero bound is
+// open.
+func (m *TicketMachine) SalesReport(from, to time.Time) (SalesReport, error) {
+	sales, err := m.sales(from, to)
+	if err != nil {
+		return SalesReport{}, err
+	}
+	r := SalesReport{From: from, To: to, Transactions: len(sales)}
+	groups := map[string]map[string]*SalesGroup{}
+	add := func(dim, key string, tickets int, revenue Money) {
+		g, ok := groups[dim][key]
+		if !ok {
+			if groups[dim] == nil {
+				groups[dim] = map[string]*SalesGroup{}
+			}
+			g = &SalesGroup{Key: key}
+			groups[dim][key] = g
+		}
+		g.Tickets += tickets
+		g.Revenue += revenue
+	}
+	for _, t := range sales {
+		lines := t.Lines
+		if len(lines) == 0 {
+			lines = []CartLine{{TicketType: t.Product, Qty: t.Quantity, UnitPrice: t.Price}}
+		}
+		tickets := 0
+		for i, l := range lines {
+			tickets += l.Qty
+			add("product", l.TicketType, l.Qty, allocate(t.Price, lines, i))
+		}
+		r.Tickets += tickets
+		r.Revenue += t.Price
+		local := t.Time.In(m.Clock.Now().Location())
+		add("hour", local.Format("15"), tickets, t.Price)
+		add("day", local.Format("2006-01-02"), tickets, t.Price)
+		for tender, v := range netTenders(t) {
+			add("tender", string(tender), tickets, v)
+		}
+	}
+	list := func(dim string) []SalesGroup {
+		out := []SalesGroup{}
+		for _, g := range groups[dim] {
+			out = append(out, *g)
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+		return out
+	}
+	r.ByProduct, r.ByHour, r.ByDay, r.ByTender = list("product"), list("hour"), list("day"), list("tender")
+	return r, nil
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r SalesReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// sales returns the sales with from <= Time < to.
+func (m *TicketMachine) sales(from, to time.Time) ([]TransactionRecord, error) {
+	if m.Store != nil {
+		list, err := m.Store.Sales(from, to)
+		if err != nil {
+			return nil, newErrorf(CodeStorage, "cannot load sales: %w", err)
+		}
+		return list, nil
+	}
+	var out []TransactionRecord
+	for _, t := range m.Transactions {
+		if inRange(t.Time, from, to) {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// refunds returns the refunds with from <= Time < to, from the Store when
+// there is one.
+func (m *TicketMachine) refunds(from, to time.Time) ([]RefundRecord, error) {
+	if m.Store != nil {
+		list, err := m.Store.Refunded(from, to)
+		if err != nil {
+			return nil, newErrorf(CodeStorage, "cannot load refunds: %w", err)
+		}
+		return list, nil
+	}
+	var out []RefundRecord
+	for _, r := range m.Refunds {
+		if inRange(r.Time, from, to) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// inRange reports whether from <= t < to; a zero bound is open.
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
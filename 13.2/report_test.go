@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSalesReport(t *testing.T) {
+	for _, stored := range []bool{false, true} {
+		name := "memory"
+		if stored {
+			name = "store"
+		}
+		t.Run(name, func(t *testing.T) {
+			m, clock := newTestMachine(t)
+			m.Gateway = &MockGateway{}
+			if stored {
+				db, _ := newFakeSQL(t)
+				must(t, m.UseStore(&SQLStore{DB: db}))
+			}
+			start := clock.Now()
+			// Two metro tickets paid 500 + 200 in cash, 100 back as change.
+			must(t, m.SelectTicket("metro", 2))
+			must(t, m.InsertMoney(KZT(500)))
+			must(t, m.InsertMoney(KZT(200)))
+			_, err := m.DispenseTicket()
+			must(t, err)
+			must(t, m.StartOver())
+
+			clock.Advance(90 * time.Minute)
+			must(t, m.SelectTicket("bus", 1))
+			must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}))
+			_, err = m.DispenseTicket()
+			must(t, err)
+			must(t, m.StartOver())
+
+			clock.Advance(24 * time.Hour)
+			sellMetro(t, m)
+
+			r, err := m.SalesReport(start, start.Add(24*time.Hour))
+			must(t, err)
+			if r.Transactions != 2 || r.Tickets != 3 || r.Revenue != KZT(850) {
+				t.Fatalf("report totals = %d transactions, %d tickets, %s", r.Transactions, r.Tickets, r.Revenue)
+			}
+			groups := map[string][]SalesGroup{
+				"product": {{"bus", 1, KZT(250)}, {"metro", 2, KZT(600)}},
+				"hour":    {{"12", 2, KZT(600)}, {"13", 1, KZT(250)}},
+				"day":     {{"2026-03-02", 3, KZT(850)}},
+				"tender":  {{"card", 1, KZT(250)}, {"cash", 2, KZT(600)}},
+			}
+			got := map[string][]SalesGroup{"product": r.ByProduct, "hour": r.ByHour, "day": r.ByDay, "tender": r.ByTender}
+			for dim, want := range groups {
+				if !reflect.DeepEqual(got[dim], want) {
+					t.Errorf("by %s = %+v, want %+v", dim, got[dim], want)
+				}
+			}
+
+			all, err := m.SalesReport(time.Time{}, time.Time{})
+			must(t, err)
+			if all.Transactions != 3 || len(all.ByDay) != 2 {
+				t.Fatalf("open range = %+v", all)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import "fmt"
+
+// BeginRestock opens the machine for an authenticated operator to refill
+// ticket stock. Sales are blocked until EndRestock.
+func (m *TicketMachine) BeginRestock(operatorID, pin string) error {
+	if err := m.Auth.Authenticate(operatorID, pin); err != nil {
+		m.audit(operatorID, "restock_denied", err.Error())
+		return err
+	}
+	if err := m.authorize(operatorID, PermRestock, "restock"); err != nil {
+		return err
+	}
+	switch m.State.(type) {
+	case *IdleState, *CashBoxFullState:
+	default:
+		return newError(CodeBusy, "transaction in progress")
+	}
+	m.SetState(&RestockingState{OperatorID: operatorID})
+	m.audit(operatorID, "restock_begin", "")
+	fmt.Fprintln(m.out(), "Restocking. Out of service.")
+	return nil
+}
+
+// Restock adds qty tickets of ticketType to stock during restocking.
+func (m *TicketMachine) Restock(ticketType string, qty int) error {
+	s, ok := m.State.(*RestockingState)
+	if !ok {
+		return newError(CodeInvalidState, "not restocking")
+	}
+	return m.restock(s.OperatorID, ticketType, qty)
+}
+
+func (m *TicketMachine) restock(operatorID, ticketType string, qty int) error {
+	if err := m.Catalog.Restock(ticketType, qty); err != nil {
+		return err
+	}
+	m.persist(ticketType)
+	m.recordMovement(ticketType, qty, operatorID, MoveRestock, "")
+	m.audit(operatorID, "restock", fmt.Sprintf("%s +%d, now %d", ticketType, qty, m.Catalog.Stock(ticketType)))
+	fmt.Fprintf(m.out(), "Restocked %s: +%d\n", ticketType, qty)
+	return nil
+}
+
+// EndRestock puts the machine back in service.
+func (m *TicketMachine) EndRestock() error {
+	s, ok := m.State.(*RestockingState)
+	if !ok {
+		return newError(CodeInvalidState, "not restocking")
+	}
+	m.audit(s.OperatorID, "restock_end", "")
+	m.SetState(m.readyState())
+	fmt.Fprintln(m.out(), "Restocking done. In service.")
+	return nil
+}
+
+// RestockingState blocks sales while an operator refills the machine.
+type RestockingState struct {
+	OperatorID string
+}
+
+func (s *RestockingState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeOutOfService, "out of service: restocking")
+}
+func (s *RestockingState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeOutOfService, "out of service: restocking")
+}
+func (s *RestockingState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeOutOfService, "out of service: restocking")
+}
+func (s *RestockingState) Cancel(m *TicketMachine) error {
+	return newError(CodeNoTransaction, "no active transaction")
+}
+func (s *RestockingState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeOutOfService, "out of service: restocking")
+}
+func (s *RestockingState) Name() string { return "Restocking" }
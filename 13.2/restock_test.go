@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestRestocking(t *testing.T) {
+	m, _ := newTestMachine(t)
+	if err := m.Restock("metro", 5); CodeOf(err) != CodeInvalidState {
+		t.Fatalf("Restock outside restocking = %v", err)
+	}
+	must(t, m.BeginRestock("clerk", "1111"))
+	if err := m.SelectTicket("metro", 1); CodeOf(err) != CodeOutOfService {
+		t.Fatalf("SelectTicket while restocking = %v", err)
+	}
+	stock := m.Catalog.Stock("metro")
+	must(t, m.Restock("metro", 5))
+	if err := m.Restock("monorail", 5); CodeOf(err) != CodeUnknownProduct {
+		t.Fatalf("Restock unknown product = %v", err)
+	}
+	if m.Catalog.Stock("metro") != stock+5 {
+		t.Fatalf("stock = %d, want %d", m.Catalog.Stock("metro"), stock+5)
+	}
+	must(t, m.EndRestock())
+	if m.GetCurrentState() != (&IdleState{}).Name() {
+		t.Fatalf("state = %s", m.GetCurrentState())
+	}
+	var actions []string
+	for _, e := range m.AuditLog {
+		actions = append(actions, e.Action)
+	}
+	if len(actions) != 3 || actions[0] != "restock_begin" || actions[1] != "restock" || actions[2] != "restock_end" {
+		t.Fatalf("audit actions = %v", actions)
+	}
+}
+
+func TestBeginRestockRefused(t *testing.T) {
+	tests := []struct {
+		name     string
+		operator string
+		pin      string
+		selling  bool
+		code     ErrorCode
+	}{
+		{"collector lacks permission", "collector", "2222", false, CodePermissionDenied},
+		{"wrong pin", "clerk", "0000", false, CodeInvalidCredentials},
+		{"sale in progress", "clerk", "1111", true, CodeBusy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			if tt.selling {
+				must(t, m.SelectTicket("metro", 1))
+			}
+			want := m.GetCurrentState()
+			if err := m.BeginRestock(tt.operator, tt.pin); CodeOf(err) != tt.code {
+				t.Fatalf("BeginRestock = %v, want %s", err, tt.code)
+			}
+			if m.GetCurrentState() != want {
+				t.Fatalf("state = %s, want %s", m.GetCurrentState(), want)
+			}
+		})
+	}
+}
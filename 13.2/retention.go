@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy limits how long sales and logs are kept on the machine.
+type RetentionPolicy struct {
+	// Keep is how long records are kept; zero keeps them for good.
+	Keep time.Duration
+	// Archive, when set, receives the sales and refunds about to be
+	// pruned as CSV, in the ExportSales format; pruning stops if the
+	// archive cannot be written.
+	Archive io.Writer
+}
+
+// PruneReport counts the records Prune removed.
+type PruneReport struct {
+	Cutoff       time.Time
+	Transactions int
+	Refunds      int
+	AuditEntries int
+	Movements    int
+}
+
+// Prune removes the records older than Retention.Keep, from the machine
+// and from the Store. Sales and refunds are only removed once reconciled:
+// booked before the last cash collection, registered with the fiscal
+// device and past the refund window.
+func (m *TicketMachine) Prune() (PruneReport, error) {
+	if m.Retention.Keep <= 0 {
+		return PruneReport{}, nil
+	}
+	now := m.Clock.Now()
+	r := PruneReport{Cutoff: now.Add(-m.Retention.Keep)}
+	sales := r.Cutoff
+	if n := len(m.Collections); n == 0 {
+		sales = time.Time{}
+	} else if c := m.Collections[n-1].Time; c.Before(sales) {
+		sales = c
+	}
+	if w := now.Add(-m.RefundWindow); w.Before(sales) {
+		sales = w
+	}
+	unfiscal := map[string]bool{}
+	for _, f := range m.FiscalQueue {
+		unfiscal[f.TransactionID] = true
+	}
+
+	of := map[string]TransactionRecord{}
+	var pruned, kept []TransactionRecord
+	for _, t := range m.Transactions {
+		of[t.ID] = t
+		if t.Time.Before(sales) && !unfiscal[t.ID] {
+			pruned = append(pruned, t)
+		} else {
+			kept = append(kept, t)
+		}
+	}
+	seen := map[string]bool{}
+	var refunds, prunedRefunds []RefundRecord
+	for _, rf := range m.Refunds {
+		if rf.Time.Before(sales) && !unfiscal[rf.TransactionID] {
+			prunedRefunds = append(prunedRefunds, rf)
+			seen[rf.TicketID] = true
+		} else {
+			refunds = append(refunds, rf)
+		}
+	}
+	store := m.Store != nil && !sales.IsZero()
+	if store {
+		list, err := m.Store.Sales(time.Time{}, sales)
+		if err != nil {
+			return PruneReport{}, newErrorf(CodeStorage, "cannot load sales, nothing pruned: %w", err)
+		}
+		for _, t := range list {
+			if _, ok := of[t.ID]; !ok && !unfiscal[t.ID] {
+				of[t.ID] = t
+				pruned = append(pruned, t)
+			}
+		}
+		stored, err := m.Store.Refunded(time.Time{}, sales)
+		if err != nil {
+			return PruneReport{}, newErrorf(CodeStorage, "cannot load refunds, nothing pruned: %w", err)
+		}
+		for _, rf := range stored {
+			if !seen[rf.TicketID] && !unfiscal[rf.TransactionID] {
+				prunedRefunds = append(prunedRefunds, rf)
+			}
+		}
+		sort.SliceStable(pruned, func(i, j int) bool { return pruned[i].Time.Before(pruned[j].Time) })
+		sort.SliceStable(prunedRefunds, func(i, j int) bool { return prunedRefunds[i].Time.Before(prunedRefunds[j].Time) })
+	}
+	if m.Retention.Archive != nil && len(pruned)+len(prunedRefunds) > 0 {
+		if err := writeSalesCSV(m.Retention.Archive, pruned, prunedRefunds, of); err != nil {
+			return PruneReport{}, newErrorf(CodeStorage, "archive failed, nothing pruned: %w", err)
+		}
+	}
+	if store {
+		if err := m.Store.Prune(sales, unfiscal); err != nil {
+			return PruneReport{}, newErrorf(CodeStorage, "store not pruned: %w", err)
+		}
+	}
+	m.Transactions = kept
+	r.Transactions = len(pruned)
+	m.Refunds = refunds
+	r.Refunds = len(prunedRefunds)
+
+	var audit []AuditEntry
+	for _, e := range m.AuditLog {
+		if e.Time.Before(r.Cutoff) {
+			r.AuditEntries++
+			continue
+		}
+		audit = append(audit, e)
+	}
+	m.AuditLog = audit
+	r.Movements = m.Movements.prune(r.Cutoff)
+
+	m.audit("", "prune", fmt.Sprintf("%d sales, %d refunds, %d audit entries, %d movements before %s",
+		r.Transactions, r.Refunds, r.AuditEntries, r.Movements, r.Cutoff.Format("2006-01-02")))
+	return r, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// prunableMachine has, in an SQL store, a refunded sale and a sale not
+// yet registered with the fiscal device from before a cash collection 100
+// days ago, and a sale from today. The machine itself holds none of them,
+// as after a restart.
+func prunableMachine(t *testing.T) (*TicketMachine, *SQLStore, [3]string) {
+	t.Helper()
+	db, _ := newFakeSQL(t)
+	s := &SQLStore{DB: db}
+	m, clock := newTestMachine(t)
+	must(t, m.UseStore(s))
+	var ids [3]string
+	sellMetro(t, m)
+	ids[0] = m.TransactionID
+	m.StartOver()
+	if _, err := m.RefundTicket(m.Transactions[0].Tickets[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	m.StartOver()
+	sellMetro(t, m)
+	ids[1] = m.TransactionID
+	m.StartOver()
+	clock.Advance(time.Hour)
+	if _, err := m.CollectCash("admin", "0000"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(100 * 24 * time.Hour)
+	sellMetro(t, m)
+	ids[2] = m.TransactionID
+	m.StartOver()
+	m.Transactions, m.Refunds = nil, nil
+	m.FiscalQueue = []FiscalSale{{TransactionID: ids[1]}}
+	return m, s, ids
+}
+
+func TestPruneStore(t *testing.T) {
+	m, s, ids := prunableMachine(t)
+	var archive bytes.Buffer
+	m.Retention.Archive = &archive
+	r, err := m.Prune()
+	must(t, err)
+	if r.Transactions != 1 || r.Refunds != 1 {
+		t.Errorf("pruned %d sales and %d refunds, want 1 and 1", r.Transactions, r.Refunds)
+	}
+	sales, err := s.Sales(time.Time{}, time.Time{})
+	must(t, err)
+	if len(sales) != 2 || sales[0].ID != ids[1] || sales[1].ID != ids[2] {
+		t.Errorf("stored sales %+v, want %s and %s", sales, ids[1], ids[2])
+	}
+	refunds, err := s.Refunded(time.Time{}, time.Time{})
+	must(t, err)
+	if len(refunds) != 0 {
+		t.Errorf("refunds %+v left in the store", refunds)
+	}
+	rows := strings.Split(strings.TrimSpace(archive.String()), "\n")
+	if len(rows) != 3 || !strings.HasPrefix(rows[1], ids[0]+",") || !strings.HasPrefix(rows[2], ids[0]+",") ||
+		!strings.Contains(rows[2], ",metro,-1,") {
+		t.Errorf("archive:\n%s", archive.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestPruneStoreKeepsAllWhenArchiveFails(t *testing.T) {
+	m, s, _ := prunableMachine(t)
+	m.Retention.Archive = failingWriter{}
+	if _, err := m.Prune(); CodeOf(err) != CodeStorage {
+		t.Fatalf("Prune: %v, want a storage error", err)
+	}
+	sales, err := s.Sales(time.Time{}, time.Time{})
+	must(t, err)
+	refunds, err := s.Refunded(time.Time{}, time.Time{})
+	must(t, err)
+	if len(sales) != 3 || len(refunds) != 1 {
+		t.Errorf("%d sales and %d refunds stored, want all 3 and 1", len(sales), len(refunds))
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import "fmt"
+
+// Permission is the right to perform one kind of operator action.
+type Permission string
+
+const (
+	PermRestock     Permission = "restock"
+	PermSetPrice    Permission = "set_price"
+	PermCollectCash Permission = "collect_cash"
+	PermDiagnostics Permission = "diagnostics"
+	PermService     Permission = "service"
+	PermReprint     Permission = "reprint"
+	PermSecurity    Permission = "clear_security"
+)
+
+// Role is a staff function with a set of permissions.
+type Role string
+
+const (
+	RoleRefillClerk   Role = "refill_clerk"
+	RoleTechnician    Role = "technician"
+	RoleCashCollector Role = "cash_collector"
+	RoleSupervisor    Role = "supervisor"
+)
+
+// DefaultRoles are the permissions of each role.
+func DefaultRoles() map[Role][]Permission {
+	return map[Role][]Permission{
+		RoleRefillClerk:   {PermRestock, PermReprint},
+		RoleTechnician:    {PermDiagnostics, PermService, PermReprint},
+		RoleCashCollector: {PermCollectCash},
+		RoleSupervisor:    {PermRestock, PermSetPrice, PermCollectCash, PermDiagnostics, PermService, PermReprint, PermSecurity},
+	}
+}
+
+// PermissionDeniedError is returned when an operator lacks a permission.
+type PermissionDeniedError struct {
+	OperatorID string
+	Permission Permission
+}
+
+func (e *PermissionDeniedError) ErrorCode() ErrorCode { return CodePermissionDenied }
+
+func (e *PermissionDeniedError) Error() string {
+	return fmt.Sprintf("operator %s may not %s", e.OperatorID, e.Permission)
+}
+
+// Authorizer decides whether an authenticated operator may do something.
+type Authorizer interface {
+	Authorize(operatorID string, p Permission) error
+}
+
+// RoleAuthorizer grants permissions through the roles of each operator.
+type RoleAuthorizer struct {
+	Roles     map[Role][]Permission
+	Operators map[string][]Role
+}
+
+func (a *RoleAuthorizer) Authorize(operatorID string, p Permission) error {
+	for _, r := range a.Operators[operatorID] {
+		for _, granted := range a.Roles[r] {
+			if granted == p {
+				return nil
+			}
+		}
+	}
+	return &PermissionDeniedError{OperatorID: operatorID, Permission: p}
+}
+
+// authorize checks a permission for an admin action, auditing a denial.
+// Without an Authorizer every authenticated operator may do anything.
+func (m *TicketMachine) authorize(operatorID string, p Permission, action string) error {
+	if m.Authz == nil {
+		return nil
+	}
+	if err := m.Authz.Authorize(operatorID, p); err != nil {
+		m.audit(operatorID, action+"_denied", err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRoleAuthorizer(t *testing.T) {
+	a := &RoleAuthorizer{Roles: DefaultRoles(), Operators: map[string][]Role{
+		"ana":  {RoleRefillClerk},
+		"bek":  {RoleTechnician, RoleCashCollector},
+		"boss": {RoleSupervisor},
+	}}
+	tests := []struct {
+		operator string
+		perm     Permission
+		ok       bool
+	}{
+		{"ana", PermRestock, true},
+		{"ana", PermSetPrice, false},
+		{"bek", PermCollectCash, true},
+		{"bek", PermService, true},
+		{"bek", PermRestock, false},
+		{"boss", PermSecurity, true},
+		{"nobody", PermReprint, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.operator+" "+string(tt.perm), func(t *testing.T) {
+			err := a.Authorize(tt.operator, tt.perm)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Authorize = %v, want ok %v", err, tt.ok)
+			}
+			var denied *PermissionDeniedError
+			if !tt.ok && (!errors.As(err, &denied) || denied.Permission != tt.perm || CodeOf(err) != CodePermissionDenied) {
+				t.Fatalf("err = %#v", err)
+			}
+		})
+	}
+}
+
+func TestSessionActionsNeedPermission(t *testing.T) {
+	m, _ := newTestMachine(t)
+	s, err := m.EnterAdminMode(Credentials{OperatorID: "clerk", PIN: "1111"})
+	must(t, err)
+	must(t, s.Restock("metro", 1))
+	if err := s.SetPrice("metro", KZT(1)); CodeOf(err) != CodePermissionDenied {
+		t.Fatalf("clerk setting a price = %v", err)
+	}
+	if _, err := s.CollectCash(); CodeOf(err) != CodePermissionDenied {
+		t.Fatalf("clerk collecting cash = %v", err)
+	}
+	if got := m.AuditLog[len(m.AuditLog)-1].Action; got != "collect_cash_denied" {
+		t.Fatalf("last audit action = %q", got)
+	}
+	if m.GetTicketPrice("metro") != KZT(300) {
+		t.Fatalf("price changed to %s", m.GetTicketPrice("metro"))
+	}
+}
+
+func TestNoAuthorizerAllowsEveryOperator(t *testing.T) {
+	m, _ := newTestMachine(t)
+	m.Authz = nil
+	s, err := m.EnterAdminMode(Credentials{OperatorID: "clerk", PIN: "1111"})
+	must(t, err)
+	must(t, s.SetPrice("metro", KZT(320)))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeOfDay is a wall-clock time as minutes since midnight.
+type TimeOfDay int
+
+// At returns the TimeOfDay for h:m.
+func At(h, m int) TimeOfDay { return TimeOfDay(h*60 + m) }
+
+func timeOfDay(t time.Time) TimeOfDay { return At(t.Hour(), t.Minute()) }
+
+// PriceRule overrides the price of a ticket type on the given weekdays
+// (all days when empty) between From and To. A window with From after To
+// runs over midnight.
+type PriceRule struct {
+	TicketType string
+	Days       []time.Weekday
+	From, To   TimeOfDay
+	Price      Money
+}
+
+func (r PriceRule) matches(ticketType string, at time.Time) bool {
+	if r.TicketType != ticketType {
+		return false
+	}
+	if len(r.Days) > 0 {
+		ok := false
+		for _, d := range r.Days {
+			ok = ok || d == at.Weekday()
+		}
+		if !ok {
+			return false
+		}
+	}
+	t := timeOfDay(at)
+	if r.From <= r.To {
+		return t >= r.From && t < r.To
+	}
+	return t >= r.From || t < r.To
+}
+
+// PriceSchedule is an ordered list of price rules; the first match wins.
+type PriceSchedule struct {
+	Rules []PriceRule
+}
+
+// Price returns the scheduled price of ticketType at the given time.
+func (s *PriceSchedule) Price(ticketType string, at time.Time) (Money, bool) {
+	for _, r := range s.Rules {
+		if r.matches(ticketType, at) {
+			return r.Price, true
+		}
+	}
+	return 0, false
+}
+
+// Weekdays is Monday to Friday, for peak-hour rules.
+var Weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+// weekdayNames are the day names of a PriceRuleSpec.
+var weekdayNames = map[string]time.Weekday{
+	"mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday,
+	"fri": time.Friday, "sat": time.Saturday, "sun": time.Sunday,
+}
+
+// parseTimeOfDay parses "HH:MM"; "24:00" is the end of the day.
+func parseTimeOfDay(s string) (TimeOfDay, error) {
+	var h, m int
+	if n, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || n != 2 || len(s) != 5 {
+		return 0, fmt.Errorf("%q is not HH:MM", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("%q is not a time of day", s)
+	}
+	return At(h, m), nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduledMetroPrice(t *testing.T) {
+	tests := []struct {
+		name string
+		at   time.Time
+		want Money
+	}{
+		{"weekday rush hour", time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC), KZT(300)},
+		{"weekday midday", time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC), KZT(280)},
+		{"weekend", time.Date(2026, 3, 7, 8, 0, 0, 0, time.UTC), KZT(280)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, clock := newTestMachine(t)
+			clock.T = tt.at
+			must(t, m.Catalog.SetPrice("metro", KZT(280)))
+			if got := m.GetTicketPrice("metro"); got != tt.want {
+				t.Errorf("price %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Seat is a reservable seat on a train.
+type Seat struct {
+	Coach  string `json:"coach"`
+	Number string `json:"number"`
+}
+
+func (s Seat) String() string { return "coach " + s.Coach + " seat " + s.Number }
+
+// SeatInventory is the reservation system behind seated products. A seat
+// reserved for a transaction is held until it is released; seats of a
+// completed sale are never released.
+type SeatInventory interface {
+	Available(ticketType string) ([]Seat, error)
+	Reserve(txID, ticketType string, seat Seat) error
+	Release(txID, ticketType string, seat Seat) error
+}
+
+// MockSeatInventory is an in-memory SeatInventory.
+type MockSeatInventory struct {
+	Seats map[string][]Seat
+	held  map[string]map[Seat]string
+}
+
+func (s *MockSeatInventory) Available(ticketType string) ([]Seat, error) {
+	var free []Seat
+	for _, seat := range s.Seats[ticketType] {
+		if _, taken := s.held[ticketType][seat]; !taken {
+			free = append(free, seat)
+		}
+	}
+	return free, nil
+}
+
+func (s *MockSeatInventory) Reserve(txID, ticketType string, seat Seat) error {
+	known := false
+	for _, x := range s.Seats[ticketType] {
+		known = known || x == seat
+	}
+	if !known {
+		return newErrorf(CodeSeatUnavailable, "no %s", seat)
+	}
+	if s.held == nil {
+		s.held = map[string]map[Seat]string{}
+	}
+	if s.held[ticketType] == nil {
+		s.held[ticketType] = map[Seat]string{}
+	}
+	if _, taken := s.held[ticketType][seat]; taken {
+		return newErrorf(CodeSeatUnavailable, "%s is taken", seat)
+	}
+	s.held[ticketType][seat] = txID
+	return nil
+}
+
+func (s *MockSeatInventory) Release(txID, ticketType string, seat Seat) error {
+	if s.held[ticketType][seat] != txID {
+		return newErrorf(CodeSeatUnavailable, "%s not held by %s", seat, txID)
+	}
+	delete(s.held[ticketType], seat)
+	return nil
+}
+
+// NewMockSeatInventory lays out coaches of the given seat count for
+// ticketType.
+func NewMockSeatInventory(ticketType string, coaches, seats int) *MockSeatInventory {
+	inv := &MockSeatInventory{Seats: map[string][]Seat{}}
+	for c := 1; c <= coaches; c++ {
+		for n := 1; n <= seats; n++ {
+			inv.Seats[ticketType] = append(inv.Seats[ticketType], Seat{Coach: fmt.Sprint(c), Number: fmt.Sprint(n)})
+		}
+	}
+	return inv
+}
+
+// isSeated reports whether ticketType offers seat selection.
+func (m *TicketMachine) isSeated(ticketType string) bool {
+	p, _ := m.Catalog.Product(ticketType)
+	return m.Seats != nil && p.Seated
+}
+
+// AvailableSeats lists the free seats for the selected ticket.
+func (m *TicketMachine) AvailableSeats() ([]Seat, error) {
+	if _, ok := m.State.(*SelectSeatState); !ok {
+		return nil, newError(CodeInvalidState, "no seat to select")
+	}
+	seats, err := m.Seats.Available(m.Cart[0].TicketType)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(seats, func(i, j int) bool {
+		if seats[i].Coach != seats[j].Coach {
+			return seats[i].Coach < seats[j].Coach
+		}
+		return seats[i].Number < seats[j].Number
+	})
+	return seats, nil
+}
+
+// SelectSeat reserves a seat for the next ticket of the line. Once every
+// ticket has a seat the machine waits for payment.
+func (m *TicketMachine) SelectSeat(seat Seat) error {
+	if err := m.logAction(JournalEntry{Action: "seat", Seat: &seat}); err != nil {
+		return err
+	}
+	if _, ok := m.State.(*SelectSeatState); !ok {
+		return newError(CodeInvalidState, "no seat to select")
+	}
+	l := &m.Cart[0]
+	if err := m.deviceCall("seats.reserve", nil, func() error { return m.Seats.Reserve(m.TransactionID, l.TicketType, seat) }); err != nil {
+		return err
+	}
+	l.Seats = append(l.Seats, seat)
+	m.show("Seat reserved: %v", seat)
+	if len(l.Seats) == l.Qty {
+		m.SetState(&WaitingForMoneyState{})
+		m.show("Please pay %s", m.formatPrice(m.CurrentPrice))
+	} else {
+		m.SetState(&SelectSeatState{})
+	}
+	return nil
+}
+
+// SkipSeat goes on to payment without reserving the remaining seats.
+func (m *TicketMachine) SkipSeat() error {
+	if err := m.logAction(JournalEntry{Action: "skip_seat"}); err != nil {
+		return err
+	}
+	if _, ok := m.State.(*SelectSeatState); !ok {
+		return newError(CodeInvalidState, "no seat to select")
+	}
+	m.SetState(&WaitingForMoneyState{})
+	m.show("Please pay %s", m.formatPrice(m.CurrentPrice))
+	return nil
+}
+
+// releaseSeats gives back the seats held by the current cart.
+func (m *TicketMachine) releaseSeats() {
+	if m.Seats == nil {
+		return
+	}
+	for i := range m.Cart {
+		l := &m.Cart[i]
+		for _, seat := range l.Seats {
+			if err := m.deviceCall("seats.release", nil, func() error { return m.Seats.Release(m.TransactionID, l.TicketType, seat) }); err != nil {
+				m.warn("seat release failed: %v", err)
+			}
+		}
+		l.Seats = nil
+	}
+}
+
+// releaseSeat frees the seat reserved for a ticket taken back.
+func (m *TicketMachine) releaseSeat(t Ticket) {
+	if m.Seats == nil || t.Seat == "" {
+		return
+	}
+	if err := m.deviceCall("seats.release", nil, func() error {
+		return m.Seats.Release(t.TransactionID, t.Type, Seat{Coach: t.Coach, Number: t.Seat})
+	}); err != nil {
+		m.warn("seat release failed: %v", err)
+	}
+}
+
+// SelectSeatState offers seat selection for a seated product.
+type SelectSeatState struct{}
+
+func (s *SelectSeatState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeStepRequired, "please select a seat")
+}
+func (s *SelectSeatState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeStepRequired, "please select a seat or skip")
+}
+func (s *SelectSeatState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeStepRequired, "please select a seat or skip")
+}
+func (s *SelectSeatState) Cancel(m *TicketMachine) error {
+	m.SetState(&TransactionCanceledState{})
+	return nil
+}
+func (s *SelectSeatState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeStepRequired, "please select a seat or skip")
+}
+func (s *SelectSeatState) Name() string { return "SelectSeat" }
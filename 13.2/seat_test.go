@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestSeatReservation(t *testing.T) {
+	m, _ := newTestMachine(t)
+	inv := NewMockSeatInventory("train", 1, 3)
+	m.Seats = inv
+	must(t, m.SelectTicket("train", 2))
+	seats, err := m.AvailableSeats()
+	must(t, err)
+	if len(seats) != 3 || seats[0] != (Seat{Coach: "1", Number: "1"}) {
+		t.Fatalf("available = %v", seats)
+	}
+	must(t, m.SelectSeat(Seat{Coach: "1", Number: "2"}))
+	if err := m.SelectSeat(Seat{Coach: "1", Number: "2"}); CodeOf(err) != CodeSeatUnavailable {
+		t.Fatalf("same seat twice = %v", err)
+	}
+	if err := m.SelectSeat(Seat{Coach: "9", Number: "1"}); CodeOf(err) != CodeSeatUnavailable {
+		t.Fatalf("unknown seat = %v", err)
+	}
+	if m.GetCurrentState() != (&SelectSeatState{}).Name() {
+		t.Fatalf("state = %s with one seat to go", m.GetCurrentState())
+	}
+	must(t, m.SelectSeat(Seat{Coach: "1", Number: "3"}))
+	if m.GetCurrentState() != (&WaitingForMoneyState{}).Name() {
+		t.Fatalf("state = %s", m.GetCurrentState())
+	}
+	must(t, m.InsertMoney(KZT(1000)))
+	must(t, m.InsertMoney(KZT(1000)))
+	d, err := m.DispenseTicket()
+	must(t, err)
+	if d.Tickets[0].Seat != "2" || d.Tickets[1].Seat != "3" || d.Tickets[1].Coach != "1" {
+		t.Fatalf("tickets = %+v", d.Tickets)
+	}
+	if free, _ := inv.Available("train"); len(free) != 1 {
+		t.Fatalf("sold seats came free: %v", free)
+	}
+}
+
+func TestCancelReleasesSeats(t *testing.T) {
+	m, _ := newTestMachine(t)
+	inv := NewMockSeatInventory("train", 1, 3)
+	m.Seats = inv
+	must(t, m.SelectTicket("train", 1))
+	must(t, m.SelectSeat(Seat{Coach: "1", Number: "1"}))
+	must(t, m.InsertMoney(KZT(500)))
+	must(t, m.Cancel())
+	if free, _ := inv.Available("train"); len(free) != 3 {
+		t.Fatalf("free seats after cancel = %v", free)
+	}
+}
+
+func TestSkipSeat(t *testing.T) {
+	m, _ := newTestMachine(t)
+	must(t, m.SelectTicket("train", 1))
+	must(t, m.SkipSeat())
+	if err := m.SelectSeat(Seat{Coach: "1", Number: "1"}); CodeOf(err) != CodeInvalidState {
+		t.Fatalf("SelectSeat after skipping = %v", err)
+	}
+	must(t, m.InsertMoney(KZT(1000)))
+	d, err := m.DispenseTicket()
+	must(t, err)
+	if d.Tickets[0].Seat != "" {
+		t.Fatalf("unreserved ticket has seat %q", d.Tickets[0].Seat)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReasonSecurity is the reason code of a machine locked by a security
+// sensor.
+const ReasonSecurity ReasonCode = "security"
+
+// SensorKind is the kind of security sensor that fired.
+type SensorKind string
+
+const (
+	SensorDoorOpen  SensorKind = "door_open"
+	SensorVibration SensorKind = "vibration"
+)
+
+// SecurityEvent is reported by a security sensor.
+type SecurityEvent struct {
+	Sensor SensorKind
+	Detail string
+}
+
+// SecuritySensor reports tamper events to the handler it is watched with.
+type SecuritySensor interface {
+	Watch(handle func(SecurityEvent))
+}
+
+// MockSecuritySensor fires events on Trigger.
+type MockSecuritySensor struct {
+	handle func(SecurityEvent)
+}
+
+func (s *MockSecuritySensor) Watch(handle func(SecurityEvent)) { s.handle = handle }
+
+func (s *MockSecuritySensor) Trigger(sensor SensorKind, detail string) {
+	if s.handle != nil {
+		s.handle(SecurityEvent{Sensor: sensor, Detail: detail})
+	}
+}
+
+// SecurityIncident records a sensor event that locked the machine and the
+// operator who cleared it.
+type SecurityIncident struct {
+	Time      time.Time
+	Sensor    SensorKind
+	Detail    string
+	ClearedBy string
+	ClearedAt time.Time
+	Note      string
+}
+
+// WatchSensor publishes the events of a security sensor.
+func (m *TicketMachine) WatchSensor(s SecuritySensor) {
+	s.Watch(func(ev SecurityEvent) {
+		m.publish(HardwareEvent{Kind: EventSecurity, Device: "security_sensor", Sensor: ev.Sensor, Detail: ev.Detail})
+	})
+}
+
+// SecurityEvent locks the machine and records an incident. The door is
+// expected to open while an operator services the machine, so door events
+// during maintenance or an admin session are only recorded.
+func (m *TicketMachine) SecurityEvent(ev SecurityEvent) {
+	inc := SecurityIncident{Time: m.Clock.Now(), Sensor: ev.Sensor, Detail: ev.Detail}
+	switch m.State.(type) {
+	case *MaintenanceState, *AdminState:
+		if ev.Sensor == SensorDoorOpen {
+			inc.ClearedBy, inc.ClearedAt, inc.Note = "service", inc.Time, "expected during service"
+			m.Incidents = append(m.Incidents, inc)
+			return
+		}
+	}
+	m.Incidents = append(m.Incidents, inc)
+	m.draining = nil
+	detail := fmt.Sprintf("%s: %s", ev.Sensor, ev.Detail)
+	m.audit("", "security_incident", detail)
+	m.notify(Alert{Kind: AlertSecurity, Detail: detail})
+	if _, ok := m.State.(*LockedState); ok {
+		return
+	}
+	m.stopService(&LockedState{Incident: len(m.Incidents) - 1})
+	fmt.Fprintln(m.out(), "Locked:", ev.Sensor)
+}
+
+// ClearSecurityIncident unlocks the machine after an operator has checked
+// it. The machine goes out of service until it is returned to service.
+func (m *TicketMachine) ClearSecurityIncident(operatorID, pin, note string) error {
+	if err := m.Auth.Authenticate(operatorID, pin); err != nil {
+		m.audit(operatorID, "clear_security_denied", err.Error())
+		return err
+	}
+	if err := m.authorize(operatorID, PermSecurity, "clear_security"); err != nil {
+		return err
+	}
+	s, ok := m.State.(*LockedState)
+	if !ok {
+		return newError(CodeInvalidState, "machine is not locked")
+	}
+	inc := &m.Incidents[s.Incident]
+	inc.ClearedBy, inc.ClearedAt, inc.Note = operatorID, m.Clock.Now(), note
+	m.audit(operatorID, "clear_security", note)
+	m.SetState(&OutOfServiceState{Reason: ReasonSecurity, Detail: "incident cleared"})
+	fmt.Fprintln(m.out(), "Security incident cleared.")
+	return nil
+}
+
+// checkUnlocked refuses to resume a machine with an open security incident
+// or a coin jam.
+func (m *TicketMachine) checkUnlocked() error {
+	switch m.State.(type) {
+	case *LockedState:
+		return newError(CodeSecurityLock, "security incident must be cleared first")
+	case *CoinJamState:
+		return newError(CodeHardware, "coin jam must be cleared first")
+	}
+	return nil
+}
+
+// LockedState refuses everything until a security incident is cleared.
+type LockedState struct {
+	// Incident indexes the incident in Incidents.
+	Incident int
+}
+
+func (s *LockedState) err() error {
+	return &OutOfServiceError{Reason: ReasonSecurity, Detail: "machine locked"}
+}
+func (s *LockedState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return s.err()
+}
+func (s *LockedState) InsertMoney(m *TicketMachine, amount Money) error { return s.err() }
+func (s *LockedState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return s.err()
+}
+func (s *LockedState) Cancel(m *TicketMachine) error { return s.err() }
+func (s *LockedState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, s.err()
+}
+func (s *LockedState) Name() string { return "Locked" }
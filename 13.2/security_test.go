@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestSecurityLock(t *testing.T) {
+	m, _ := newTestMachine(t)
+	n := &recordingNotifier{}
+	m.Notifier = n
+	sensor := &MockSecuritySensor{}
+	m.WatchSensor(sensor)
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.InsertMoney(KZT(200)))
+
+	sensor.Trigger(SensorVibration, "side panel")
+	if _, ok := m.State.(*LockedState); !ok || m.InsertedMoney != 0 {
+		t.Fatalf("state %s, %s still inserted", m.State.Name(), m.InsertedMoney)
+	}
+	if len(n.alerts) != 1 || n.alerts[0].Kind != AlertSecurity {
+		t.Fatalf("alerts = %+v", n.alerts)
+	}
+	if err := m.SelectTicket("metro", 1); CodeOf(err) != CodeOutOfService {
+		t.Fatalf("SelectTicket while locked = %v", err)
+	}
+	if err := m.ReturnToService("admin", "0000"); CodeOf(err) != CodeSecurityLock {
+		t.Fatalf("ReturnToService while locked = %v, want %s", err, CodeSecurityLock)
+	}
+	if err := m.ClearSecurityIncident("clerk", "1111", "looks fine"); CodeOf(err) != CodePermissionDenied {
+		t.Fatalf("clerk cleared the incident: %v", err)
+	}
+
+	must(t, m.ClearSecurityIncident("admin", "0000", "panel secured"))
+	if inc := m.Incidents[0]; inc.Sensor != SensorVibration || inc.ClearedBy != "admin" || inc.Note != "panel secured" {
+		t.Fatalf("incident = %+v", inc)
+	}
+	if _, ok := m.State.(*OutOfServiceState); !ok {
+		t.Fatalf("state %s after clearing", m.State.Name())
+	}
+	must(t, m.ReturnToService("admin", "0000"))
+	if m.GetCurrentState() != (&IdleState{}).Name() {
+		t.Fatalf("state %s after return to service", m.State.Name())
+	}
+}
+
+func TestDoorOpenDuringMaintenance(t *testing.T) {
+	m, _ := newTestMachine(t)
+	n := &recordingNotifier{}
+	m.Notifier = n
+	must(t, m.EnterMaintenance("admin", "0000", "coin path"))
+	m.SecurityEvent(SecurityEvent{Sensor: SensorDoorOpen, Detail: "front door"})
+	if _, ok := m.State.(*MaintenanceState); !ok || len(n.alerts) != 0 {
+		t.Fatalf("state %s, alerts %+v", m.State.Name(), n.alerts)
+	}
+	if len(m.Incidents) != 1 || m.Incidents[0].ClearedBy != "service" {
+		t.Fatalf("incidents = %+v", m.Incidents)
+	}
+
+	m.SecurityEvent(SecurityEvent{Sensor: SensorVibration, Detail: "impact"})
+	if _, ok := m.State.(*LockedState); !ok {
+		t.Fatalf("vibration during maintenance left state %s", m.State.Name())
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import "fmt"
+
+// ReasonCode says why the machine is not serving customers.
+type ReasonCode string
+
+const (
+	ReasonHardwareFault ReasonCode = "hardware_fault"
+	ReasonOperator      ReasonCode = "operator"
+	ReasonMaintenance   ReasonCode = "maintenance"
+)
+
+// OutOfServiceError is returned for every customer action while the
+// machine is out of service or in maintenance.
+type OutOfServiceError struct {
+	Reason ReasonCode
+	Detail string
+}
+
+func (e *OutOfServiceError) ErrorCode() ErrorCode { return CodeOutOfService }
+
+func (e *OutOfServiceError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("machine out of service (%s)", e.Reason)
+	}
+	return fmt.Sprintf("machine out of service (%s): %s", e.Reason, e.Detail)
+}
+
+// inService returns the out-of-service error while customers are refused.
+func (m *TicketMachine) inService() error {
+	switch s := m.State.(type) {
+	case *OutOfServiceState:
+		return s.err()
+	case *MaintenanceState:
+		return s.err()
+	case *AdminState:
+		return s.err()
+	case *LockedState:
+		return s.err()
+	case *CoinJamState:
+		return s.err()
+	}
+	return nil
+}
+
+// stopService ends any transaction in progress, returning the customer's
+// money, and enters s. A locked machine stays locked.
+func (m *TicketMachine) stopService(s State) {
+	if _, ok := m.State.(*LockedState); ok {
+		return
+	}
+	_, paid := m.State.(*MoneyReceivedState)
+	if m.awaitingCustomer() || paid {
+		if err := m.Cancel(); err != nil {
+			m.warn("cancel failed: %v", err)
+		}
+	}
+	m.startSale()
+	m.SetState(s)
+}
+
+// HardwareFault takes the machine out of service because a device failed.
+// Device drivers report faults as EventFault or EventJam on the bus.
+func (m *TicketMachine) HardwareFault(component string, err error) {
+	detail := component
+	if err != nil {
+		detail += ": " + err.Error()
+	}
+	m.audit("", "hardware_fault", detail)
+	m.notify(Alert{Kind: AlertHardwareFault, Detail: detail})
+	m.stopService(&OutOfServiceState{Reason: ReasonHardwareFault, Detail: detail})
+	fmt.Fprintln(m.out(), "Out of service:", detail)
+	m.emit(HardwareFaulted{Component: component, Detail: detail})
+}
+
+// TakeOutOfService is the admin action to stop sales, e.g. for a station
+// closure.
+func (m *TicketMachine) TakeOutOfService(operatorID, pin, detail string) error {
+	if err := m.Auth.Authenticate(operatorID, pin); err != nil {
+		m.audit(operatorID, "out_of_service_denied", err.Error())
+		return err
+	}
+	if err := m.authorize(operatorID, PermService, "out_of_service"); err != nil {
+		return err
+	}
+	m.audit(operatorID, "out_of_service", detail)
+	m.stopService(&OutOfServiceState{Reason: ReasonOperator, Detail: detail})
+	return nil
+}
+
+// EnterMaintenance is the admin action to open the machine for service
+// work.
+func (m *TicketMachine) EnterMaintenance(operatorID, pin, detail string) error {
+	if err := m.Auth.Authenticate(operatorID, pin); err != nil {
+		m.audit(operatorID, "maintenance_denied", err.Error())
+		return err
+	}
+	if err := m.authorize(operatorID, PermService, "maintenance"); err != nil {
+		return err
+	}
+	m.audit(operatorID, "maintenance", detail)
+	m.stopService(&MaintenanceState{OperatorID: operatorID, Detail: detail})
+	return nil
+}
+
+// ReturnToService brings an out-of-service or maintenance machine back to
+// its ready state.
+func (m *TicketMachine) ReturnToService(operatorID, pin string) error {
+	if err := m.Auth.Authenticate(operatorID, pin); err != nil {
+		m.audit(operatorID, "return_to_service_denied", err.Error())
+		return err
+	}
+	if err := m.authorize(operatorID, PermService, "return_to_service"); err != nil {
+		return err
+	}
+	if m.inService() == nil {
+		return newError(CodeInvalidState, "machine is in service")
+	}
+	if err := m.checkUnlocked(); err != nil {
+		return err
+	}
+	m.audit(operatorID, "return_to_service", "")
+	m.SetState(m.readyState())
+	fmt.Fprintln(m.out(), "Back in service.")
+	return nil
+}
+
+// OutOfServiceState refuses customers after a fault or operator stop.
+type OutOfServiceState struct {
+	Reason ReasonCode
+	Detail string
+}
+
+func (s *OutOfServiceState) err() error {
+	return &OutOfServiceError{Reason: s.Reason, Detail: s.Detail}
+}
+func (s *OutOfServiceState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return s.err()
+}
+func (s *OutOfServiceState) InsertMoney(m *TicketMachine, amount Money) error { return s.err() }
+func (s *OutOfServiceState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return s.err()
+}
+func (s *OutOfServiceState) Cancel(m *TicketMachine) error { return s.err() }
+func (s *OutOfServiceState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, s.err()
+}
+func (s *OutOfServiceState) Name() string { return "OutOfService" }
+
+// MaintenanceState refuses customers while an operator services the
+// machine.
+type MaintenanceState struct {
+	OperatorID string
+	Detail     string
+}
+
+func (s *MaintenanceState) err() error {
+	return &OutOfServiceError{Reason: ReasonMaintenance, Detail: s.Detail}
+}
+func (s *MaintenanceState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return s.err()
+}
+func (s *MaintenanceState) InsertMoney(m *TicketMachine, amount Money) error { return s.err() }
+func (s *MaintenanceState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return s.err()
+}
+func (s *MaintenanceState) Cancel(m *TicketMachine) error { return s.err() }
+func (s *MaintenanceState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, s.err()
+}
+func (s *MaintenanceState) Name() string { return "Maintenance" }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStopService(t *testing.T) {
+	tests := []struct {
+		name   string
+		stop   func(m *TicketMachine) error
+		reason ReasonCode
+		state  string
+	}{
+		{"operator stop", func(m *TicketMachine) error { return m.TakeOutOfService("admin", "0000", "station closed") },
+			ReasonOperator, (&OutOfServiceState{}).Name()},
+		{"maintenance", func(m *TicketMachine) error { return m.EnterMaintenance("admin", "0000", "cleaning") },
+			ReasonMaintenance, (&MaintenanceState{}).Name()},
+		{"hardware fault", func(m *TicketMachine) error { m.HardwareFault("printer", errors.New("head overheated")); return nil },
+			ReasonHardwareFault, (&OutOfServiceState{}).Name()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			must(t, m.SelectTicket("metro", 1))
+			must(t, m.InsertMoney(KZT(200)))
+			must(t, tt.stop(m))
+			if m.GetCurrentState() != tt.state || m.InsertedMoney != 0 || m.CashBox.Total() != 0 {
+				t.Fatalf("state %s, inserted %s, cash box %s", m.GetCurrentState(), m.InsertedMoney, m.CashBox.Total())
+			}
+			var oos *OutOfServiceError
+			if err := m.SelectTicket("metro", 1); !errors.As(err, &oos) || oos.Reason != tt.reason {
+				t.Fatalf("SelectTicket = %v, want out of service for %s", err, tt.reason)
+			}
+			must(t, m.ReturnToService("admin", "0000"))
+			if m.GetCurrentState() != (&IdleState{}).Name() {
+				t.Fatalf("state after return = %s", m.GetCurrentState())
+			}
+			must(t, m.SelectTicket("metro", 1))
+		})
+	}
+}
+
+func TestServiceActionsRefused(t *testing.T) {
+	m, _ := newTestMachine(t)
+	if err := m.TakeOutOfService("clerk", "1111", ""); CodeOf(err) != CodePermissionDenied {
+		t.Fatalf("clerk stopping the machine = %v", err)
+	}
+	if err := m.EnterMaintenance("admin", "1234", ""); CodeOf(err) != CodeInvalidCredentials {
+		t.Fatalf("wrong pin = %v", err)
+	}
+	if err := m.ReturnToService("admin", "0000"); CodeOf(err) != CodeInvalidState {
+		t.Fatalf("return while in service = %v", err)
+	}
+	if m.GetCurrentState() != (&IdleState{}).Name() {
+		t.Fatalf("state = %s", m.GetCurrentState())
+	}
+}
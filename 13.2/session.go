@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReasonAdminSession is reported to customers while an operator works on
+// the machine.
+const ReasonAdminSession ReasonCode = "admin_session"
+
+// Credentials identify an operator.
+type Credentials struct {
+	OperatorID string
+	PIN        string
+}
+
+// AdminSession is an authenticated operator session. While it is open the
+// machine is in AdminState and refuses customers.
+type AdminSession struct {
+	OperatorID string
+	Started    time.Time
+
+	m *TicketMachine
+	// resume is the out-of-service state to go back to on Exit, if the
+	// session was opened from one and did not return the machine to service.
+	resume State
+}
+
+// EnterAdminMode authenticates an operator and opens an admin session. It
+// is allowed from the ready state and from out of service.
+func (m *TicketMachine) EnterAdminMode(c Credentials) (*AdminSession, error) {
+	if err := m.Auth.Authenticate(c.OperatorID, c.PIN); err != nil {
+		m.audit(c.OperatorID, "admin_denied", err.Error())
+		return nil, err
+	}
+	s := &AdminSession{OperatorID: c.OperatorID, Started: m.Clock.Now(), m: m}
+	switch m.State.(type) {
+	case *IdleState, *CashBoxFullState:
+	case *OutOfServiceState, *MaintenanceState:
+		s.resume = m.State
+	default:
+		return nil, newError(CodeBusy, "transaction in progress")
+	}
+	m.SetState(&AdminState{Session: s})
+	m.audit(c.OperatorID, "admin_enter", "")
+	fmt.Fprintf(m.out(), "Admin mode: %s\n", c.OperatorID)
+	return s, nil
+}
+
+func (s *AdminSession) active() error {
+	if st, ok := s.m.State.(*AdminState); !ok || st.Session != s {
+		return newError(CodeInvalidState, "admin session closed")
+	}
+	return nil
+}
+
+// Restock adds qty tickets of ticketType to stock.
+func (s *AdminSession) Restock(ticketType string, qty int) error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	if err := s.m.authorize(s.OperatorID, PermRestock, "restock"); err != nil {
+		return err
+	}
+	return s.m.restock(s.OperatorID, ticketType, qty)
+}
+
+// SetPrice changes the catalog price of a product.
+func (s *AdminSession) SetPrice(ticketType string, price Money) error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	if err := s.m.authorize(s.OperatorID, PermSetPrice, "set_price"); err != nil {
+		return err
+	}
+	old, ok := s.m.Catalog.Product(ticketType)
+	if err := s.m.Catalog.SetPrice(ticketType, price); err != nil {
+		return err
+	}
+	s.m.persist(ticketType)
+	if ok {
+		s.m.audit(s.OperatorID, "set_price", fmt.Sprintf("%s %s -> %s", ticketType, old.Price, price))
+	}
+	fmt.Fprintf(s.m.out(), "Price of %s: %s\n", ticketType, price.In(s.m.Currency))
+	return nil
+}
+
+// CollectCash empties the cash box.
+func (s *AdminSession) CollectCash() (CashCollection, error) {
+	if err := s.active(); err != nil {
+		return CashCollection{}, err
+	}
+	if err := s.m.authorize(s.OperatorID, PermCollectCash, "collect_cash"); err != nil {
+		return CashCollection{}, err
+	}
+	return s.m.collectCash(s.OperatorID), nil
+}
+
+// Diagnostics is a snapshot of the machine's condition.
+type Diagnostics struct {
+	State             string
+	CashBox           Money
+	CashBoxFull       bool
+	Hopper            Money
+	ExactChangeOnly   bool
+	CardPayments      bool
+	FiscalQueue       int
+	Stock             []InventoryLine
+	CashRejectionRate float64
+	TransactionsToday int
+	PendingDeliveries int
+	PaperRemaining    int
+}
+
+// Diagnostics reports the machine's condition.
+func (s *AdminSession) Diagnostics() (Diagnostics, error) {
+	if err := s.active(); err != nil {
+		return Diagnostics{}, err
+	}
+	if err := s.m.authorize(s.OperatorID, PermDiagnostics, "diagnostics"); err != nil {
+		return Diagnostics{}, err
+	}
+	m := s.m
+	d := Diagnostics{
+		State:             m.State.Name(),
+		CashBox:           m.CashBox.Total(),
+		CashBoxFull:       !m.canAcceptCash(),
+		Hopper:            m.HopperTotal(),
+		ExactChangeOnly:   m.ExactChangeRequired(),
+		CardPayments:      m.CardPaymentsAvailable(),
+		FiscalQueue:       len(m.FiscalQueue),
+		Stock:             m.InventoryReport(),
+		CashRejectionRate: m.CashStats.RejectionRate(),
+	}
+	if m.Paper != nil {
+		d.PaperRemaining = m.Paper.Remaining
+	}
+	now := m.Clock.Now()
+	for _, r := range m.Transactions {
+		if r.Time.YearDay() == now.YearDay() && r.Time.Year() == now.Year() {
+			d.TransactionsToday++
+		}
+		if r.Delivery != nil {
+			if st, _, _ := r.Delivery.Status(); st == DeliveryPending {
+				d.PendingDeliveries++
+			}
+		}
+	}
+	return d, nil
+}
+
+// ReturnToService makes Exit go back to the ready state even if the
+// session was opened while the machine was out of service.
+func (s *AdminSession) ReturnToService() error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	if err := s.m.authorize(s.OperatorID, PermService, "return_to_service"); err != nil {
+		return err
+	}
+	s.resume = nil
+	s.m.audit(s.OperatorID, "return_to_service", "")
+	return nil
+}
+
+// Exit closes the session.
+func (s *AdminSession) Exit() error {
+	if err := s.active(); err != nil {
+		return err
+	}
+	s.m.audit(s.OperatorID, "admin_exit", "")
+	if s.resume != nil {
+		s.m.SetState(s.resume)
+	} else {
+		s.m.SetState(s.m.readyState())
+	}
+	fmt.Fprintln(s.m.out(), "Admin mode closed.")
+	return nil
+}
+
+// AdminState refuses customers during an admin session.
+type AdminState struct {
+	Session *AdminSession
+}
+
+func (s *AdminState) err() error {
+	return &OutOfServiceError{Reason: ReasonAdminSession, Detail: "operator at work"}
+}
+func (s *AdminState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return s.err()
+}
+func (s *AdminState) InsertMoney(m *TicketMachine, amount Money) error { return s.err() }
+func (s *AdminState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return s.err()
+}
+func (s *AdminState) Cancel(m *TicketMachine) error { return s.err() }
+func (s *AdminState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, s.err()
+}
+func (s *AdminState) Name() string { return "Admin" }
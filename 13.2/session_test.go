@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAdminSession(t *testing.T) {
+	m, _ := newTestMachine(t)
+	s, err := m.EnterAdminMode(Credentials{OperatorID: "admin", PIN: "0000"})
+	must(t, err)
+	var oos *OutOfServiceError
+	if err := m.SelectTicket("metro", 1); !errors.As(err, &oos) || oos.Reason != ReasonAdminSession {
+		t.Fatalf("SelectTicket during a session = %v", err)
+	}
+	must(t, s.SetPrice("metro", KZT(350)))
+	must(t, s.Restock("metro", 2))
+	d, err := s.Diagnostics()
+	must(t, err)
+	if d.State != (&AdminState{}).Name() || !d.CardPayments {
+		t.Fatalf("diagnostics = %+v", d)
+	}
+	must(t, s.Exit())
+	if m.GetCurrentState() != (&IdleState{}).Name() || m.GetTicketPrice("metro") != KZT(350) {
+		t.Fatalf("state %s, metro %s", m.GetCurrentState(), m.GetTicketPrice("metro"))
+	}
+	if err := s.SetPrice("metro", KZT(1)); CodeOf(err) != CodeInvalidState {
+		t.Fatalf("closed session still works: %v", err)
+	}
+}
+
+func TestEnterAdminMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		pin     string
+		setup   func(t *testing.T, m *TicketMachine)
+		code    ErrorCode
+		resumed bool
+	}{
+		{"wrong pin", "1234", nil, CodeInvalidCredentials, false},
+		{"sale in progress", "0000", func(t *testing.T, m *TicketMachine) { must(t, m.SelectTicket("metro", 1)) }, CodeBusy, false},
+		{"from out of service", "0000", func(t *testing.T, m *TicketMachine) {
+			must(t, m.TakeOutOfService("admin", "0000", "closed"))
+		}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			if tt.setup != nil {
+				tt.setup(t, m)
+			}
+			before := m.GetCurrentState()
+			s, err := m.EnterAdminMode(Credentials{OperatorID: "admin", PIN: tt.pin})
+			if CodeOf(err) != tt.code {
+				t.Fatalf("EnterAdminMode = %v, want %q", err, tt.code)
+			}
+			if tt.code != "" {
+				if m.GetCurrentState() != before {
+					t.Fatalf("state = %s, want %s", m.GetCurrentState(), before)
+				}
+				return
+			}
+			must(t, s.Exit())
+			if tt.resumed && m.GetCurrentState() != before {
+				t.Fatalf("state after exit = %s, want %s", m.GetCurrentState(), before)
+			}
+		})
+	}
+}
+
+func TestAdminSessionReturnToService(t *testing.T) {
+	m, _ := newTestMachine(t)
+	must(t, m.TakeOutOfService("admin", "0000", "closed"))
+	s, err := m.EnterAdminMode(Credentials{OperatorID: "admin", PIN: "0000"})
+	must(t, err)
+	must(t, s.ReturnToService())
+	must(t, s.Exit())
+	if m.GetCurrentState() != (&IdleState{}).Name() {
+		t.Fatalf("state = %s", m.GetCurrentState())
+	}
+}
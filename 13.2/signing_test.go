@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyTicket(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	hmacKey := []byte("secret")
+	pub, priv, err := ed25519.GenerateKey(nil)
+	must(t, err)
+	ticket := Ticket{ID: "TK-1", Type: "metro", Kind: KindSingle, MachineID: "TM-1", ValidFrom: now, ValidUntil: now.Add(time.Hour)}
+	tests := []struct {
+		name   string
+		signer TicketSigner
+		at     time.Time
+		tamper bool
+		want   error
+	}{
+		{"hmac", &HMACSigner{ID: "k1", Key: hmacKey}, now, false, nil},
+		{"ed25519", &Ed25519Signer{ID: "e1", Key: priv}, now.Add(30 * time.Minute), false, nil},
+		{"tampered", &HMACSigner{ID: "k1", Key: hmacKey}, now, true, ErrBadSignature},
+		{"wrong key", &HMACSigner{ID: "k1", Key: []byte("other")}, now, false, ErrBadSignature},
+		{"unknown key", &HMACSigner{ID: "k9", Key: hmacKey}, now, false, ErrUnknownKey},
+		{"expired", &HMACSigner{ID: "k1", Key: hmacKey}, now.Add(time.Hour), false, ErrTicketExpired},
+		{"not yet valid", &HMACSigner{ID: "k1", Key: hmacKey}, now.Add(-time.Second), false, ErrTicketNotYetValid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewTicketVerifier(&FakeClock{T: tt.at})
+			v.AddHMACKey("k1", hmacKey)
+			v.AddEd25519Key("e1", pub)
+			payload, err := EncodeTicketQR(ticket, tt.signer)
+			must(t, err)
+			if tt.tamper {
+				forged := ticket
+				forged.ValidUntil = now.Add(24 * time.Hour)
+				other, err := EncodeTicketQR(forged, tt.signer)
+				must(t, err)
+				payload = other[:len(other)-len(signaturePart(other))] + signaturePart(payload)
+			}
+			c, err := v.VerifyTicket(payload)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyTicket = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && c.ID != ticket.ID {
+				t.Fatalf("claims = %+v", c)
+			}
+		})
+	}
+}
+
+// signaturePart is the last dot-separated part of a payload.
+func signaturePart(payload string) string {
+	return payload[strings.LastIndex(payload, ".")+1:]
+}
+
+func TestRotateTicketSigner(t *testing.T) {
+	m, clock := newTestMachine(t)
+	v := NewTicketVerifier(clock)
+	v.AddHMACKey(m.TicketSigner.KeyID(), m.TicketSigner.(*HMACSigner).Key)
+	old := sellAndScan(t, m)
+	m.RotateTicketSigner(&HMACSigner{ID: "k2", Key: []byte("next")})
+	fresh := sellAndScan(t, m)
+	if _, err := v.VerifyTicket(fresh); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("new key before the verifier learned it: %v", err)
+	}
+	v.AddHMACKey("k2", []byte("next"))
+	for _, p := range []string{old, fresh} {
+		if _, err := v.VerifyTicket(p); err != nil {
+			t.Fatalf("after rotation: %v", err)
+		}
+	}
+}
+
+func sellAndScan(t *testing.T, m *TicketMachine) string {
+	t.Helper()
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.InsertMoney(KZT(200)))
+	must(t, m.InsertMoney(KZT(100)))
+	d, err := m.DispenseTicket()
+	must(t, err)
+	must(t, m.StartOver())
+	return d.Tickets[0].QRPayload
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// MachineSnapshot is a checkpoint of a machine: its state, the sale in
+// progress, stock and cash levels, and the security incidents.
+type MachineSnapshot struct {
+	MachineID       string               `json:"machine_id"`
+	TakenAt         time.Time            `json:"taken_at"`
+	State           StateSnapshot        `json:"state"`
+	Transaction     *TransactionSnapshot `json:"transaction,omitempty"`
+	Inventory       []StoredProduct      `json:"inventory"`
+	Hopper          map[Money]int        `json:"hopper"`
+	CashBox         map[Money]int        `json:"cash_box"`
+	CashBoxForeign  []ForeignCash        `json:"cash_box_foreign,omitempty"`
+	Donations       Money                `json:"donations"`
+	PaperRemaining  *int                 `json:"paper_remaining,omitempty"`
+	ExactChangeOnly bool                 `json:"exact_change_only"`
+	ConfigVersion   int                  `json:"config_version"`
+	CommandSeq      int                  `json:"command_seq"`
+	Incidents       []SecurityIncident   `json:"incidents,omitempty"`
+}
+
+// StateSnapshot names the machine state, with the reason of an
+// out-of-service state and the incident a machine is locked on.
+type StateSnapshot struct {
+	Name       string     `json:"name"`
+	Reason     ReasonCode `json:"reason,omitempty"`
+	Detail     string     `json:"detail,omitempty"`
+	OperatorID string     `json:"operator_id,omitempty"`
+	Incident   int        `json:"incident,omitempty"`
+}
+
+// TransactionSnapshot is a ticket sale not yet committed.
+type TransactionSnapshot struct {
+	ID            string         `json:"id"`
+	Cart          []CartLine     `json:"cart"`
+	Price         Money          `json:"price"`
+	FareCategory  FareCategory   `json:"fare_category"`
+	PromoDiscount Money          `json:"promo_discount,omitempty"`
+	RiderID       string         `json:"rider_id,omitempty"`
+	CapDiscount   Money          `json:"cap_discount,omitempty"`
+	Inserted      Money          `json:"inserted"`
+	Overpayment   Money          `json:"overpayment,omitempty"`
+	Tally         map[Money]int  `json:"tally,omitempty"`
+	Foreign       []ForeignCash  `json:"foreign,omitempty"`
+	Escrow        Money          `json:"escrow,omitempty"`
+	CardAuth      *Authorization `json:"card_auth,omitempty"`
+	QRPaid        *QRPayment     `json:"qr_paid,omitempty"`
+}
+
+// Snapshot checkpoints the machine.
+func (m *TicketMachine) Snapshot() MachineSnapshot {
+	s := MachineSnapshot{
+		MachineID:       m.MachineID,
+		TakenAt:         m.Clock.Now(),
+		State:           StateSnapshot{Name: m.State.Name()},
+		Hopper:          copyCounts(m.Hopper),
+		CashBox:         copyCounts(m.CashBox.Contents),
+		CashBoxForeign:  append([]ForeignCash(nil), m.CashBox.Foreign...),
+		Donations:       m.Donations,
+		ExactChangeOnly: m.ExactChangeOnly,
+		ConfigVersion:   m.ConfigVersion,
+		CommandSeq:      m.CommandSeq,
+		Incidents:       append([]SecurityIncident(nil), m.Incidents...),
+	}
+	switch st := m.State.(type) {
+	case *OutOfServiceState:
+		s.State.Reason, s.State.Detail = st.Reason, st.Detail
+	case *MaintenanceState:
+		s.State.OperatorID, s.State.Detail = st.OperatorID, st.Detail
+	case *CoinJamState:
+		s.State.Detail = st.Detail
+	case *LockedState:
+		s.State.Reason, s.State.Incident = ReasonSecurity, st.Incident
+	}
+	for _, p := range m.Catalog.List() {
+		s.Inventory = append(s.Inventory, StoredProduct{Type: p.Type, Price: p.Price, Stock: p.Stock})
+	}
+	if m.Paper != nil {
+		n := m.Paper.Remaining
+		s.PaperRemaining = &n
+	}
+	if m.saleOpen() {
+		s.Transaction = &TransactionSnapshot{
+			ID:            m.TransactionID,
+			Cart:          append([]CartLine(nil), m.Cart...),
+			Price:         m.CurrentPrice,
+			FareCategory:  m.FareCategory,
+			PromoDiscount: m.PromoDiscount,
+			RiderID:       m.RiderID,
+			CapDiscount:   m.CapDiscount,
+			Inserted:      m.InsertedMoney,
+			Overpayment:   m.Overpayment,
+			Tally:         copyCounts(m.SessionTally),
+			Foreign:       append([]ForeignCash(nil), m.SessionForeign...),
+			Escrow:        m.escrow,
+			CardAuth:      m.CardAuth,
+			QRPaid:        m.QRPaid,
+		}
+	}
+	return s
+}
+
+// saleOpen reports whether a ticket sale is under way and not yet
+// committed.
+func (m *TicketMachine) saleOpen() bool {
+	if len(m.Cart) == 0 {
+		return false
+	}
+	if _, ok := m.State.(*PrintErrorState); ok {
+		return false
+	}
+	_, paid := m.State.(*MoneyReceivedState)
+	return paid || m.awaitingCustomer()
+}
+
+func (m *TicketMachine) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Snapshot())
+}
+
+func (m *TicketMachine) UnmarshalJSON(data []byte) error {
+	var s MachineSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return newErrorf(CodeStorage, "snapshot: %w", err)
+	}
+	return m.Restore(s)
+}
+
+// Restore puts the machine back to a snapshot. The machine must not be
+// serving a customer. States that cannot be rebuilt from a snapshot, such
+// as a pending card or QR payment, resume at payment; finished sales
+// resume at the ready state.
+func (m *TicketMachine) Restore(s MachineSnapshot) error {
+	if m.saleOpen() {
+		return newError(CodeInvalidState, "transaction in progress")
+	}
+	for _, p := range s.Inventory {
+		if _, ok := m.Catalog.Product(p.Type); !ok {
+			return newErrorf(CodeUnknownProduct, "unknown product %s", p.Type)
+		}
+		if p.Price < 0 || p.Stock < 0 {
+			return newErrorf(CodeStorage, "snapshot: bad product %s", p.Type)
+		}
+	}
+	if s.Transaction != nil {
+		for _, l := range s.Transaction.Cart {
+			if _, ok := m.Catalog.Product(l.TicketType); !ok {
+				return newErrorf(CodeUnknownProduct, "unknown product %s", l.TicketType)
+			}
+		}
+	}
+	for _, p := range s.Inventory {
+		if err := m.Catalog.SetPrice(p.Type, p.Price); err != nil {
+			return err
+		}
+		if err := m.Catalog.Adjust(p.Type, p.Stock-m.Catalog.Stock(p.Type)); err != nil {
+			return err
+		}
+		m.persist(p.Type)
+	}
+	m.Hopper = copyCounts(s.Hopper)
+	m.CashBox.Contents = copyCounts(s.CashBox)
+	m.CashBox.Foreign = append([]ForeignCash(nil), s.CashBoxForeign...)
+	m.Donations = s.Donations
+	if m.Paper != nil && s.PaperRemaining != nil {
+		m.Paper.Remaining = *s.PaperRemaining
+	}
+	m.ExactChangeOnly = s.ExactChangeOnly
+	m.ConfigVersion = s.ConfigVersion
+	m.CommandSeq = s.CommandSeq
+	m.Incidents = append([]SecurityIncident(nil), s.Incidents...)
+
+	m.startSale()
+	m.InsertedMoney, m.Overpayment, m.CardAuth, m.QRPaid, m.escrow = 0, 0, nil, nil, 0
+	if t := s.Transaction; t != nil {
+		m.TransactionID = t.ID
+		m.Cart = append([]CartLine(nil), t.Cart...)
+		m.CurrentTicket = t.Cart[0].TicketType
+		m.CurrentPrice = t.Price
+		m.FareCategory = t.FareCategory
+		m.PromoDiscount = t.PromoDiscount
+		m.RiderID = t.RiderID
+		m.CapDiscount = t.CapDiscount
+		m.InsertedMoney = t.Inserted
+		m.Overpayment = t.Overpayment
+		m.SessionTally = copyCounts(t.Tally)
+		m.SessionForeign = append([]ForeignCash(nil), t.Foreign...)
+		m.escrow = t.Escrow
+		m.CardAuth = t.CardAuth
+		m.QRPaid = t.QRPaid
+		var tax TaxBreakdown
+		for _, l := range m.Cart {
+			tax = tax.Add(Breakdown(l.Total(), m.VATRate(l.TicketType)))
+		}
+		m.CurrentTax = tax.Scale(m.CurrentPrice, tax.Gross)
+	}
+	m.audit("", "restore", s.State.Name)
+	m.SetState(m.restoredState(s))
+	return nil
+}
+
+func (m *TicketMachine) restoredState(s MachineSnapshot) State {
+	st := s.State
+	switch st.Name {
+	case "OutOfService":
+		return &OutOfServiceState{Reason: st.Reason, Detail: st.Detail}
+	case "Maintenance":
+		return &MaintenanceState{OperatorID: st.OperatorID, Detail: st.Detail}
+	case "CoinJam":
+		return &CoinJamState{Detail: st.Detail}
+	case "Locked":
+		// A snapshot without its incident still stays locked, on an
+		// incident that only ClearSecurityIncident clears.
+		if st.Incident < 0 || st.Incident >= len(m.Incidents) || m.Incidents[st.Incident].ClearedBy != "" {
+			m.Incidents = append(m.Incidents, SecurityIncident{Time: s.TakenAt, Detail: "locked when checkpointed"})
+			st.Incident = len(m.Incidents) - 1
+		}
+		return &LockedState{Incident: st.Incident}
+	}
+	if s.Transaction == nil {
+		return m.readyState()
+	}
+	switch st.Name {
+	case "Cart":
+		return &CartState{}
+	case "CardDeclined":
+		return &CardDeclinedState{Reason: "restored"}
+	}
+	if m.PaidTotal() >= m.CurrentPrice {
+		return &MoneyReceivedState{}
+	}
+	return &WaitingForMoneyState{}
+}
+
+func copyCounts(c map[Money]int) map[Money]int {
+	out := make(map[Money]int, len(c))
+	for d, n := range c {
+		out[d] = n
+	}
+	return out
+}
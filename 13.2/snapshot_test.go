@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, m *TicketMachine)
+		state string
+		paid  Money
+	}{
+		{"idle", func(t *testing.T, m *TicketMachine) {}, "Idle", 0},
+		{"part paid in cash", func(t *testing.T, m *TicketMachine) {
+			must(t, m.SelectTicket("bus", 1))
+			must(t, m.InsertMoney(KZT(200)))
+		}, "WaitingForMoney", KZT(200)},
+		{"paid by card", func(t *testing.T, m *TicketMachine) {
+			must(t, m.SelectTicket("bus", 1))
+			must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}))
+		}, "MoneyReceived", KZT(250)},
+		{"paid by QR", func(t *testing.T, m *TicketMachine) {
+			must(t, m.SelectTicket("bus", 1))
+			p, err := m.PayByQR()
+			must(t, err)
+			must(t, m.ConfirmQRPayment(p.ID))
+		}, "MoneyReceived", KZT(250)},
+		{"locked", func(t *testing.T, m *TicketMachine) {
+			m.SecurityEvent(SecurityEvent{Sensor: SensorDoorOpen, Detail: "forced"})
+		}, "Locked", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			tt.setup(t, m)
+			raw, err := json.Marshal(m)
+			must(t, err)
+			restored, _ := newTestMachine(t)
+			must(t, json.Unmarshal(raw, restored))
+			if got := restored.State.Name(); got != tt.state {
+				t.Fatalf("restored to %s, want %s", got, tt.state)
+			}
+			if got := restored.PaidTotal(); got != tt.paid {
+				t.Errorf("paid %s after restore, want %s", got, tt.paid)
+			}
+			if len(restored.Incidents) != len(m.Incidents) {
+				t.Errorf("%d incidents after restore, want %d", len(restored.Incidents), len(m.Incidents))
+			}
+		})
+	}
+}
+
+func TestRestoreLockedWithoutIncident(t *testing.T) {
+	m, _ := newTestMachine(t)
+	s := m.Snapshot()
+	s.State = StateSnapshot{Name: "Locked", Reason: ReasonSecurity}
+	must(t, m.Restore(s))
+	if _, ok := m.State.(*LockedState); !ok {
+		t.Fatalf("restored to %s, want Locked", m.State.Name())
+	}
+	if err := m.ReturnToService("admin", "0000"); err == nil {
+		t.Fatal("returned to service without clearing the incident")
+	}
+	must(t, m.ClearSecurityIncident("admin", "0000", "checked"))
+}
+
+func TestRestoreRejectsBadStock(t *testing.T) {
+	m, _ := newTestMachine(t)
+	s := m.Snapshot()
+	s.Inventory[0].Stock = -1
+	if err := m.Restore(s); err == nil {
+		t.Fatal("restored negative stock")
+	}
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSQL is a database/sql driver over in-memory tables, so the stores
+// are tested without a SQLite build. It runs only the statements the
+// stores and migrations send: INSERT with an optional ON CONFLICT upsert,
+// SELECT of columns, COUNT(*) or COALESCE(MAX(c), 0) with AND-ed
+// comparisons, ORDER BY and LIMIT, DELETE by comparison, and VACUUM INTO,
+// which writes the row counts by table. CREATE TABLE makes an empty table;
+// other schema statements are accepted and ignored, so rows hold whatever
+// columns were inserted.
+type fakeSQL struct {
+	mu     sync.Mutex
+	tables map[string][]fakeRow
+	// fail makes every statement that contains it fail.
+	fail string
+}
+
+type fakeRow map[string]driver.Value
+
+var fakeSQLs = struct {
+	sync.Mutex
+	once sync.Once
+	dbs  map[string]*fakeSQL
+}{dbs: map[string]*fakeSQL{}}
+
+// newFakeSQL opens an empty database of its own for the test.
+func newFakeSQL(t *testing.T) (*sql.DB, *fakeSQL) {
+	t.Helper()
+	fakeSQLs.once.Do(func() { sql.Register("fakesql", fakeSQLDriver{}) })
+	f := &fakeSQL{tables: map[string][]fakeRow{}}
+	fakeSQLs.Lock()
+	fakeSQLs.dbs[t.Name()] = f
+	fakeSQLs.Unlock()
+	db, err := sql.Open("fakesql", t.Name())
+	must(t, err)
+	t.Cleanup(func() {
+		db.Close()
+		fakeSQLs.Lock()
+		delete(fakeSQLs.dbs, t.Name())
+		fakeSQLs.Unlock()
+	})
+	return db, f
+}
+
+type fakeSQLDriver struct{}
+
+func (fakeSQLDriver) Open(name string) (driver.Conn, error) {
+	fakeSQLs.Lock()
+	defer fakeSQLs.Unlock()
+	f, ok := fakeSQLs.dbs[name]
+	if !ok {
+		return nil, fmt.Errorf("fakesql: no database %q", name)
+	}
+	return &fakeConn{db: f}, nil
+}
+
+type fakeConn struct {
+	db     *fakeSQL
+	backup map[string][]fakeRow
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+// Begin keeps a copy of the tables for Rollback; rows are never changed
+// in place, so copying the slices is enough.
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.backup = map[string][]fakeRow{}
+	for name, rows := range c.db.tables {
+		c.backup[name] = append([]fakeRow(nil), rows...)
+	}
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.backup = nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	if c.backup != nil {
+		c.db.tables, c.backup = c.backup, nil
+	}
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, n, err := s.run(args)
+	return driver.RowsAffected(n), err
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, _, err := s.run(args)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = &fakeRows{}
+	}
+	return rows, nil
+}
+
+var (
+	sqlToken = regexp.MustCompile(`'(?:[^']|'')*'|[A-Za-z_][A-Za-z_0-9.]*|\d+|>=|<=|[(),=<>*?]`)
+	sqlWord  = regexp.MustCompile(`^[A-Za-z_]`)
+)
+
+func (s *fakeStmt) run(args []driver.Value) (*fakeRows, int64, error) {
+	db := s.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.fail != "" && strings.Contains(s.query, db.fail) {
+		return nil, 0, errors.New("fakesql: disk I/O error")
+	}
+	p := &fakeParser{toks: sqlToken.FindAllString(s.query, -1), args: args, db: db}
+	rows, n, err := p.statement()
+	if err == nil && p.pos < len(p.toks) {
+		err = fmt.Errorf("fakesql: unexpected %q in %q", p.toks[p.pos], s.query)
+	}
+	if err == nil && p.arg != len(args) {
+		err = fmt.Errorf("fakesql: %d arguments for %d parameters", len(args), p.arg)
+	}
+	return rows, n, err
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+type fakeParser struct {
+	toks []string
+	pos  int
+	args []driver.Value
+	arg  int
+	db   *fakeSQL
+}
+
+// accept consumes the next tokens if they are words, case-insensitively.
+func (p *fakeParser) accept(words ...string) bool {
+	if p.pos+len(words) > len(p.toks) {
+		return false
+	}
+	for i, w := range words {
+		if !strings.EqualFold(p.toks[p.pos+i], w) {
+			return false
+		}
+	}
+	p.pos += len(words)
+	return true
+}
+
+func (p *fakeParser) expect(words ...string) error {
+	if !p.accept(words...) {
+		return fmt.Errorf("fakesql: want %q at %d of %q", strings.Join(words, " "), p.pos, p.toks)
+	}
+	return nil
+}
+
+func (p *fakeParser) next() string {
+	if p.pos == len(p.toks) {
+		return ""
+	}
+	p.pos++
+	return p.toks[p.pos-1]
+}
+
+// value reads a parameter or a literal.
+func (p *fakeParser) value() (driver.Value, error) {
+	tok := p.next()
+	switch {
+	case tok == "?":
+		if p.arg == len(p.args) {
+			return nil, errors.New("fakesql: too few arguments")
+		}
+		p.arg++
+		return p.args[p.arg-1], nil
+	case strings.HasPrefix(tok, "'"):
+		return strings.ReplaceAll(tok[1:len(tok)-1], "''", "'"), nil
+	}
+	return strconv.ParseInt(tok, 10, 64)
+}
+
+// list reads a parenthesized, comma-separated list with item.
+func (p *fakeParser) list(item func() error) error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+		if p.accept(")") {
+			return nil
+		}
+		if err := p.expect(","); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *fakeParser) statement() (*fakeRows, int64, error) {
+	switch {
+	case p.accept("CREATE", "TABLE"):
+		p.accept("IF", "NOT", "EXISTS")
+		name := p.next()
+		if _, ok := p.db.tables[name]; !ok {
+			p.db.tables[name] = []fakeRow{}
+		}
+		p.pos = len(p.toks)
+	case p.accept("CREATE"), p.accept("ALTER"):
+		p.pos = len(p.toks)
+	case p.accept("INSERT", "INTO"):
+		return nil, 1, p.insert()
+	case p.accept("SELECT"):
+		rows, err := p.selectRows()
+		return rows, 0, err
+	case p.accept("DELETE", "FROM"):
+		n, err := p.delete()
+		return nil, n, err
+	case p.accept("VACUUM", "INTO"):
+		return nil, 0, p.vacuumInto()
+	default:
+		return nil, 0, fmt.Errorf("fakesql: unsupported statement %q", p.toks)
+	}
+	return nil, 0, nil
+}
+
+func (p *fakeParser) insert() error {
+	table := p.next()
+	var cols []string
+	if err := p.list(func() error { cols = append(cols, p.next()); return nil }); err != nil {
+		return err
+	}
+	if err := p.expect("VALUES"); err != nil {
+		return err
+	}
+	row := fakeRow{}
+	i := 0
+	if err := p.list(func() error {
+		v, err := p.value()
+		if i < len(cols) {
+			row[cols[i]] = v
+		}
+		i++
+		return err
+	}); err != nil {
+		return err
+	}
+	if i != len(cols) {
+		return fmt.Errorf("fakesql: %d values for %d columns", i, len(cols))
+	}
+	rows := p.db.tables[table]
+	if p.accept("ON", "CONFLICT") {
+		var key string
+		if err := p.list(func() error { key = p.next(); return nil }); err != nil {
+			return err
+		}
+		// DO UPDATE SET c = excluded.c, ...: the new row replaces the old.
+		p.pos = len(p.toks)
+		for j, r := range rows {
+			if compareSQL(r[key], row[key]) == 0 {
+				rows = append(append(rows[:j:j], row), rows[j+1:]...)
+				p.db.tables[table] = rows
+				return nil
+			}
+		}
+	}
+	p.db.tables[table] = append(rows, row)
+	return nil
+}
+
+func (p *fakeParser) selectRows() (*fakeRows, error) {
+	var cols []string
+	// count and maxCol are set for an aggregate, which is the only column.
+	count, maxCol := false, ""
+	for {
+		switch {
+		case p.accept("COUNT", "(", "*", ")"):
+			count = true
+			cols = append(cols, "COUNT(*)")
+		case p.accept("COALESCE", "(", "MAX", "("):
+			maxCol = p.next()
+			cols = append(cols, "MAX("+maxCol+")")
+			if err := p.expect(")", ",", "0", ")"); err != nil {
+				return nil, err
+			}
+		default:
+			cols = append(cols, p.next())
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	table := p.next()
+	match, err := p.where(table)
+	if err != nil {
+		return nil, err
+	}
+	var rows []fakeRow
+	for _, r := range p.db.tables[table] {
+		if match(r) {
+			rows = append(rows, r)
+		}
+	}
+	if p.accept("ORDER", "BY") {
+		col := p.next()
+		desc := p.accept("DESC")
+		sort.SliceStable(rows, func(i, j int) bool {
+			c := compareSQL(rows[i][col], rows[j][col])
+			return c < 0 && !desc || c > 0 && desc
+		})
+	}
+	if p.accept("LIMIT") {
+		n, err := strconv.Atoi(p.next())
+		if err != nil {
+			return nil, err
+		}
+		if n < len(rows) {
+			rows = rows[:n]
+		}
+	}
+	out := &fakeRows{cols: cols}
+	if count {
+		out.rows = [][]driver.Value{{int64(len(rows))}}
+		return out, nil
+	}
+	if maxCol != "" {
+		var v driver.Value = int64(0)
+		for _, r := range rows {
+			if compareSQL(r[maxCol], v) > 0 {
+				v = r[maxCol]
+			}
+		}
+		out.rows = [][]driver.Value{{v}}
+		return out, nil
+	}
+	for _, r := range rows {
+		vals := make([]driver.Value, len(cols))
+		for i, c := range cols {
+			vals[i] = r[c]
+		}
+		out.rows = append(out.rows, vals)
+	}
+	return out, nil
+}
+
+func (p *fakeParser) delete() (int64, error) {
+	table := p.next()
+	match, err := p.where(table)
+	if err != nil {
+		return 0, err
+	}
+	var kept []fakeRow
+	for _, r := range p.db.tables[table] {
+		if !match(r) {
+			kept = append(kept, r)
+		}
+	}
+	n := len(p.db.tables[table]) - len(kept)
+	p.db.tables[table] = kept
+	return int64(n), nil
+}
+
+// where reads an optional WHERE of AND-ed comparisons of a column with a
+// value, "1 = 1" matching every row, and returns the match of a row.
+func (p *fakeParser) where(table string) (func(fakeRow) bool, error) {
+	if _, ok := p.db.tables[table]; !ok {
+		return nil, fmt.Errorf("fakesql: no such table: %s", table)
+	}
+	type cond struct {
+		col, op string
+		v       driver.Value
+	}
+	var conds []cond
+	match := func(r fakeRow) bool {
+		for _, c := range conds {
+			n := compareSQL(r[c.col], c.v)
+			if !(c.op == "=" && n == 0 || c.op == "<" && n < 0 || c.op == ">=" && n >= 0) {
+				return false
+			}
+		}
+		return true
+	}
+	if !p.accept("WHERE") {
+		return match, nil
+	}
+	for {
+		if !p.accept("1", "=", "1") {
+			col, op := p.next(), p.next()
+			if !sqlWord.MatchString(col) {
+				return nil, fmt.Errorf("fakesql: want a column, got %q", col)
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, cond{col, op, v})
+		}
+		if !p.accept("AND") {
+			return match, nil
+		}
+	}
+}
+
+func (p *fakeParser) vacuumInto() error {
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	path, ok := v.(string)
+	if !ok {
+		return errors.New("fakesql: VACUUM INTO needs a file name")
+	}
+	var names []string
+	for name := range p.db.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s %d\n", name, len(p.db.tables[name]))
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// compareSQL orders two values of the same kind; NULL sorts first.
+func compareSQL(a, b driver.Value) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	switch a := a.(type) {
+	case int64:
+		if b, ok := b.(int64); ok {
+			return cmp.Compare(a, b)
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b)
+		}
+	case []byte:
+		if b, ok := b.([]byte); ok {
+			return bytes.Compare(a, b)
+		}
+	}
+	return cmp.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// StoredProduct is the persisted state of a product.
+type StoredProduct struct {
+	Type  string `json:"type"`
+	Price Money  `json:"price"`
+	Stock int    `json:"stock"`
+}
+
+// Storage persists stock and prices across restarts.
+type Storage interface {
+	Load() ([]StoredProduct, error)
+	Save(p StoredProduct) error
+}
+
+// FileStorage keeps products in a JSON file, rewritten atomically on every
+// save and encrypted when Sealer is set.
+type FileStorage struct {
+	Path   string
+	Sealer *Sealer
+
+	products map[string]StoredProduct
+}
+
+func (s *FileStorage) Load() ([]StoredProduct, error) {
+	s.products = map[string]StoredProduct{}
+	raw, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.Sealer != nil {
+		if raw, err = s.Sealer.Open(raw); err != nil {
+			return nil, newErrorf(CodeStorage, "%s: %w", s.Path, err)
+		}
+	}
+	var list []StoredProduct
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, newErrorf(CodeStorage, "%s: %w", s.Path, err)
+	}
+	for _, p := range list {
+		s.products[p.Type] = p
+	}
+	return list, nil
+}
+
+func (s *FileStorage) Save(p StoredProduct) error {
+	if s.products == nil {
+		s.products = map[string]StoredProduct{}
+	}
+	s.products[p.Type] = p
+	list := make([]StoredProduct, 0, len(s.products))
+	for _, p := range s.products {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Type < list[j].Type })
+	raw, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if s.Sealer != nil {
+		if raw, err = s.Sealer.Seal(raw); err != nil {
+			return err
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), ".stock-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// SQLStorage keeps products in a SQL table. It is written for SQLite but
+// only uses database/sql; the integrator opens DB with the driver of their
+// choice. UseStorage brings the schema up to date before loading.
+type SQLStorage struct {
+	DB *sql.DB
+}
+
+// Migrate brings the schema up to date.
+func (s *SQLStorage) Migrate() error {
+	_, err := Migrate(s.DB)
+	return err
+}
+
+func (s *SQLStorage) Load() ([]StoredProduct, error) {
+	rows, err := s.DB.Query(`SELECT type, price, stock FROM products`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []StoredProduct
+	for rows.Next() {
+		var p StoredProduct
+		if err := rows.Scan(&p.Type, &p.Price, &p.Stock); err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLStorage) Save(p StoredProduct) error {
+	_, err := s.DB.Exec(`INSERT INTO products (type, price, stock) VALUES (?, ?, ?)
+		ON CONFLICT(type) DO UPDATE SET price = excluded.price, stock = excluded.stock`,
+		p.Type, p.Price, p.Stock)
+	return err
+}
+
+// UseStorage migrates the schema of s, loads stock and prices from it
+// into the catalog and writes every later change through to it. Products
+// s does not know yet are saved with their current values.
+func (m *TicketMachine) UseStorage(s Storage) error {
+	if err := migrateFirst(s); err != nil {
+		return err
+	}
+	list, err := s.Load()
+	if err != nil {
+		return newErrorf(CodeStorage, "cannot load stock: %w", err)
+	}
+	known := map[string]bool{}
+	for _, sp := range list {
+		p, ok := m.Catalog.products[sp.Type]
+		if !ok {
+			continue
+		}
+		p.Price, p.Stock = sp.Price, sp.Stock
+		known[sp.Type] = true
+	}
+	m.Storage = s
+	for _, p := range m.Catalog.List() {
+		if !known[p.Type] {
+			m.persist(p.Type)
+		}
+	}
+	return nil
+}
+
+// persist writes a product's stock and price through to storage.
+func (m *TicketMachine) persist(ticketType string) {
+	if m.Storage == nil {
+		return
+	}
+	p, ok := m.Catalog.Product(ticketType)
+	if !ok {
+		return
+	}
+	if err := m.Storage.Save(StoredProduct{Type: p.Type, Price: p.Price, Stock: p.Stock}); err != nil {
+		m.warn("stock not saved: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStorageSurvivesRestart(t *testing.T) {
+	ring := &KeyRing{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	for _, sealer := range []*Sealer{nil, {Keys: ring}} {
+		name := "clear"
+		if sealer != nil {
+			name = "sealed"
+		}
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "stock.json")
+			m, _ := newTestMachine(t)
+			must(t, m.UseStorage(&FileStorage{Path: path, Sealer: sealer}))
+			stock := m.Catalog.Stock("metro")
+			sellMetro(t, m)
+			must(t, m.StartOver())
+			s, err := m.EnterAdminMode(Credentials{OperatorID: "admin", PIN: "0000"})
+			must(t, err)
+			must(t, s.SetPrice("bus", KZT(270)))
+			must(t, s.Exit())
+
+			raw, err := os.ReadFile(path)
+			must(t, err)
+			if sealed := !bytes.Contains(raw, []byte(`"metro"`)); sealed != (sealer != nil) {
+				t.Fatalf("file sealed = %v, want %v", sealed, sealer != nil)
+			}
+
+			fresh, _ := newTestMachine(t)
+			must(t, fresh.UseStorage(&FileStorage{Path: path, Sealer: sealer}))
+			if got := fresh.Catalog.Stock("metro"); got != stock-1 {
+				t.Errorf("metro stock %d after restart, want %d", got, stock-1)
+			}
+			if got := fresh.GetTicketPrice("bus"); got != KZT(270) {
+				t.Errorf("bus price %s after restart, want %s", got, KZT(270))
+			}
+		})
+	}
+}
+
+func TestFileStorageLoad(t *testing.T) {
+	dir := t.TempDir()
+	corrupt := filepath.Join(dir, "corrupt.json")
+	must(t, os.WriteFile(corrupt, []byte("{not json"), 0o600))
+
+	m, _ := newTestMachine(t)
+	price := m.GetTicketPrice("metro")
+	must(t, m.UseStorage(&FileStorage{Path: filepath.Join(dir, "missing.json")}))
+	if m.GetTicketPrice("metro") != price {
+		t.Fatalf("missing file changed the catalog: metro %s", m.GetTicketPrice("metro"))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing.json")); err != nil {
+		t.Fatalf("catalog not written to a new file: %v", err)
+	}
+
+	m, _ = newTestMachine(t)
+	if err := m.UseStorage(&FileStorage{Path: corrupt}); CodeOf(err) != CodeStorage {
+		t.Fatalf("UseStorage(corrupt) = %v, want %s", err, CodeStorage)
+	}
+}
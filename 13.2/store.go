@@ -0,0 +1,533 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Store keeps the sales ledger: every committed sale with its lines and
+// tenders, refunds, stock movements, and the configuration applied last,
+// which is loaded again at boot.
+type Store interface {
+	SaveTransaction(rec TransactionRecord) error
+	SaveRefund(r RefundRecord) error
+	SaveMovement(mv StockMovement) error
+	SaveConfig(cfg MachineConfig) error
+	// Sales returns the sales with from <= Time < to; a zero bound
+	// is open.
+	Sales(from, to time.Time) ([]TransactionRecord, error)
+	// Refunded returns the refunds with from <= Time < to; a zero bound
+	// is open.
+	Refunded(from, to time.Time) ([]RefundRecord, error)
+	// LoadConfig returns the stored configuration, nil when there is none.
+	LoadConfig() (*MachineConfig, error)
+	// Prune removes the sales and refunds from before the cutoff, except
+	// the sales in keep and their refunds. Stock movements and
+	// configurations are kept.
+	Prune(before time.Time, keep map[string]bool) error
+}
+
+// MemoryStore keeps the ledger in memory, for tests and demos.
+type MemoryStore struct {
+	Transactions []TransactionRecord
+	Refunds      []RefundRecord
+	Movements    []StockMovement
+	Config       *MachineConfig
+}
+
+func (s *MemoryStore) SaveTransaction(rec TransactionRecord) error {
+	s.Transactions = append(s.Transactions, rec)
+	return nil
+}
+
+func (s *MemoryStore) Sales(from, to time.Time) ([]TransactionRecord, error) {
+	var out []TransactionRecord
+	for _, t := range s.Transactions {
+		if inRange(t.Time, from, to) {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) SaveRefund(r RefundRecord) error {
+	s.Refunds = append(s.Refunds, r)
+	return nil
+}
+
+func (s *MemoryStore) Refunded(from, to time.Time) ([]RefundRecord, error) {
+	var out []RefundRecord
+	for _, r := range s.Refunds {
+		if inRange(r.Time, from, to) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) Prune(before time.Time, keep map[string]bool) error {
+	var sales []TransactionRecord
+	for _, t := range s.Transactions {
+		if !t.Time.Before(before) || keep[t.ID] {
+			sales = append(sales, t)
+		}
+	}
+	var refunds []RefundRecord
+	for _, r := range s.Refunds {
+		if !r.Time.Before(before) || keep[r.TransactionID] {
+			refunds = append(refunds, r)
+		}
+	}
+	s.Transactions, s.Refunds = sales, refunds
+	return nil
+}
+
+func (s *MemoryStore) SaveMovement(mv StockMovement) error {
+	s.Movements = append(s.Movements, mv)
+	return nil
+}
+
+func (s *MemoryStore) SaveConfig(cfg MachineConfig) error {
+	s.Config = &cfg
+	return nil
+}
+
+func (s *MemoryStore) LoadConfig() (*MachineConfig, error) { return s.Config, nil }
+
+// SQLStore keeps the ledger in SQL tables. Like SQLStorage it is written
+// for SQLite but only uses database/sql; UseStore brings the schema up to
+// date before anything is stored. When Sealer is set, sales and refunds are stored encrypted: only
+// the IDs and times that queries need stay in the clear, and the record
+// itself is sealed in the sealed column.
+type SQLStore struct {
+	DB     *sql.DB
+	Sealer *Sealer
+}
+
+// seal encrypts a record as JSON.
+func (s *SQLStore) seal(v interface{}) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return s.Sealer.Seal(raw)
+}
+
+// Migrate brings the schema up to date.
+func (s *SQLStore) Migrate() error {
+	_, err := Migrate(s.DB)
+	return err
+}
+
+// SaveTransaction writes a sale with its lines and tenders in one
+// database transaction.
+func (s *SQLStore) SaveTransaction(rec TransactionRecord) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if s.Sealer != nil {
+		rec.Delivery = nil
+		sealed, err := s.seal(rec)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO transactions (id, time, machine_id, product, quantity, category,
+			price, vat, promo_code, promo_discount, change, donation, sealed) VALUES (?, ?, ?, '', 0, '', 0, 0, '', 0, 0, 0, ?)`,
+			rec.ID, rec.Time.UTC(), rec.MachineID, sealed); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if _, err := tx.Exec(`INSERT INTO transactions (id, time, machine_id, product, quantity, category,
+		price, vat, promo_code, promo_discount, change, donation) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.ID, rec.Time.UTC(), rec.MachineID, rec.Product, rec.Quantity, string(rec.Category),
+		rec.Price, rec.Tax.VAT, rec.PromoCode, rec.PromoDiscount, rec.Change, rec.Donation); err != nil {
+		return err
+	}
+	for i, l := range rec.Lines {
+		if _, err := tx.Exec(`INSERT INTO transaction_lines (transaction_id, line, ticket_type, qty, unit_price, discount)
+			VALUES (?, ?, ?, ?, ?, ?)`, rec.ID, i+1, l.TicketType, l.Qty, l.UnitPrice, l.Discount); err != nil {
+			return err
+		}
+	}
+	for t, amount := range rec.Tenders {
+		if _, err := tx.Exec(`INSERT INTO tenders (transaction_id, tender, amount) VALUES (?, ?, ?)`,
+			rec.ID, string(t), amount); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) Sales(from, to time.Time) ([]TransactionRecord, error) {
+	q := `SELECT id, time, machine_id, product, quantity, category, price, vat, promo_code, promo_discount,
+		change, donation, sealed FROM transactions WHERE 1 = 1`
+	var args []interface{}
+	if !from.IsZero() {
+		q += ` AND time >= ?`
+		args = append(args, from.UTC())
+	}
+	if !to.IsZero() {
+		q += ` AND time < ?`
+		args = append(args, to.UTC())
+	}
+	rows, err := s.DB.Query(q+` ORDER BY time`, args...)
+	if err != nil {
+		return nil, err
+	}
+	var out []TransactionRecord
+	var sealed [][]byte
+	for rows.Next() {
+		var t TransactionRecord
+		var category string
+		var sl []byte
+		if err := rows.Scan(&t.ID, &t.Time, &t.MachineID, &t.Product, &t.Quantity, &category, &t.Price,
+			&t.Tax.VAT, &t.PromoCode, &t.PromoDiscount, &t.Change, &t.Donation, &sl); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t.Category = FareCategory(category)
+		out = append(out, t)
+		sealed = append(sealed, sl)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range out {
+		if sealed[i] != nil {
+			if err := s.open(sealed[i], &out[i]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := s.loadDetails(&out[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// open decrypts a sealed record into v.
+func (s *SQLStore) open(sealed []byte, v interface{}) error {
+	if s.Sealer == nil {
+		return newError(CodeStorage, "ledger is encrypted and no sealer is set")
+	}
+	raw, err := s.Sealer.Open(sealed)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return newErrorf(CodeStorage, "sealed record: %w", err)
+	}
+	return nil
+}
+
+// loadDetails reads the lines and tenders of a stored sale.
+func (s *SQLStore) loadDetails(t *TransactionRecord) error {
+	rows, err := s.DB.Query(`SELECT ticket_type, qty, unit_price, discount FROM transaction_lines
+		WHERE transaction_id = ? ORDER BY line`, t.ID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var l CartLine
+		if err := rows.Scan(&l.TicketType, &l.Qty, &l.UnitPrice, &l.Discount); err != nil {
+			rows.Close()
+			return err
+		}
+		t.Lines = append(t.Lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows, err = s.DB.Query(`SELECT tender, amount FROM tenders WHERE transaction_id = ?`, t.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	t.Tenders = map[Tender]Money{}
+	for rows.Next() {
+		var tender string
+		var amount Money
+		if err := rows.Scan(&tender, &amount); err != nil {
+			return err
+		}
+		t.Tenders[Tender(tender)] = amount
+	}
+	return rows.Err()
+}
+
+// SaveRefund writes a refund with its split by tender in one database
+// transaction.
+func (s *SQLStore) SaveRefund(r RefundRecord) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if s.Sealer != nil {
+		sealed, err := s.seal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO refunds (ticket_id, transaction_id, time, amount, tender, sealed)
+			VALUES (?, ?, ?, 0, '', ?)`, r.TicketID, r.TransactionID, r.Time.UTC(), sealed); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if _, err := tx.Exec(`INSERT INTO refunds (ticket_id, transaction_id, time, amount, tender) VALUES (?, ?, ?, ?, ?)`,
+		r.TicketID, r.TransactionID, r.Time.UTC(), r.Amount, string(r.Tender)); err != nil {
+		return err
+	}
+	for t, amount := range r.Parts {
+		if _, err := tx.Exec(`INSERT INTO refund_parts (ticket_id, tender, amount) VALUES (?, ?, ?)`,
+			r.TicketID, string(t), amount); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) Refunded(from, to time.Time) ([]RefundRecord, error) {
+	q := `SELECT ticket_id, transaction_id, time, amount, tender, sealed FROM refunds WHERE 1 = 1`
+	var args []interface{}
+	if !from.IsZero() {
+		q += ` AND time >= ?`
+		args = append(args, from.UTC())
+	}
+	if !to.IsZero() {
+		q += ` AND time < ?`
+		args = append(args, to.UTC())
+	}
+	rows, err := s.DB.Query(q+` ORDER BY time`, args...)
+	if err != nil {
+		return nil, err
+	}
+	var out []RefundRecord
+	var sealed [][]byte
+	for rows.Next() {
+		var r RefundRecord
+		var tender string
+		var sl []byte
+		if err := rows.Scan(&r.TicketID, &r.TransactionID, &r.Time, &r.Amount, &tender, &sl); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		r.Tender = Tender(tender)
+		out = append(out, r)
+		sealed = append(sealed, sl)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range out {
+		if sealed[i] != nil {
+			if err := s.open(sealed[i], &out[i]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := s.loadParts(&out[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// loadParts reads the split by tender of a stored refund; refunds stored
+// before the split was have none.
+func (s *SQLStore) loadParts(r *RefundRecord) error {
+	rows, err := s.DB.Query(`SELECT tender, amount FROM refund_parts WHERE ticket_id = ?`, r.TicketID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tender string
+		var amount Money
+		if err := rows.Scan(&tender, &amount); err != nil {
+			return err
+		}
+		if r.Parts == nil {
+			r.Parts = map[Tender]Money{}
+		}
+		r.Parts[Tender(tender)] = amount
+	}
+	return rows.Err()
+}
+
+// Prune deletes the old sales and refunds with their lines, tenders and
+// parts in one database transaction.
+func (s *SQLStore) Prune(before time.Time, keep map[string]bool) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	sales, err := pruneKeys(tx, `SELECT id, id FROM transactions WHERE time < ?`, before.UTC(), keep)
+	if err != nil {
+		return err
+	}
+	for _, id := range sales {
+		for _, q := range []string{
+			`DELETE FROM transaction_lines WHERE transaction_id = ?`,
+			`DELETE FROM tenders WHERE transaction_id = ?`,
+			`DELETE FROM transactions WHERE id = ?`,
+		} {
+			if _, err := tx.Exec(q, id); err != nil {
+				return err
+			}
+		}
+	}
+	refunds, err := pruneKeys(tx, `SELECT ticket_id, transaction_id FROM refunds WHERE time < ?`, before.UTC(), keep)
+	if err != nil {
+		return err
+	}
+	for _, id := range refunds {
+		for _, q := range []string{
+			`DELETE FROM refund_parts WHERE ticket_id = ?`,
+			`DELETE FROM refunds WHERE ticket_id = ?`,
+		} {
+			if _, err := tx.Exec(q, id); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// pruneKeys runs q, which selects a key and the sale it belongs to, and
+// returns the keys of the sales not in keep.
+func pruneKeys(tx *sql.Tx, q string, before time.Time, keep map[string]bool) ([]string, error) {
+	rows, err := tx.Query(q, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key, sale string
+		if err := rows.Scan(&key, &sale); err != nil {
+			return nil, err
+		}
+		if !keep[sale] {
+			keys = append(keys, key)
+		}
+	}
+	return keys, rows.Err()
+}
+
+func (s *SQLStore) SaveMovement(mv StockMovement) error {
+	_, err := s.DB.Exec(`INSERT INTO movements (time, ticket_type, delta, balance, actor, reason, reference)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		mv.Time.UTC(), mv.TicketType, mv.Delta, mv.Balance, mv.Actor, string(mv.Reason), mv.Reference)
+	return err
+}
+
+func (s *SQLStore) SaveConfig(cfg MachineConfig) error {
+	doc, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`INSERT INTO config (version, document) VALUES (?, ?)
+		ON CONFLICT(version) DO UPDATE SET document = excluded.document`, cfg.Version, string(doc))
+	return err
+}
+
+func (s *SQLStore) LoadConfig() (*MachineConfig, error) {
+	var doc string
+	err := s.DB.QueryRow(`SELECT document FROM config ORDER BY version DESC LIMIT 1`).Scan(&doc)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cfg MachineConfig
+	if err := json.Unmarshal([]byte(doc), &cfg); err != nil {
+		return nil, newErrorf(CodeStorage, "stored config: %w", err)
+	}
+	return &cfg, nil
+}
+
+// migrator is a Store or Storage whose schema must be brought up to date
+// before use.
+type migrator interface {
+	Migrate() error
+}
+
+// migrateFirst runs the migrations of s, when it has any.
+func migrateFirst(s interface{}) error {
+	if mg, ok := s.(migrator); ok {
+		if err := mg.Migrate(); err != nil {
+			return newErrorf(CodeStorage, "cannot migrate schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// UseStore migrates the schema of s, applies the configuration s holds,
+// when newer than the current one, and records every later sale, refund,
+// stock movement and applied configuration in s.
+func (m *TicketMachine) UseStore(s Store) error {
+	if err := migrateFirst(s); err != nil {
+		return err
+	}
+	cfg, err := s.LoadConfig()
+	if err != nil {
+		return newErrorf(CodeStorage, "cannot load config: %w", err)
+	}
+	if cfg != nil && cfg.Version > m.ConfigVersion {
+		if err := m.applyConfig(*cfg); err != nil {
+			return err
+		}
+		m.audit("", "config_loaded", fmt.Sprintf("version %d", cfg.Version))
+	}
+	m.Store = s
+	return nil
+}
+
+// saveSale writes a paid sale to the Store before anything is handed out.
+// When the write fails the sale is called off, the captured card refunded
+// and the cash given back, so no sale completes without a ledger record.
+func (m *TicketMachine) saveSale(rec TransactionRecord) error {
+	if m.Store == nil {
+		return nil
+	}
+	err := m.Store.SaveTransaction(rec)
+	if err == nil {
+		return nil
+	}
+	m.warn("transaction not stored: %v", err)
+	if card := rec.Card; card != nil {
+		if rerr := m.deviceCall("payment.refund", nil, func() error {
+			return m.Gateway.Refund(m.gatewayKey("refund"), *card, card.Amount)
+		}, moneyAttr("amount", card.Amount)); rerr != nil {
+			m.warn("card refund failed: %v", rerr)
+		}
+	}
+	if cerr := m.cancel(); cerr != nil {
+		m.warn("unrecorded sale not canceled: %v", cerr)
+	}
+	return newErrorf(CodeStorage, "sale not recorded, payment returned: %w", err)
+}
+
+// store writes a refund, stock movement or configuration through to the
+// Store; a failure is logged and does not hold up the machine.
+func (m *TicketMachine) store(what string, save func(s Store) error) {
+	if m.Store == nil {
+		return
+	}
+	if err := save(m.Store); err != nil {
+		m.warn("%s not stored: %v", what, err)
+	}
+}
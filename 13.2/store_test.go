@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sellMetro sells one metro ticket for cash.
+func sellMetro(t *testing.T, m *TicketMachine) {
+	t.Helper()
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.InsertMoney(KZT(200)))
+	must(t, m.InsertMoney(KZT(100)))
+	if _, err := m.DispenseTicket(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQLStoreSales(t *testing.T) {
+	for _, sealed := range []bool{false, true} {
+		name := "clear"
+		if sealed {
+			name = "sealed"
+		}
+		t.Run(name, func(t *testing.T) {
+			db, fake := newFakeSQL(t)
+			s := &SQLStore{DB: db}
+			if sealed {
+				s.Sealer = &Sealer{Keys: StaticKey(bytes.Repeat([]byte{7}, 32))}
+			}
+			m, clock := newTestMachine(t)
+			must(t, m.UseStore(s))
+			sellMetro(t, m)
+			m.StartOver()
+			clock.Advance(time.Hour)
+			sellMetro(t, m)
+
+			got, err := s.Sales(time.Time{}, time.Time{})
+			must(t, err)
+			if len(got) != 2 {
+				t.Fatalf("%d sales stored, want 2", len(got))
+			}
+			want := m.Transactions[0]
+			rec := got[0]
+			if rec.ID != want.ID || !rec.Time.Equal(want.Time) || rec.Product != want.Product || rec.Price != want.Price ||
+				rec.Change != want.Change || !reflect.DeepEqual(rec.Lines, want.Lines) || !reflect.DeepEqual(rec.Tenders, want.Tenders) {
+				t.Errorf("stored %+v, want %+v", rec, want)
+			}
+			later, err := s.Sales(want.Time.Add(time.Minute), time.Time{})
+			must(t, err)
+			if len(later) != 1 || later[0].ID != m.Transactions[1].ID {
+				t.Errorf("sales from %s: %+v", want.Time.Add(time.Minute), later)
+			}
+			must(t, s.SaveRefund(RefundRecord{TicketID: "T-1", TransactionID: want.ID, Time: want.Time.Add(time.Minute),
+				Amount: KZT(250), Tender: TenderCash, Parts: map[Tender]Money{TenderCash: KZT(250)}}))
+			if product := fake.tables["transactions"][0]["product"]; sealed != (product == "") {
+				t.Errorf("product column %q with sealed=%t", product, sealed)
+			}
+		})
+	}
+}
+
+func TestSQLStoreConfig(t *testing.T) {
+	db, _ := newFakeSQL(t)
+	s := &SQLStore{DB: db}
+	must(t, s.Migrate())
+	cfg, err := s.LoadConfig()
+	must(t, err)
+	if cfg != nil {
+		t.Fatalf("config %+v in an empty store", cfg)
+	}
+	must(t, s.SaveConfig(MachineConfig{Version: 2, TimeoutSeconds: 30}))
+	must(t, s.SaveConfig(MachineConfig{Version: 3, TimeoutSeconds: 45}))
+	must(t, s.SaveConfig(MachineConfig{Version: 3, TimeoutSeconds: 60}))
+	cfg, err = s.LoadConfig()
+	must(t, err)
+	if cfg == nil || cfg.Version != 3 || cfg.TimeoutSeconds != 60 {
+		t.Fatalf("loaded %+v, want version 3 with a 60s timeout", cfg)
+	}
+}
+
+func TestUseStoreMigrates(t *testing.T) {
+	db, _ := newFakeSQL(t)
+	m, _ := newTestMachine(t)
+	must(t, m.UseStore(&SQLStore{DB: db}))
+	list, err := Migrations()
+	must(t, err)
+	v, err := Migrate(db)
+	must(t, err)
+	if v != list[len(list)-1].Version {
+		t.Errorf("schema version %d, want %d", v, list[len(list)-1].Version)
+	}
+	var applied int
+	must(t, db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied))
+	if applied != len(list) {
+		t.Errorf("%d migrations recorded, want %d", applied, len(list))
+	}
+}
+
+func TestUseStoreFailsWhenMigrationFails(t *testing.T) {
+	db, fake := newFakeSQL(t)
+	fake.fail = "ALTER TABLE"
+	m, _ := newTestMachine(t)
+	if err := m.UseStore(&SQLStore{DB: db}); CodeOf(err) != CodeStorage {
+		t.Fatalf("UseStore: %v, want a storage error", err)
+	}
+	if m.Store != nil {
+		t.Error("store in use after a failed migration")
+	}
+}
+
+func TestSQLStorageRoundTrip(t *testing.T) {
+	db, _ := newFakeSQL(t)
+	m, _ := newTestMachine(t)
+	must(t, m.UseStorage(&SQLStorage{DB: db}))
+	before := m.Catalog.Stock("metro")
+	sellMetro(t, m)
+
+	fresh, _ := newTestMachine(t)
+	must(t, fresh.UseStorage(&SQLStorage{DB: db}))
+	if got := fresh.Catalog.Stock("metro"); got != before-1 {
+		t.Errorf("stock %d after reload, want %d", got, before-1)
+	}
+}
+
+func TestSaleFailsWhenNotRecorded(t *testing.T) {
+	db, fake := newFakeSQL(t)
+	m, _ := newTestMachine(t)
+	g := &MockGateway{}
+	m.Gateway = g
+	must(t, m.UseStore(&SQLStore{DB: db}))
+	fake.fail = "INSERT INTO transactions"
+	stock := m.Catalog.Stock("metro")
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.InsertMoney(KZT(100)))
+	must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}))
+	d, err := m.DispenseTicket()
+	if CodeOf(err) != CodeStorage {
+		t.Fatalf("DispenseTicket: %v, want a storage error", err)
+	}
+	if len(d.Tickets) != 0 || m.Catalog.Stock("metro") != stock || len(m.Transactions) != 0 {
+		t.Errorf("unrecorded sale went through: %+v", d)
+	}
+	if len(g.Refunded) != 1 || m.InsertedMoney != 0 {
+		t.Errorf("refunded %+v, %s still inserted", g.Refunded, m.InsertedMoney)
+	}
+	if _, ok := m.State.(*TransactionCanceledState); !ok {
+		t.Errorf("state %s, want TransactionCanceled", m.State.Name())
+	}
+}
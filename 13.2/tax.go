@@ -0,0 +1,69 @@
+package main
+
+import "fmt"
+
+// TaxBreakdown splits a VAT-inclusive price into net amount and VAT.
+// RateBP is the VAT rate in basis points (1200 = 12%).
+type TaxBreakdown struct {
+	Gross  Money
+	Net    Money
+	VAT    Money
+	RateBP int
+}
+
+// Breakdown splits gross at the given rate, rounding VAT to the nearest
+// minor unit.
+func Breakdown(gross Money, rateBP int) TaxBreakdown {
+	vat := (int64(gross)*int64(rateBP)*2 + int64(10000+rateBP)) / (int64(10000+rateBP) * 2)
+	return TaxBreakdown{Gross: gross, Net: gross - Money(vat), VAT: Money(vat), RateBP: rateBP}
+}
+
+// MixedRate is the RateBP of a breakdown summed over different rates.
+const MixedRate = -1
+
+// Add sums two breakdowns, as for the lines of a cart.
+func (t TaxBreakdown) Add(o TaxBreakdown) TaxBreakdown {
+	rate := t.RateBP
+	if t.Gross == 0 {
+		rate = o.RateBP
+	} else if o.Gross != 0 && o.RateBP != t.RateBP {
+		rate = MixedRate
+	}
+	return TaxBreakdown{Gross: t.Gross + o.Gross, Net: t.Net + o.Net, VAT: t.VAT + o.VAT, RateBP: rate}
+}
+
+// Scale reduces the breakdown to a new gross amount, keeping the VAT share,
+// as when a promo discount is taken off a taxed total.
+func (t TaxBreakdown) Scale(gross, of Money) TaxBreakdown {
+	if of == 0 {
+		return TaxBreakdown{RateBP: t.RateBP}
+	}
+	if t.RateBP != MixedRate {
+		return Breakdown(gross, t.RateBP)
+	}
+	vat := t.VAT * gross / of
+	return TaxBreakdown{Gross: gross, Net: gross - vat, VAT: vat, RateBP: t.RateBP}
+}
+
+// Rate formats the VAT rate as a percentage, e.g. "12.00%".
+func (t TaxBreakdown) Rate() string {
+	if t.RateBP == MixedRate {
+		return "(mixed rates)"
+	}
+	return fmt.Sprintf("%d.%02d%%", t.RateBP/100, t.RateBP%100)
+}
+
+// VATRate is the rate configured for a product; unknown products are
+// untaxed.
+func (m *TicketMachine) VATRate(product string) int {
+	p, _ := m.Catalog.Product(product)
+	return p.VATRate
+}
+
+// printTax prints the VAT line for the current sale.
+func (m *TicketMachine) printTax(t TaxBreakdown) {
+	if t.VAT == 0 {
+		return
+	}
+	m.show("Net: %s, VAT %s: %s", t.Net.In(m.Currency), t.Rate(), t.VAT.In(m.Currency))
+}
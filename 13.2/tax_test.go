@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestBreakdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		gross    Money
+		rateBP   int
+		net, vat Money
+		rate     string
+	}{
+		{"exact", KZT(112), 1200, KZT(100), KZT(12), "12.00%"},
+		{"rounds down", KZT(300), 1200, 26786, 3214, "12.00%"},
+		{"rounds up", 7, 1200, 6, 1, "12.00%"},
+		{"untaxed", KZT(300), 0, KZT(300), 0, "0.00%"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Breakdown(tt.gross, tt.rateBP)
+			if b.Gross != tt.gross || b.Net != tt.net || b.VAT != tt.vat || b.Rate() != tt.rate {
+				t.Fatalf("Breakdown = %+v (%s), want net %d vat %d (%s)", b, b.Rate(), tt.net, tt.vat, tt.rate)
+			}
+		})
+	}
+}
+
+func TestBreakdownAdd(t *testing.T) {
+	taxed, untaxed := Breakdown(KZT(112), 1200), Breakdown(KZT(100), 0)
+	if got := (TaxBreakdown{}).Add(taxed); got != taxed {
+		t.Fatalf("empty + taxed = %+v", got)
+	}
+	if got := taxed.Add(taxed); got.RateBP != 1200 || got.VAT != KZT(24) {
+		t.Fatalf("same rate = %+v", got)
+	}
+	got := taxed.Add(untaxed)
+	if got.RateBP != MixedRate || got.Rate() != "(mixed rates)" || got.Gross != KZT(212) || got.VAT != KZT(12) {
+		t.Fatalf("mixed rates = %+v", got)
+	}
+	if s := got.Scale(KZT(106), KZT(212)); s.VAT != KZT(6) || s.Net != KZT(100) {
+		t.Fatalf("scaled mixed breakdown = %+v", s)
+	}
+}
+
+func TestSaleRecordsTax(t *testing.T) {
+	m, _ := newTestMachine(t)
+	sellMetro(t, m)
+	want := Breakdown(KZT(300), m.VATRate("metro"))
+	if want.VAT == 0 {
+		t.Fatal("metro is untaxed by default")
+	}
+	if got := m.Transactions[len(m.Transactions)-1].Tax; got != want {
+		t.Fatalf("recorded tax = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+// Tender is a way of paying.
+type Tender string
+
+const (
+	TenderCash Tender = "cash"
+	TenderCard Tender = "card"
+	TenderQR   Tender = "qr"
+)
+
+// Tenders returns how much of the current transaction has been paid with
+// each tender. Tenders that were not used are omitted.
+func (m *TicketMachine) Tenders() map[Tender]Money {
+	t := map[Tender]Money{}
+	if m.InsertedMoney > 0 {
+		t[TenderCash] = m.InsertedMoney
+	}
+	if m.CardAuth != nil {
+		t[TenderCard] = m.CardAuth.Amount
+	}
+	if m.QRPaid != nil {
+		t[TenderQR] = m.QRPaid.Amount
+	}
+	return t
+}
+
+// PaidTotal is the combined amount paid over all tenders.
+func (m *TicketMachine) PaidTotal() Money {
+	var total Money
+	for _, v := range m.Tenders() {
+		total += v
+	}
+	return total
+}
+
+// Outstanding is what is still owed for the current transaction.
+func (m *TicketMachine) Outstanding() Money {
+	if rest := m.CurrentPrice - m.PaidTotal(); rest > 0 {
+		return rest
+	}
+	return 0
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestSplitTender(t *testing.T) {
+	tests := []struct {
+		name   string
+		cash   []Money
+		cancel bool
+		card   Money
+	}{
+		{"card pays the rest", []Money{KZT(500)}, false, KZT(500)},
+		{"card pays everything", nil, false, KZT(1000)},
+		{"cancel voids the card and returns the cash", []Money{KZT(200), KZT(100)}, true, KZT(700)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			g := &MockGateway{}
+			m.Gateway = g
+			must(t, m.SelectTicket("train", 1))
+			must(t, m.SkipSeat())
+			var cash Money
+			for _, c := range tt.cash {
+				must(t, m.InsertMoney(c))
+				cash += c
+			}
+			if m.Outstanding() != KZT(1000)-cash {
+				t.Fatalf("outstanding = %s, want %s", m.Outstanding(), KZT(1000)-cash)
+			}
+			must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}))
+			if m.CardAuth.Amount != tt.card || m.Outstanding() != 0 {
+				t.Fatalf("card authorized %s, outstanding %s", m.CardAuth.Amount, m.Outstanding())
+			}
+			if got := m.Tenders(); got[TenderCash] != cash || got[TenderCard] != tt.card {
+				t.Fatalf("tenders = %v", got)
+			}
+			if tt.cancel {
+				must(t, m.Cancel())
+				if len(g.Voided) != 1 || len(g.Captured) != 0 || m.CashBox.Total() != 0 {
+					t.Fatalf("voided %d, captured %d, cash box %s", len(g.Voided), len(g.Captured), m.CashBox.Total())
+				}
+				return
+			}
+			_, err := m.DispenseTicket()
+			must(t, err)
+			if len(g.Captured) != 1 || g.Captured[0].Amount != tt.card {
+				t.Fatalf("captured %+v", g.Captured)
+			}
+			rec := m.Transactions[len(m.Transactions)-1]
+			if rec.Tenders[TenderCard] != tt.card || rec.Tenders[TenderCash] != cash {
+				t.Fatalf("recorded tenders = %v", rec.Tenders)
+			}
+		})
+	}
+}
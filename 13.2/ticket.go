@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Ticket is an issued travel ticket.
+type Ticket struct {
+	ID            string
+	Type          string
+	Kind          ProductKind
+	Category      FareCategory
+	PricePaid     Money
+	IssuedAt      time.Time
+	ValidFrom     time.Time
+	ValidUntil    time.Time
+	MachineID     string
+	StationID     string
+	TransactionID string
+	FromZone      string
+	ToZone        string
+	// Journey, Legs and TransferWindow are the rules validators enforce: a
+	// return ticket has two legs, a transfer ticket allows free boardings
+	// for TransferWindow after its first validation.
+	Journey        JourneyType
+	Legs           int
+	TransferWindow time.Duration
+	// Coach and Seat are set for tickets with a reserved seat.
+	Coach string
+	Seat  string
+	// Bundle is the bundle product a ride credit was sold in; Persons is
+	// the number of riders a group ticket admits.
+	Bundle  string
+	Persons int
+	// QRPayload is the signed payload gates and inspectors scan; QRImage
+	// is its rendering by the machine's QRRenderer.
+	QRPayload string
+	QRImage   []byte
+}
+
+func newTicketID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "TK-" + hex.EncodeToString(b)
+}
+
+// newTicket creates the n-th ticket of a cart line of the current
+// transaction, without its QR code.
+func (m *TicketMachine) newTicket(l CartLine, n int) Ticket {
+	now := m.Clock.Now()
+	t := Ticket{
+		ID:            newTicketID(),
+		Type:          l.TicketType,
+		Kind:          m.Kind(l.TicketType),
+		Category:      m.FareCategory,
+		PricePaid:     l.Price(),
+		IssuedAt:      now,
+		ValidFrom:     now,
+		ValidUntil:    m.validUntil(l.TicketType, now),
+		MachineID:     m.MachineID,
+		StationID:     m.Location.StationID,
+		TransactionID: m.TransactionID,
+		FromZone:      l.FromZone,
+		ToZone:        l.ToZone,
+	}
+	m.applyJourney(&t, l.Journey)
+	if n < len(l.Seats) {
+		t.Coach, t.Seat = l.Seats[n].Coach, l.Seats[n].Number
+	}
+	return t
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDispenseReturnsTicket(t *testing.T) {
+	m, clock := newTestMachine(t)
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.InsertMoney(KZT(200)))
+	must(t, m.InsertMoney(KZT(100)))
+	tx := m.TransactionID
+	d, err := m.DispenseTicket()
+	must(t, err)
+	if len(d.Tickets) != 1 {
+		t.Fatalf("got %d tickets, want 1", len(d.Tickets))
+	}
+	for i, tk := range d.Tickets {
+		if !strings.HasPrefix(tk.ID, "TK-") || tk.Type != "metro" || tk.PricePaid != KZT(300) {
+			t.Errorf("ticket %d = %+v", i, tk)
+		}
+		if !tk.IssuedAt.Equal(clock.Now()) || !tk.ValidUntil.Equal(m.validUntil("metro", clock.Now())) || !tk.ValidUntil.After(tk.IssuedAt) {
+			t.Errorf("ticket %d issued %s, valid until %s", i, tk.IssuedAt, tk.ValidUntil)
+		}
+		if tk.MachineID != m.MachineID || tk.TransactionID != tx {
+			t.Errorf("ticket %d machine %q, transaction %q", i, tk.MachineID, tk.TransactionID)
+		}
+	}
+}
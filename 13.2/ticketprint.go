@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Branding is the operator identity printed on tickets.
+type Branding struct {
+	Operator string
+	Footer   string
+}
+
+// DefaultLabels are the ticket field labels per locale.
+func DefaultLabels() map[string]map[string]string {
+	return map[string]map[string]string{
+		"en": {"ticket": "Ticket", "fare": "Fare", "price": "Price", "valid": "Valid", "until": "Valid until",
+			"zones": "Zones", "seat": "Coach/seat", "riders": "Riders", "journey": "Journey", "number": "No.", "issued": "Issued at", "ref": "Ref."},
+		"ru": {"ticket": "Билет", "fare": "Тариф", "price": "Цена", "valid": "Действует", "until": "Действует до",
+			"zones": "Зоны", "seat": "Вагон/место", "riders": "Пассажиры", "journey": "Поездка", "number": "№", "issued": "Выдан", "ref": "Операция"},
+		"kk": {"ticket": "Билет", "fare": "Тариф", "price": "Бағасы", "valid": "Жарамды", "until": "Жарамды мерзімі",
+			"zones": "Аймақтар", "seat": "Вагон/орын", "riders": "Жолаушылар", "journey": "Сапар", "number": "№", "issued": "Берілді", "ref": "Операция"},
+	}
+}
+
+const singleLayout = `{{.Brand.Operator}}
+{{label "ticket"}}: {{.Type}}{{if ne .Journey "single"}} ({{.Journey}}){{end}}
+{{label "fare"}}: {{.Category}}  {{label "price"}}: {{money .PricePaid}}
+{{if .ToZone}}{{label "zones"}}: {{.FromZone}} - {{.ToZone}}
+{{end}}{{if .Seat}}{{label "seat"}}: {{.Coach}}/{{.Seat}}
+{{end}}{{label "valid"}}: {{date .ValidFrom}} - {{date .ValidUntil}}
+{{label "number"}}: {{.ID}}
+{{label "issued"}}: {{.StationID}} {{.MachineID}}
+{{label "ref"}}: {{.TransactionID}}
+{{.Brand.Footer}}
+`
+
+const passLayout = `{{.Brand.Operator}}
+{{label "ticket"}}: {{.Type}}{{if .Persons}}  {{label "riders"}}: {{.Persons}}{{end}}
+{{label "fare"}}: {{.Category}}  {{label "price"}}: {{money .PricePaid}}
+{{label "until"}}: {{date .ValidUntil}}
+{{label "number"}}: {{.ID}}
+{{label "issued"}}: {{.StationID}} {{.MachineID}}
+{{label "ref"}}: {{.TransactionID}}
+{{.Brand.Footer}}
+`
+
+// TicketTemplates renders tickets for the printer. Layouts are looked up
+// by ticket type, then by product kind, then Default.
+type TicketTemplates struct {
+	Brand   Branding
+	Locale  string
+	Labels  map[string]map[string]string
+	Layouts map[string]string
+	Default string
+}
+
+// DefaultTicketTemplates has a layout for single tickets and one for
+// passes, with English labels.
+func DefaultTicketTemplates() *TicketTemplates {
+	return &TicketTemplates{
+		Brand:   Branding{Operator: "City Transit", Footer: "Keep your ticket until the end of the trip"},
+		Locale:  "en",
+		Labels:  DefaultLabels(),
+		Layouts: map[string]string{string(KindPass): passLayout},
+		Default: singleLayout,
+	}
+}
+
+// ticketView is what a layout is executed against.
+type ticketView struct {
+	Ticket
+	Brand Branding
+}
+
+// Render writes t in its layout to w, with amounts shown in currency.
+func (p *TicketTemplates) Render(w io.Writer, t Ticket, currency Currency) error {
+	layout, ok := p.Layouts[t.Type]
+	if !ok {
+		if layout, ok = p.Layouts[string(t.Kind)]; !ok {
+			layout = p.Default
+		}
+	}
+	tmpl, err := template.New(t.Type).Funcs(template.FuncMap{
+		"label": func(key string) string {
+			if l, ok := p.Labels[p.Locale][key]; ok {
+				return l
+			}
+			return key
+		},
+		"money": func(m Money) string { return m.In(currency) },
+		"date":  func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+	}).Parse(layout)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, ticketView{Ticket: t, Brand: p.Brand})
+}
+
+// printTickets sends the dispensed tickets to the ticket printer, taking
+// paper for each ticket as it is printed. On a render or printer failure,
+// or when paper runs out and the sale has no e-ticket delivery to fall
+// back on, it returns the tickets left unprinted.
+func (m *TicketMachine) printTickets(tickets []Ticket) ([]Ticket, error) {
+	var p TicketPrinter = WriterPrinter{W: m.Out}
+	if m.Printer != nil {
+		p = m.Printer
+	}
+	n := 0
+	for ; n < len(tickets) && !m.Paper.out(); n++ {
+		t := tickets[n]
+		if m.Templates != nil {
+			var b strings.Builder
+			if err := m.Templates.Render(&b, t, m.Currency); err != nil {
+				return tickets[n:], newErrorf(CodePrintFailed, "ticket %s not rendered: %w", t.ID, err)
+			}
+			if err := m.deviceCall("printer.print", nil, func() error { return p.Print(t, b.String()) },
+				slog.String("ticket_id", t.ID)); err != nil {
+				return tickets[n:], err
+			}
+		}
+		m.usePaper(1)
+	}
+	if m.Templates == nil {
+		if n > 1 {
+			m.show("%d tickets dispensed!", n)
+		} else if n == 1 {
+			m.show("Ticket dispensed!")
+		}
+	}
+	short := tickets[n:]
+	if len(short) == 0 {
+		return nil, nil
+	}
+	if d := m.Transactions[len(m.Transactions)-1].Delivery; d != nil {
+		m.show("Out of paper, %d ticket(s) sent by %s only", len(short), d.Channel)
+		return nil, nil
+	}
+	return short, newError(CodeOutOfPaper, "out of ticket paper")
+}
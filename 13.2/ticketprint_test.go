@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderTicket(t *testing.T) {
+	from := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	single := Ticket{ID: "TK-1", Type: "tram", Kind: KindSingle, Category: FareAdult, Journey: JourneyReturn, PricePaid: KZT(380),
+		ValidFrom: from, ValidUntil: from.Add(24 * time.Hour), MachineID: "TM-1", TransactionID: "TX-1", Coach: "2", Seat: "14"}
+	pass := Ticket{ID: "TK-2", Type: "family_day_pass", Kind: KindPass, Category: FareAdult, PricePaid: KZT(2500), Persons: 4,
+		ValidFrom: from, ValidUntil: from.AddDate(0, 0, 1), MachineID: "TM-1"}
+	tests := []struct {
+		name    string
+		ticket  Ticket
+		locale  string
+		layouts map[string]string
+		want    []string
+		absent  []string
+	}{
+		{"single", single, "en", nil,
+			[]string{"City Transit\n", "Ticket: tram (return)\n", "Price: 380.00 KZT", "Coach/seat: 2/14\n", "Valid: 2026-03-02 12:00 - 2026-03-03 12:00\n", "Ref.: TX-1\n"},
+			[]string{"Zones"}},
+		{"pass layout", pass, "en", nil,
+			[]string{"Ticket: family_day_pass  Riders: 4\n", "Valid until: 2026-03-03 12:00\n"},
+			[]string{"Valid: "}},
+		{"russian labels", single, "ru", nil, []string{"Билет: tram", "Вагон/место: 2/14"}, nil},
+		{"layout per ticket type", single, "en", map[string]string{"tram": "{{label \"ticket\"}} {{.ID}} {{label \"nope\"}}"}, []string{"Ticket TK-1 nope"}, []string{"City Transit"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := DefaultTicketTemplates()
+			p.Locale = tt.locale
+			for k, v := range tt.layouts {
+				p.Layouts[k] = v
+			}
+			var b strings.Builder
+			must(t, p.Render(&b, tt.ticket, CurrencyKZT))
+			for _, s := range tt.want {
+				if !strings.Contains(b.String(), s) {
+					t.Errorf("missing %q in\n%s", s, b.String())
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(b.String(), s) {
+					t.Errorf("unexpected %q in\n%s", s, b.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRenderBadLayout(t *testing.T) {
+	p := DefaultTicketTemplates()
+	p.Default = "{{.Missing"
+	if err := p.Render(&strings.Builder{}, Ticket{Type: "metro"}, CurrencyKZT); err == nil {
+		t.Fatal("broken layout rendered")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// qrPrefix versions the ticket QR payload format.
+const qrPrefix = "TK1"
+
+// TicketClaims are the ticket fields carried in the QR payload.
+type TicketClaims struct {
+	ID        string `json:"id"`
+	Type      string `json:"typ"`
+	Kind      string `json:"knd"`
+	ValidFrom int64  `json:"nbf"`
+	Expires   int64  `json:"exp"`
+	MachineID string `json:"mid"`
+	StationID string `json:"stn,omitempty"`
+	KeyID     string `json:"kid"`
+	Journey   string `json:"jrn,omitempty"`
+	Legs      int    `json:"leg,omitempty"`
+	// Transfer is the transfer window in seconds.
+	Transfer int64  `json:"xfr,omitempty"`
+	Coach    string `json:"cch,omitempty"`
+	Seat     string `json:"st,omitempty"`
+	Bundle   string `json:"bdl,omitempty"`
+	Persons  int    `json:"pax,omitempty"`
+}
+
+// QRRenderer turns a payload into something a printer or screen can show,
+// e.g. a PNG or an ESC/POS raster. It is plugged in by the integrator.
+type QRRenderer interface {
+	Render(payload string) ([]byte, error)
+}
+
+// PlainQRRenderer "renders" the payload as its text, for printers that
+// draw QR codes themselves.
+type PlainQRRenderer struct{}
+
+func (PlainQRRenderer) Render(payload string) ([]byte, error) { return []byte(payload), nil }
+
+// EncodeTicketQR builds the payload "TK1.<claims>.<signature>", both parts
+// base64url encoded, with the signature taken over the first two.
+func EncodeTicketQR(t Ticket, signer TicketSigner) (string, error) {
+	claims, err := json.Marshal(TicketClaims{
+		ID:        t.ID,
+		Type:      t.Type,
+		Kind:      string(t.Kind),
+		ValidFrom: t.ValidFrom.Unix(),
+		Expires:   t.ValidUntil.Unix(),
+		MachineID: t.MachineID,
+		StationID: t.StationID,
+		KeyID:     signer.KeyID(),
+		Journey:   string(t.Journey),
+		Legs:      t.Legs,
+		Transfer:  int64(t.TransferWindow / time.Second),
+		Coach:     t.Coach,
+		Seat:      t.Seat,
+		Bundle:    t.Bundle,
+		Persons:   t.Persons,
+	})
+	if err != nil {
+		return "", err
+	}
+	body := qrPrefix + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sig, err := signer.Sign([]byte(body))
+	if err != nil {
+		return "", err
+	}
+	return body + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// DecodeTicketQR parses a payload without checking its signature.
+func DecodeTicketQR(payload string) (TicketClaims, error) {
+	_, c, _, err := splitTicketQR(payload)
+	return c, err
+}
+
+func splitTicketQR(payload string) (body string, c TicketClaims, sig []byte, err error) {
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 || parts[0] != qrPrefix {
+		return "", c, nil, ErrMalformedTicket
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", c, nil, ErrMalformedTicket
+	}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return "", c, nil, ErrMalformedTicket
+	}
+	if sig, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return "", c, nil, ErrMalformedTicket
+	}
+	return parts[0] + "." + parts[1], c, sig, nil
+}
+
+// attachQR adds the QR payload and rendering to a freshly issued ticket.
+func (m *TicketMachine) attachQR(t *Ticket) error {
+	payload, err := EncodeTicketQR(*t, m.TicketSigner)
+	if err != nil {
+		return err
+	}
+	t.QRPayload = payload
+	if m.QRRenderer != nil {
+		if t.QRImage, err = m.QRRenderer.Render(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTicketQRPayload(t *testing.T) {
+	m, _ := newTestMachine(t)
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.InsertMoney(KZT(200)))
+	must(t, m.InsertMoney(KZT(100)))
+	d, err := m.DispenseTicket()
+	must(t, err)
+	tk := d.Tickets[0]
+	if tk.QRPayload == "" || string(tk.QRImage) != tk.QRPayload {
+		t.Fatalf("payload %q, image %q", tk.QRPayload, tk.QRImage)
+	}
+	c, err := DecodeTicketQR(tk.QRPayload)
+	must(t, err)
+	if c.ID != tk.ID || c.Type != "metro" || c.MachineID != m.MachineID || c.KeyID != m.TicketSigner.KeyID() {
+		t.Fatalf("claims = %+v", c)
+	}
+	if c.ValidFrom != tk.ValidFrom.Unix() || c.Expires != tk.ValidUntil.Unix() {
+		t.Fatalf("claims window %d-%d, ticket %s-%s", c.ValidFrom, c.Expires, tk.ValidFrom, tk.ValidUntil)
+	}
+}
+
+func TestDecodeMalformedTicketQR(t *testing.T) {
+	for _, payload := range []string{
+		"",
+		"TK1.e30",
+		"TK2.e30.AA",
+		"TK1.!!!.AA",
+		"TK1.bm90IGpzb24.AA",
+		"TK1.e30.!!!",
+	} {
+		if _, err := DecodeTicketQR(payload); !errors.Is(err, ErrMalformedTicket) {
+			t.Errorf("DecodeTicketQR(%q) = %v, want ErrMalformedTicket", payload, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+// awaitingCustomer reports whether the machine is waiting on the customer
+// to pay, which is when an inactivity timeout applies.
+func (m *TicketMachine) awaitingCustomer() bool {
+	switch m.State.(type) {
+	case *WaitingForMoneyState, *CardDeclinedState, *QRPaymentPendingState,
+		*CardPresentedState, *TopUpAmountSelectedState, *CartState,
+		*SelectDestinationState, *SelectJourneyState, *SelectSeatState, *PrintErrorState:
+		return true
+	}
+	return false
+}
+
+// Tick checks the inactivity timeout against the machine clock. The host
+// loop calls it periodically; when the customer has been idle for longer
+// than Timeout, lengthened in accessibility mode, the transaction is
+// canceled, inserted cash is returned and the machine goes back to its
+// ready state. Tick also runs scheduled backups and refreshes the
+// dashboard and health reports.
+func (m *TicketMachine) Tick() {
+	m.backupIfDue()
+	m.flushEvents()
+	m.dashboard.refresh(m)
+	m.health.refresh(m)
+	if m.Timeout <= 0 || !m.awaitingCustomer() {
+		return
+	}
+	if m.Clock.Now().Sub(m.LastActivity) < m.timeout() {
+		return
+	}
+	if m.logAction(JournalEntry{Action: "timeout"}) != nil {
+		return
+	}
+	m.timeOut()
+}
+
+// timeOut cancels the transaction of a customer who walked away.
+func (m *TicketMachine) timeOut() {
+	m.show("Transaction timed out.")
+	txID, paid := m.TransactionID, m.PaidTotal()
+	if err := m.State.Cancel(m); err != nil {
+		m.show("Timeout cancel failed: %v", err)
+		return
+	}
+	m.emit(TransactionCanceled{TransactionID: txID, Paid: paid, TimedOut: true})
+	m.startSale()
+	m.SetState(m.readyState())
+}
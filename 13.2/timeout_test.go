@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInactivityTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		accessible bool
+		idle       time.Duration
+		timedOut   bool
+	}{
+		{"still within the timeout", false, 50 * time.Second, false},
+		{"walked away", false, 61 * time.Second, true},
+		{"accessibility mode waits longer", true, 2 * time.Minute, false},
+		{"accessibility mode times out too", true, 181 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, clock := newTestMachine(t)
+			m.Timeout = time.Minute
+			m.Accessible = tt.accessible
+			var canceled []TransactionCanceled
+			m.Subscribe(func(e MachineEvent) {
+				if ev, ok := e.(TransactionCanceled); ok {
+					canceled = append(canceled, ev)
+				}
+			})
+			must(t, m.SelectTicket("metro", 1))
+			must(t, m.InsertMoney(KZT(100)))
+			clock.Advance(tt.idle)
+			m.Tick()
+			if !tt.timedOut {
+				if m.GetCurrentState() != (&WaitingForMoneyState{}).Name() || m.InsertedMoney != KZT(100) || len(canceled) != 0 {
+					t.Fatalf("state %s, inserted %s, canceled %v", m.GetCurrentState(), m.InsertedMoney, canceled)
+				}
+				return
+			}
+			if m.GetCurrentState() != (&IdleState{}).Name() || m.InsertedMoney != 0 {
+				t.Fatalf("state %s, inserted %s after timeout", m.GetCurrentState(), m.InsertedMoney)
+			}
+			if len(canceled) != 1 || !canceled[0].TimedOut || canceled[0].Paid != KZT(100) {
+				t.Fatalf("canceled events = %+v", canceled)
+			}
+			if m.CashBox.Total() != 0 {
+				t.Fatalf("cash box kept %s of a timed-out sale", m.CashBox.Total())
+			}
+		})
+	}
+}
+
+func TestActivityRestartsTimeout(t *testing.T) {
+	m, clock := newTestMachine(t)
+	m.Timeout = time.Minute
+	must(t, m.SelectTicket("metro", 1))
+	clock.Advance(50 * time.Second)
+	must(t, m.InsertMoney(KZT(100)))
+	clock.Advance(50 * time.Second)
+	m.Tick()
+	if m.GetCurrentState() != (&WaitingForMoneyState{}).Name() {
+		t.Fatalf("state = %s, want WaitingForMoney", m.GetCurrentState())
+	}
+}
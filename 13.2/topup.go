@@ -0,0 +1,209 @@
+package main
+
+import "fmt"
+
+// TransitCard is a stored-value travel card as read from the reader.
+type TransitCard struct {
+	ID      string
+	Balance Money
+}
+
+// TransitCardReader reads the transit card held to the reader.
+type TransitCardReader interface {
+	ReadTransitCard() (TransitCard, error)
+}
+
+// TransitCardWriter stores a new balance on a transit card.
+type TransitCardWriter interface {
+	WriteBalance(cardID string, balance Money) error
+}
+
+// TransitCardDevice is a reader/writer for stored-value cards.
+type TransitCardDevice interface {
+	TransitCardReader
+	TransitCardWriter
+}
+
+// WithTransitCards connects the transit card reader/writer, which enables
+// balance checks, top-ups and fare capping by card. Without it the
+// machine sells tickets only.
+func WithTransitCards(d TransitCardDevice) Option {
+	return func(o *machineOptions) { o.transitCards = d }
+}
+
+// MockTransitCards keeps card balances in memory. Presented is the ID of
+// the card currently held to the reader; empty means no card.
+type MockTransitCards struct {
+	Balances  map[string]Money
+	Presented string
+	WriteErr  error
+}
+
+func (d *MockTransitCards) ReadTransitCard() (TransitCard, error) {
+	if d.Presented == "" {
+		return TransitCard{}, newError(CodeCardRead, "no card presented")
+	}
+	return TransitCard{ID: d.Presented, Balance: d.Balances[d.Presented]}, nil
+}
+
+func (d *MockTransitCards) WriteBalance(cardID string, balance Money) error {
+	if d.WriteErr != nil {
+		return d.WriteErr
+	}
+	d.Balances[cardID] = balance
+	return nil
+}
+
+// TopUp is a transit card top-up in progress.
+type TopUp struct {
+	Card   TransitCard
+	Amount Money
+}
+
+// readTransitCard reads the presented card; it is only allowed from the
+// ready state, between transactions.
+func (m *TicketMachine) readTransitCard() (TransitCard, error) {
+	if err := m.inService(); err != nil {
+		return TransitCard{}, err
+	}
+	switch m.State.(type) {
+	case *IdleState, *CashBoxFullState:
+	default:
+		return TransitCard{}, newError(CodeBusy, "transaction in progress")
+	}
+	if m.TransitCards == nil {
+		return TransitCard{}, newError(CodePaymentUnavailable, "transit cards not supported")
+	}
+	var card TransitCard
+	err := m.deviceCall("transit_card.read", &card, func() (err error) {
+		card, err = m.TransitCards.ReadTransitCard()
+		return err
+	})
+	if err != nil {
+		return TransitCard{}, newErrorf(CodeCardRead, "cannot read transit card: %w", err)
+	}
+	return card, nil
+}
+
+// CheckBalance shows the balance of the presented transit card without
+// starting a transaction; the machine stays in its ready state.
+func (m *TicketMachine) CheckBalance() (Money, error) {
+	card, err := m.readTransitCard()
+	if err != nil {
+		return 0, err
+	}
+	m.show("Card %s balance: %s", card.ID, card.Balance.In(m.Currency))
+	return card.Balance, nil
+}
+
+// PresentTransitCard starts a top-up by reading the card on the reader.
+func (m *TicketMachine) PresentTransitCard() error {
+	if err := m.logAction(JournalEntry{Action: "transit_card"}); err != nil {
+		return err
+	}
+	card, err := m.readTransitCard()
+	if err != nil {
+		return err
+	}
+	m.SetState(&CardPresentedState{Card: card})
+	m.show("Card %s balance: %s", card.ID, card.Balance.In(m.Currency))
+	return nil
+}
+
+// SelectTopUp chooses how much to load onto the presented card.
+func (m *TicketMachine) SelectTopUp(amount Money) error {
+	if err := m.logAction(JournalEntry{Action: "top_up", Amount: amount}); err != nil {
+		return err
+	}
+	s, ok := m.State.(*CardPresentedState)
+	if !ok {
+		return newError(CodeStepRequired, "please present a transit card first")
+	}
+	valid := false
+	for _, a := range m.TopUpAmounts {
+		valid = valid || a == amount
+	}
+	if !valid {
+		return newError(CodeNotOffered, "top-up amount not offered")
+	}
+	m.TransactionID = newTransactionID()
+	m.TopUp = &TopUp{Card: s.Card, Amount: amount}
+	m.CurrentPrice = amount
+	m.CurrentTax = Breakdown(amount, m.VATRate(TopUpProduct))
+	m.SessionTally = map[Money]int{}
+	m.SessionForeign = nil
+	m.SetState(&TopUpAmountSelectedState{})
+	m.show("Top-up selected: %s", amount.In(m.Currency))
+	return nil
+}
+
+// completeTopUp writes the new balance and only then settles the payment,
+// so a failed write leaves the customer free to cancel for a refund. When
+// the payment then fails the old balance is written back; if that fails
+// too an attendant is called, as the card holds value that was not paid.
+func (m *TicketMachine) completeTopUp() (Dispensed, error) {
+	if err := m.checkChange(); err != nil {
+		return Dispensed{}, err
+	}
+	t := m.TopUp
+	balance := t.Card.Balance + t.Amount
+	if err := m.deviceCall("transit_card.write", nil, func() error { return m.TransitCards.WriteBalance(t.Card.ID, balance) }); err != nil {
+		return Dispensed{}, newErrorf(CodeCardWrite, "cannot write transit card: %w", err)
+	}
+	change, err := m.settle()
+	if err != nil {
+		if rerr := m.deviceCall("transit_card.write", nil, func() error {
+			return m.TransitCards.WriteBalance(t.Card.ID, t.Card.Balance)
+		}); rerr != nil {
+			m.audit("", "topup_reversal_failed", fmt.Sprintf("card %s", t.Card.ID))
+			m.notify(Alert{Kind: AlertAttendant, Severity: SeverityCritical,
+				Detail: fmt.Sprintf("card %s topped up by %s without payment", t.Card.ID, t.Amount.In(m.Currency))})
+		}
+		return Dispensed{}, err
+	}
+	m.fiscalize()
+	m.TopUp = nil
+	m.show("Card %s topped up. New balance: %s", t.Card.ID, balance.In(m.Currency))
+	d := Dispensed{Change: change}
+	m.finish(&d)
+	return d, nil
+}
+
+// CardPresentedState holds a read transit card while the customer picks a
+// top-up amount.
+type CardPresentedState struct {
+	Card TransitCard
+}
+
+func (s *CardPresentedState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeStepRequired, "please choose a top-up amount")
+}
+func (s *CardPresentedState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeStepRequired, "please choose a top-up amount first")
+}
+func (s *CardPresentedState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeStepRequired, "please choose a top-up amount first")
+}
+func (s *CardPresentedState) Cancel(m *TicketMachine) error {
+	m.SetState(&TransactionCanceledState{})
+	return nil
+}
+func (s *CardPresentedState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeNoTransaction, "nothing to dispense")
+}
+func (s *CardPresentedState) Name() string { return "CardPresented" }
+
+// TopUpAmountSelectedState takes payment for a top-up exactly like
+// WaitingForMoneyState does for a ticket.
+type TopUpAmountSelectedState struct {
+	WaitingForMoneyState
+}
+
+func (s *TopUpAmountSelectedState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeBusy, "top-up in progress")
+}
+func (s *TopUpAmountSelectedState) Cancel(m *TicketMachine) error {
+	m.TopUp = nil
+	return s.WaitingForMoneyState.Cancel(m)
+}
+func (s *TopUpAmountSelectedState) Name() string { return "TopUpAmountSelected" }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTopUpCaptureFailureRestoresBalance(t *testing.T) {
+	m, _ := newTestMachine(t)
+	cards := &MockTransitCards{Balances: map[string]Money{"ONAY-1": KZT(150)}, Presented: "ONAY-1"}
+	m.TransitCards = cards
+	m.Gateway = &captureFailGateway{}
+	must(t, m.PresentTransitCard())
+	must(t, m.SelectTopUp(KZT(1000)))
+	must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}))
+	if _, err := m.DispenseTicket(); err == nil {
+		t.Fatal("top-up completed without payment")
+	}
+	if b := cards.Balances["ONAY-1"]; b != KZT(150) {
+		t.Errorf("balance %s after failed payment, want 150.00", b)
+	}
+}
+
+// captureFailGateway approves authorizations and fails every capture.
+type captureFailGateway struct{ MockGateway }
+
+func (*captureFailGateway) Capture(key string, auth Authorization) error {
+	return errors.New("acquirer unavailable")
+}
+
+func TestCheckBalance(t *testing.T) {
+	tests := []struct {
+		name    string
+		cards   *MockTransitCards
+		selling bool
+		balance Money
+		code    ErrorCode
+	}{
+		{"presented card", &MockTransitCards{Balances: map[string]Money{"ONAY-1": KZT(150)}, Presented: "ONAY-1"}, false, KZT(150), ""},
+		{"no card on the reader", &MockTransitCards{}, false, 0, CodeCardRead},
+		{"reader not fitted", nil, false, 0, CodePaymentUnavailable},
+		{"sale in progress", &MockTransitCards{Balances: map[string]Money{"ONAY-1": KZT(150)}, Presented: "ONAY-1"}, true, 0, CodeBusy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			if tt.cards != nil {
+				m.TransitCards = tt.cards
+			}
+			want := (&IdleState{}).Name()
+			if tt.selling {
+				must(t, m.SelectTicket("metro", 1))
+				want = m.GetCurrentState()
+			}
+			balance, err := m.CheckBalance()
+			if CodeOf(err) != tt.code || balance != tt.balance {
+				t.Fatalf("CheckBalance = %s, %v; want %s, %q", balance, err, tt.balance, tt.code)
+			}
+			if m.GetCurrentState() != want {
+				t.Fatalf("state = %s, want %s", m.GetCurrentState(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// TracerProvider hands out tracers, as an OpenTelemetry TracerProvider
+// does; an adapter over the OpenTelemetry SDK plugs in here.
+type TracerProvider interface {
+	Tracer(name string) Tracer
+}
+
+// Tracer starts spans; the parent span is carried in ctx.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is one timed operation of a trace.
+type Span interface {
+	SetAttributes(attrs ...slog.Attr)
+	RecordError(err error)
+	End()
+}
+
+// WithTracerProvider traces every transaction through tp: a span per
+// transaction, with a child span per state and, below it, one per call
+// to the payment gateway, QR provider, ticket printer and fiscal device.
+func WithTracerProvider(tp TracerProvider) Option {
+	return func(o *machineOptions) { o.tracing = tp }
+}
+
+// trace is the spans of the transaction under way.
+type trace struct {
+	txID     string
+	tx       Span
+	state    Span
+	stateCtx context.Context
+	txCtx    context.Context
+}
+
+// traceState ends the span of the state left and starts one for the state
+// entered; the transaction span starts with the first state of a sale and
+// ends when the machine leaves the sale.
+func (m *TicketMachine) traceState(to State) {
+	if m.Tracing == nil {
+		return
+	}
+	t := m.trace
+	if t != nil {
+		t.state.End()
+		if !inSale(to) || t.txID != m.TransactionID {
+			t.tx.End()
+			m.trace, t = nil, nil
+		}
+	}
+	if !inSale(to) {
+		return
+	}
+	if t == nil {
+		ctx, span := m.Tracing.Tracer("ticketmachine").Start(context.Background(), "transaction")
+		span.SetAttributes(slog.String("machine_id", m.MachineID), slog.String("transaction_id", m.TransactionID))
+		t = &trace{txID: m.TransactionID, tx: span, txCtx: ctx}
+		m.trace = t
+	}
+	t.stateCtx, t.state = m.Tracing.Tracer("ticketmachine").Start(t.txCtx, "state "+to.Name())
+}
+
+// traceCall runs an external call in a span below the current state.
+func (m *TicketMachine) traceCall(name string, call func() error, attrs ...slog.Attr) error {
+	if m.Tracing == nil {
+		return call()
+	}
+	ctx := context.Background()
+	if m.trace != nil {
+		ctx = m.trace.stateCtx
+	}
+	_, span := m.Tracing.Tracer("ticketmachine").Start(ctx, name)
+	span.SetAttributes(attrs...)
+	err := call()
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+	return err
+}
+
+// MemoryTracer records finished spans, for tests and demos.
+type MemoryTracer struct {
+	Clock Clock
+	Spans []*MemorySpan
+}
+
+// MemorySpan is a span recorded by MemoryTracer.
+type MemorySpan struct {
+	Name   string
+	Parent *MemorySpan
+	Start  time.Time
+	Finish time.Time
+	Attrs  []slog.Attr
+	Err    error
+	t      *MemoryTracer
+}
+
+type spanKey struct{}
+
+func (t *MemoryTracer) Tracer(name string) Tracer { return t }
+
+func (t *MemoryTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(*MemorySpan)
+	s := &MemorySpan{Name: name, Parent: parent, Start: t.now(), t: t}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (t *MemoryTracer) now() time.Time {
+	if t.Clock == nil {
+		return time.Now()
+	}
+	return t.Clock.Now()
+}
+
+func (s *MemorySpan) SetAttributes(attrs ...slog.Attr) { s.Attrs = append(s.Attrs, attrs...) }
+
+func (s *MemorySpan) RecordError(err error) { s.Err = err }
+
+func (s *MemorySpan) End() {
+	s.Finish = s.t.now()
+	s.t.Spans = append(s.t.Spans, s)
+}
+
+// Depth is the number of ancestors of the span.
+func (s *MemorySpan) Depth() int {
+	n := 0
+	for p := s.Parent; p != nil; p = p.Parent {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTraceTransaction(t *testing.T) {
+	m, clock := newTestMachine(t)
+	tracer := &MemoryTracer{Clock: clock}
+	m.Tracing = tracer
+	m.Gateway = &MockGateway{Decline: map[string]string{"tok_bad": "insufficient funds"}}
+	must(t, m.SelectTicket("metro", 1))
+	tx := m.TransactionID
+	if err := m.PayByCard(CardDetails{Token: "tok_bad", MaskedPAN: "**** 0002"}); CodeOf(err) != CodeCardDeclined {
+		t.Fatalf("PayByCard(tok_bad) = %v, want %s", err, CodeCardDeclined)
+	}
+	must(t, m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}))
+	_, err := m.DispenseTicket()
+	must(t, err)
+	must(t, m.StartOver())
+	sellMetro(t, m)
+	must(t, m.StartOver())
+
+	var roots, authorized, captured []*MemorySpan
+	states := 0
+	for _, s := range tracer.Spans {
+		switch {
+		case s.Name == "transaction":
+			roots = append(roots, s)
+		case strings.HasPrefix(s.Name, "state "):
+			states++
+			if s.Depth() != 1 || s.Parent.Name != "transaction" {
+				t.Errorf("%s below %v", s.Name, s.Parent)
+			}
+		case s.Name == "payment.authorize":
+			authorized = append(authorized, s)
+		case s.Name == "payment.capture":
+			captured = append(captured, s)
+		}
+	}
+	if len(roots) != 2 || states == 0 {
+		t.Fatalf("%d transaction spans and %d state spans, want 2 and some", len(roots), states)
+	}
+	var txAttr string
+	for _, a := range roots[0].Attrs {
+		if a.Key == "transaction_id" {
+			txAttr = a.Value.String()
+		}
+	}
+	if txAttr != tx {
+		t.Errorf("first transaction span is for %q, want %q", txAttr, tx)
+	}
+	if len(authorized) != 2 || len(captured) != 1 {
+		t.Fatalf("%d authorize and %d capture spans, want 2 and 1", len(authorized), len(captured))
+	}
+	for _, s := range append(authorized, captured...) {
+		if s.Depth() != 2 || s.Parent.Parent != roots[0] {
+			t.Errorf("%s is not below a state of the first transaction", s.Name)
+		}
+	}
+}
+
+func TestTraceRecordsCallErrors(t *testing.T) {
+	m, clock := newTestMachine(t)
+	tracer := &MemoryTracer{Clock: clock}
+	m.Tracing = tracer
+	m.Gateway = &MockGateway{Fail: errors.New("acquirer timeout")}
+	must(t, m.SelectTicket("metro", 1))
+	if err := m.PayByCard(CardDetails{Token: "tok_visa", MaskedPAN: "**** 4242"}); err == nil {
+		t.Fatal("paid through a failing gateway")
+	}
+	for _, s := range tracer.Spans {
+		if s.Name == "payment.authorize" {
+			if s.Err == nil || !strings.Contains(s.Err.Error(), "acquirer timeout") {
+				t.Fatalf("authorize span error = %v", s.Err)
+			}
+			return
+		}
+	}
+	t.Fatal("no authorize span")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Product names recorded for sales that are not a single ticket type.
+const (
+	TopUpProduct = "topup"
+	MixedProduct = "mixed"
+)
+
+// TransactionRecord is the permanent record of a completed sale.
+type TransactionRecord struct {
+	ID        string
+	Time      time.Time
+	MachineID string
+	StationID string
+	Product   string
+	Quantity  int
+	Price     Money
+	Lines     []CartLine
+	Category  FareCategory
+	// PromoCode and PromoDiscount record an applied promo code.
+	PromoCode     string
+	PromoDiscount Money
+	// RiderID and CapDiscount record fare capping for a linked rider.
+	RiderID     string
+	CapDiscount Money
+	Tax         TaxBreakdown
+	Tenders     map[Tender]Money
+	Change      Money
+	Donation    Money
+	// FiscalNumber is set once the fiscal device has registered the sale.
+	FiscalNumber string
+	// Card is the captured card authorization of a card sale.
+	Card *Authorization
+	// QR is the QR payment of a sale paid by QR.
+	QR      *QRPayment
+	Tickets []Ticket
+	// Delivery is set when the rider asked for e-tickets.
+	Delivery       *Delivery
+	ReceiptPrinted bool
+}
+
+// newRecord snapshots the current transaction before it is settled.
+func (m *TicketMachine) newRecord() TransactionRecord {
+	product, qty := TopUpProduct, 1
+	if m.TopUp == nil {
+		product, qty = MixedProduct, 0
+		for _, l := range m.Cart {
+			qty += l.Qty
+		}
+		if len(m.Cart) == 1 {
+			product = m.Cart[0].TicketType
+		}
+	}
+	var code string
+	if m.Promo != nil {
+		code = m.Promo.Code
+	}
+	var card *Authorization
+	if m.CardAuth != nil {
+		a := *m.CardAuth
+		card = &a
+	}
+	var qr *QRPayment
+	if m.QRPaid != nil {
+		p := *m.QRPaid
+		qr = &p
+	}
+	return TransactionRecord{
+		ID:            m.TransactionID,
+		Time:          m.Clock.Now(),
+		MachineID:     m.MachineID,
+		StationID:     m.Location.StationID,
+		Product:       product,
+		Quantity:      qty,
+		Price:         m.CurrentPrice,
+		Lines:         append([]CartLine(nil), m.Cart...),
+		Category:      m.FareCategory,
+		PromoCode:     code,
+		PromoDiscount: m.PromoDiscount,
+		RiderID:       m.RiderID,
+		CapDiscount:   m.CapDiscount,
+		Tax:           m.CurrentTax,
+		Tenders:       m.Tenders(),
+		Card:          card,
+		QR:            qr,
+	}
+}
+
+// maxDispensedHistory bounds how many finished transactions are remembered
+// for idempotent dispense retries.
+const maxDispensedHistory = 256
+
+func newTransactionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "TX-" + hex.EncodeToString(b)
+}
+
+// recordDispensed remembers the outcome of the current transaction so a
+// retried DispenseTransaction returns it instead of dispensing again.
+func (m *TicketMachine) recordDispensed(d Dispensed) {
+	if m.dispensed == nil {
+		m.dispensed = map[string]Dispensed{}
+	}
+	m.dispensed[m.TransactionID] = d
+	m.dispensedOrder = append(m.dispensedOrder, m.TransactionID)
+	if len(m.dispensedOrder) > maxDispensedHistory {
+		delete(m.dispensed, m.dispensedOrder[0])
+		m.dispensedOrder = m.dispensedOrder[1:]
+	}
+}
+
+// DispenseTransaction is an idempotent DispenseTicket: it dispenses only if
+// txID is the current transaction, and for a transaction that was already
+// dispensed it returns the original result without touching inventory.
+func (m *TicketMachine) DispenseTransaction(txID string) (Dispensed, error) {
+	if c, ok := m.dispensed[txID]; ok {
+		return c, nil
+	}
+	if txID == "" || txID != m.TransactionID {
+		return Dispensed{}, newError(CodeNoTransaction, "unknown transaction")
+	}
+	return m.DispenseTicket()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDispenseTransaction(t *testing.T) {
+	m, _ := newTestMachine(t)
+	var journal bytes.Buffer
+	m.Journal = &Journal{W: &journal}
+	dispensed := 0
+	m.Subscribe(func(e MachineEvent) {
+		if _, ok := e.(TicketDispensed); ok {
+			dispensed++
+		}
+	})
+	must(t, m.SelectTicket("metro", 1))
+	must(t, m.InsertMoney(KZT(200)))
+	must(t, m.InsertMoney(KZT(100)))
+	if _, err := m.DispenseTransaction("TX-OTHER"); CodeOf(err) != CodeNoTransaction {
+		t.Fatalf("other transaction: %v, want %s", err, CodeNoTransaction)
+	}
+	txID := m.TransactionID
+	first, err := m.DispenseTransaction(txID)
+	must(t, err)
+	again, err := m.DispenseTransaction(txID)
+	must(t, err)
+	if len(again.Tickets) != 1 || again.Tickets[0].ID != first.Tickets[0].ID {
+		t.Errorf("retry returned %+v, want %+v", again.Tickets, first.Tickets)
+	}
+	if dispensed != 1 {
+		t.Errorf("%d TicketDispensed events, want 1", dispensed)
+	}
+	if n := bytes.Count(journal.Bytes(), []byte(`"action":"dispense"`)); n != 1 {
+		t.Errorf("%d dispense journal entries, want 1", n)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import "fmt"
+
+// CashValidator inspects an inserted coin or note before it is credited,
+// e.g. to reject counterfeits or damaged notes.
+type CashValidator interface {
+	Validate(amount Money) error
+}
+
+// CashValidatorFunc adapts a function to the CashValidator interface.
+type CashValidatorFunc func(amount Money) error
+
+func (f CashValidatorFunc) Validate(amount Money) error { return f(amount) }
+
+// CashRejectedError is returned by InsertMoney when the validator refuses
+// the inserted money. The money is returned and not credited.
+type CashRejectedError struct {
+	Amount Money
+	Reason string
+}
+
+func (e *CashRejectedError) ErrorCode() ErrorCode { return CodeCashRejected }
+
+func (e *CashRejectedError) Error() string {
+	return fmt.Sprintf("%s rejected: %s", e.Amount, e.Reason)
+}
+
+// CashStats counts validated and rejected insertions.
+type CashStats struct {
+	Accepted int
+	Rejected map[string]int
+}
+
+// RejectionRate is the share of insertions the validator refused.
+func (s CashStats) RejectionRate() float64 {
+	rejected := 0
+	for _, n := range s.Rejected {
+		rejected += n
+	}
+	if total := s.Accepted + rejected; total > 0 {
+		return float64(rejected) / float64(total)
+	}
+	return 0
+}
+
+// validateCash runs the validator and records the outcome.
+func (m *TicketMachine) validateCash(amount Money) error {
+	if m.Validator == nil {
+		return nil
+	}
+	err := m.Validator.Validate(amount)
+	if err == nil {
+		return nil
+	}
+	rej, ok := err.(*CashRejectedError)
+	if !ok {
+		rej = &CashRejectedError{Amount: amount, Reason: err.Error()}
+	}
+	if m.CashStats.Rejected == nil {
+		m.CashStats.Rejected = map[string]int{}
+	}
+	m.CashStats.Rejected[rej.Reason]++
+	m.show("Money rejected: %s (%s)", amount.In(m.Currency), rej.Reason)
+	if m.OnCashRejected != nil {
+		m.OnCashRejected(*rej)
+	}
+	return rej
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCashValidator(t *testing.T) {
+	counterfeit := CashValidatorFunc(func(amount Money) error {
+		switch amount {
+		case KZT(200):
+			return &CashRejectedError{Amount: amount, Reason: "counterfeit"}
+		case KZT(50):
+			return errors.New("worn")
+		}
+		return nil
+	})
+	tests := []struct {
+		name     string
+		amount   Money
+		reason   string
+		accepted int
+	}{
+		{"genuine coin", KZT(100), "", 1},
+		{"counterfeit", KZT(200), "counterfeit", 0},
+		{"plain error becomes a rejection", KZT(50), "worn", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			m.Validator = counterfeit
+			var rejected []CashRejectedError
+			m.OnCashRejected = func(r CashRejectedError) { rejected = append(rejected, r) }
+			must(t, m.SelectTicket("metro", 1))
+			err := m.InsertMoney(tt.amount)
+			if m.CashStats.Accepted != tt.accepted {
+				t.Fatalf("accepted = %d, want %d", m.CashStats.Accepted, tt.accepted)
+			}
+			if tt.reason == "" {
+				must(t, err)
+				if m.InsertedMoney != tt.amount || len(rejected) != 0 {
+					t.Fatalf("inserted %s, rejected %v", m.InsertedMoney, rejected)
+				}
+				return
+			}
+			if CodeOf(err) != CodeCashRejected || m.InsertedMoney != 0 {
+				t.Fatalf("err = %v, inserted %s", err, m.InsertedMoney)
+			}
+			if len(rejected) != 1 || rejected[0].Reason != tt.reason || m.CashStats.Rejected[tt.reason] != 1 {
+				t.Fatalf("rejected %v, stats %v", rejected, m.CashStats.Rejected)
+			}
+		})
+	}
+}
+
+func TestRejectionRate(t *testing.T) {
+	s := CashStats{Accepted: 3, Rejected: map[string]int{"counterfeit": 1}}
+	if got := s.RejectionRate(); got != 0.25 {
+		t.Fatalf("rate = %v, want 0.25", got)
+	}
+	if got := (CashStats{}).RejectionRate(); got != 0 {
+		t.Fatalf("rate with no insertions = %v", got)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Webhook event types.
+const (
+	WebhookSaleCompleted = "sale_completed"
+	WebhookSoldOut       = "sold_out"
+	WebhookFault         = "fault"
+)
+
+// WebhookEndpoint is a back-office URL that receives webhooks. Events
+// limits it to some event types, all when empty.
+type WebhookEndpoint struct {
+	URL    string
+	Secret []byte
+	Events []string
+}
+
+func (e WebhookEndpoint) wants(event string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, ev := range e.Events {
+		if ev == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookPayload is the JSON body of a webhook. ID stays the same across
+// retries, so receivers can drop duplicates.
+type WebhookPayload struct {
+	ID            string          `json:"id"`
+	Event         string          `json:"event"`
+	Time          time.Time       `json:"time"`
+	MachineID     string          `json:"machine_id"`
+	StationID     string          `json:"station_id,omitempty"`
+	TransactionID string          `json:"transaction_id,omitempty"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// WebhookSale is the data of a sale_completed webhook; money is in minor
+// units.
+type WebhookSale struct {
+	Currency Currency            `json:"currency"`
+	Total    Money               `json:"total"`
+	Change   Money               `json:"change"`
+	Tickets  []WebhookSaleTicket `json:"tickets"`
+}
+
+// WebhookSaleTicket is a ticket of a sale_completed webhook.
+type WebhookSaleTicket struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Price Money  `json:"price"`
+}
+
+// WebhookDeadLetter is a webhook that could not be delivered, as written
+// to the dead-letter log.
+type WebhookDeadLetter struct {
+	URL      string         `json:"url"`
+	Attempts int            `json:"attempts"`
+	Error    string         `json:"error"`
+	Payload  WebhookPayload `json:"payload"`
+}
+
+// WebhookDispatcher POSTs sale completed, sold out and fault events to
+// the Endpoints. Each body is signed with the endpoint secret: the
+// X-Webhook-Signature header is "sha256=" and the hex HMAC-SHA256 of the
+// X-Webhook-Timestamp header, a dot and the body. Deliveries run in the
+// background, one queue and goroutine per endpoint, so the customer never
+// waits for the back office and a hung endpoint holds up only its own
+// deliveries; a failed delivery is retried Attempts times in all, Backoff
+// apart and doubling, and then written as a WebhookDeadLetter line to
+// DeadLetters.
+type WebhookDispatcher struct {
+	Endpoints []WebhookEndpoint
+	// Client is a client with a 10s timeout when nil.
+	Client   *http.Client
+	Attempts int
+	Backoff  time.Duration
+	// Sleep waits between attempts, time.Sleep when nil.
+	Sleep       func(time.Duration)
+	DeadLetters io.Writer
+	// QueueSize is the number of deliveries that may wait for an
+	// endpoint; events past it are dead-lettered at once.
+	QueueSize int
+
+	logger  *slog.Logger
+	queues  []chan webhookDelivery
+	workers sync.WaitGroup
+	stopped sync.Once
+	dlMu    sync.Mutex
+}
+
+// webhookTimeout bounds one delivery attempt.
+const webhookTimeout = 10 * time.Second
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+type webhookDelivery struct {
+	endpoint WebhookEndpoint
+	payload  WebhookPayload
+	body     []byte
+}
+
+// EnableWebhooks starts delivering the machine's events through d; the
+// returned func stops taking events and waits for the queued deliveries;
+// calling it again does nothing.
+func (m *TicketMachine) EnableWebhooks(d *WebhookDispatcher) (stop func()) {
+	d.logger = m.Logger
+	if d.logger == nil {
+		d.logger = slog.Default()
+	}
+	d.logger = d.logger.With(slog.String("machine_id", m.MachineID))
+	size := d.QueueSize
+	if size <= 0 {
+		size = 64
+	}
+	d.queues = make([]chan webhookDelivery, len(d.Endpoints))
+	for i := range d.queues {
+		d.queues[i] = make(chan webhookDelivery, size)
+		d.workers.Add(1)
+		go d.run(d.queues[i])
+	}
+	unsubscribe := m.Subscribe(func(e MachineEvent) { m.webhook(d, e) })
+	return func() {
+		d.stopped.Do(func() {
+			unsubscribe()
+			for _, q := range d.queues {
+				close(q)
+			}
+		})
+		d.workers.Wait()
+	}
+}
+
+// webhook turns a machine event into a webhook payload and queues it for
+// every endpoint that wants it.
+func (m *TicketMachine) webhook(d *WebhookDispatcher, e MachineEvent) {
+	p := WebhookPayload{Time: m.Clock.Now(), MachineID: m.MachineID, StationID: m.Location.StationID}
+	var data interface{}
+	switch e := e.(type) {
+	case TicketDispensed:
+		sale := WebhookSale{Currency: m.Currency, Change: e.Change, Tickets: []WebhookSaleTicket{}}
+		for _, t := range e.Tickets {
+			sale.Total += t.PricePaid
+			sale.Tickets = append(sale.Tickets, WebhookSaleTicket{ID: t.ID, Type: t.Type, Price: t.PricePaid})
+		}
+		p.Event, p.TransactionID, data = WebhookSaleCompleted, e.TransactionID, sale
+	case ProductSoldOut:
+		p.Event, p.TransactionID = WebhookSoldOut, e.TransactionID
+		data = map[string]string{"ticket_type": e.TicketType}
+	case HardwareFaulted:
+		p.Event = WebhookFault
+		data = map[string]string{"component": e.Component, "detail": e.Detail}
+	default:
+		return
+	}
+	p.ID = newEventID()
+	p.Data, _ = json.Marshal(data)
+	body, _ := json.Marshal(p)
+	for i, ep := range d.Endpoints {
+		if !ep.wants(p.Event) {
+			continue
+		}
+		select {
+		case d.queues[i] <- webhookDelivery{ep, p, body}:
+		default:
+			d.deadLetter(webhookDelivery{ep, p, body}, 0, errors.New("webhook queue full"))
+		}
+	}
+}
+
+func (d *WebhookDispatcher) run(queue chan webhookDelivery) {
+	defer d.workers.Done()
+	for w := range queue {
+		d.deliver(w)
+	}
+}
+
+func (d *WebhookDispatcher) deliver(w webhookDelivery) {
+	attempts := d.Attempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	backoff := d.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	sleep := d.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	var err error
+	for n := 1; n <= attempts; n++ {
+		var retry bool
+		if retry, err = d.post(w); err == nil {
+			return
+		}
+		d.logger.Warn("webhook failed", slog.String("url", w.endpoint.URL), slog.String("id", w.payload.ID),
+			slog.Int("attempt", n), slog.String("error", err.Error()))
+		if !retry || n == attempts {
+			d.deadLetter(w, n, err)
+			return
+		}
+		sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends one attempt; retry tells whether a failure may pass.
+func (d *WebhookDispatcher) post(w webhookDelivery) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, w.endpoint.URL, bytes.NewReader(w.body))
+	if err != nil {
+		return false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", w.payload.Event)
+	req.Header.Set("X-Webhook-ID", w.payload.ID)
+	req.Header.Set("X-Webhook-Timestamp", ts)
+	req.Header.Set("X-Webhook-Signature", SignWebhook(w.endpoint.Secret, ts, w.body))
+	client := d.Client
+	if client == nil {
+		client = webhookClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook: %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook: %s", resp.Status)
+	}
+}
+
+func (d *WebhookDispatcher) deadLetter(w webhookDelivery, attempts int, err error) {
+	d.logger.Error("webhook dead-lettered", slog.String("url", w.endpoint.URL), slog.String("id", w.payload.ID),
+		slog.String("event", w.payload.Event), slog.String("error", err.Error()))
+	if d.DeadLetters == nil {
+		return
+	}
+	line, _ := json.Marshal(WebhookDeadLetter{URL: w.endpoint.URL, Attempts: attempts, Error: err.Error(), Payload: w.payload})
+	d.dlMu.Lock()
+	defer d.dlMu.Unlock()
+	d.DeadLetters.Write(append(line, '\n'))
+}
+
+// SignWebhook is the X-Webhook-Signature of a body sent at timestamp ts.
+func SignWebhook(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookTolerance is how far the X-Webhook-Timestamp of a received
+// webhook may be from the receiver's clock.
+const WebhookTolerance = 5 * time.Minute
+
+// VerifyWebhook checks the signature headers of a received webhook, for
+// receivers written in Go. A webhook signed more than WebhookTolerance
+// ago, or ahead, is refused, so a captured one cannot be replayed later.
+func VerifyWebhook(secret []byte, h http.Header, body []byte) bool {
+	return verifyWebhook(secret, h, body, time.Now())
+}
+
+func verifyWebhook(secret []byte, h http.Header, body []byte, now time.Time) bool {
+	ts := h.Get("X-Webhook-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > WebhookTolerance || age < -WebhookTolerance {
+		return false
+	}
+	want := SignWebhook(secret, ts, body)
+	return hmac.Equal([]byte(want), []byte(h.Get("X-Webhook-Signature")))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhookHungEndpointDoesNotStallOthers(t *testing.T) {
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	received := make(chan string, 4)
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Webhook-Event")
+	}))
+	defer live.Close()
+
+	m, _ := newTestMachine(t)
+	stop := m.EnableWebhooks(&WebhookDispatcher{
+		Endpoints: []WebhookEndpoint{{URL: hung.URL}, {URL: live.URL}},
+		Sleep:     func(time.Duration) {},
+	})
+	must(t, m.SelectTicket("bus", 1))
+	must(t, m.InsertMoney(KZT(500)))
+	if _, err := m.DispenseTicket(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-received:
+		if ev != WebhookSaleCompleted {
+			t.Errorf("got %s, want %s", ev, WebhookSaleCompleted)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("live endpoint waited for the hung one")
+	}
+	close(release)
+	stop()
+	stop()
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"id":"evt_1"}`)
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	signed := func(at time.Time) http.Header {
+		ts := strconv.FormatInt(at.Unix(), 10)
+		h := http.Header{}
+		h.Set("X-Webhook-Timestamp", ts)
+		h.Set("X-Webhook-Signature", SignWebhook(secret, ts, body))
+		return h
+	}
+	forged := signed(now)
+	forged.Set("X-Webhook-Signature", SignWebhook([]byte("other"), forged.Get("X-Webhook-Timestamp"), body))
+	tests := []struct {
+		name string
+		h    http.Header
+		want bool
+	}{
+		{"fresh", signed(now.Add(-time.Minute)), true},
+		{"replayed", signed(now.Add(-time.Hour)), false},
+		{"from the future", signed(now.Add(time.Hour)), false},
+		{"wrong secret", forged, false},
+		{"no timestamp", http.Header{"X-Webhook-Signature": {SignWebhook(secret, "", body)}}, false},
+	}
+	for _, tt := range tests {
+		if got := verifyWebhook(secret, tt.h, body, now); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
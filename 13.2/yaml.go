@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// decodeYAML decodes the YAML that configuration files are written in:
+// block mappings and sequences, single-line flow sequences and mappings,
+// plain and quoted scalars and comments. Anchors, tags, multi-line
+// scalars and multiple documents are not supported. The document is then
+// decoded into v as strict JSON is, so unknown keys are rejected.
+func decodeYAML(data []byte, v interface{}) error {
+	p := &yamlParser{}
+	if err := p.split(string(data)); err != nil {
+		return err
+	}
+	var doc interface{}
+	if len(p.lines) > 0 {
+		var err error
+		if doc, err = p.block(p.lines[0].indent); err != nil {
+			return err
+		}
+		if p.pos < len(p.lines) {
+			return p.errorf("unexpected indentation")
+		}
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return decodeStrictJSON(raw, v)
+}
+
+type yamlLine struct {
+	n      int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	n := 0
+	if p.pos < len(p.lines) {
+		n = p.lines[p.pos].n
+	} else if len(p.lines) > 0 {
+		n = p.lines[len(p.lines)-1].n
+	}
+	return fmt.Errorf("yaml line %d: %s", n, fmt.Sprintf(format, args...))
+}
+
+// split drops comments and blank lines and measures the indentation of
+// the rest.
+func (p *yamlParser) split(doc string) error {
+	for i, l := range strings.Split(doc, "\n") {
+		l = strings.TrimRight(stripYAMLComment(l), " \t\r")
+		text := strings.TrimLeft(l, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if text[0] == '\t' {
+			return fmt.Errorf("yaml line %d: tabs are not allowed in indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{n: i + 1, indent: len(l) - len(text), text: text})
+	}
+	return nil
+}
+
+func stripYAMLComment(l string) string {
+	var quote byte
+	for i := 0; i < len(l); i++ {
+		c := l[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" :[{,-", l[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || l[i-1] == ' ' || l[i-1] == '\t'):
+			return l[:i]
+		}
+	}
+	return l
+}
+
+func isYAMLItem(text string) bool { return text == "-" || strings.HasPrefix(text, "- ") }
+
+func isYAMLFlow(text string) bool { return text[0] == '[' || text[0] == '{' }
+
+// block parses the mapping or sequence whose lines start at indent.
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	l := p.lines[p.pos]
+	switch {
+	case isYAMLItem(l.text):
+		return p.sequence(indent)
+	case isYAMLFlow(l.text):
+		p.pos++
+		return yamlValue(l.text)
+	}
+	return p.mapping(indent)
+}
+
+// nested parses the block below a key or item, null when there is none.
+func (p *yamlParser) nested(parent int) (interface{}, error) {
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > parent {
+		return p.block(p.lines[p.pos].indent)
+	}
+	return nil, nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent || (l.indent == indent && !isYAMLItem(l.text)) {
+			break
+		}
+		if l.indent > indent {
+			return nil, p.errorf("bad indentation of a sequence item")
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		var v interface{}
+		var err error
+		switch _, _, isKey := splitYAMLKey(rest); {
+		case rest == "":
+			p.pos++
+			v, err = p.nested(indent)
+		case !isYAMLFlow(rest) && isKey:
+			// "- key: value" starts a mapping at the column of key.
+			col := l.indent + len(l.text) - len(rest)
+			p.lines[p.pos] = yamlLine{n: l.n, indent: col, text: rest}
+			v, err = p.mapping(col)
+		default:
+			p.pos++
+			v, err = yamlValue(rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent || isYAMLItem(l.text) {
+			return nil, p.errorf("bad indentation of a mapping entry")
+		}
+		key, rest, ok := splitYAMLKey(l.text)
+		if !ok {
+			return nil, p.errorf("expected key: value")
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.pos++
+		var v interface{}
+		var err error
+		switch {
+		case rest != "":
+			v, err = yamlValue(rest)
+		case p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text):
+			v, err = p.sequence(indent)
+		default:
+			v, err = p.nested(indent)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("yaml line %d: %s: %w", l.n, key, err)
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// splitYAMLKey splits "key: value" and "key:", with key plain or quoted.
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	i := 0
+	if text[0] == '"' || text[0] == '\'' {
+		end := quotedEnd(text)
+		if end < 0 {
+			return "", "", false
+		}
+		i = end
+	}
+	for ; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			k, err := yamlScalar(strings.TrimSpace(text[:i]))
+			if err != nil {
+				return "", "", false
+			}
+			return fmt.Sprint(k), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// quotedEnd is the index just past the quoted scalar text starts with,
+// -1 when it is not closed.
+func quotedEnd(text string) int {
+	q := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case q == '"' && text[i] == '\\':
+			i++
+		case text[i] == q && q == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == q:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// yamlValue parses the value after a key or item marker on one line.
+func yamlValue(s string) (interface{}, error) {
+	if !isYAMLFlow(s) {
+		return yamlScalar(s)
+	}
+	f := &yamlFlow{s: s}
+	v, err := f.value()
+	if err != nil {
+		return nil, err
+	}
+	if f.space(); f.i < len(f.s) {
+		return nil, fmt.Errorf("unexpected %q after flow collection", f.s[f.i:])
+	}
+	return v, nil
+}
+
+// yamlFlow parses a flow collection such as [1, 2] or {a: b}.
+type yamlFlow struct {
+	s string
+	i int
+}
+
+func (f *yamlFlow) space() {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+}
+
+func (f *yamlFlow) next(c byte) bool {
+	if f.space(); f.i < len(f.s) && f.s[f.i] == c {
+		f.i++
+		return true
+	}
+	return false
+}
+
+func (f *yamlFlow) value() (interface{}, error) {
+	switch {
+	case f.next('['):
+		list := []interface{}{}
+		if f.next(']') {
+			return list, nil
+		}
+		for {
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			if f.next(']') {
+				return list, nil
+			}
+			if !f.next(',') {
+				return nil, fmt.Errorf("expected , or ] in %q", f.s)
+			}
+		}
+	case f.next('{'):
+		m := map[string]interface{}{}
+		if f.next('}') {
+			return m, nil
+		}
+		for {
+			k, err := f.token(":")
+			if err != nil {
+				return nil, err
+			}
+			if !f.next(':') {
+				return nil, fmt.Errorf("expected : in %q", f.s)
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = v
+			if f.next('}') {
+				return m, nil
+			}
+			if !f.next(',') {
+				return nil, fmt.Errorf("expected , or } in %q", f.s)
+			}
+		}
+	}
+	return f.token(",]}")
+}
+
+// token reads a scalar up to one of the stop characters.
+func (f *yamlFlow) token(stop string) (interface{}, error) {
+	f.space()
+	start := f.i
+	if f.i < len(f.s) && (f.s[f.i] == '"' || f.s[f.i] == '\'') {
+		end := quotedEnd(f.s[f.i:])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string in %q", f.s)
+		}
+		f.i += end
+	} else {
+		for f.i < len(f.s) && strings.IndexByte(stop, f.s[f.i]) < 0 {
+			f.i++
+		}
+	}
+	return yamlScalar(strings.TrimSpace(f.s[start:f.i]))
+}
+
+// yamlScalar resolves a scalar as the YAML core schema does: null,
+// booleans, integers and floats, and strings otherwise.
+func yamlScalar(s string) (interface{}, error) {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	switch s[0] {
+	case '"':
+		if quotedEnd(s) != len(s) {
+			return nil, fmt.Errorf("bad string %s", s)
+		}
+		return strconv.Unquote(s)
+	case '\'':
+		if quotedEnd(s) != len(s) {
+			return nil, fmt.Errorf("bad string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	if x, err := strconv.ParseFloat(s, 64); err == nil && strings.ContainsAny(s, ".eE") {
+		return x, nil
+	}
+	return s, nil
+}
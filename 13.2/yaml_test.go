@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want interface{}
+	}{
+		{"scalars", "a: 1\nb: -2.5\nc: true\nd: ~\ne: 07:00\nf: \"0000\"\ng: 'it''s'\n",
+			map[string]interface{}{"a": 1.0, "b": -2.5, "c": true, "d": nil, "e": "07:00", "f": "0000", "g": "it's"}},
+		{"comments", "# header\na: x # trailing\nb: \"#not\"\n",
+			map[string]interface{}{"a": "x", "b": "#not"}},
+		{"nested mapping", "a:\n  b:\n    c: 1\n  d: 2\n",
+			map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": 1.0}, "d": 2.0}}},
+		{"sequences", "a:\n  - 1\n  - two\nb:\n- x\n- y\n",
+			map[string]interface{}{"a": []interface{}{1.0, "two"}, "b": []interface{}{"x", "y"}}},
+		{"sequence of mappings", "p:\n  - type: bus\n    price: 250\n  - type: tram\n    tags: [a, b]\n",
+			map[string]interface{}{"p": []interface{}{
+				map[string]interface{}{"type": "bus", "price": 250.0},
+				map[string]interface{}{"type": "tram", "tags": []interface{}{"a", "b"}},
+			}}},
+		{"flow", "a: {x: 1, y: [2, \"3\"]}\nb: []\n",
+			map[string]interface{}{"a": map[string]interface{}{"x": 1.0, "y": []interface{}{2.0, "3"}}, "b": []interface{}{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got interface{}
+			must(t, decodeYAML([]byte(tt.doc), &got))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeYAMLErrors(t *testing.T) {
+	for _, doc := range []string{
+		"a: 1\n  b: 2\n",
+		"a: 1\na: 2\n",
+		"just a line\n",
+		"a: [1, 2\n",
+		"a:\n\t- 1\n",
+	} {
+		var got interface{}
+		if err := decodeYAML([]byte(doc), &got); err == nil {
+			t.Errorf("%q decoded to %#v", doc, got)
+		}
+	}
+}
+
+func TestLoadYAMLConfigFile(t *testing.T) {
+	doc := `machine_id: TM-0009
+locale: ru
+location:
+  station_id: ABY
+  station: Abay
+products:
+  - type: train
+    name: Train
+    price: 100000
+    vat_bp: 1200
+    stock: 5
+    validity_minutes: 1440
+    seated: true
+    coaches: 2
+    seats_per_coach: 10
+schedule:
+  - {ticket_type: train, days: [sat, sun], from: "00:00", to: "24:00", price: 80000}
+denominations: [10000, 20000]
+hopper: {10000: 4}
+cash_box_capacity: 100
+top_up_amounts: [50000]
+timeout_seconds: 30
+refund_window_minutes: 15
+operators:
+  - id: supervisor
+    pin: "4821"
+    roles: [supervisor]
+ticket_key_id: k1
+ticket_key: 3f9c1e7a5b2d4068
+`
+	path := filepath.Join(t.TempDir(), "machine.yml")
+	must(t, os.WriteFile(path, []byte(doc), 0o600))
+	cfg, err := LoadConfigFile(path)
+	must(t, err)
+	m, err := NewTicketMachineFrom(cfg)
+	must(t, err)
+	if m.MachineID != "TM-0009" || m.Location.Station != "Abay" || m.AudioLocale != "ru" {
+		t.Errorf("machine %s at %+v, locale %s", m.MachineID, m.Location, m.AudioLocale)
+	}
+	if seats, _ := m.Seats.Available("train"); len(seats) != 20 {
+		t.Errorf("%d train seats, want 20", len(seats))
+	}
+	if len(m.TopUpAmounts) != 1 || m.TopUpAmounts[0] != KZT(500) {
+		t.Errorf("top-up amounts %v", m.TopUpAmounts)
+	}
+	if err := m.Auth.Authenticate("supervisor", "4821"); err != nil {
+		t.Error(err)
+	}
+	if err := m.Auth.Authenticate("admin", "0000"); err == nil {
+		t.Error("default admin PIN accepted")
+	}
+	if p, ok := m.Schedule.Price("train", time.Date(2026, 3, 7, 9, 0, 0, 0, time.UTC)); !ok || p != KZT(800) {
+		t.Errorf("weekend train price %s, %v", p, ok)
+	}
+}
+
+func TestConfigValidateMachineSettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   string
+	}{
+		{"short ticket key", func(c *Config) { c.TicketKey = "change-me" }, "ticket_key must be at least 16 bytes"},
+		{"unknown role", func(c *Config) { c.Operators[0].Roles = []Role{"janitor"} }, `unknown role "janitor"`},
+		{"duplicate operator", func(c *Config) { c.Operators[1].ID = c.Operators[0].ID }, "duplicate operator"},
+		{"bad schedule time", func(c *Config) { c.Schedule[0].From = "7am" }, "schedule[0]: from"},
+		{"bad schedule day", func(c *Config) { c.Schedule[0].Days = []string{"monday"} }, `unknown day "monday"`},
+		{"seats missing", func(c *Config) { c.Products[2].Coaches = 0 }, "needs coaches and seats_per_coach"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			must(t, cfg.Validate())
+			tt.change(&cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import "sort"
+
+// ZoneFares prices zoned products by origin and destination zone. Fares
+// are symmetric: a fare for A→B also applies to B→A.
+type ZoneFares struct {
+	// Products lists the ticket types priced by zone.
+	Products map[string]bool
+	// Origin is the zone of the station the machine stands in.
+	Origin string
+	Fares  map[string]map[string]Money
+}
+
+// Fare returns the fare between two zones.
+func (z *ZoneFares) Fare(from, to string) (Money, bool) {
+	if f, ok := z.Fares[from][to]; ok {
+		return f, true
+	}
+	f, ok := z.Fares[to][from]
+	return f, ok
+}
+
+// Zones lists the destination zones reachable from Origin.
+func (z *ZoneFares) Zones() []string {
+	seen := map[string]bool{}
+	var zones []string
+	add := func(zone string) {
+		if !seen[zone] {
+			seen[zone] = true
+			zones = append(zones, zone)
+		}
+	}
+	for to := range z.Fares[z.Origin] {
+		add(to)
+	}
+	for from, row := range z.Fares {
+		if _, ok := row[z.Origin]; ok {
+			add(from)
+		}
+	}
+	sort.Strings(zones)
+	return zones
+}
+
+// isZoned reports whether ticketType needs a destination.
+func (m *TicketMachine) isZoned(ticketType string) bool {
+	return m.ZoneFares != nil && m.ZoneFares.Products[ticketType]
+}
+
+// SelectDestination prices the selected zoned ticket for a trip from the
+// machine's zone to zone and moves on to payment.
+func (m *TicketMachine) SelectDestination(zone string) error {
+	if err := m.logAction(JournalEntry{Action: "destination", Zone: zone}); err != nil {
+		return err
+	}
+	if _, ok := m.State.(*SelectDestinationState); !ok {
+		return newError(CodeInvalidState, "no destination to select")
+	}
+	if _, ok := m.ZoneFares.Fare(m.ZoneFares.Origin, zone); !ok {
+		return newErrorf(CodeNotOffered, "no fare from zone %s to zone %s", m.ZoneFares.Origin, zone)
+	}
+	l := &m.Cart[0]
+	ctx := m.priceContext()
+	ctx.FromZone, ctx.ToZone = m.ZoneFares.Origin, zone
+	l.UnitPrice, _ = m.pricing().GetPrice(l.TicketType, ctx)
+	l.FromZone = m.ZoneFares.Origin
+	l.ToZone = zone
+	m.repriceCart()
+	m.SetState(&WaitingForMoneyState{})
+	m.show("Destination: zone %s (%s)", zone, m.formatPrice(m.CurrentPrice))
+	return nil
+}
+
+// SelectDestinationState asks for the destination zone of a zoned ticket.
+type SelectDestinationState struct{}
+
+func (s *SelectDestinationState) SelectTicket(m *TicketMachine, ticketType string, qty int) error {
+	return newError(CodeStepRequired, "please select a destination")
+}
+func (s *SelectDestinationState) InsertMoney(m *TicketMachine, amount Money) error {
+	return newError(CodeStepRequired, "please select a destination first")
+}
+func (s *SelectDestinationState) PayByCard(m *TicketMachine, card CardDetails) error {
+	return newError(CodeStepRequired, "please select a destination first")
+}
+func (s *SelectDestinationState) Cancel(m *TicketMachine) error {
+	m.SetState(&TransactionCanceledState{})
+	return nil
+}
+func (s *SelectDestinationState) DispenseTicket(m *TicketMachine) (Dispensed, error) {
+	return Dispensed{}, newError(CodeStepRequired, "please select a destination first")
+}
+func (s *SelectDestinationState) Name() string { return "SelectDestination" }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// withZones adds the zoned product "suburban" sold from zone A.
+func withZones(t *testing.T, m *TicketMachine) {
+	t.Helper()
+	must(t, m.Catalog.RegisterProduct(Product{Type: "suburban", Name: "Suburban", Price: KZT(400), Stock: 10}))
+	m.ZoneFares = &ZoneFares{
+		Products: map[string]bool{"suburban": true},
+		Origin:   "A",
+		Fares: map[string]map[string]Money{
+			"A": {"A": KZT(400), "B": KZT(600)},
+			"C": {"A": KZT(900)},
+			"B": {"C": KZT(500)},
+		},
+	}
+}
+
+func TestZoneFares(t *testing.T) {
+	z := &ZoneFares{Origin: "A", Fares: map[string]map[string]Money{"A": {"B": KZT(600)}, "C": {"A": KZT(900)}, "B": {"C": KZT(500)}}}
+	if got := z.Zones(); !reflect.DeepEqual(got, []string{"B", "C"}) {
+		t.Fatalf("zones = %v", got)
+	}
+	for _, tt := range []struct {
+		from, to string
+		fare     Money
+		ok       bool
+	}{
+		{"A", "B", KZT(600), true},
+		{"B", "A", KZT(600), true},
+		{"A", "C", KZT(900), true},
+		{"A", "D", 0, false},
+	} {
+		if f, ok := z.Fare(tt.from, tt.to); f != tt.fare || ok != tt.ok {
+			t.Errorf("Fare(%s, %s) = %s, %v", tt.from, tt.to, f, ok)
+		}
+	}
+}
+
+func TestSelectDestination(t *testing.T) {
+	tests := []struct {
+		name  string
+		zone  string
+		price Money
+		code  ErrorCode
+	}{
+		{"same zone", "A", KZT(400), ""},
+		{"next zone", "B", KZT(600), ""},
+		{"fare stored the other way round", "C", KZT(900), ""},
+		{"unreachable zone", "D", 0, CodeNotOffered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMachine(t)
+			withZones(t, m)
+			must(t, m.SelectTicket("suburban", 1))
+			if m.GetCurrentState() != (&SelectDestinationState{}).Name() {
+				t.Fatalf("state = %s", m.GetCurrentState())
+			}
+			if err := m.InsertMoney(KZT(100)); CodeOf(err) != CodeStepRequired {
+				t.Fatalf("InsertMoney before the destination = %v", err)
+			}
+			err := m.SelectDestination(tt.zone)
+			if CodeOf(err) != tt.code {
+				t.Fatalf("SelectDestination = %v, want %q", err, tt.code)
+			}
+			if tt.code != "" {
+				return
+			}
+			if m.CurrentPrice != tt.price || m.GetCurrentState() != (&WaitingForMoneyState{}).Name() {
+				t.Fatalf("price %s, state %s", m.CurrentPrice, m.GetCurrentState())
+			}
+			for m.InsertedMoney < tt.price {
+				must(t, m.InsertMoney(KZT(100)))
+			}
+			d, err := m.DispenseTicket()
+			must(t, err)
+			if tk := d.Tickets[0]; tk.FromZone != "A" || tk.ToZone != tt.zone {
+				t.Fatalf("ticket zones %s-%s", tk.FromZone, tk.ToZone)
+			}
+		})
+	}
+}
+
+func TestZoneTicketsBoughtAlone(t *testing.T) {
+	m, _ := newTestMachine(t)
+	withZones(t, m)
+	must(t, m.AddToCart("metro", 1))
+	if err := m.AddToCart("suburban", 1); CodeOf(err) != CodeCart {
+		t.Fatalf("AddToCart = %v, want %s", err, CodeCart)
+	}
+}